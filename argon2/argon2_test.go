@@ -0,0 +1,49 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package argon2
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// These test vectors have been taken from RFC 9106, section 5.
+var rfcTestVectors = []struct {
+	mode int
+	hash string
+}{
+	{argon2d, "512b391b6f1162975371d30919734294f868e3be3984f3c1a13a4db9fabe4acb"},
+	{argon2i, "c814d9d1dc7f37aa13f0d77f2494bda1c8de6b016dd388d29952a4c4672b6ce8"},
+	{argon2id, "0d640df58d78766c08c037a34a8b53c9d01ef0452d75b65eb52520e96b01e659"},
+}
+
+func TestVectors(t *testing.T) {
+	password := bytes.Repeat([]byte{1}, 32)
+	salt := bytes.Repeat([]byte{2}, 16)
+	secret := bytes.Repeat([]byte{3}, 8)
+	data := bytes.Repeat([]byte{4}, 12)
+
+	for i, v := range rfcTestVectors {
+		want, _ := hex.DecodeString(v.hash)
+		got := deriveKey(v.mode, password, salt, secret, data, 3, 32, 4, 32)
+		if !bytes.Equal(got, want) {
+			t.Errorf("#%d: got %x, want %x", i, got, want)
+		}
+	}
+}
+
+func TestKeyLengths(t *testing.T) {
+	password, salt := []byte("password"), []byte("somesalt")
+	for _, keyLen := range []uint32{4, 32, 64, 65, 100} {
+		key := IDKey(password, salt, 1, 64, 1, keyLen)
+		if len(key) != int(keyLen) {
+			t.Errorf("got a %d byte key, want %d", len(key), keyLen)
+		}
+		if !bytes.Equal(key, IDKey(password, salt, 1, 64, 1, keyLen)) {
+			t.Errorf("keyLen %d: result is not deterministic", keyLen)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package argon2
+
+func processBlock(out, in1, in2 *block) {
+	processBlockGeneric(out, in1, in2, false)
+}
+
+func processBlockXOR(out, in1, in2 *block) {
+	processBlockGeneric(out, in1, in2, true)
+}
+
+// processBlockGeneric applies the compression function G of RFC 9106,
+// section 3.5, to in1 and in2 and stores, or XORs, the result into out.
+func processBlockGeneric(out, in1, in2 *block, xor bool) {
+	var t block
+	for i := range t {
+		t[i] = in1[i] ^ in2[i]
+	}
+
+	var v [16]uint64
+	// Apply the permutation P to each row of the block...
+	for i := 0; i < blockLength; i += 16 {
+		copy(v[:], t[i:i+16])
+		blamka(&v)
+		copy(t[i:i+16], v[:])
+	}
+	// ...and then to each column.
+	for i := 0; i < blockLength/8; i += 2 {
+		for j := 0; j < 8; j++ {
+			v[2*j], v[2*j+1] = t[16*j+i], t[16*j+i+1]
+		}
+		blamka(&v)
+		for j := 0; j < 8; j++ {
+			t[16*j+i], t[16*j+i+1] = v[2*j], v[2*j+1]
+		}
+	}
+
+	if xor {
+		for i := range t {
+			out[i] ^= in1[i] ^ in2[i] ^ t[i]
+		}
+	} else {
+		for i := range t {
+			out[i] = in1[i] ^ in2[i] ^ t[i]
+		}
+	}
+}
+
+// blamka is the permutation P of RFC 9106, section 3.6.
+func blamka(v *[16]uint64) {
+	gb(v, 0, 4, 8, 12)
+	gb(v, 1, 5, 9, 13)
+	gb(v, 2, 6, 10, 14)
+	gb(v, 3, 7, 11, 15)
+	gb(v, 0, 5, 10, 15)
+	gb(v, 1, 6, 11, 12)
+	gb(v, 2, 7, 8, 13)
+	gb(v, 3, 4, 9, 14)
+}
+
+// gb is the BLAKE2b round function modified with the multiplications that
+// Argon2 adds.
+func gb(v *[16]uint64, a, b, c, d int) {
+	v[a] += v[b] + 2*uint64(uint32(v[a]))*uint64(uint32(v[b]))
+	v[d] = rotr(v[d]^v[a], 32)
+	v[c] += v[d] + 2*uint64(uint32(v[c]))*uint64(uint32(v[d]))
+	v[b] = rotr(v[b]^v[c], 24)
+	v[a] += v[b] + 2*uint64(uint32(v[a]))*uint64(uint32(v[b]))
+	v[d] = rotr(v[d]^v[a], 16)
+	v[c] += v[d] + 2*uint64(uint32(v[c]))*uint64(uint32(v[d]))
+	v[b] = rotr(v[b]^v[c], 63)
+}
+
+func rotr(x uint64, n uint) uint64 {
+	return x>>n | x<<(64-n)
+}
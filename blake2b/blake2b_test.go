@@ -0,0 +1,86 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blake2b
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+var hashTests = []struct {
+	size    int
+	in, out string
+}{
+	// From RFC 7693, Appendix A.
+	{
+		Size,
+		"abc",
+		"ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923",
+	},
+	// The empty string and BLAKE2b-256, from the reference implementation.
+	{
+		Size,
+		"",
+		"786a02f742015903c6c6fd852552d272912f4740e15847618a86e217f71f5419d25e1031afee585313896444934eb04b903a685b1448b755d56f701afe9be2ce",
+	},
+	{
+		Size256,
+		"abc",
+		"bddd813c634239723171ef3fee98579b94964e3bb1cb3e427262c8c068d52319",
+	},
+}
+
+func TestHashes(t *testing.T) {
+	for i, test := range hashTests {
+		h, err := New(test.size, nil)
+		if err != nil {
+			t.Fatalf("#%d: New: %s", i, err)
+		}
+		h.Write([]byte(test.in))
+		if got := hex.EncodeToString(h.Sum(nil)); got != test.out {
+			t.Errorf("#%d: got %s, want %s", i, got, test.out)
+		}
+	}
+
+	if sum := Sum512([]byte("abc")); hex.EncodeToString(sum[:]) != hashTests[0].out {
+		t.Errorf("Sum512: got %x", sum)
+	}
+}
+
+// TestIncremental checks that splitting the input across writes, in
+// particular on block boundaries, doesn't change the result.
+func TestIncremental(t *testing.T) {
+	input := make([]byte, 3*BlockSize+7)
+	for i := range input {
+		input[i] = byte(i)
+	}
+	key := []byte("secret key")
+
+	for _, n := range []int{0, 1, BlockSize - 1, BlockSize, BlockSize + 1, 2 * BlockSize, len(input)} {
+		h, _ := New512(key)
+		h.Write(input)
+		want := h.Sum(nil)
+
+		h.Reset()
+		h.Write(input[:n])
+		h.Write(input[n:])
+		if got := h.Sum(nil); !bytes.Equal(got, want) {
+			t.Errorf("split at %d: got %x, want %x", n, got, want)
+		}
+	}
+}
+
+func TestInvalidSizes(t *testing.T) {
+	if _, err := New(0, nil); err == nil {
+		t.Error("New accepted a zero hash size")
+	}
+	if _, err := New(Size+1, nil); err == nil {
+		t.Error("New accepted a hash size larger than 64")
+	}
+	if _, err := New512(make([]byte, Size+1)); err == nil {
+		t.Error("New512 accepted a key larger than 64 bytes")
+	}
+}
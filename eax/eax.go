@@ -0,0 +1,189 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package eax implements the EAX authenticated encryption mode as specified
+// by Bellare, Rogaway and Wagner in "The EAX Mode of Operation".
+//
+// EAX is a two-pass AEAD mode built from CTR mode encryption and OMAC
+// (CMAC) authentication, both keyed with the same block cipher key. It is
+// only defined here for block ciphers with a 16-byte block size.
+//
+// Like GCM, EAX is broken if a nonce is ever reused with the same key.
+package eax
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+)
+
+const (
+	blockSize = 16
+
+	// defaultNonceSize is the nonce size used by OpenPGP. EAX itself
+	// accepts nonces of any length.
+	defaultNonceSize = 16
+	// defaultTagSize is the size of a full length tag.
+	defaultTagSize = 16
+)
+
+var errOpen = errors.New("eax: message authentication failed")
+
+type eax struct {
+	block     cipher.Block
+	nonceSize int
+	tagSize   int
+
+	// k1 and k2 are the OMAC subkeys for complete and padded final
+	// blocks.
+	k1, k2 [blockSize]byte
+}
+
+// NewEAX returns the given 128-bit block cipher wrapped in EAX mode with a
+// 16-byte nonce and a 16-byte tag.
+func NewEAX(block cipher.Block) (cipher.AEAD, error) {
+	return NewEAXWithNonceAndTagSize(block, defaultNonceSize, defaultTagSize)
+}
+
+// NewEAXWithNonceAndTagSize returns the given 128-bit block cipher wrapped in
+// EAX mode with the given nonce and tag sizes. The nonce must be at least 1
+// byte long and the tag between 1 and 16 bytes.
+func NewEAXWithNonceAndTagSize(block cipher.Block, nonceSize, tagSize int) (cipher.AEAD, error) {
+	if block.BlockSize() != blockSize {
+		return nil, errors.New("eax: cipher does not have a block size of 16")
+	}
+	if nonceSize < 1 {
+		return nil, errors.New("eax: invalid nonce size")
+	}
+	if tagSize < 1 || tagSize > defaultTagSize {
+		return nil, errors.New("eax: invalid tag size")
+	}
+
+	e := &eax{
+		block:     block,
+		nonceSize: nonceSize,
+		tagSize:   tagSize,
+	}
+	var l [blockSize]byte
+	block.Encrypt(l[:], l[:])
+	double(&e.k1, &l)
+	double(&e.k2, &e.k1)
+	return e, nil
+}
+
+func (e *eax) NonceSize() int {
+	return e.nonceSize
+}
+
+func (e *eax) Overhead() int {
+	return e.tagSize
+}
+
+func (e *eax) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != e.nonceSize {
+		panic("eax: incorrect nonce length given to EAX")
+	}
+
+	ret, out := sliceForAppend(dst, len(plaintext)+e.tagSize)
+	var n, h, c [blockSize]byte
+	e.omac(&n, 0, nonce)
+	e.omac(&h, 1, additionalData)
+	cipher.NewCTR(e.block, n[:]).XORKeyStream(out, plaintext)
+	e.omac(&c, 2, out[:len(plaintext)])
+
+	var tag [blockSize]byte
+	xorBlock(tag[:], n[:], c[:])
+	xorBlock(tag[:], tag[:], h[:])
+	copy(out[len(plaintext):], tag[:e.tagSize])
+	return ret
+}
+
+func (e *eax) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != e.nonceSize {
+		panic("eax: incorrect nonce length given to EAX")
+	}
+	if len(ciphertext) < e.tagSize {
+		return nil, errOpen
+	}
+
+	tagStart := len(ciphertext) - e.tagSize
+	var n, h, c [blockSize]byte
+	e.omac(&n, 0, nonce)
+	e.omac(&h, 1, additionalData)
+	e.omac(&c, 2, ciphertext[:tagStart])
+
+	var tag [blockSize]byte
+	xorBlock(tag[:], n[:], c[:])
+	xorBlock(tag[:], tag[:], h[:])
+	if subtle.ConstantTimeCompare(tag[:e.tagSize], ciphertext[tagStart:]) != 1 {
+		return nil, errOpen
+	}
+
+	ret, out := sliceForAppend(dst, tagStart)
+	cipher.NewCTR(e.block, n[:]).XORKeyStream(out, ciphertext[:tagStart])
+	return ret, nil
+}
+
+// omac sets sum to OMAC^t(data), the CMAC of the block holding t followed
+// by data.
+func (e *eax) omac(sum *[blockSize]byte, t byte, data []byte) {
+	// The block holding t is never the last, as data follows it, unless
+	// data is empty.
+	*sum = [blockSize]byte{}
+	sum[blockSize-1] = t
+	if len(data) == 0 {
+		xorBlock(sum[:], sum[:], e.k1[:])
+		e.block.Encrypt(sum[:], sum[:])
+		return
+	}
+	e.block.Encrypt(sum[:], sum[:])
+
+	for len(data) > blockSize {
+		xorBlock(sum[:], sum[:], data)
+		e.block.Encrypt(sum[:], sum[:])
+		data = data[blockSize:]
+	}
+
+	var last [blockSize]byte
+	copy(last[:], data)
+	if len(data) == blockSize {
+		xorBlock(last[:], last[:], e.k1[:])
+	} else {
+		last[len(data)] = 0x80
+		xorBlock(last[:], last[:], e.k2[:])
+	}
+	xorBlock(sum[:], sum[:], last[:])
+	e.block.Encrypt(sum[:], sum[:])
+}
+
+// double sets out to in·x in GF(2¹²⁸).
+func double(out, in *[blockSize]byte) {
+	msb := in[0] >> 7
+	for i := 0; i < blockSize-1; i++ {
+		out[i] = in[i]<<1 | in[i+1]>>7
+	}
+	out[blockSize-1] = in[blockSize-1]<<1 ^ (0x87 & -msb)
+}
+
+// xorBlock sets dst to the XOR of the first blockSize bytes of a and b.
+func xorBlock(dst, a, b []byte) {
+	for i := 0; i < blockSize; i++ {
+		dst[i] = a[i] ^ b[i]
+	}
+}
+
+// sliceForAppend takes a slice and a requested number of bytes. It returns a
+// slice with the contents of the given slice followed by that many bytes and a
+// second slice that aliases into it and contains only the extra bytes. If the
+// original slice has sufficient capacity then no allocation is performed.
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return
+}
@@ -0,0 +1,120 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eax
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"testing"
+)
+
+// These test vectors have been taken from "The EAX Mode of Operation",
+// Appendix.
+var eaxTestVectors = []struct {
+	key, nonce, header, plaintext, ciphertext string
+}{
+	{
+		"233952dee4d5ed5f9b9c6d6ff80ff478",
+		"62ec67f9c3a4a407fcb2a8c49031a8b3",
+		"6bfb914fd07eae6b",
+		"",
+		"e037830e8389f27b025a2d6527e79d01",
+	}, {
+		"91945d3f4dcbee0bf45ef52255f095a4",
+		"becaf043b0a23d843194ba972c66debd",
+		"fa3bfd4806eb53fa",
+		"f7fb",
+		"19dd5c4c9331049d0bdab0277408f67967e5",
+	}, {
+		"01f74ad64077f2e704c0f60ada3dd523",
+		"70c3db4f0d26368400a10ed05d2bff5e",
+		"234a3463c1264ac6",
+		"1a47cb4933",
+		"d851d5bae03a59f238a23e39199dc9266626c40f80",
+	}, {
+		"d07cf6cbb7f313bdde66b727afd3c5e8",
+		"8408dfff3c1a2b1292dc199e46b7d617",
+		"33cce2eabff5a79d",
+		"481c9e39b1",
+		"632a9d131ad4c168a4225d8e1ff755939974a7bede",
+	}, {
+		"35b6d0580005bbc12b0587124557d2c2",
+		"fdb6b06676eedc5c61d74276e1f8e816",
+		"aeb96eaebe2970e9",
+		"40d0c07da5e4",
+		"071dfe16c675cb0677e536f73afe6a14b74ee49844dd",
+	},
+}
+
+func TestEAX(t *testing.T) {
+	for i, test := range eaxTestVectors {
+		key, _ := hex.DecodeString(test.key)
+		nonce, _ := hex.DecodeString(test.nonce)
+		header, _ := hex.DecodeString(test.header)
+		plaintext, _ := hex.DecodeString(test.plaintext)
+		expected, _ := hex.DecodeString(test.ciphertext)
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		aead, err := NewEAX(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ciphertext := aead.Seal(nil, nonce, plaintext, header)
+		if !bytes.Equal(ciphertext, expected) {
+			t.Errorf("#%d: Seal got %x, want %x", i, ciphertext, expected)
+			continue
+		}
+
+		decrypted, err := aead.Open(nil, nonce, ciphertext, header)
+		if err != nil {
+			t.Errorf("#%d: Open failed: %s", i, err)
+			continue
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("#%d: Open got %x, want %x", i, decrypted, plaintext)
+		}
+
+		ciphertext[0] ^= 0x80
+		if _, err := aead.Open(nil, nonce, ciphertext, header); err == nil {
+			t.Errorf("#%d: Open accepted a corrupted ciphertext", i)
+		}
+	}
+}
+
+func TestEAXLongMessage(t *testing.T) {
+	block, _ := aes.NewCipher(make([]byte, 16))
+	aead, err := NewEAX(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	for _, n := range []int{15, 16, 17, 31, 32, 33, 1000} {
+		plaintext := bytes.Repeat([]byte{0x5a}, n)
+		header := plaintext[:n/2]
+		ciphertext := aead.Seal(nil, nonce, plaintext, header)
+		decrypted, err := aead.Open(nil, nonce, ciphertext, header)
+		if err != nil {
+			t.Errorf("%d bytes: Open failed: %s", n, err)
+			continue
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("%d bytes: Open got %x, want %x", n, decrypted, plaintext)
+		}
+	}
+}
+
+func TestEAXInvalidSizes(t *testing.T) {
+	block, _ := aes.NewCipher(make([]byte, 16))
+	for _, sizes := range [][2]int{{0, 16}, {16, 0}, {16, 17}} {
+		if _, err := NewEAXWithNonceAndTagSize(block, sizes[0], sizes[1]); err == nil {
+			t.Errorf("nonce size %d, tag size %d: expected error", sizes[0], sizes[1])
+		}
+	}
+}
@@ -172,26 +172,46 @@ func Sign(privateKey PrivateKey, message []byte) []byte {
 // Verify reports whether sig is a valid signature of message by publicKey. It
 // will panic if len(publicKey) is not PublicKeySize.
 func Verify(publicKey PublicKey, message, sig []byte) bool {
+	return NewVerifier(publicKey).Verify(message, sig)
+}
+
+// A Verifier checks signatures made by a single public key. It decodes the
+// key only once, so it is faster than Verify for checking many signatures
+// by the same key. A Verifier is safe for concurrent use.
+type Verifier struct {
+	publicKey [PublicKeySize]byte
+	// negA is the negated public key point. It is only valid if ok is set.
+	negA edwards25519.ExtendedGroupElement
+	ok   bool
+}
+
+// NewVerifier returns a Verifier for publicKey. It will panic if
+// len(publicKey) is not PublicKeySize.
+func NewVerifier(publicKey PublicKey) *Verifier {
 	if l := len(publicKey); l != PublicKeySize {
 		panic("ed25519: bad public key length: " + strconv.Itoa(l))
 	}
 
-	if len(sig) != SignatureSize || sig[63]&224 != 0 {
-		return false
+	v := new(Verifier)
+	copy(v.publicKey[:], publicKey)
+	if v.negA.FromBytes(&v.publicKey) {
+		edwards25519.FeNeg(&v.negA.X, &v.negA.X)
+		edwards25519.FeNeg(&v.negA.T, &v.negA.T)
+		v.ok = true
 	}
+	return v
+}
 
-	var A edwards25519.ExtendedGroupElement
-	var publicKeyBytes [32]byte
-	copy(publicKeyBytes[:], publicKey)
-	if !A.FromBytes(&publicKeyBytes) {
+// Verify reports whether sig is a valid signature of message by the public
+// key of v.
+func (v *Verifier) Verify(message, sig []byte) bool {
+	if !v.ok || len(sig) != SignatureSize || sig[63]&224 != 0 {
 		return false
 	}
-	edwards25519.FeNeg(&A.X, &A.X)
-	edwards25519.FeNeg(&A.T, &A.T)
 
 	h := sha512.New()
 	h.Write(sig[:32])
-	h.Write(publicKey[:])
+	h.Write(v.publicKey[:])
 	h.Write(message)
 	var digest [64]byte
 	h.Sum(digest[:0])
@@ -209,7 +229,7 @@ func Verify(publicKey PublicKey, message, sig []byte) bool {
 		return false
 	}
 
-	edwards25519.GeDoubleScalarMultVartime(&R, &hReduced, &A, &s)
+	edwards25519.GeDoubleScalarMultVartime(&R, &hReduced, &v.negA, &s)
 
 	var checkR [32]byte
 	R.ToBytes(&checkR)
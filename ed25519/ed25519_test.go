@@ -61,6 +61,30 @@ func TestSignVerify(t *testing.T) {
 	}
 }
 
+func TestVerifier(t *testing.T) {
+	var zero zeroReader
+	public, private, _ := GenerateKey(zero)
+	v := NewVerifier(public)
+
+	message := []byte("test message")
+	sig := Sign(private, message)
+	for i := 0; i < 2; i++ {
+		if !v.Verify(message, sig) {
+			t.Errorf("valid signature rejected")
+		}
+		if v.Verify([]byte("wrong message"), sig) {
+			t.Errorf("signature of different message accepted")
+		}
+	}
+
+	// A y coordinate with no matching point on the curve.
+	badKey := make(PublicKey, PublicKeySize)
+	badKey[0] = 2
+	if NewVerifier(badKey).Verify(message, sig) {
+		t.Errorf("signature accepted for an invalid public key")
+	}
+}
+
 func TestCryptoSigner(t *testing.T) {
 	var zero zeroReader
 	public, private, _ := GenerateKey(zero)
@@ -218,3 +242,18 @@ func BenchmarkVerification(b *testing.B) {
 		Verify(pub, message, signature)
 	}
 }
+
+func BenchmarkVerifier(b *testing.B) {
+	var zero zeroReader
+	pub, priv, err := GenerateKey(zero)
+	if err != nil {
+		b.Fatal(err)
+	}
+	message := []byte("Hello, world!")
+	signature := Sign(priv, message)
+	v := NewVerifier(pub)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v.Verify(message, signature)
+	}
+}
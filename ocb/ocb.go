@@ -0,0 +1,262 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package ocb implements the OCB authenticated encryption mode as specified
+// in RFC 7253.
+//
+// OCB is a single-pass AEAD mode: each block of plaintext costs one block
+// cipher invocation, plus a small, fixed amount of work per message. It is
+// only defined for block ciphers with a 16-byte block size.
+//
+// Like GCM, OCB is catastrophically broken if a nonce is ever reused with the
+// same key.
+package ocb
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+)
+
+const (
+	blockSize = 16
+
+	// defaultNonceSize is the nonce size recommended by RFC 7253.
+	defaultNonceSize = 12
+	// maxNonceSize is the largest nonce that fits in the formatted nonce
+	// block of RFC 7253, section 4.2.
+	maxNonceSize = 15
+	// defaultTagSize is the size of a full length tag.
+	defaultTagSize = 16
+
+	// numL is the number of precomputed L_i values. The i'th block of a
+	// message uses L_ntz(i), so this bounds messages to 2⁶⁴ blocks.
+	numL = 64
+)
+
+var errOpen = errors.New("ocb: message authentication failed")
+
+type ocb struct {
+	block     cipher.Block
+	nonceSize int
+	tagSize   int
+
+	lStar, lDollar [blockSize]byte
+	l              [numL][blockSize]byte
+}
+
+// NewOCB returns the given 128-bit block cipher wrapped in OCB mode with a
+// 12-byte nonce and a 16-byte tag.
+func NewOCB(block cipher.Block) (cipher.AEAD, error) {
+	return NewOCBWithNonceAndTagSize(block, defaultNonceSize, defaultTagSize)
+}
+
+// NewOCBWithNonceAndTagSize returns the given 128-bit block cipher wrapped in
+// OCB mode with the given nonce and tag sizes. The nonce must be between 1 and
+// 15 bytes long and the tag between 1 and 16 bytes. OpenPGP, for example,
+// uses a 15-byte nonce and a 16-byte tag.
+func NewOCBWithNonceAndTagSize(block cipher.Block, nonceSize, tagSize int) (cipher.AEAD, error) {
+	if block.BlockSize() != blockSize {
+		return nil, errors.New("ocb: cipher does not have a block size of 16")
+	}
+	if nonceSize < 1 || nonceSize > maxNonceSize {
+		return nil, errors.New("ocb: invalid nonce size")
+	}
+	if tagSize < 1 || tagSize > defaultTagSize {
+		return nil, errors.New("ocb: invalid tag size")
+	}
+
+	o := &ocb{
+		block:     block,
+		nonceSize: nonceSize,
+		tagSize:   tagSize,
+	}
+	block.Encrypt(o.lStar[:], o.lStar[:])
+	double(&o.lDollar, &o.lStar)
+	double(&o.l[0], &o.lDollar)
+	for i := 1; i < numL; i++ {
+		double(&o.l[i], &o.l[i-1])
+	}
+	return o, nil
+}
+
+func (o *ocb) NonceSize() int {
+	return o.nonceSize
+}
+
+func (o *ocb) Overhead() int {
+	return o.tagSize
+}
+
+func (o *ocb) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != o.nonceSize {
+		panic("ocb: incorrect nonce length given to OCB")
+	}
+
+	ret, out := sliceForAppend(dst, len(plaintext)+o.tagSize)
+	var tag [blockSize]byte
+	o.crypt(true, out, plaintext, nonce, &tag)
+	o.hash(&tag, additionalData)
+	copy(out[len(plaintext):], tag[:o.tagSize])
+	return ret
+}
+
+func (o *ocb) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != o.nonceSize {
+		panic("ocb: incorrect nonce length given to OCB")
+	}
+	if len(ciphertext) < o.tagSize {
+		return nil, errOpen
+	}
+
+	tagStart := len(ciphertext) - o.tagSize
+	ret, out := sliceForAppend(dst, tagStart)
+	var tag [blockSize]byte
+	o.crypt(false, out, ciphertext[:tagStart], nonce, &tag)
+	o.hash(&tag, additionalData)
+
+	if subtle.ConstantTimeCompare(tag[:o.tagSize], ciphertext[tagStart:]) != 1 {
+		for i := range out {
+			out[i] = 0
+		}
+		return nil, errOpen
+	}
+	return ret, nil
+}
+
+// crypt encrypts or decrypts in into out and sets tag to the tag of the
+// message before HASH(K, A) is mixed in. See RFC 7253, sections 4.2 and 4.3.
+func (o *ocb) crypt(encrypt bool, out, in, nonce []byte, tag *[blockSize]byte) {
+	var offset, checksum, tmp [blockSize]byte
+	o.initialOffset(&offset, nonce)
+
+	i := 1
+	for ; len(in) >= blockSize; i++ {
+		xorBlock(offset[:], offset[:], o.l[ntz(i)][:])
+		xorBlock(tmp[:], in, offset[:])
+		if encrypt {
+			xorBlock(checksum[:], checksum[:], in)
+			o.block.Encrypt(tmp[:], tmp[:])
+			xorBlock(out, tmp[:], offset[:])
+		} else {
+			o.block.Decrypt(tmp[:], tmp[:])
+			xorBlock(out, tmp[:], offset[:])
+			xorBlock(checksum[:], checksum[:], out)
+		}
+		in = in[blockSize:]
+		out = out[blockSize:]
+	}
+
+	if len(in) > 0 {
+		xorBlock(offset[:], offset[:], o.lStar[:])
+		var pad [blockSize]byte
+		o.block.Encrypt(pad[:], offset[:])
+		for j := range in {
+			out[j] = in[j] ^ pad[j]
+		}
+		var last [blockSize]byte
+		if encrypt {
+			copy(last[:], in)
+		} else {
+			copy(last[:], out[:len(in)])
+		}
+		last[len(in)] = 0x80
+		xorBlock(checksum[:], checksum[:], last[:])
+	}
+
+	xorBlock(tag[:], checksum[:], offset[:])
+	xorBlock(tag[:], tag[:], o.lDollar[:])
+	o.block.Encrypt(tag[:], tag[:])
+}
+
+// initialOffset computes Offset_0 from the nonce as described in RFC 7253,
+// section 4.2.
+func (o *ocb) initialOffset(offset *[blockSize]byte, nonce []byte) {
+	var n [blockSize]byte
+	copy(n[blockSize-len(nonce):], nonce)
+	n[blockSize-1-len(nonce)] |= 1
+	n[0] |= byte(o.tagSize*8%128) << 1
+
+	bottom := uint(n[blockSize-1] & 0x3f)
+	n[blockSize-1] &^= 0x3f
+
+	var stretch [blockSize + 8]byte
+	o.block.Encrypt(stretch[:blockSize], n[:])
+	for i := 0; i < 8; i++ {
+		stretch[blockSize+i] = stretch[i] ^ stretch[i+1]
+	}
+
+	byteShift, bitShift := bottom/8, bottom%8
+	for i := range offset {
+		offset[i] = stretch[byteShift+uint(i)] << bitShift
+		if bitShift != 0 {
+			offset[i] |= stretch[byteShift+uint(i)+1] >> (8 - bitShift)
+		}
+	}
+}
+
+// hash XORs HASH(K, A) from RFC 7253, section 4.1, into sum.
+func (o *ocb) hash(sum *[blockSize]byte, additionalData []byte) {
+	var offset, tmp [blockSize]byte
+
+	i := 1
+	for ; len(additionalData) >= blockSize; i++ {
+		xorBlock(offset[:], offset[:], o.l[ntz(i)][:])
+		xorBlock(tmp[:], additionalData, offset[:])
+		o.block.Encrypt(tmp[:], tmp[:])
+		xorBlock(sum[:], sum[:], tmp[:])
+		additionalData = additionalData[blockSize:]
+	}
+
+	if len(additionalData) > 0 {
+		xorBlock(offset[:], offset[:], o.lStar[:])
+		tmp = [blockSize]byte{}
+		copy(tmp[:], additionalData)
+		tmp[len(additionalData)] = 0x80
+		xorBlock(tmp[:], tmp[:], offset[:])
+		o.block.Encrypt(tmp[:], tmp[:])
+		xorBlock(sum[:], sum[:], tmp[:])
+	}
+}
+
+// double sets out to in·x in GF(2¹²⁸), as defined in RFC 7253, section 2.
+func double(out, in *[blockSize]byte) {
+	msb := in[0] >> 7
+	for i := 0; i < blockSize-1; i++ {
+		out[i] = in[i]<<1 | in[i+1]>>7
+	}
+	out[blockSize-1] = in[blockSize-1]<<1 ^ (0x87 & -msb)
+}
+
+// ntz returns the number of trailing zero bits in i, which must be positive.
+func ntz(i int) int {
+	n := 0
+	for i&1 == 0 {
+		i >>= 1
+		n++
+	}
+	return n
+}
+
+// xorBlock sets dst to the XOR of the first blockSize bytes of a and b.
+func xorBlock(dst, a, b []byte) {
+	for i := 0; i < blockSize; i++ {
+		dst[i] = a[i] ^ b[i]
+	}
+}
+
+// sliceForAppend takes a slice and a requested number of bytes. It returns a
+// slice with the contents of the given slice followed by that many bytes and a
+// second slice that aliases into it and contains only the extra bytes. If the
+// original slice has sufficient capacity then no allocation is performed.
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return
+}
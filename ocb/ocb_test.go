@@ -0,0 +1,138 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ocb
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+// These test vectors have been taken from RFC 7253, Appendix A.
+var ocbTestVectors = []struct {
+	nonce, ad, plaintext, ciphertext string
+}{
+	{
+		"bbaa99887766554433221100",
+		"",
+		"",
+		"785407bfffc8ad9edcc5520ac9111ee6",
+	}, {
+		"bbaa99887766554433221101",
+		"0001020304050607",
+		"0001020304050607",
+		"6820b3657b6f615a5725bda0d3b4eb3a257c9af1f8f03009",
+	}, {
+		"bbaa99887766554433221102",
+		"0001020304050607",
+		"",
+		"81017f8203f081277152fade694a0a00",
+	}, {
+		"bbaa99887766554433221103",
+		"",
+		"0001020304050607",
+		"45dd69f8f5aae72414054cd1f35d82760b2cd00d2f99bfa9",
+	}, {
+		"bbaa99887766554433221104",
+		"000102030405060708090a0b0c0d0e0f",
+		"000102030405060708090a0b0c0d0e0f",
+		"571d535b60b277188be5147170a9a22c3ad7a4ff3835b8c5701c1ccec8fc3358",
+	},
+}
+
+func TestOCB(t *testing.T) {
+	key, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := NewOCB(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, test := range ocbTestVectors {
+		nonce, _ := hex.DecodeString(test.nonce)
+		ad, _ := hex.DecodeString(test.ad)
+		plaintext, _ := hex.DecodeString(test.plaintext)
+		expected, _ := hex.DecodeString(test.ciphertext)
+
+		ciphertext := aead.Seal(nil, nonce, plaintext, ad)
+		if !bytes.Equal(ciphertext, expected) {
+			t.Errorf("#%d: Seal got %x, want %x", i, ciphertext, expected)
+			continue
+		}
+
+		decrypted, err := aead.Open(nil, nonce, ciphertext, ad)
+		if err != nil {
+			t.Errorf("#%d: Open failed: %s", i, err)
+			continue
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("#%d: Open got %x, want %x", i, decrypted, plaintext)
+		}
+
+		ciphertext[0] ^= 0x80
+		if _, err := aead.Open(nil, nonce, ciphertext, ad); err == nil {
+			t.Errorf("#%d: Open accepted a corrupted ciphertext", i)
+		}
+	}
+}
+
+// TestOCBIterated runs the tag length test of RFC 7253, Appendix A, which
+// covers long messages and truncated tags.
+func TestOCBIterated(t *testing.T) {
+	tests := []struct {
+		tagSize  int
+		expected string
+	}{
+		{16, "67e944d23256c5e0b6c61fa22fdf1ea2"},
+		{12, "77a3d8e73589158d25d01209"},
+		{8, "192c9b7bd90ba06a"},
+	}
+
+	for _, test := range tests {
+		key := make([]byte, 16)
+		key[15] = byte(test.tagSize * 8)
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		aead, err := NewOCBWithNonceAndTagSize(block, 12, test.tagSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		nonce := make([]byte, 12)
+		setNonce := func(n int) []byte {
+			binary.BigEndian.PutUint32(nonce[8:], uint32(n))
+			return nonce
+		}
+
+		var c []byte
+		for i := 0; i < 128; i++ {
+			s := make([]byte, i)
+			c = aead.Seal(c, setNonce(3*i+1), s, s)
+			c = aead.Seal(c, setNonce(3*i+2), s, nil)
+			c = aead.Seal(c, setNonce(3*i+3), nil, s)
+		}
+		out := aead.Seal(nil, setNonce(385), nil, c)
+
+		if got := hex.EncodeToString(out); got != test.expected {
+			t.Errorf("tag size %d: got %s, want %s", test.tagSize, got, test.expected)
+		}
+	}
+}
+
+func TestOCBInvalidSizes(t *testing.T) {
+	block, _ := aes.NewCipher(make([]byte, 16))
+	for _, sizes := range [][2]int{{0, 16}, {16, 16}, {12, 0}, {12, 17}} {
+		if _, err := NewOCBWithNonceAndTagSize(block, sizes[0], sizes[1]); err == nil {
+			t.Errorf("nonce size %d, tag size %d: expected error", sizes[0], sizes[1])
+		}
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"strings"
 	"unicode"
 
@@ -41,6 +42,10 @@ type Block struct {
 
 var ArmorCorrupt error = errors.StructuralError("armor invalid")
 
+// CRCMismatch is returned by DecodeLenient for a block whose body could be
+// decoded but doesn't match its checksum.
+var CRCMismatch error = errors.StructuralError("armor checksum mismatch")
+
 const crc24Init = 0xb704ce
 const crc24Poly = 0x1864cfb
 const crc24Mask = 0xffffff
@@ -218,6 +223,9 @@ type openpgpReader struct {
 	lReader    *lineReader
 	b64Reader  io.Reader
 	currentCRC uint32
+	// lenient makes a wrong checksum return CRCMismatch, with the last
+	// of the data, rather than ArmorCorrupt.
+	lenient bool
 }
 
 func (r *openpgpReader) Read(p []byte) (n int, err error) {
@@ -226,6 +234,9 @@ func (r *openpgpReader) Read(p []byte) (n int, err error) {
 
 	if err == io.EOF {
 		if r.lReader.crc != nil && *r.lReader.crc != uint32(r.currentCRC&crc24Mask) {
+			if r.lenient {
+				return n, CRCMismatch
+			}
 			return 0, ArmorCorrupt
 		}
 	}
@@ -238,7 +249,52 @@ func (r *openpgpReader) Read(p []byte) (n int, err error) {
 // given Reader is not usable after calling this function: an arbitrary amount
 // of data may have been read past the end of the block.
 func Decode(in io.Reader) (p *Block, err error) {
+	return decode(bufio.NewReaderSize(in, 100))
+}
+
+// DecodeAll reads all the PGP armored blocks that follow each other in the
+// given Reader. Unlike Decode, it reads the body of each block in full, so
+// that the checksum of every block has been verified once it returns. If no
+// block is found, it returns nil, io.EOF.
+func DecodeAll(in io.Reader) (blocks []*Block, err error) {
 	r := bufio.NewReaderSize(in, 100)
+	for {
+		p, err := decode(r)
+		if err == io.EOF && len(blocks) > 0 {
+			return blocks, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		body, err := ioutil.ReadAll(p.Body)
+		if err != nil {
+			return nil, err
+		}
+		p.Body = bytes.NewReader(body)
+		blocks = append(blocks, p)
+	}
+}
+
+// DecodeLenient is like Decode, but reads the body of the block in full and
+// doesn't reject it if it doesn't match its checksum, so that the payload of
+// a slightly damaged block can be recovered. The block is then returned
+// together with CRCMismatch. Bodies that aren't valid base64 are still
+// rejected.
+func DecodeLenient(in io.Reader) (p *Block, err error) {
+	p, err = decode(bufio.NewReaderSize(in, 100))
+	if err != nil {
+		return nil, err
+	}
+	p.oReader.lenient = true
+	body, err := ioutil.ReadAll(p.Body)
+	if err != nil && err != CRCMismatch {
+		return nil, err
+	}
+	p.Body = bytes.NewReader(body)
+	return p, err
+}
+
+func decode(r *bufio.Reader) (p *Block, err error) {
 	var line []byte
 	ignoreNext := false
 
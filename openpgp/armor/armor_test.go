@@ -58,6 +58,98 @@ func TestDecodeEncode(t *testing.T) {
 	}
 }
 
+func TestEncodeHeaders(t *testing.T) {
+	headers := []Header{
+		{"Comment", "User-ID: Golang Gopher <no-reply@golang.com>"},
+		{"Hash", "SHA256"},
+		{"Comment", "second comment"},
+	}
+	buf := new(bytes.Buffer)
+	w, err := EncodeHeaders(buf, "PGP MESSAGE", headers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("contents"))
+	w.Close()
+
+	const wantStart = "-----BEGIN PGP MESSAGE-----\n" +
+		"Comment: User-ID: Golang Gopher <no-reply@golang.com>\n" +
+		"Hash: SHA256\n" +
+		"Comment: second comment\n\n"
+	if !strings.HasPrefix(buf.String(), wantStart) {
+		t.Errorf("got:\n%s\nwant it to start with:\n%s", buf.String(), wantStart)
+	}
+
+	result, err := Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contents, err := ioutil.ReadAll(result.Body); err != nil || string(contents) != "contents" {
+		t.Errorf("got contents %q, %v", contents, err)
+	}
+
+	buf.Reset()
+	w, err = Encode(buf, "PGP MESSAGE", map[string]string{"Version": "1", "Comment": "c", "Hash": "SHA256"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	const wantSorted = "-----BEGIN PGP MESSAGE-----\nComment: c\nHash: SHA256\nVersion: 1\n\n"
+	if !strings.HasPrefix(buf.String(), wantSorted) {
+		t.Errorf("got:\n%s\nwant it to start with:\n%s", buf.String(), wantSorted)
+	}
+}
+
+func TestDecodeAllConcatenated(t *testing.T) {
+	payloads := []string{"first", "", strings.Repeat("third block ", 20)}
+
+	buf := new(bytes.Buffer)
+	for i, payload := range payloads {
+		w, err := Encode(buf, "PGP SIGNATURE", map[string]string{"Comment": fmt.Sprint(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(payload)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	armored := buf.String()
+
+	blocks, err := DecodeAll(strings.NewReader(armored))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != len(payloads) {
+		t.Fatalf("got %d blocks, want %d", len(blocks), len(payloads))
+	}
+	for i, block := range blocks {
+		if block.Type != "PGP SIGNATURE" || block.Header["Comment"] != fmt.Sprint(i) {
+			t.Errorf("#%d: bad block %q %#v", i, block.Type, block.Header)
+		}
+		contents, err := ioutil.ReadAll(block.Body)
+		if err != nil {
+			t.Errorf("#%d: %s", i, err)
+		}
+		if string(contents) != payloads[i] {
+			t.Errorf("#%d: got %q, want %q", i, contents, payloads[i])
+		}
+	}
+
+	// Corrupt the checksum of the second block.
+	crcs := strings.SplitAfter(armored, "\n=")
+	corrupted := crcs[0] + crcs[1] + "AAAA" + crcs[2][4:] + crcs[3]
+	if _, err := DecodeAll(strings.NewReader(corrupted)); err != ArmorCorrupt {
+		t.Errorf("got %v, want ArmorCorrupt", err)
+	}
+
+	if _, err := DecodeAll(strings.NewReader("no armor here")); err != io.EOF {
+		t.Errorf("got %v, want io.EOF", err)
+	}
+}
+
 func TestLongHeader(t *testing.T) {
 	buf := bytes.NewBuffer([]byte(armorLongLine))
 	result, err := Decode(buf)
@@ -222,6 +314,43 @@ func TestMalformedCRCs(t *testing.T) {
 	decodeAndReadFail(t, armorErrorText, stuffAfterChecksum2)
 }
 
+func TestDecodeLenient(t *testing.T) {
+	flipped := strings.Replace(armorExample1, "=/teI", "=/teJ", 1)
+	result, err := Decode(strings.NewReader(flipped))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(result.Body); err != ArmorCorrupt {
+		t.Fatalf("Decode: got %v, want ArmorCorrupt", err)
+	}
+
+	for _, test := range []struct {
+		armor   string
+		wantErr error
+	}{
+		{armorExample1, nil},
+		{flipped, CRCMismatch},
+		{strings.Replace(armorExample1, "=/teI\n", "", 1), nil},
+	} {
+		result, err := DecodeLenient(strings.NewReader(test.armor))
+		if err != test.wantErr {
+			t.Fatalf("got %v, want %v", err, test.wantErr)
+		}
+		contents, err := ioutil.ReadAll(result.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if adler32.Checksum(contents) != 0x27b144be {
+			t.Errorf("contents: got: %x", contents)
+		}
+	}
+
+	malformed := strings.Replace(armorExample1, "iJwE", "iJ!E", 1)
+	if result, err := DecodeLenient(strings.NewReader(malformed)); err == nil || err == CRCMismatch || result != nil {
+		t.Errorf("malformed base64: got %v", err)
+	}
+}
+
 const armorExample1 = `-----BEGIN PGP SIGNATURE-----
 Version: GnuPG v1.4.10 (GNU/Linux)
 
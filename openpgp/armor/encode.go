@@ -7,6 +7,7 @@ package armor
 import (
 	"encoding/base64"
 	"io"
+	"sort"
 )
 
 var armorHeaderSep = []byte(": ")
@@ -128,17 +129,38 @@ func (e *encoding) Close() (err error) {
 	return writeSlices(e.out, blockEnd, b64ChecksumBytes[:], newline, armorEnd, e.blockType, armorEndOfLine, []byte{'\n'})
 }
 
+// A Header is a single "Key: Value" line of the header of an armored block.
+type Header struct {
+	Key, Value string
+}
+
 // Encode returns a WriteCloser which will encode the data written to it in
-// OpenPGP armor.
+// OpenPGP armor. The headers are written sorted by key.
 func Encode(out io.Writer, blockType string, headers map[string]string) (w io.WriteCloser, err error) {
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	ordered := make([]Header, len(keys))
+	for i, k := range keys {
+		ordered[i] = Header{k, headers[k]}
+	}
+	return EncodeHeaders(out, blockType, ordered)
+}
+
+// EncodeHeaders is like Encode, but writes exactly the given headers, in
+// order. Keys may repeat, e.g. for several "Comment" lines.
+func EncodeHeaders(out io.Writer, blockType string, headers []Header) (w io.WriteCloser, err error) {
 	bType := []byte(blockType)
 	err = writeSlices(out, armorStart, bType, armorEndOfLineOut)
 	if err != nil {
 		return
 	}
 
-	for k, v := range headers {
-		err = writeSlices(out, []byte(k), armorHeaderSep, []byte(v), newline)
+	for _, h := range headers {
+		err = writeSlices(out, []byte(h.Key), armorHeaderSep, []byte(h.Value), newline)
 		if err != nil {
 			return
 		}
@@ -1,11 +1,9 @@
 package openpgp
 
 import (
-	"bytes"
 	"strings"
 	"testing"
 
-	"github.com/keybase/go-crypto/openpgp/clearsign"
 	"github.com/keybase/go-crypto/openpgp/errors"
 	"github.com/keybase/go-crypto/openpgp/packet"
 )
@@ -37,21 +35,8 @@ func TestBadElgamalSubkey(t *testing.T) {
 		t.Fatalf("Unexpected bad key PubKeyAlgo, expected 20, got %d", algo)
 	}
 
-	// When reading a signature produced by algo 20 key, checking
-	// should fail with UnsupportedError - signatures also have
-	// algorithm field, and  PubKeyAlgoBadElGamal is not recognized
-	// there. See signature_v3.go:parse.
-	b, _ := clearsign.Decode([]byte(clearsignMsg))
-	if b == nil {
-		t.Fatal("Failed to decode clearsign msg")
-	}
-	_, err = CheckDetachedSignature(entities, bytes.NewBuffer(b.Bytes), b.ArmoredSignature.Body)
-	if err == nil {
-		t.Fatal("Expected to see error when checking clearsign")
-	}
-	if _, ok := err.(errors.UnsupportedError); !ok {
-		t.Fatalf("Unexpected error type: %s", err)
-	}
+	// Checking a signature produced by the algo 20 key is tested by
+	// TestVerifyAndExtractBadElGamal in the clearsign package.
 }
 
 func TestBadElgamalPrimary(t *testing.T) {
@@ -93,21 +78,6 @@ ygm0xrHw5xEAoJonEzW5F3oDhft9cfKk4mR+QAnv
 =qGLg
 -----END PGP PUBLIC KEY BLOCK-----`
 
-const clearsignMsg = `-----BEGIN PGP SIGNED MESSAGE-----
-Hash: SHA1
-
-aaa
------BEGIN PGP SIGNATURE-----
-Version: GnuPG v1.2.0 (GNU/Linux)
-
-iNcDBQFaTOUbUXZ9JopEDEYUAmtOAv427hD+yJD5i8lv2HISIB4XnG5NQcX3HMbp
-4JzS/17T0PVzhbUaoguK4S4HbCy2TKDAiqFW+uTPVD2g/hDdz3iigdZC0q2qATfS
-F4cO0rBiZy0h/MadrW54md5VPd3cruQC/j9P1MQF1pzp1R8DKrI/aD2zUxzv3tR2
-5kMs9zLJFk+sEY3ppati3sUZpwukn4tNXsMVq5VUjKu81jUxr5Te/114gjbk6Oqo
-bvEOhvf8VAzGswfr7Ur2/KN0D5n1Zr5wmA==
-=yqX0
------END PGP SIGNATURE-----`
-
 const badPrimaryPublicKey = `-----BEGIN PGP PUBLIC KEY BLOCK-----
 Version: GnuPG v1.2.0 (GNU/Linux)
 
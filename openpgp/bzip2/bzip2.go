@@ -0,0 +1,416 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package bzip2 implements a bzip2 compressor, as the standard library only
+// provides a decompressor. The Burrows-Wheeler transform is computed from a
+// suffix array built in linear time, and each block is coded with a single
+// Huffman table. See
+// https://github.com/dsnet/compress/blob/master/doc/bzip2-format.pdf for a
+// description of the format.
+package bzip2 // import "github.com/keybase/go-crypto/openpgp/bzip2"
+
+import (
+	"container/heap"
+	"io"
+)
+
+const (
+	blockMagic = 0x314159265359
+	finalMagic = 0x177245385090
+	// groupSize is the number of symbols coded with each selected
+	// Huffman table.
+	groupSize = 50
+	// maxCodeLength is the longest Huffman code that we produce.
+	maxCodeLength = 17
+)
+
+var crcTable = func() (table [256]uint32) {
+	for i := range table {
+		c := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if c&0x80000000 != 0 {
+				c = c<<1 ^ 0x04c11db7
+			} else {
+				c <<= 1
+			}
+		}
+		table[i] = c
+	}
+	return
+}()
+
+// A Writer compresses the data written to it in the bzip2 format.
+type Writer struct {
+	bw bitWriter
+	// maxBlock is the number of bytes of a block after the initial run
+	// length encoding.
+	maxBlock int
+	block    []byte
+	// blockCRC and streamCRC are the CRCs of the uncompressed data in
+	// the current block and of all the blocks.
+	blockCRC, streamCRC uint32
+	// last is the last byte written to block and run the number of
+	// times it was repeated, up to four. After four repetitions, extra
+	// counts further repetitions, which are written as a single byte.
+	last       byte
+	run, extra int
+	closed     bool
+}
+
+// NewWriter returns a Writer compressing to w. The level, from 1 to 9, sets
+// the block size in units of 100k; other values select 9. The data is only
+// complete once Close has been called.
+func NewWriter(w io.Writer, level int) *Writer {
+	if level < 1 || level > 9 {
+		level = 9
+	}
+	z := &Writer{
+		bw:       bitWriter{w: w},
+		maxBlock: level*100000 - 19,
+		blockCRC: 0xffffffff,
+	}
+	z.bw.writeBits('B'<<24|'Z'<<16|'h'<<8|uint64('0'+level), 32)
+	return z
+}
+
+// Write compresses p. The error, if any, is that of writing to the
+// underlying io.Writer.
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, io.ErrClosedPipe
+	}
+	for _, b := range p {
+		if z.run == 4 && b == z.last && z.extra < 251 {
+			z.blockCRC = z.blockCRC<<8 ^ crcTable[byte(z.blockCRC>>24)^b]
+			z.extra++
+			continue
+		}
+		// Leave room for the count of a finished run and b.
+		if len(z.block)+2 > z.maxBlock {
+			z.writeBlock()
+		}
+		z.blockCRC = z.blockCRC<<8 ^ crcTable[byte(z.blockCRC>>24)^b]
+		if z.run == 4 {
+			z.block = append(z.block, byte(z.extra))
+			z.run = 0
+		}
+		if z.run > 0 && b == z.last {
+			z.run++
+		} else {
+			z.last, z.run = b, 1
+		}
+		z.extra = 0
+		z.block = append(z.block, b)
+	}
+	return len(p), z.bw.err
+}
+
+// Close writes out the buffered data and the end of the stream. It does not
+// close the underlying io.Writer.
+func (z *Writer) Close() error {
+	if z.closed {
+		return z.bw.err
+	}
+	z.closed = true
+	if len(z.block) > 0 {
+		z.writeBlock()
+	}
+	z.bw.writeBits(finalMagic, 48)
+	z.bw.writeBits(uint64(z.streamCRC), 32)
+	z.bw.flush()
+	return z.bw.err
+}
+
+// writeBlock compresses and writes z.block and starts a new block.
+func (z *Writer) writeBlock() {
+	if z.run == 4 {
+		z.block = append(z.block, byte(z.extra))
+	}
+	crc := ^z.blockCRC
+	z.streamCRC = (z.streamCRC<<1 | z.streamCRC>>31) ^ crc
+
+	bwt, origPtr := transform(z.block)
+
+	var inUse [256]bool
+	for _, b := range z.block {
+		inUse[b] = true
+	}
+	var seqToUnseq []byte
+	var unseqToSeq [256]byte
+	for i, used := range inUse {
+		if used {
+			unseqToSeq[i] = byte(len(seqToUnseq))
+			seqToUnseq = append(seqToUnseq, byte(i))
+		}
+	}
+	alphaSize := len(seqToUnseq) + 2
+	symbols := moveToFront(bwt, unseqToSeq[:], len(seqToUnseq))
+
+	freqs := make([]int, alphaSize)
+	for _, s := range symbols {
+		freqs[s]++
+	}
+	lengths := codeLengths(freqs)
+	codes := canonicalCodes(lengths)
+
+	bw := &z.bw
+	bw.writeBits(blockMagic, 48)
+	bw.writeBits(uint64(crc), 32)
+	bw.writeBits(0, 1) // not randomised
+	bw.writeBits(uint64(origPtr), 24)
+
+	var ranges uint64
+	for i := 0; i < 16; i++ {
+		for _, used := range inUse[i*16 : i*16+16] {
+			if used {
+				ranges |= 1 << uint(15-i)
+				break
+			}
+		}
+	}
+	bw.writeBits(ranges, 16)
+	for i := 0; i < 16; i++ {
+		if ranges&(1<<uint(15-i)) == 0 {
+			continue
+		}
+		var bits uint64
+		for j, used := range inUse[i*16 : i*16+16] {
+			if used {
+				bits |= 1 << uint(15-j)
+			}
+		}
+		bw.writeBits(bits, 16)
+	}
+
+	// At least two tables are required, but all symbols are coded with
+	// the first one, so every selector is a zero bit.
+	const numTables = 2
+	numSelectors := (len(symbols) + groupSize - 1) / groupSize
+	bw.writeBits(numTables, 3)
+	bw.writeBits(uint64(numSelectors), 15)
+	for i := 0; i < numSelectors; i++ {
+		bw.writeBits(0, 1)
+	}
+	for t := 0; t < numTables; t++ {
+		cur := lengths[0]
+		bw.writeBits(uint64(cur), 5)
+		for _, l := range lengths {
+			for ; cur < l; cur++ {
+				bw.writeBits(2, 2)
+			}
+			for ; cur > l; cur-- {
+				bw.writeBits(3, 2)
+			}
+			bw.writeBits(0, 1)
+		}
+	}
+
+	for _, s := range symbols {
+		bw.writeBits(uint64(codes[s]), uint(lengths[s]))
+	}
+
+	z.block = z.block[:0]
+	z.blockCRC = 0xffffffff
+	z.run, z.extra = 0, 0
+}
+
+// transform returns the Burrows-Wheeler transform of block, the last
+// column of its sorted rotations, and the index of the rotation starting
+// at 0. The rotations are the first len(block) bytes of the suffixes of
+// block repeated twice that start in its first copy, and so are sorted by
+// sorting those suffixes. Rotations that are equal sort in any order, as
+// they have the same last byte and any of them can start the inverse
+// transform.
+func transform(block []byte) ([]byte, int) {
+	n := len(block)
+	text := make([]int32, 2*n)
+	for i, b := range block {
+		text[i] = int32(b)
+		text[n+i] = int32(b)
+	}
+	sa := suffixArray(text, 255)
+
+	bwt := make([]byte, 0, n)
+	origPtr := 0
+	for _, start := range sa {
+		if int(start) >= n {
+			continue
+		}
+		if start == 0 {
+			origPtr = len(bwt)
+		}
+		bwt = append(bwt, block[(int(start)+n-1)%n])
+	}
+	return bwt, origPtr
+}
+
+// moveToFront applies the move-to-front transform to bwt and codes the
+// runs of zeros with the RUNA (0) and RUNB (1) symbols. The other symbols
+// are the move-to-front indexes plus one, followed by the end of block
+// symbol.
+func moveToFront(bwt []byte, unseqToSeq []byte, numInUse int) []uint16 {
+	var list [256]byte
+	for i := range list {
+		list[i] = byte(i)
+	}
+	var symbols []uint16
+	zeros := 0
+	flushZeros := func() {
+		if zeros == 0 {
+			return
+		}
+		zeros--
+		for {
+			symbols = append(symbols, uint16(zeros&1))
+			if zeros < 2 {
+				break
+			}
+			zeros = (zeros - 2) / 2
+		}
+		zeros = 0
+	}
+	for _, b := range bwt {
+		seq := unseqToSeq[b]
+		pos := 0
+		for list[pos] != seq {
+			pos++
+		}
+		if pos == 0 {
+			zeros++
+			continue
+		}
+		flushZeros()
+		copy(list[1:pos+1], list[:pos])
+		list[0] = seq
+		symbols = append(symbols, uint16(pos+1))
+	}
+	flushZeros()
+	return append(symbols, uint16(numInUse+1))
+}
+
+// codeLengths returns Huffman code lengths, of at most maxCodeLength bits,
+// for symbols with the given frequencies. Unused symbols get a code too, as
+// the format requires.
+func codeLengths(freqs []int) []uint8 {
+	weights := make([]int, len(freqs))
+	for i, f := range freqs {
+		weights[i] = f + 1
+	}
+	for {
+		lengths := huffmanLengths(weights)
+		max := uint8(0)
+		for _, l := range lengths {
+			if l > max {
+				max = l
+			}
+		}
+		if max <= maxCodeLength {
+			return lengths
+		}
+		// Flatten the distribution and try again.
+		for i := range weights {
+			weights[i] = weights[i]/2 + 1
+		}
+	}
+}
+
+type huffmanNode struct {
+	weight      int
+	left, right *huffmanNode
+	symbol      int
+}
+
+type huffmanHeap []*huffmanNode
+
+func (h huffmanHeap) Len() int            { return len(h) }
+func (h huffmanHeap) Less(i, j int) bool  { return h[i].weight < h[j].weight }
+func (h huffmanHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *huffmanHeap) Push(x interface{}) { *h = append(*h, x.(*huffmanNode)) }
+func (h *huffmanHeap) Pop() interface{} {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
+
+// huffmanLengths returns the code lengths of a Huffman code for symbols of
+// the given, non-zero, weights.
+func huffmanLengths(weights []int) []uint8 {
+	h := make(huffmanHeap, len(weights))
+	for i, w := range weights {
+		h[i] = &huffmanNode{weight: w, symbol: i}
+	}
+	heap.Init(&h)
+	for h.Len() > 1 {
+		a := heap.Pop(&h).(*huffmanNode)
+		b := heap.Pop(&h).(*huffmanNode)
+		heap.Push(&h, &huffmanNode{weight: a.weight + b.weight, left: a, right: b})
+	}
+
+	lengths := make([]uint8, len(weights))
+	var walk func(n *huffmanNode, depth uint8)
+	walk = func(n *huffmanNode, depth uint8) {
+		if n.left == nil {
+			lengths[n.symbol] = depth
+			return
+		}
+		walk(n.left, depth+1)
+		walk(n.right, depth+1)
+	}
+	walk(h[0], 0)
+	return lengths
+}
+
+// canonicalCodes assigns canonical Huffman codes for the given code lengths.
+func canonicalCodes(lengths []uint8) []uint32 {
+	codes := make([]uint32, len(lengths))
+	code := uint32(0)
+	for l := uint8(1); l <= maxCodeLength; l++ {
+		for i, length := range lengths {
+			if length == l {
+				codes[i] = code
+				code++
+			}
+		}
+		code <<= 1
+	}
+	return codes
+}
+
+// bitWriter writes bits, most significant first, to w.
+type bitWriter struct {
+	w     io.Writer
+	bits  uint64
+	nBits uint
+	buf   []byte
+	err   error
+}
+
+// writeBits writes the n least significant bits of v, with n at most 48.
+func (bw *bitWriter) writeBits(v uint64, n uint) {
+	bw.bits = bw.bits<<n | v&(1<<n-1)
+	bw.nBits += n
+	for bw.nBits >= 8 {
+		bw.nBits -= 8
+		bw.buf = append(bw.buf, byte(bw.bits>>bw.nBits))
+	}
+	if len(bw.buf) >= 4096 {
+		bw.flushBytes()
+	}
+}
+
+// flush writes out the pending bits, padded with zeros to a byte.
+func (bw *bitWriter) flush() {
+	if bw.nBits > 0 {
+		bw.writeBits(0, 8-bw.nBits)
+	}
+	bw.flushBytes()
+}
+
+func (bw *bitWriter) flushBytes() {
+	if bw.err == nil && len(bw.buf) > 0 {
+		_, bw.err = bw.w.Write(bw.buf)
+	}
+	bw.buf = bw.buf[:0]
+}
@@ -0,0 +1,113 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bzip2
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	random := make([]byte, 150000)
+	rand.New(rand.NewSource(1)).Read(random)
+	var runs []byte
+	for i := 0; len(runs) < 250000; i++ {
+		runs = append(runs, bytes.Repeat([]byte{byte(i)}, i%300)...)
+	}
+	inputs := [][]byte{
+		nil,
+		[]byte("a"),
+		[]byte("hello, world\n"),
+		bytes.Repeat([]byte("a"), 1000),
+		bytes.Repeat([]byte("ab"), 100000),
+		random,
+		runs,
+	}
+
+	for _, level := range []int{1, 9} {
+		for i, input := range inputs {
+			buf := new(bytes.Buffer)
+			w := NewWriter(buf, level)
+			if _, err := w.Write(input); err != nil {
+				t.Fatalf("level %d, #%d: %s", level, i, err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("level %d, #%d: %s", level, i, err)
+			}
+			contents, err := ioutil.ReadAll(bzip2.NewReader(buf))
+			if err != nil {
+				t.Errorf("level %d, #%d: %s", level, i, err)
+				continue
+			}
+			if !bytes.Equal(contents, input) {
+				t.Errorf("level %d, #%d: got %d bytes back, want %d", level, i, len(contents), len(input))
+			}
+		}
+	}
+}
+
+func TestSuffixArray(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		upper := int32(1 + rnd.Intn(4))
+		if i%10 == 0 {
+			upper = 255
+		}
+		text := make([]int32, rnd.Intn(100))
+		for j := range text {
+			text[j] = rnd.Int31n(upper + 1)
+		}
+
+		want := make([]int32, len(text))
+		for j := range want {
+			want[j] = int32(j)
+		}
+		sort.Slice(want, func(a, b int) bool {
+			x, y := text[want[a]:], text[want[b]:]
+			for k := 0; k < len(x) && k < len(y); k++ {
+				if x[k] != y[k] {
+					return x[k] < y[k]
+				}
+			}
+			return len(x) < len(y)
+		})
+
+		got := suffixArray(text, upper)
+		if len(got) != len(want) {
+			t.Fatalf("%v: got %v, want %v", text, got, want)
+		}
+		for j := range got {
+			if got[j] != want[j] {
+				t.Fatalf("%v: got %v, want %v", text, got, want)
+			}
+		}
+	}
+}
+
+func TestTransform(t *testing.T) {
+	// The example from Burrows and Wheeler's report.
+	bwt, origPtr := transform([]byte("abraca"))
+	if string(bwt) != "caraab" || origPtr != 1 {
+		t.Errorf("got %q, %d, want \"caraab\", 1", bwt, origPtr)
+	}
+}
+
+func BenchmarkWriter(b *testing.B) {
+	data := make([]byte, 900000)
+	rnd := rand.New(rand.NewSource(1))
+	for i := range data {
+		data[i] = "abcd"[rnd.Intn(4)]
+	}
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		w := NewWriter(ioutil.Discard, 9)
+		w.Write(data)
+		w.Close()
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2026 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bzip2
+
+// suffixArray returns the suffix array of text, whose symbols are between
+// 0 and upper inclusive, using the SA-IS algorithm of Nong, Zhang and Chan,
+// "Two Efficient Algorithms for Linear Time Suffix Array Construction".
+//
+// Suffixes are classified as S-type if they are smaller than the suffix
+// that follows them and L-type otherwise. The leftmost S-type suffixes
+// (LMS), those preceded by an L-type suffix, are sorted recursively, and
+// the order of all other suffixes is then induced from theirs.
+func suffixArray(text []int32, upper int32) []int32 {
+	n := len(text)
+	switch n {
+	case 0:
+		return nil
+	case 1:
+		return []int32{0}
+	case 2:
+		if text[0] < text[1] {
+			return []int32{0, 1}
+		}
+		return []int32{1, 0}
+	}
+
+	sa := make([]int32, n)
+	// isS records the type of each suffix. The last suffix is L-type, as
+	// the empty suffix after it is smaller.
+	isS := make([]bool, n)
+	for i := n - 2; i >= 0; i-- {
+		if text[i] == text[i+1] {
+			isS[i] = isS[i+1]
+		} else {
+			isS[i] = text[i] < text[i+1]
+		}
+	}
+
+	// Within the bucket of each symbol, L-type suffixes come before
+	// S-type ones. startL[c] and startS[c] are where they begin.
+	startL := make([]int32, upper+2)
+	startS := make([]int32, upper+2)
+	for i, c := range text {
+		if !isS[i] {
+			startS[c]++
+		} else {
+			startL[c+1]++
+		}
+	}
+	for c := int32(0); c <= upper; c++ {
+		startS[c] += startL[c]
+		if c < upper {
+			startL[c+1] += startS[c]
+		}
+	}
+
+	bucket := make([]int32, upper+2)
+	induce := func(lms []int32) {
+		for i := range sa {
+			sa[i] = -1
+		}
+		copy(bucket, startS)
+		for _, p := range lms {
+			sa[bucket[text[p]]] = p
+			bucket[text[p]]++
+		}
+		// Induce the L-type suffixes from left to right, starting with
+		// the last suffix...
+		copy(bucket, startL)
+		sa[bucket[text[n-1]]] = int32(n - 1)
+		bucket[text[n-1]]++
+		for i := 0; i < n; i++ {
+			if p := sa[i]; p >= 1 && !isS[p-1] {
+				sa[bucket[text[p-1]]] = p - 1
+				bucket[text[p-1]]++
+			}
+		}
+		// ... and then the S-type ones from right to left, filling
+		// each bucket from its end, which is where the next one
+		// starts.
+		copy(bucket, startL)
+		for i := n - 1; i >= 0; i-- {
+			if p := sa[i]; p >= 1 && isS[p-1] {
+				bucket[text[p-1]+1]--
+				sa[bucket[text[p-1]+1]] = p - 1
+			}
+		}
+	}
+
+	// lmsIndex maps the position of each LMS suffix to its rank among
+	// them in text order, and every other position to -1.
+	lmsIndex := make([]int32, n)
+	var lms []int32
+	for i := range lmsIndex {
+		lmsIndex[i] = -1
+		if i > 0 && !isS[i-1] && isS[i] {
+			lmsIndex[i] = int32(len(lms))
+			lms = append(lms, int32(i))
+		}
+	}
+
+	// Inducing from the LMS suffixes in text order sorts the LMS
+	// substrings, from one LMS position to the next, correctly.
+	induce(lms)
+	if len(lms) == 0 {
+		return sa
+	}
+
+	m := len(lms)
+	sorted := make([]int32, 0, m)
+	for _, p := range sa {
+		if lmsIndex[p] != -1 {
+			sorted = append(sorted, p)
+		}
+	}
+
+	// Name the LMS substrings by their rank, equal substrings getting
+	// the same name, and sort the LMS suffixes by sorting the string of
+	// names.
+	substringEnd := func(p int32) int {
+		if k := lmsIndex[p] + 1; int(k) < m {
+			return int(lms[k])
+		}
+		return n
+	}
+	names := make([]int32, m)
+	name := int32(0)
+	names[lmsIndex[sorted[0]]] = 0
+	for i := 1; i < m; i++ {
+		l, r := int(sorted[i-1]), int(sorted[i])
+		endL, endR := substringEnd(sorted[i-1]), substringEnd(sorted[i])
+		same := endL-l == endR-r
+		if same {
+			for l < endL && text[l] == text[r] {
+				l++
+				r++
+			}
+			same = l < n && r < n && text[l] == text[r]
+		}
+		if !same {
+			name++
+		}
+		names[lmsIndex[sorted[i]]] = name
+	}
+
+	for i, p := range suffixArray(names, name) {
+		sorted[i] = lms[p]
+	}
+	induce(sorted)
+	return sa
+}
@@ -4,7 +4,10 @@
 
 package openpgp
 
-import "hash"
+import (
+	"hash"
+	"io"
+)
 
 // NewCanonicalTextHash reformats text written to it into the canonical
 // form and then applies the hash h.  See RFC 4880, section 5.2.1.
@@ -20,25 +23,61 @@ type canonicalTextHash struct {
 var newline = []byte{'\r', '\n'}
 
 func (cth *canonicalTextHash) Write(buf []byte) (int, error) {
+	writeCanonical(cth.h, buf, &cth.s)
+	return len(buf), nil
+}
+
+// canonicalTextWriter reformats text written to it into the canonical form
+// and passes it on to w.
+type canonicalTextWriter struct {
+	w io.Writer
+	s int
+}
+
+func (ctw *canonicalTextWriter) Write(buf []byte) (int, error) {
+	if err := writeCanonical(ctw.w, buf, &ctw.s); err != nil {
+		return 0, err
+	}
+	return len(buf), nil
+}
+
+// canonicalTextWriteCloser is a canonicalTextWriter that closes the
+// underlying writer when closed.
+type canonicalTextWriteCloser struct {
+	canonicalTextWriter
+	io.Closer
+}
+
+func newCanonicalTextWriteCloser(w io.WriteCloser) *canonicalTextWriteCloser {
+	return &canonicalTextWriteCloser{canonicalTextWriter{w: w}, w}
+}
+
+// writeCanonical writes buf to w with its line endings made CRLF. s holds
+// the state between calls.
+func writeCanonical(w io.Writer, buf []byte, s *int) error {
 	start := 0
 
 	for i, c := range buf {
-		switch cth.s {
+		switch *s {
 		case 0:
 			if c == '\r' {
-				cth.s = 1
+				*s = 1
 			} else if c == '\n' {
-				cth.h.Write(buf[start:i])
-				cth.h.Write(newline)
+				if _, err := w.Write(buf[start:i]); err != nil {
+					return err
+				}
+				if _, err := w.Write(newline); err != nil {
+					return err
+				}
 				start = i + 1
 			}
 		case 1:
-			cth.s = 0
+			*s = 0
 		}
 	}
 
-	cth.h.Write(buf[start:])
-	return len(buf), nil
+	_, err := w.Write(buf[start:])
+	return err
 }
 
 func (cth *canonicalTextHash) Sum(in []byte) []byte {
@@ -18,6 +18,7 @@ import (
 	"net/textproto"
 	"strconv"
 
+	"github.com/keybase/go-crypto/openpgp"
 	"github.com/keybase/go-crypto/openpgp/armor"
 	"github.com/keybase/go-crypto/openpgp/errors"
 	"github.com/keybase/go-crypto/openpgp/packet"
@@ -165,6 +166,21 @@ func Decode(data []byte) (b *Block, rest []byte) {
 	return b, rest
 }
 
+// VerifyAndExtract finds the first clearsigned message in data, checks its
+// signature with the keys in keyring and returns the message text, as in
+// Block.Plaintext, and the signer.
+func VerifyAndExtract(data []byte, keyring openpgp.KeyRing) (body []byte, signer *openpgp.Entity, err error) {
+	b, _ := Decode(data)
+	if b == nil {
+		return nil, nil, errors.StructuralError("no clearsigned message found")
+	}
+	signer, err = openpgp.CheckDetachedSignature(keyring, bytes.NewReader(b.Bytes), b.ArmoredSignature.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b.Plaintext, signer, nil
+}
+
 // A dashEscaper is an io.WriteCloser which processes the body of a clear-signed
 // message. The clear-signed message is written to buffered and a hash, suitable
 // for signing, is maintained in h.
@@ -265,7 +281,9 @@ func (d *dashEscaper) Close() (err error) {
 			return
 		}
 	}
+	// The hash was started before the signature, so it can't be salted.
 	sig := new(packet.Signature)
+	sig.Version = 4
 	sig.SigType = packet.SigTypeText
 	sig.PubKeyAlgo = d.privateKey.PubKeyAlgo
 	sig.Hash = d.hashType
@@ -367,6 +385,10 @@ func nameOfHash(h crypto.Hash) string {
 		return "SHA384"
 	case crypto.SHA512:
 		return "SHA512"
+	case crypto.SHA3_256:
+		return "SHA3-256"
+	case crypto.SHA3_512:
+		return "SHA3-512"
 	}
 	return ""
 }
@@ -6,7 +6,9 @@
 package errors // import "github.com/keybase/go-crypto/openpgp/errors"
 
 import (
+	"io"
 	"strconv"
+	"strings"
 )
 
 // A StructuralError is returned when OpenPGP data is found to be syntactically
@@ -17,6 +19,74 @@ func (s StructuralError) Error() string {
 	return "openpgp: invalid data: " + string(s)
 }
 
+// WithDetail returns s followed by ": " and the given detail and cause,
+// either of which may be empty. If cause is itself a StructuralError it
+// comes right after s, so that errors.Is still matches its sentinels.
+func (s StructuralError) WithDetail(detail string, cause error) StructuralError {
+	if c, ok := cause.(StructuralError); ok {
+		s += ": " + c
+		cause = nil
+	}
+	if detail != "" {
+		s += StructuralError(": " + detail)
+	}
+	if cause != nil {
+		s += StructuralError(": " + cause.Error())
+	}
+	return s
+}
+
+// Is reports whether target is a StructuralError that s starts with,
+// followed by ": " and further detail if s is longer. The sentinels below
+// that s starts with are skipped over first, so that errors.Is matches each
+// of the sentinels that WithDetail chained together, but never a sentinel
+// quoted in the detail.
+func (s StructuralError) Is(target error) bool {
+	t, ok := target.(StructuralError)
+	if !ok || len(t) == 0 {
+		return false
+	}
+	for {
+		if s == t || strings.HasPrefix(string(s), string(t)+": ") {
+			return true
+		}
+		next := s
+		for _, sentinel := range sentinels {
+			if strings.HasPrefix(string(s), string(sentinel)+": ") {
+				next = s[len(sentinel)+2:]
+				break
+			}
+		}
+		if next == s {
+			return false
+		}
+		s = next
+	}
+}
+
+// Structural failures found while reading or validating a key. The errors
+// returned for them are StructuralErrors that may carry more detail, and so
+// should be compared using errors.Is.
+var (
+	ErrNoIdentities          = StructuralError("entity without any identities")
+	ErrBadSelfSignature      = StructuralError("identity without a valid self-signature")
+	ErrMissingSubkeyBinding  = StructuralError("subkey without a binding signature")
+	ErrBadSubkeySignature    = StructuralError("subkey signature invalid")
+	ErrMissingCrossSignature = StructuralError("signing subkey is missing cross-signature")
+	ErrBadCrossSignature     = StructuralError("invalid cross-signature")
+	ErrWeakHash              = StructuralError("signature uses a weak hash")
+)
+
+var sentinels = []StructuralError{
+	ErrNoIdentities,
+	ErrBadSelfSignature,
+	ErrMissingSubkeyBinding,
+	ErrBadSubkeySignature,
+	ErrMissingCrossSignature,
+	ErrBadCrossSignature,
+	ErrWeakHash,
+}
+
 // UnsupportedError indicates that, although the OpenPGP data is valid, it
 // makes use of currently unimplemented features.
 type UnsupportedError string
@@ -49,6 +119,21 @@ func (ki keyIncorrectError) Error() string {
 
 var ErrKeyIncorrect error = keyIncorrectError(0)
 
+type truncatedError int
+
+func (truncatedError) Error() string {
+	return "openpgp: invalid signature: message truncated"
+}
+
+func (truncatedError) Unwrap() error {
+	return io.ErrUnexpectedEOF
+}
+
+// ErrTruncated is returned when an integrity protected message ends before
+// its modification detection code has been read. It wraps
+// io.ErrUnexpectedEOF.
+var ErrTruncated error = truncatedError(0)
+
 type unknownIssuerError int
 
 func (unknownIssuerError) Error() string {
@@ -65,6 +150,47 @@ func (keyRevokedError) Error() string {
 
 var ErrKeyRevoked error = keyRevokedError(0)
 
+type signatureExpiredError int
+
+func (signatureExpiredError) Error() string {
+	return "openpgp: signature expired"
+}
+
+// ErrSignatureExpired is reported by ReadMessage for a signature that is
+// valid but whose expiration time has passed.
+var ErrSignatureExpired error = signatureExpiredError(0)
+
+type signatureNotYetValidError int
+
+func (signatureNotYetValidError) Error() string {
+	return "openpgp: signature is not yet valid"
+}
+
+// ErrSignatureNotYetValid is reported for a signature whose creation time
+// is later than the current time, beyond the clock skew allowed by
+// Config.MaxClockSkew.
+var ErrSignatureNotYetValid error = signatureNotYetValidError(0)
+
+type messageNotSignedError int
+
+func (messageNotSignedError) Error() string {
+	return "openpgp: message is not signed"
+}
+
+// ErrMessageNotSigned is returned by ReadMessage when the configuration
+// requires a signed message and the message carries no signature.
+var ErrMessageNotSigned error = messageNotSignedError(0)
+
+type messageNotEncryptedError int
+
+func (messageNotEncryptedError) Error() string {
+	return "openpgp: message is not encrypted"
+}
+
+// ErrMessageNotEncrypted is returned by ReadMessage when the configuration
+// requires an encrypted message and the message is in the clear.
+var ErrMessageNotEncrypted error = messageNotEncryptedError(0)
+
 type UnknownPacketTypeError uint8
 
 func (upte UnknownPacketTypeError) Error() string {
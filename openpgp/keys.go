@@ -5,14 +5,25 @@
 package openpgp
 
 import (
+	"bytes"
+	"crypto"
 	"crypto/hmac"
 	"encoding/binary"
+	"fmt"
 	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
 	"time"
 
+	"github.com/keybase/go-crypto/curve25519"
+	"github.com/keybase/go-crypto/ed25519"
 	"github.com/keybase/go-crypto/openpgp/armor"
+	"github.com/keybase/go-crypto/openpgp/ecdh"
 	"github.com/keybase/go-crypto/openpgp/errors"
 	"github.com/keybase/go-crypto/openpgp/packet"
+	"github.com/keybase/go-crypto/openpgp/s2k"
 	"github.com/keybase/go-crypto/rsa"
 )
 
@@ -35,8 +46,23 @@ type Entity struct {
 	// issuers' public keys, API consumers should do this instead (or
 	// not, and just assume that the key is probably revoked).
 	UnverifiedRevocations []*packet.Signature
-	Subkeys               []Subkey
-	BadSubkeys            []BadSubkey
+	// DesignatedRevokers lists the keys that the owner has authorized,
+	// in a direct key signature, to revoke this key. See RFC 4880,
+	// section 5.2.3.15, and ApplyDesignatedRevocation.
+	DesignatedRevokers []*packet.RevocationKey
+	Subkeys            []Subkey
+	BadSubkeys         []BadSubkey
+	// BadIdentities holds the identities that were left out of
+	// Identities because their self-signatures were rejected by the
+	// policy that the entity was read with.
+	BadIdentities []BadIdentity
+	// Attributes holds the user attributes, such as photos, that carry a
+	// valid self-signature.
+	Attributes []*UserAttribute
+	// UnknownPackets holds the packets of unknown type, such as private
+	// or experimental ones, that were skipped while reading the entity.
+	// At most 64 KiB of their contents are kept.
+	UnknownPackets []*packet.OpaquePacket
 }
 
 // An Identity represents an identity claimed by an Entity and zero or more
@@ -49,6 +75,15 @@ type Identity struct {
 	Revocation    *packet.Signature
 }
 
+// A UserAttribute is a user attribute, usually a photo, of an Entity and zero
+// or more assertions by other entities about it. See RFC 4880, section 5.12.
+type UserAttribute struct {
+	UserAttribute *packet.UserAttribute
+	SelfSignature *packet.Signature
+	Signatures    []*packet.Signature
+	Revocation    *packet.Signature
+}
+
 // A Subkey is an additional public key in an Entity. Subkeys can be used for
 // encryption.
 type Subkey struct {
@@ -65,6 +100,13 @@ type BadSubkey struct {
 	Err error
 }
 
+// BadIdentity is an identity without an acceptable self-signature, kept
+// around, like BadSubkey, for informational purposes.
+type BadIdentity struct {
+	Identity
+	Err error
+}
+
 // A Key identifies a specific public key in an Entity. This is either the
 // Entity's primary key or a subkey.
 type Key struct {
@@ -75,6 +117,80 @@ type Key struct {
 	KeyFlags      packet.KeyFlagBits
 }
 
+// RevocationStatus describes whether a key has been revoked and why.
+type RevocationStatus struct {
+	// Revoked is true if the key has been revoked.
+	Revoked bool
+	// Reason is the reason code of the revocation, NoReason if the
+	// revocation signature didn't give one.
+	Reason packet.ReasonForRevocation
+	// ReasonText is the explanation that came with the reason code.
+	ReasonText string
+	// Signature is the revocation signature.
+	Signature *packet.Signature
+}
+
+// Compromised reports whether the key was revoked for a reason other than
+// being superseded or retired. Such a revocation, including one without a
+// reason, means that the key may be in the wrong hands, so even the
+// signatures it made before the revocation can't be trusted.
+func (rs RevocationStatus) Compromised() bool {
+	return rs.Revoked && rs.Reason != packet.KeySuperseded && rs.Reason != packet.KeyRetired
+}
+
+func revocationStatus(sig *packet.Signature) RevocationStatus {
+	rs := RevocationStatus{Revoked: true, Signature: sig, ReasonText: sig.RevocationReasonText}
+	if sig.RevocationReason != nil {
+		rs.Reason = packet.ReasonForRevocation(*sig.RevocationReason)
+	}
+	return rs
+}
+
+// Revocation returns the revocation status of k. A subkey is revoked when
+// either it or its primary key has been. Of several revocations, of either
+// key, a compromised one is reported, or else the first one of the primary
+// key.
+func (k Key) Revocation() RevocationStatus {
+	sigs := k.Entity.Revocations
+	if subkey := k.subkey(); subkey != nil && subkey.Revocation != nil {
+		sigs = append(sigs[:len(sigs):len(sigs)], subkey.Revocation)
+	}
+	var first RevocationStatus
+	for _, sig := range sigs {
+		rs := revocationStatus(sig)
+		if rs.Compromised() {
+			return rs
+		}
+		if !first.Revoked {
+			first = rs
+		}
+	}
+	return first
+}
+
+// Expired reports whether k has expired at time now. A subkey has also
+// expired when its primary key has.
+func (k Key) Expired(now time.Time) bool {
+	if i := k.Entity.PrimaryIdentity(); i != nil && i.SelfSignature != nil && i.SelfSignature.KeyExpired(now) {
+		return true
+	}
+	if subkey := k.subkey(); subkey != nil && subkey.Sig != nil {
+		return subkey.Sig.KeyExpired(now)
+	}
+	return false
+}
+
+// subkey returns the subkey of k.Entity that k refers to, or nil if k is
+// the primary key.
+func (k Key) subkey() *Subkey {
+	for i := range k.Entity.Subkeys {
+		if k.Entity.Subkeys[i].PublicKey == k.PublicKey {
+			return &k.Entity.Subkeys[i]
+		}
+	}
+	return nil
+}
+
 // A KeyRing provides access to public and private keys.
 type KeyRing interface {
 
@@ -87,7 +203,8 @@ type KeyRing interface {
 	// KeysByIdAndUsage returns the set of keys with the given id
 	// that also meet the key usage given by requiredUsage.
 	// The requiredUsage is expressed as the bitwise-OR of
-	// packet.KeyFlag* values.
+	// packet.KeyFlag* values. Revoked keys are left out, so callers
+	// that need to know why a key was revoked must use KeysById.
 	// fp can be optionally supplied, which is the full key fingerprint.
 	// If it's provided, then it must match. This comes up in the case
 	// of GPG subpacket 33.
@@ -98,19 +215,90 @@ type KeyRing interface {
 	DecryptionKeys() []Key
 }
 
-// primaryIdentity returns the Identity marked as primary or the first identity
-// if none are so marked.
-func (e *Entity) primaryIdentity() *Identity {
-	var firstIdentity *Identity
+// PrimaryIdentity returns the Identity whose self-signature marks it as the
+// primary user ID. If several are so marked, or none are, the one with the
+// most recent self-signature is chosen, with ties broken by name so that the
+// result does not depend on map iteration order.
+func (e *Entity) PrimaryIdentity() *Identity {
+	var primary *Identity
 	for _, ident := range e.Identities {
-		if firstIdentity == nil {
-			firstIdentity = ident
+		if primary == nil || isPreferredIdentity(ident, primary) {
+			primary = ident
+		}
+	}
+	return primary
+}
+
+// isPreferredIdentity reports whether a is a better choice than b for the
+// primary identity.
+func isPreferredIdentity(a, b *Identity) bool {
+	if a.SelfSignature == nil || b.SelfSignature == nil {
+		if a.SelfSignature != b.SelfSignature {
+			return b.SelfSignature == nil
+		}
+		return a.Name < b.Name
+	}
+	aPrimary := a.SelfSignature.IsPrimaryId != nil && *a.SelfSignature.IsPrimaryId
+	bPrimary := b.SelfSignature.IsPrimaryId != nil && *b.SelfSignature.IsPrimaryId
+	if aPrimary != bPrimary {
+		return aPrimary
+	}
+	if at, bt := a.SelfSignature.CreationTime, b.SelfSignature.CreationTime; !at.Equal(bt) {
+		return at.After(bt)
+	}
+	return a.Name < b.Name
+}
+
+// strongSigningHashes are the hash functions that SigningHash is willing to
+// pick for a data signature. MD5, SHA-1 and RIPEMD-160 are never chosen.
+var strongSigningHashes = []crypto.Hash{
+	crypto.SHA256,
+	crypto.SHA384,
+	crypto.SHA512,
+	crypto.SHA224,
+	crypto.SHA3_256,
+	crypto.SHA3_512,
+}
+
+// SigningHash returns the hash function to use for a data signature that
+// should be verifiable by the owner of e. The hash configured in config is
+// used if e lists it among its preferred hash algorithms (or lists none at
+// all); otherwise the first strong hash from e's preferences is chosen. If
+// nothing suitable is found, SHA-256 is returned.
+func (e *Entity) SigningHash(config *packet.Config) crypto.Hash {
+	var preferred []uint8
+	if i := e.PrimaryIdentity(); i != nil && i.SelfSignature != nil {
+		preferred = i.SelfSignature.PreferredHash
+	}
+
+	isStrong := func(h crypto.Hash) bool {
+		for _, s := range strongSigningHashes {
+			if h == s {
+				return h.Available()
+			}
+		}
+		return false
+	}
+
+	configured := config.Hash()
+	if isStrong(configured) {
+		if len(preferred) == 0 {
+			return configured
+		}
+		for _, id := range preferred {
+			if h, ok := s2k.HashIdToHash(id); ok && h == configured {
+				return configured
+			}
 		}
-		if ident.SelfSignature.IsPrimaryId != nil && *ident.SelfSignature.IsPrimaryId {
-			return ident
+	}
+
+	for _, id := range preferred {
+		if h, ok := s2k.HashIdToHash(id); ok && isStrong(h) {
+			return h
 		}
 	}
-	return firstIdentity
+
+	return crypto.SHA256
 }
 
 // encryptionKey returns the best candidate Key for encrypting a message to the
@@ -134,11 +322,7 @@ func (e *Entity) encryptionKey(now time.Time) (Key, bool) {
 		// One more note: old DSA/ElGamal keys tend not to have the Flags subpacket,
 		// so this sort of thing is pretty important for encrypting to older keys.
 		//
-		if ((subkey.Sig.FlagsValid && subkey.Sig.FlagEncryptCommunications) ||
-			(!subkey.Sig.FlagsValid && subkey.PublicKey.PubKeyAlgo == packet.PubKeyAlgoElGamal)) &&
-			subkey.PublicKey.PubKeyAlgo.CanEncrypt() &&
-			!subkey.Sig.KeyExpired(now) &&
-			subkey.Revocation == nil &&
+		if isEncryptionSubkey(subkey, now) &&
 			(maxTime.IsZero() || subkey.Sig.CreationTime.After(maxTime)) {
 			candidateSubkey = i
 			maxTime = subkey.Sig.CreationTime
@@ -150,6 +334,12 @@ func (e *Entity) encryptionKey(now time.Time) (Key, bool) {
 		return Key{e, subkey.PublicKey, subkey.PrivateKey, subkey.Sig, subkey.Sig.GetKeyFlags()}, true
 	}
 
+	return e.primaryEncryptionKey(now)
+}
+
+// primaryEncryptionKey returns the primary key of e if it can be used for
+// encryption, which is the fallback when no subkey can.
+func (e *Entity) primaryEncryptionKey(now time.Time) (Key, bool) {
 	// If we don't have any candidate subkeys for encryption and
 	// the primary key doesn't have any usage metadata then we
 	// assume that the primary key is ok. Or, if the primary key is
@@ -157,7 +347,7 @@ func (e *Entity) encryptionKey(now time.Time) (Key, bool) {
 	//
 	// NOTE(maxtaco) - see note above, how this policy is a little too open-ended
 	// for my liking, but leave it for now.
-	i := e.primaryIdentity()
+	i := e.PrimaryIdentity()
 	if (!i.SelfSignature.FlagsValid || i.SelfSignature.FlagEncryptCommunications) &&
 		e.PrimaryKey.PubKeyAlgo.CanEncrypt() &&
 		!i.SelfSignature.KeyExpired(now) {
@@ -168,6 +358,39 @@ func (e *Entity) encryptionKey(now time.Time) (Key, bool) {
 	return Key{}, false
 }
 
+// isEncryptionSubkey reports whether subkey may be used to encrypt a message
+// at time now.
+func isEncryptionSubkey(subkey Subkey, now time.Time) bool {
+	return ((subkey.Sig.FlagsValid && subkey.Sig.FlagEncryptCommunications) ||
+		(!subkey.Sig.FlagsValid && subkey.PublicKey.PubKeyAlgo == packet.PubKeyAlgoElGamal)) &&
+		subkey.PublicKey.PubKeyAlgo.CanEncrypt() &&
+		!subkey.Sig.KeyExpired(now) &&
+		subkey.Revocation == nil
+}
+
+// EncryptionKeys returns all the keys of e that may be used to encrypt a
+// message at time now, oldest first. These are the subkeys that
+// encryptionKey would consider, or, if there are none, the primary key if it
+// can encrypt. Callers that want their own selection policy can use this
+// instead of the single key that Encrypt picks.
+func (e *Entity) EncryptionKeys(now time.Time) []Key {
+	var keys []Key
+	for _, subkey := range e.Subkeys {
+		if isEncryptionSubkey(subkey, now) {
+			keys = append(keys, Key{e, subkey.PublicKey, subkey.PrivateKey, subkey.Sig, subkey.Sig.GetKeyFlags()})
+		}
+	}
+	if len(keys) == 0 {
+		if key, ok := e.primaryEncryptionKey(now); ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].PublicKey.CreationTime.Before(keys[j].PublicKey.CreationTime)
+	})
+	return keys
+}
+
 // signingKey return the best candidate Key for signing a message with this
 // Entity.
 func (e *Entity) signingKey(now time.Time) (Key, bool) {
@@ -196,7 +419,7 @@ func (e *Entity) signingKey(now time.Time) (Key, bool) {
 
 	// If we have no candidate subkey then we assume that it's ok to sign
 	// with the primary key.
-	i := e.primaryIdentity()
+	i := e.PrimaryIdentity()
 	if (!i.SelfSignature.FlagsValid || i.SelfSignature.FlagSign) &&
 		e.PrimaryKey.PubKeyAlgo.CanSign() &&
 		!i.SelfSignature.KeyExpired(now) &&
@@ -207,9 +430,153 @@ func (e *Entity) signingKey(now time.Time) (Key, bool) {
 	return Key{}, false
 }
 
+// Capabilities returns a GnuPG-style summary, such as "[SCE]", of what e
+// can be used for at time now: S(ign), C(ertify), E(ncrypt) and
+// A(uthenticate). The usages of the primary key and of every subkey that
+// is neither expired nor revoked are combined. Keys without a key flags
+// subpacket are assumed to allow whatever their algorithm supports. A
+// revoked or expired entity yields "[]".
+func (e *Entity) Capabilities(now time.Time) string {
+	var flags packet.KeyFlagBits
+
+	usage := func(sig *packet.Signature, algo packet.PublicKeyAlgorithm, primary bool) {
+		if sig.FlagsValid {
+			flags.Merge(sig.GetKeyFlags())
+			return
+		}
+		if algo.CanSign() {
+			flags.BitField |= packet.KeyFlagSign
+			if primary {
+				flags.BitField |= packet.KeyFlagCertify
+			}
+		}
+		if algo.CanEncrypt() {
+			flags.BitField |= packet.KeyFlagEncryptCommunications
+		}
+	}
+
+	i := e.PrimaryIdentity()
+	if len(e.Revocations) > 0 || i == nil || i.SelfSignature == nil || i.SelfSignature.KeyExpired(now) {
+		return "[]"
+	}
+	usage(i.SelfSignature, e.PrimaryKey.PubKeyAlgo, true)
+
+	for _, subkey := range e.Subkeys {
+		if subkey.Sig == nil || subkey.Sig.KeyExpired(now) || subkey.Revocation != nil {
+			continue
+		}
+		usage(subkey.Sig, subkey.PublicKey.PubKeyAlgo, false)
+	}
+
+	summary := "["
+	if flags.HasFlagSign() {
+		summary += "S"
+	}
+	if flags.HasFlagCertify() {
+		summary += "C"
+	}
+	if flags.HasFlagEncryptCommunications() || flags.HasFlagEncryptStorage() {
+		summary += "E"
+	}
+	if flags.HasFlagAuthenticate() {
+		summary += "A"
+	}
+	return summary + "]"
+}
+
 // An EntityList contains one or more Entities.
 type EntityList []*Entity
 
+// NewEntityList returns an EntityList holding entities. It returns an
+// error if any entity fails Validate or if two entities share the same
+// primary key fingerprint.
+func NewEntityList(entities []*Entity) (EntityList, error) {
+	seen := make(map[string]bool, len(entities))
+	for _, e := range entities {
+		if e == nil {
+			return nil, errors.InvalidArgumentError("nil entity")
+		}
+		if err := e.Validate(nil); err != nil {
+			return nil, err
+		}
+		fp := string(e.PrimaryKey.FullFingerprint())
+		if seen[fp] {
+			return nil, errors.InvalidArgumentError(fmt.Sprintf("duplicate key %X", fp))
+		}
+		seen[fp] = true
+	}
+	return EntityList(entities), nil
+}
+
+// Validate checks that e is complete and self-consistent: it has a primary
+// key, any private key matches it, there is at least one identity, every
+// identity carries a valid self-signature, every subkey a valid binding
+// signature (including the cross-signature of signing subkeys), and every
+// revocation is made by the primary key or, if it was added by
+// ApplyDesignatedRevocation, by one of e.DesignatedRevokers. Signatures made after config.Now(),
+// beyond the clock skew allowed by config.MaxClockSkew, are rejected. It
+// returns the first problem found, as the same error ReadEntity would
+// report.
+// If config is nil, sensible defaults will be used.
+func (e *Entity) Validate(config *packet.Config) error {
+	if e.PrimaryKey == nil {
+		return errors.StructuralError("entity without a primary key")
+	}
+	if e.PrivateKey != nil && !bytes.Equal(e.PrivateKey.PublicKey.FullFingerprint(), e.PrimaryKey.FullFingerprint()) {
+		return errors.StructuralError("private key does not match primary key")
+	}
+	if len(e.Identities) == 0 {
+		return errors.ErrNoIdentities
+	}
+	now := config.Now().Add(config.ClockSkew())
+	for _, ident := range e.Identities {
+		if ident.UserId == nil || ident.SelfSignature == nil {
+			return errors.ErrBadSelfSignature.WithDetail(strconv.Quote(ident.Name), nil)
+		}
+		if ident.SelfSignature.CreationTime.After(now) {
+			return errors.ErrBadSelfSignature.WithDetail(strconv.Quote(ident.Name)+": signature is from the future", nil)
+		}
+		if err := e.PrimaryKey.VerifyUserIdSignature(ident.Name, e.PrimaryKey, ident.SelfSignature); err != nil {
+			return errors.ErrBadSelfSignature.WithDetail(strconv.Quote(ident.Name), err)
+		}
+		if ident.Revocation != nil {
+			if err := e.PrimaryKey.VerifyUserIdSignature(ident.Name, e.PrimaryKey, ident.Revocation); err != nil {
+				return errors.StructuralError("identity revocation invalid: " + ident.Name + ": " + err.Error())
+			}
+		}
+	}
+	for _, subkey := range e.Subkeys {
+		if subkey.PublicKey == nil || subkey.Sig == nil {
+			return errors.ErrMissingSubkeyBinding
+		}
+		if subkey.Sig.CreationTime.After(now) {
+			return errors.ErrBadSubkeySignature.WithDetail("signature is from the future", nil)
+		}
+		if err := e.PrimaryKey.VerifyKeySignature(subkey.PublicKey, subkey.Sig); err != nil {
+			return errors.ErrBadSubkeySignature.WithDetail("", err)
+		}
+		if subkey.Revocation != nil {
+			if err := e.PrimaryKey.VerifyKeySignature(subkey.PublicKey, subkey.Revocation); err != nil {
+				return errors.ErrBadSubkeySignature.WithDetail("", err)
+			}
+		}
+	}
+	for _, revocation := range e.Revocations {
+		if revocation.IssuerKeyId != nil && *revocation.IssuerKeyId != e.PrimaryKey.KeyId {
+			// Made by a designated revoker, whose key we don't have
+			// here. Its signature was checked when it was applied.
+			if e.designatedRevoker(revocation) == nil {
+				return errors.StructuralError("revocation signature not made by the key or a designated revoker")
+			}
+			continue
+		}
+		if err := e.PrimaryKey.VerifyRevocationSignature(e.PrimaryKey, revocation); err != nil {
+			return errors.StructuralError("revocation signature signed by alternate key")
+		}
+	}
+	return nil
+}
+
 func keyMatchesIdAndFingerprint(key *packet.PublicKey, id uint64, fp []byte) bool {
 	if key.KeyId != id {
 		return false
@@ -217,7 +584,7 @@ func keyMatchesIdAndFingerprint(key *packet.PublicKey, id uint64, fp []byte) boo
 	if fp == nil {
 		return true
 	}
-	return hmac.Equal(fp, key.Fingerprint[:])
+	return hmac.Equal(fp, key.FullFingerprint())
 }
 
 // KeysById returns the set of keys that have the given key id.
@@ -225,16 +592,31 @@ func keyMatchesIdAndFingerprint(key *packet.PublicKey, id uint64, fp []byte) boo
 // If it's provided, then it must match. This comes up in the case
 // of GPG subpacket 33.
 func (el EntityList) KeysById(id uint64, fp []byte) (keys []Key) {
+	return el.keysMatching(func(pk *packet.PublicKey) bool {
+		return keyMatchesIdAndFingerprint(pk, id, fp)
+	})
+}
+
+// KeysByFingerprint returns the set of keys whose full fingerprint is fp.
+// Unlike key ids, fingerprints can't be made to collide. fp is 20 bytes
+// long for version 4 keys and 32 bytes long for version 5 and 6 keys.
+func (el EntityList) KeysByFingerprint(fp []byte) []Key {
+	if len(fp) == 0 {
+		return nil
+	}
+	return el.keysMatching(func(pk *packet.PublicKey) bool {
+		return bytes.Equal(pk.FullFingerprint(), fp)
+	})
+}
+
+// keysMatching returns the primary keys and subkeys for which match
+// returns true.
+func (el EntityList) keysMatching(match func(pk *packet.PublicKey) bool) (keys []Key) {
 	for _, e := range el {
-		if keyMatchesIdAndFingerprint(e.PrimaryKey, id, fp) {
+		if match(e.PrimaryKey) {
 			var selfSig *packet.Signature
-			for _, ident := range e.Identities {
-				if selfSig == nil {
-					selfSig = ident.SelfSignature
-				} else if ident.SelfSignature.IsPrimaryId != nil && *ident.SelfSignature.IsPrimaryId {
-					selfSig = ident.SelfSignature
-					break
-				}
+			if ident := e.PrimaryIdentity(); ident != nil {
+				selfSig = ident.SelfSignature
 			}
 
 			var keyFlags packet.KeyFlagBits
@@ -246,7 +628,7 @@ func (el EntityList) KeysById(id uint64, fp []byte) (keys []Key) {
 		}
 
 		for _, subKey := range e.Subkeys {
-			if keyMatchesIdAndFingerprint(subKey.PublicKey, id, fp) {
+			if match(subKey.PublicKey) {
 
 				// If there's both a a revocation and a sig, then take the
 				// revocation. Otherwise, we can proceed with the sig.
@@ -262,9 +644,22 @@ func (el EntityList) KeysById(id uint64, fp []byte) (keys []Key) {
 	return
 }
 
+// ForEachKey calls fn for the primary key and then each subkey of every
+// entity in el, in order. Keys are not filtered by usage, expiry or
+// revocation.
+func (el EntityList) ForEachKey(fn func(Key)) {
+	for _, key := range el.keysMatching(func(*packet.PublicKey) bool { return true }) {
+		fn(key)
+	}
+}
+
 // KeysByIdAndUsage returns the set of keys with the given id that also meet
 // the key usage given by requiredUsage.  The requiredUsage is expressed as
 // the bitwise-OR of packet.KeyFlag* values.
+// Revoked keys are left out, whatever the reason for the revocation. Callers
+// that need to tell a compromised key from a retired one, e.g. to accept the
+// signatures it made before it was retired, must use KeysById and
+// Key.Revocation instead.
 // fp can be optionally supplied, which is the full key fingerprint.
 // If it's provided, then it must match. This comes up in the case
 // of GPG subpacket 33.
@@ -316,11 +711,22 @@ func (el EntityList) KeysByIdUsage(id uint64, fp []byte, requiredUsage byte) (ke
 	return
 }
 
-// DecryptionKeys returns all private keys that are valid for decryption.
+// DecryptionKeys returns all private keys that are valid for decryption,
+// whether or not they are still encrypted with a passphrase. ReadMessage
+// tries them all when a message hides the key ID of its recipient. The
+// primary key is included when it is flagged for encryption.
 func (el EntityList) DecryptionKeys() (keys []Key) {
 	for _, e := range el {
+		if usablePrivateKey(e.PrivateKey) && e.PrimaryKey.PubKeyAlgo.CanEncrypt() {
+			if ident := e.PrimaryIdentity(); ident != nil && ident.SelfSignature != nil {
+				selfSig := ident.SelfSignature
+				if selfSig.FlagsValid && (selfSig.FlagEncryptStorage || selfSig.FlagEncryptCommunications) {
+					keys = append(keys, Key{e, e.PrimaryKey, e.PrivateKey, selfSig, selfSig.GetKeyFlags()})
+				}
+			}
+		}
 		for _, subKey := range e.Subkeys {
-			if subKey.PrivateKey != nil && subKey.PrivateKey.PrivateKey != nil && (!subKey.Sig.FlagsValid || subKey.Sig.FlagEncryptStorage || subKey.Sig.FlagEncryptCommunications) {
+			if usablePrivateKey(subKey.PrivateKey) && (!subKey.Sig.FlagsValid || subKey.Sig.FlagEncryptStorage || subKey.Sig.FlagEncryptCommunications) {
 				keys = append(keys, Key{e, subKey.PublicKey, subKey.PrivateKey, subKey.Sig, subKey.Sig.GetKeyFlags()})
 			}
 		}
@@ -328,8 +734,121 @@ func (el EntityList) DecryptionKeys() (keys []Key) {
 	return
 }
 
+// usablePrivateKey reports whether priv holds key material, possibly
+// encrypted, rather than being missing or a stub.
+func usablePrivateKey(priv *packet.PrivateKey) bool {
+	return priv != nil && (priv.Encrypted || priv.PrivateKey != nil)
+}
+
+// Merge adds the entities of other to el and returns the result. An entity
+// whose primary key has the same fingerprint as one already in el is merged
+// into it: identities, subkeys, certifications and revocations are united,
+// and between two self-signatures or subkey binding signatures the one
+// ReadEntity would keep is chosen. Entities of el are modified in place;
+// those of other are only referenced.
+func (el EntityList) Merge(other EntityList) EntityList {
+	for _, src := range other {
+		var dst *Entity
+		for _, e := range el {
+			if bytes.Equal(e.PrimaryKey.FullFingerprint(), src.PrimaryKey.FullFingerprint()) {
+				dst = e
+				break
+			}
+		}
+		if dst == nil {
+			el = append(el, src)
+			continue
+		}
+		dst.merge(src)
+	}
+	return el
+}
+
+// merge adds what src knows about e's key to e. Both must have the same
+// primary key.
+func (e *Entity) merge(src *Entity) {
+	if e == src {
+		return
+	}
+	if e.PrivateKey == nil {
+		e.PrivateKey = src.PrivateKey
+	}
+
+	for name, ident := range src.Identities {
+		cur, ok := e.Identities[name]
+		if !ok {
+			e.Identities[name] = ident
+			continue
+		}
+		// See ReadEntity for why the key flags matter.
+		if sig := ident.SelfSignature; !sig.CreationTime.Before(cur.SelfSignature.CreationTime) &&
+			(sig.FlagsValid || !cur.SelfSignature.FlagsValid) {
+			cur.SelfSignature = sig
+		}
+		cur.Signatures = appendNewSignatures(cur.Signatures, ident.Signatures...)
+		if cur.Revocation == nil {
+			cur.Revocation = ident.Revocation
+		}
+	}
+
+	e.Revocations = appendNewSignatures(e.Revocations, src.Revocations...)
+	e.UnverifiedRevocations = appendNewSignatures(e.UnverifiedRevocations, src.UnverifiedRevocations...)
+
+NextSubkey:
+	for _, subkey := range src.Subkeys {
+		for i := range e.Subkeys {
+			cur := &e.Subkeys[i]
+			if !bytes.Equal(cur.PublicKey.FullFingerprint(), subkey.PublicKey.FullFingerprint()) {
+				continue
+			}
+			if cur.PrivateKey == nil {
+				cur.PrivateKey = subkey.PrivateKey
+			}
+			if cur.Sig.ExpiresBeforeOther(subkey.Sig) {
+				cur.Sig = subkey.Sig
+			}
+			if cur.Revocation == nil {
+				cur.Revocation = subkey.Revocation
+			}
+			continue NextSubkey
+		}
+		e.Subkeys = append(e.Subkeys, subkey)
+	}
+}
+
+// appendNewSignatures appends to sigs those of more that it doesn't contain
+// yet. Signatures are compared by their serialization.
+func appendNewSignatures(sigs []*packet.Signature, more ...*packet.Signature) []*packet.Signature {
+	serialized := make(map[string]bool)
+	serialize := func(sig *packet.Signature) string {
+		var buf bytes.Buffer
+		if err := sig.Serialize(&buf); err != nil {
+			return ""
+		}
+		return buf.String()
+	}
+	for _, sig := range sigs {
+		serialized[serialize(sig)] = true
+	}
+	for _, sig := range more {
+		s := serialize(sig)
+		if s != "" && serialized[s] {
+			continue
+		}
+		serialized[s] = true
+		sigs = append(sigs, sig)
+	}
+	return sigs
+}
+
 // ReadArmoredKeyRing reads one or more public/private keys from an armor keyring file.
 func ReadArmoredKeyRing(r io.Reader) (EntityList, error) {
+	return ReadArmoredKeyRingWithConfig(r, nil)
+}
+
+// ReadArmoredKeyRingWithConfig is like ReadArmoredKeyRing but reads each key
+// with ReadEntityWithConfig.
+func ReadArmoredKeyRingWithConfig(r io.Reader, config *packet.Config) (EntityList, error) {
 	block, err := armor.Decode(r)
 	if err == io.EOF {
 		return nil, errors.InvalidArgumentError("no armored data found")
@@ -341,45 +860,75 @@ func ReadArmoredKeyRing(r io.Reader) (EntityList, error) {
 		return nil, errors.InvalidArgumentError("expected public or private key block, got: " + block.Type)
 	}
 
-	return ReadKeyRing(block.Body)
+	return ReadKeyRingWithConfig(block.Body, config)
 }
 
 // ReadKeyRing reads one or more public/private keys. Unsupported keys are
 // ignored as long as at least a single valid key is found.
 func ReadKeyRing(r io.Reader) (el EntityList, err error) {
-	packets := packet.NewReader(r)
+	return ReadKeyRingWithConfig(r, nil)
+}
+
+// ReadKeyRingWithConfig is like ReadKeyRing but reads each key with
+// ReadEntityWithConfig.
+func ReadKeyRingWithConfig(r io.Reader, config *packet.Config) (el EntityList, err error) {
 	var lastUnsupportedError error
+	err = ReadKeyRingFuncWithConfig(r, config, func(e *Entity, err error) error {
+		if err != nil {
+			// TODO: warn about skipped unsupported/unreadable keys
+			lastUnsupportedError = err
+		} else {
+			el = append(el, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(el) == 0 {
+		err = lastUnsupportedError
+	}
+	return
+}
+
+// ReadKeyRingFunc reads public/private keys from r one at a time and calls
+// fn for each of them, so that large keyrings can be processed without
+// holding them in memory. Keys that are unsupported or badly formatted are
+// skipped: fn is called with a nil Entity and the error instead, and
+// reading continues with the next key. If fn returns an error, reading
+// stops and ReadKeyRingFunc returns that error.
+func ReadKeyRingFunc(r io.Reader, fn func(e *Entity, err error) error) error {
+	return ReadKeyRingFuncWithConfig(r, nil, fn)
+}
+
+// ReadKeyRingFuncWithConfig is like ReadKeyRingFunc but reads each key with
+// ReadEntityWithConfig.
+func ReadKeyRingFuncWithConfig(r io.Reader, config *packet.Config, fn func(e *Entity, err error) error) error {
+	packets := packet.NewReader(r)
 
 	for {
-		var e *Entity
-		e, err = ReadEntity(packets)
+		e, err := ReadEntityWithConfig(packets, config)
 		if err != nil {
-			// TODO: warn about skipped unsupported/unreadable keys
-			if _, ok := err.(errors.UnsupportedError); ok {
-				lastUnsupportedError = err
-				err = readToNextPublicKey(packets)
-			} else if _, ok := err.(errors.StructuralError); ok {
-				// Skip unreadable, badly-formatted keys
-				lastUnsupportedError = err
+			switch err.(type) {
+			case errors.UnsupportedError, errors.StructuralError:
+				if err = fn(nil, err); err != nil {
+					return err
+				}
 				err = readToNextPublicKey(packets)
 			}
 			if err == io.EOF {
-				err = nil
-				break
+				return nil
 			}
 			if err != nil {
-				el = nil
-				break
+				return err
 			}
-		} else {
-			el = append(el, e)
+			continue
+		}
+		if err = fn(e, nil); err != nil {
+			return err
 		}
 	}
-
-	if len(el) == 0 && err == nil {
-		err = lastUnsupportedError
-	}
-	return
 }
 
 // readToNextPublicKey reads packets until the start of the entity and leaves
@@ -407,12 +956,37 @@ func readToNextPublicKey(packets *packet.Reader) (err error) {
 	panic("unreachable")
 }
 
+// maxUnknownPacketsSize bounds the contents of the packets kept in
+// Entity.UnknownPackets.
+const maxUnknownPacketsSize = 64 << 10
+
+// maxMisplacedSelfSignatures bounds both the self-signatures held back
+// until their User ID turns up and the earlier User IDs a self-signature
+// is tried against, so that a key full of bogus signatures can't make
+// reading it quadratic.
+const maxMisplacedSelfSignatures = 32
+
 // ReadEntity reads an entity (public key, identities, subkeys etc) from the
 // given Reader.
 func ReadEntity(packets *packet.Reader) (*Entity, error) {
+	return ReadEntityWithConfig(packets, nil)
+}
+
+// ReadEntityWithConfig is like ReadEntity but applies the policy in config.
+// With config.RejectWeakHashes set, self-signatures made with a weak hash
+// are ignored. Identities whose only self-signatures use one are returned
+// in BadIdentities, and subkeys whose only bindings use one in BadSubkeys,
+// with an error matching errors.ErrWeakHash. If no identity is left, the
+// error returned matches errors.ErrWeakHash as well as
+// errors.ErrNoIdentities.
+func ReadEntityWithConfig(packets *packet.Reader, config *packet.Config) (*Entity, error) {
 	e := new(Entity)
 	e.Identities = make(map[string]*Identity)
 
+	// Only the unknown packets of this entity are kept, which also drops
+	// any left over from an entity that could not be read.
+	packets.KeepSkipped(maxUnknownPacketsSize)
+
 	p, err := packets.Next()
 	if err != nil {
 		return nil, err
@@ -433,7 +1007,15 @@ func ReadEntity(packets *packet.Reader) (*Entity, error) {
 	}
 
 	var current *Identity
+	var currentAttr *UserAttribute
+	var userIds []*Identity
 	var revocations []*packet.Signature
+	// misplaced holds self-signatures that did not follow the User ID
+	// they are over. Each new User ID is checked against them.
+	var misplaced []*packet.Signature
+	// weak holds the identities that have a self-signature that was
+	// rejected for its hash.
+	weak := make(map[*Identity]bool)
 
 	designatedRevokers := make(map[uint64]bool)
 EachPacket:
@@ -453,6 +1035,16 @@ EachPacket:
 			current = new(Identity)
 			current.Name = pkt.Id
 			current.UserId = pkt
+			userIds = append(userIds, current)
+			currentAttr = nil
+
+			remaining := misplaced[:0]
+			for _, sig := range misplaced {
+				if !e.addMisplacedSelfSignature(current, sig) {
+					remaining = append(remaining, sig)
+				}
+			}
+			misplaced = remaining
 		case *packet.Signature:
 			if pkt.SigType == packet.SigTypeKeyRevocation {
 				// These revocations won't revoke UIDs (see
@@ -463,10 +1055,24 @@ EachPacket:
 				continue
 			}
 
-			// These are signatures by other people on this key. Let's just ignore them
-			// from the beginning, since they shouldn't affect our key decoding one way
-			// or the other.
+			if currentAttr != nil && e.addAttributeSignature(currentAttr, pkt, config) {
+				continue
+			}
+
+			// These are signatures by other people on this key. They
+			// shouldn't affect our key decoding one way or the other, so
+			// don't look at them any further. Certifications, and
+			// timestamp and third-party confirmation signatures, are
+			// kept, unverified, with the identity they follow, so that
+			// e.g. trust signatures survive re-serialization.
 			if pkt.IssuerKeyId != nil && *pkt.IssuerKeyId != e.PrimaryKey.KeyId {
+				switch pkt.SigType {
+				case packet.SigTypeGenericCert, packet.SigTypePersonaCert, packet.SigTypeCasualCert, packet.SigTypePositiveCert,
+					packet.SigTypeIdentityRevocation, packet.SigTypeTimestamp, packet.SigTypeThirdPartyConfirmation:
+					if current != nil {
+						current.Signatures = append(current.Signatures, pkt)
+					}
+				}
 				continue
 			}
 
@@ -494,31 +1100,40 @@ EachPacket:
 			// So further tighten our overwrite rules, and only allow the later
 			// signature to overwrite the earlier signature if so doing won't
 			// trash the key flags.
-			if current != nil &&
-				(current.SelfSignature == nil ||
-					(!pkt.CreationTime.Before(current.SelfSignature.CreationTime) &&
-						(pkt.FlagsValid || !current.SelfSignature.FlagsValid))) &&
-				(pkt.SigType == packet.SigTypePositiveCert || pkt.SigType == packet.SigTypeGenericCert) &&
-				pkt.IssuerKeyId != nil &&
-				*pkt.IssuerKeyId == e.PrimaryKey.KeyId {
-
-				if err = e.PrimaryKey.VerifyUserIdSignature(current.Name, e.PrimaryKey, pkt); err == nil {
-
-					current.SelfSignature = pkt
-
-					// NOTE(maxtaco) 2016.01.11
-					// Only register an identity once we've gotten a valid self-signature.
-					// It's possible therefore for us to throw away `current` in the case
-					// no valid self-signatures were found. That's OK as long as there are
-					// other identities that make sense.
-					//
-					// NOTE! We might later see a revocation for this very same UID, and it
-					// won't be undone. We've preserved this feature from the original
-					// Google OpenPGP we forked from.
-					e.Identities[current.Name] = current
-				} else {
-					// We really should warn that there was a failure here. Not raise an error
-					// since this really shouldn't be a fail-stop error.
+			if isSelfCertification(e.PrimaryKey, pkt) && config.WeakHashesRejected() && isWeakHash(pkt.Hash) {
+				if ident := e.selfSignatureIdentity(userIds, pkt); ident != nil {
+					weak[ident] = true
+				}
+				continue
+			}
+			if current != nil && isSelfCertification(e.PrimaryKey, pkt) {
+				if e.addSelfSignature(current, pkt) {
+					continue
+				}
+				// Some exporters put several User IDs first and
+				// their self-signatures after them.
+				earlier := userIds[:len(userIds)-1]
+				if len(earlier) > maxMisplacedSelfSignatures {
+					earlier = earlier[len(earlier)-maxMisplacedSelfSignatures:]
+				}
+				for _, ident := range earlier {
+					if e.addMisplacedSelfSignature(ident, pkt) {
+						continue EachPacket
+					}
+				}
+				if pkt.IssuerKeyId == nil || !replacesSelfSignature(current, pkt) {
+					// Not a self-signature after all, or one superseded
+					// by the current one; keep it as a certification.
+					current.Signatures = append(current.Signatures, pkt)
+				} else if len(misplaced) < maxMisplacedSelfSignatures {
+					// It may be over a User ID still to come.
+					misplaced = append(misplaced, pkt)
+				}
+			} else if current == nil && isSelfCertification(e.PrimaryKey, pkt) {
+				// A self-signature ahead of any User ID; hold on to it
+				// until its User ID turns up.
+				if len(misplaced) < maxMisplacedSelfSignatures {
+					misplaced = append(misplaced, pkt)
 				}
 			} else if current != nil && pkt.SigType == packet.SigTypeIdentityRevocation {
 				if err = e.PrimaryKey.VerifyUserIdSignature(current.Name, e.PrimaryKey, pkt); err == nil {
@@ -536,6 +1151,7 @@ EachPacket:
 						// revocation should be added to UnverifiedRevocations.
 						keyID := binary.BigEndian.Uint64(desig.Fingerprint[len(desig.Fingerprint)-8:])
 						designatedRevokers[keyID] = true
+						e.DesignatedRevokers = append(e.DesignatedRevokers, desig)
 					}
 				}
 			} else if current == nil {
@@ -553,12 +1169,17 @@ EachPacket:
 			} else {
 				current.Signatures = append(current.Signatures, pkt)
 			}
+		case *packet.UserAttribute:
+			// The signatures that follow are over the attribute, not
+			// the last User ID.
+			current = nil
+			currentAttr = &UserAttribute{UserAttribute: pkt}
 		case *packet.PrivateKey:
 			if pkt.IsSubkey == false {
 				packets.Unread(p)
 				break EachPacket
 			}
-			err = addSubkey(e, packets, &pkt.PublicKey, pkt)
+			err = addSubkey(e, packets, &pkt.PublicKey, pkt, config)
 			if err != nil {
 				return nil, err
 			}
@@ -567,7 +1188,7 @@ EachPacket:
 				packets.Unread(p)
 				break EachPacket
 			}
-			err = addSubkey(e, packets, pkt, nil)
+			err = addSubkey(e, packets, pkt, nil, config)
 			if err != nil {
 				return nil, err
 			}
@@ -575,9 +1196,19 @@ EachPacket:
 			// we ignore unknown packets
 		}
 	}
+	e.UnknownPackets = packets.Skipped()
 
+	for _, ident := range userIds {
+		if ident.SelfSignature == nil && weak[ident] {
+			err := errors.ErrBadSelfSignature.WithDetail(strconv.Quote(ident.Name), errors.ErrWeakHash)
+			e.BadIdentities = append(e.BadIdentities, BadIdentity{Identity: *ident, Err: err})
+		}
+	}
 	if len(e.Identities) == 0 {
-		return nil, errors.StructuralError("entity without any identities")
+		if len(e.BadIdentities) > 0 {
+			return nil, errors.ErrNoIdentities.WithDetail("", e.BadIdentities[0].Err)
+		}
+		return nil, errors.ErrNoIdentities
 	}
 
 	for _, revocation := range revocations {
@@ -601,22 +1232,143 @@ EachPacket:
 	return e, nil
 }
 
-func addSubkey(e *Entity, packets *packet.Reader, pub *packet.PublicKey, priv *packet.PrivateKey) error {
-	var subKey Subkey
-	subKey.PublicKey = pub
-	subKey.PrivateKey = priv
-	var lastErr error
-	for {
-		p, err := packets.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return errors.StructuralError("subkey signature invalid: " + err.Error())
+// addAttributeSignature files sig, which follows the user attribute attr, as
+// its self-signature, its revocation or a certification by another key, and
+// adds attr to e.Attributes once it has a valid self-signature. It returns
+// false if sig is of a type that doesn't belong to a user attribute.
+func (e *Entity) addAttributeSignature(attr *UserAttribute, sig *packet.Signature, config *packet.Config) bool {
+	switch sig.SigType {
+	case packet.SigTypeGenericCert, packet.SigTypePersonaCert, packet.SigTypeCasualCert, packet.SigTypePositiveCert:
+	case packet.SigTypeIdentityRevocation:
+		if err := e.PrimaryKey.VerifyUserAttributeSignature(attr.UserAttribute, e.PrimaryKey, sig); err == nil {
+			attr.Revocation = sig
 		}
-		sig, ok := p.(*packet.Signature)
-		if !ok {
-			// Hit a non-signature packet, so assume we're up to the next key
+		return true
+	default:
+		return false
+	}
+
+	if !isSelfCertification(e.PrimaryKey, sig) {
+		attr.Signatures = append(attr.Signatures, sig)
+		return true
+	}
+	if config.WeakHashesRejected() && isWeakHash(sig.Hash) {
+		return true
+	}
+	if attr.SelfSignature != nil && sig.CreationTime.Before(attr.SelfSignature.CreationTime) {
+		return true
+	}
+	if err := e.PrimaryKey.VerifyUserAttributeSignature(attr.UserAttribute, e.PrimaryKey, sig); err != nil {
+		return true
+	}
+	if attr.SelfSignature == nil {
+		e.Attributes = append(e.Attributes, attr)
+	}
+	attr.SelfSignature = sig
+	return true
+}
+
+// isSelfCertification reports whether sig could be a self-signature over a
+// User ID of primary: a generic or positive certification issued by
+// primary, or with no issuer at all.
+func isSelfCertification(primary *packet.PublicKey, sig *packet.Signature) bool {
+	return (sig.SigType == packet.SigTypePositiveCert || sig.SigType == packet.SigTypeGenericCert) &&
+		(sig.IssuerKeyId == nil || *sig.IssuerKeyId == primary.KeyId)
+}
+
+// replacesSelfSignature reports whether sig should take the place of the
+// current self-signature of ident, if it verifies.
+func replacesSelfSignature(ident *Identity, sig *packet.Signature) bool {
+	return ident.SelfSignature == nil ||
+		(!sig.CreationTime.Before(ident.SelfSignature.CreationTime) &&
+			(sig.FlagsValid || !ident.SelfSignature.FlagsValid))
+}
+
+// addSelfSignature makes sig the self-signature of ident, and registers
+// ident with e, if sig is a valid self-signature over it. It reports whether
+// it did.
+func (e *Entity) addSelfSignature(ident *Identity, sig *packet.Signature) bool {
+	if !replacesSelfSignature(ident, sig) {
+		return false
+	}
+	if err := e.PrimaryKey.VerifyUserIdSignature(ident.Name, e.PrimaryKey, sig); err != nil {
+		return false
+	}
+	// Some old signatures carry no issuer subpacket at all. The primary
+	// key is the only candidate for a self-signature, so fill in the
+	// issuer now that it verifies.
+	if sig.IssuerKeyId == nil {
+		keyId := e.PrimaryKey.KeyId
+		sig.IssuerKeyId = &keyId
+	}
+	ident.SelfSignature = sig
+
+	// NOTE(maxtaco) 2016.01.11
+	// Only register an identity once we've gotten a valid self-signature.
+	// It's possible therefore for us to throw away `current` in the case
+	// no valid self-signatures were found. That's OK as long as there are
+	// other identities that make sense.
+	//
+	// NOTE! We might later see a revocation for this very same UID, and it
+	// won't be undone. We've preserved this feature from the original
+	// Google OpenPGP we forked from.
+	e.Identities[ident.Name] = ident
+	return true
+}
+
+// selfSignatureIdentity returns the identity, among the last few of
+// userIds, that the self-signature sig appears to be over, judging by its
+// hash tag, or nil if there is none.
+func (e *Entity) selfSignatureIdentity(userIds []*Identity, sig *packet.Signature) *Identity {
+	for i := len(userIds) - 1; i >= 0 && i >= len(userIds)-maxMisplacedSelfSignatures; i-- {
+		h, err := sig.PrepareVerifyUserId(userIds[i].Name, e.PrimaryKey)
+		if err != nil {
+			return nil
+		}
+		if digest := sig.Digest(h); digest[0] == sig.HashTag[0] && digest[1] == sig.HashTag[1] {
+			return userIds[i]
+		}
+	}
+	return nil
+}
+
+// addMisplacedSelfSignature is like addSelfSignature, for a sig that did not
+// follow ident. Such a sig is most likely over another User ID, so it is
+// only verified if its hash tag matches ident.
+func (e *Entity) addMisplacedSelfSignature(ident *Identity, sig *packet.Signature) bool {
+	if !replacesSelfSignature(ident, sig) {
+		return false
+	}
+	h, err := sig.PrepareVerifyUserId(ident.Name, e.PrimaryKey)
+	if err != nil {
+		return false
+	}
+	if digest := sig.Digest(h); digest[0] != sig.HashTag[0] || digest[1] != sig.HashTag[1] {
+		return false
+	}
+	return e.addSelfSignature(ident, sig)
+}
+
+func addSubkey(e *Entity, packets *packet.Reader, pub *packet.PublicKey, priv *packet.PrivateKey, config *packet.Config) error {
+	var subKey Subkey
+	subKey.PublicKey = pub
+	subKey.PrivateKey = priv
+	var lastErr error
+	for {
+		p, err := packets.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.ErrBadSubkeySignature.WithDetail("", err)
+		}
+		if _, ok := p.(*packet.Trust); ok {
+			// GnuPG keeps Trust packets after signatures in its keyrings.
+			continue
+		}
+		sig, ok := p.(*packet.Signature)
+		if !ok {
+			// Hit a non-signature packet, so assume we're up to the next key
 			packets.Unread(p)
 			break
 		}
@@ -634,11 +1386,15 @@ func addSubkey(e *Entity, packets *packet.Reader, pub *packet.PublicKey, priv *p
 		if err != nil {
 			// Non valid signature, so again, no need to abandon all hope, just continue;
 			// make a note of the error we hit.
-			lastErr = errors.StructuralError("subkey signature invalid: " + err.Error())
+			lastErr = errors.ErrBadSubkeySignature.WithDetail("", err)
 			continue
 		}
 		switch sig.SigType {
 		case packet.SigTypeSubkeyBinding:
+			if config.WeakHashesRejected() && isWeakHash(sig.Hash) {
+				lastErr = errors.ErrWeakHash.WithDetail(sig.Hash.String(), nil)
+				continue
+			}
 			// Does the "new" sig set expiration to later date than
 			// "previous" sig?
 			if subKey.Sig == nil || subKey.Sig.ExpiresBeforeOther(sig) {
@@ -664,43 +1420,111 @@ func addSubkey(e *Entity, packets *packet.Reader, pub *packet.PublicKey, priv *p
 		e.Subkeys = append(e.Subkeys, subKey)
 	} else {
 		if lastErr == nil {
-			lastErr = errors.StructuralError("Subkey wasn't signed; expected a 'binding' signature")
+			lastErr = errors.ErrMissingSubkeyBinding
 		}
 		e.BadSubkeys = append(e.BadSubkeys, BadSubkey{Subkey: subKey, Err: lastErr})
 	}
 	return nil
 }
 
-const defaultRSAKeyBits = 2048
+// isWeakHash reports whether h is too weak to be trusted for signatures
+// over keys.
+func isWeakHash(h crypto.Hash) bool {
+	return h == crypto.MD5 || h == crypto.SHA1
+}
 
-// NewEntity returns an Entity that contains a fresh RSA/RSA keypair with a
-// single identity composed of the given full name, comment and email, any of
-// which may be empty but must not contain any of "()<>\x00".
-// If config is nil, sensible defaults will be used.
-func NewEntity(name, comment, email string, config *packet.Config) (*Entity, error) {
-	currentTime := config.Now()
+const (
+	defaultRSAKeyBits = 2048
+	// minRSAKeyBits is the smallest RSA key that NewEntity will make.
+	minRSAKeyBits = 1024
+)
+
+// newEntityKeys generates the primary signing key and the encryption
+// subkey for NewEntity, using the algorithm selected in config.
+func newEntityKeys(currentTime time.Time, config *packet.Config) (signing, encrypting *packet.PrivateKey, err error) {
+	if signing, err = newSigningKey(currentTime, config.PublicKeyAlgorithm(), config); err != nil {
+		return nil, nil, err
+	}
+	if encrypting, err = newEncryptionKey(currentTime, config.PublicKeyAlgorithm(), config); err != nil {
+		return nil, nil, err
+	}
+	return signing, encrypting, nil
+}
+
+// newSigningKey generates a signing key of algorithm algo, created at
+// currentTime.
+func newSigningKey(currentTime time.Time, algo packet.PublicKeyAlgorithm, config *packet.Config) (*packet.PrivateKey, error) {
+	switch algo {
+	case packet.PubKeyAlgoRSA:
+		priv, err := newRSAKey(config)
+		if err != nil {
+			return nil, err
+		}
+		return packet.NewRSAPrivateKey(currentTime, priv), nil
+	case packet.PubKeyAlgoEdDSA:
+		_, priv, err := ed25519.GenerateKey(config.Random())
+		if err != nil {
+			return nil, err
+		}
+		return packet.NewEdDSAPrivateKey(currentTime, priv), nil
+	}
+	return nil, errors.UnsupportedError("public key algorithm for new entity: " + strconv.Itoa(int(algo)))
+}
 
+// newEncryptionKey generates an encryption key created at currentTime to
+// go with a signing key of algorithm algo: RSA for RSA, and Curve25519 ECDH
+// for EdDSA or ECDH.
+func newEncryptionKey(currentTime time.Time, algo packet.PublicKeyAlgorithm, config *packet.Config) (*packet.PrivateKey, error) {
+	switch algo {
+	case packet.PubKeyAlgoRSA:
+		priv, err := newRSAKey(config)
+		if err != nil {
+			return nil, err
+		}
+		return packet.NewRSAPrivateKey(currentTime, priv), nil
+	case packet.PubKeyAlgoEdDSA, packet.PubKeyAlgoECDH:
+		priv, err := ecdh.GenerateKey(curve25519.Cv25519(), config.Random())
+		if err != nil {
+			return nil, err
+		}
+		return packet.NewECDHPrivateKey(currentTime, priv), nil
+	}
+	return nil, errors.UnsupportedError("public key algorithm for new entity: " + strconv.Itoa(int(algo)))
+}
+
+// newRSAKey generates an RSA key of the size given by config.
+func newRSAKey(config *packet.Config) (*rsa.PrivateKey, error) {
 	bits := defaultRSAKeyBits
 	if config != nil && config.RSABits != 0 {
 		bits = config.RSABits
 	}
+	if bits < minRSAKeyBits {
+		return nil, errors.InvalidArgumentError("RSA key size " + strconv.Itoa(bits) + " is too small")
+	}
+	return rsa.GenerateKey(config.Random(), bits)
+}
+
+// NewEntity returns an Entity that contains a fresh keypair with a single
+// identity composed of the given full name, comment and email, any of
+// which may be empty but must not contain any of "()<>\x00". The keys are
+// RSA/RSA unless config.Algorithm selects EdDSA, in which case they are
+// Ed25519/Curve25519.
+// If config is nil, sensible defaults will be used.
+func NewEntity(name, comment, email string, config *packet.Config) (*Entity, error) {
+	currentTime := config.Now()
 
 	uid := packet.NewUserId(name, comment, email)
 	if uid == nil {
 		return nil, errors.InvalidArgumentError("user id field contained invalid characters")
 	}
-	signingPriv, err := rsa.GenerateKey(config.Random(), bits)
-	if err != nil {
-		return nil, err
-	}
-	encryptingPriv, err := rsa.GenerateKey(config.Random(), bits)
+	signingPriv, encryptingPriv, err := newEntityKeys(currentTime, config)
 	if err != nil {
 		return nil, err
 	}
 
 	e := &Entity{
-		PrimaryKey: packet.NewRSAPublicKey(currentTime, &signingPriv.PublicKey),
-		PrivateKey: packet.NewRSAPrivateKey(currentTime, signingPriv),
+		PrimaryKey: &signingPriv.PublicKey,
+		PrivateKey: signingPriv,
 		Identities: make(map[string]*Identity),
 	}
 	isPrimaryId := true
@@ -710,13 +1534,15 @@ func NewEntity(name, comment, email string, config *packet.Config) (*Entity, err
 		SelfSignature: &packet.Signature{
 			CreationTime: currentTime,
 			SigType:      packet.SigTypePositiveCert,
-			PubKeyAlgo:   packet.PubKeyAlgoRSA,
+			PubKeyAlgo:   signingPriv.PubKeyAlgo,
 			Hash:         config.Hash(),
 			IsPrimaryId:  &isPrimaryId,
 			FlagsValid:   true,
 			FlagSign:     true,
 			FlagCertify:  true,
 			IssuerKeyId:  &e.PrimaryKey.KeyId,
+			MDC:          true,
+			Features:     []byte{packet.FeatureMDC},
 		},
 	}
 
@@ -731,14 +1557,24 @@ func NewEntity(name, comment, email string, config *packet.Config) (*Entity, err
 		e.Identities[uid.Id].SelfSignature.PreferredSymmetric = []uint8{uint8(config.DefaultCipher)}
 	}
 
+	// And for DefaultCompressionAlgo.
+	if config != nil && config.DefaultCompressionAlgo != packet.CompressionNone {
+		e.Identities[uid.Id].SelfSignature.PreferredCompression = []uint8{uint8(config.DefaultCompressionAlgo)}
+	}
+
+	if config != nil {
+		e.Identities[uid.Id].SelfSignature.PreferredKeyServer = config.PreferredKeyServer
+		e.Identities[uid.Id].SelfSignature.PolicyURI = config.PolicyURI
+	}
+
 	e.Subkeys = make([]Subkey, 1)
 	e.Subkeys[0] = Subkey{
-		PublicKey:  packet.NewRSAPublicKey(currentTime, &encryptingPriv.PublicKey),
-		PrivateKey: packet.NewRSAPrivateKey(currentTime, encryptingPriv),
+		PublicKey:  &encryptingPriv.PublicKey,
+		PrivateKey: encryptingPriv,
 		Sig: &packet.Signature{
 			CreationTime:              currentTime,
 			SigType:                   packet.SigTypeSubkeyBinding,
-			PubKeyAlgo:                packet.PubKeyAlgoRSA,
+			PubKeyAlgo:                signingPriv.PubKeyAlgo,
 			Hash:                      config.Hash(),
 			FlagsValid:                true,
 			FlagEncryptStorage:        true,
@@ -749,6 +1585,17 @@ func NewEntity(name, comment, email string, config *packet.Config) (*Entity, err
 	e.Subkeys[0].PublicKey.IsSubkey = true
 	e.Subkeys[0].PrivateKey.IsSubkey = true
 
+	// Sign right away, so that the entity is valid before it is
+	// serialized. SerializePrivate signs again, after any changes.
+	err = e.Identities[uid.Id].SelfSignature.SignUserId(uid.Id, e.PrimaryKey, e.PrivateKey, config)
+	if err != nil {
+		return nil, err
+	}
+	err = e.Subkeys[0].Sig.SignKey(e.Subkeys[0].PublicKey, e.PrivateKey, config)
+	if err != nil {
+		return nil, err
+	}
+
 	return e, nil
 }
 
@@ -776,8 +1623,26 @@ func (e *Entity) SerializePrivate(w io.Writer, config *packet.Config) (err error
 		if err != nil {
 			return
 		}
+		if ident.Revocation != nil {
+			err = ident.Revocation.Serialize(w)
+			if err != nil {
+				return
+			}
+		}
+	}
+	for _, attr := range e.Attributes {
+		if e.PrivateKey.PrivateKey != nil {
+			err = attr.SelfSignature.SignUserAttribute(attr.UserAttribute, e.PrimaryKey, e.PrivateKey, config)
+			if err != nil {
+				return
+			}
+		}
+		err = attr.serialize(w, false, false)
+		if err != nil {
+			return
+		}
 	}
-	for _, subkey := range e.Subkeys {
+	for i, subkey := range e.Subkeys {
 		err = subkey.PrivateKey.Serialize(w)
 		if err != nil {
 			return
@@ -787,6 +1652,11 @@ func (e *Entity) SerializePrivate(w io.Writer, config *packet.Config) (err error
 			// (subkey binding), but also sign primary key using subkey (primary
 			// key binding) if subkey is used for signing.
 			if subkey.Sig.FlagSign {
+				// The binding signature may already have been made, e.g.
+				// by AddSigningSubkey, and the cross-signature has to
+				// be made before it.
+				subkey.Sig = subkey.Sig.CopyUnsigned()
+				e.Subkeys[i].Sig = subkey.Sig
 				err = subkey.Sig.CrossSignKey(e.PrimaryKey, subkey.PrivateKey, config)
 				if err != nil {
 					return err
@@ -813,93 +1683,1047 @@ func (e *Entity) SerializePrivate(w io.Writer, config *packet.Config) (err error
 	return nil
 }
 
-// Serialize writes the public part of the given Entity to w. (No private
-// key material will be output).
-func (e *Entity) Serialize(w io.Writer) error {
-	err := e.PrimaryKey.Serialize(w)
+// SerializePrivateWithoutSigning writes the given Entity, including private
+// key material, to w without making new signatures: the self-signatures,
+// binding signatures and certifications that e already holds are written as
+// they are. Unlike SerializePrivate, it works on keys that are still
+// encrypted.
+//
+// The output is not byte-identical to the packets e was read from: packets
+// are rebuilt from what e holds, not copied, and neither their order nor
+// their encoding is kept. Identities are written with the primary identity
+// first and the others sorted by name, so that the output does not change
+// between calls; each identity and subkey keeps only the self-signature or
+// binding signature that ReadEntity selected; Trust packets, BadSubkeys and
+// UnverifiedRevocations are left out; UnknownPackets are written last; and
+// all packets get new format headers. Callers that need the exact input
+// must keep its bytes themselves.
+func (e *Entity) SerializePrivateWithoutSigning(w io.Writer) (err error) {
+	if e.PrivateKey == nil {
+		return errors.InvalidArgumentError("entity has no private key")
+	}
+	err = e.PrivateKey.Serialize(w)
 	if err != nil {
-		return err
+		return
 	}
-	for _, ident := range e.Identities {
+	for _, sig := range e.Revocations {
+		err = sig.Serialize(w)
+		if err != nil {
+			return
+		}
+	}
+	for _, ident := range e.sortedIdentities() {
 		err = ident.UserId.Serialize(w)
 		if err != nil {
-			return err
+			return
 		}
 		err = ident.SelfSignature.Serialize(w)
 		if err != nil {
-			return err
+			return
+		}
+		if ident.Revocation != nil {
+			err = ident.Revocation.Serialize(w)
+			if err != nil {
+				return
+			}
 		}
 		for _, sig := range ident.Signatures {
 			err = sig.Serialize(w)
 			if err != nil {
-				return err
+				return
 			}
 		}
 	}
+	for _, attr := range e.Attributes {
+		err = attr.serialize(w, true, false)
+		if err != nil {
+			return
+		}
+	}
 	for _, subkey := range e.Subkeys {
-		err = subkey.PublicKey.Serialize(w)
+		err = subkey.PrivateKey.Serialize(w)
 		if err != nil {
-			return err
+			return
 		}
-
 		if subkey.Revocation != nil {
 			err = subkey.Revocation.Serialize(w)
 			if err != nil {
-				return err
+				return
 			}
 		}
 		err = subkey.Sig.Serialize(w)
 		if err != nil {
+			return
+		}
+	}
+	for _, op := range e.UnknownPackets {
+		err = op.Serialize(w)
+		if err != nil {
+			return
+		}
+	}
+	return nil
+}
+
+// sortedIdentities returns the identities of e, the primary identity first
+// and the others sorted by name.
+func (e *Entity) sortedIdentities() []*Identity {
+	primary := e.PrimaryIdentity()
+	idents := make([]*Identity, 0, len(e.Identities))
+	for _, ident := range e.Identities {
+		if ident != primary {
+			idents = append(idents, ident)
+		}
+	}
+	sort.Slice(idents, func(i, j int) bool { return idents[i].Name < idents[j].Name })
+	if primary != nil {
+		idents = append([]*Identity{primary}, idents...)
+	}
+	return idents
+}
+
+// ChangePassphrase decrypts the private primary key and subkeys of e with
+// oldPassphrase and encrypts them again with newPassphrase. Keys that are
+// not encrypted are encrypted with newPassphrase, and keys without private
+// material, such as GNU dummy keys, are left alone. If any key can't be
+// decrypted, an error is returned and none of the keys are changed.
+// If config is nil, sensible defaults will be used.
+func (e *Entity) ChangePassphrase(oldPassphrase, newPassphrase []byte, config *packet.Config) error {
+	keys := []*packet.PrivateKey{e.PrivateKey}
+	for _, subkey := range e.Subkeys {
+		keys = append(keys, subkey.PrivateKey)
+	}
+
+	// Work on copies so that a failure leaves e as it was.
+	locked := make([]packet.PrivateKey, len(keys))
+	for i, key := range keys {
+		if key == nil {
+			continue
+		}
+		locked[i] = *key
+		if err := locked[i].Decrypt(oldPassphrase); err != nil {
+			return err
+		}
+		if locked[i].PrivateKey == nil {
+			continue
+		}
+		if err := locked[i].Encrypt(newPassphrase, config); err != nil {
 			return err
 		}
 	}
+
+	for i, key := range keys {
+		if key != nil {
+			*key = locked[i]
+		}
+	}
 	return nil
 }
 
-// SignIdentity adds a signature to e, from signer, attesting that identity is
-// associated with e. The provided identity must already be an element of
-// e.Identities and the private key of signer must have been decrypted if
-// necessary.
+// AddEncryptionSubkey generates a new encryption subkey, binds it to e with
+// a signature by the primary key and appends it to e.Subkeys. The subkey is
+// RSA or Curve25519 ECDH to match the primary key, unless config.Algorithm
+// asks for the other. Being the newest, it is the
+// subkey that will be used for encryption. The primary private key must
+// have been decrypted.
 // If config is nil, sensible defaults will be used.
-func (e *Entity) SignIdentity(identity string, signer *Entity, config *packet.Config) error {
-	if signer.PrivateKey == nil {
-		return errors.InvalidArgumentError("signing Entity must have a private key")
+func (e *Entity) AddEncryptionSubkey(config *packet.Config) error {
+	if e.PrivateKey == nil || e.PrivateKey.Encrypted || e.PrivateKey.PrivateKey == nil {
+		return errors.InvalidArgumentError("AddEncryptionSubkey needs a decrypted primary private key")
 	}
-	if signer.PrivateKey.Encrypted {
-		return errors.InvalidArgumentError("signing Entity's private key must be decrypted")
+
+	algo := e.PrimaryKey.PubKeyAlgo
+	if config != nil && config.Algorithm != 0 {
+		algo = config.Algorithm
 	}
-	ident, ok := e.Identities[identity]
-	if !ok {
-		return errors.InvalidArgumentError("given identity string not found in Entity")
+	currentTime := config.Now()
+	priv, err := newEncryptionKey(currentTime, algo, config)
+	if err != nil {
+		return err
+	}
+	priv.IsSubkey = true
+	priv.PublicKey.IsSubkey = true
+
+	subkey := Subkey{
+		PublicKey:  &priv.PublicKey,
+		PrivateKey: priv,
+		Sig: &packet.Signature{
+			CreationTime:              currentTime,
+			SigType:                   packet.SigTypeSubkeyBinding,
+			PubKeyAlgo:                e.PrivateKey.PubKeyAlgo,
+			Hash:                      config.Hash(),
+			FlagsValid:                true,
+			FlagEncryptStorage:        true,
+			FlagEncryptCommunications: true,
+			IssuerKeyId:               &e.PrimaryKey.KeyId,
+		},
+	}
+	if err := subkey.Sig.SignKey(subkey.PublicKey, e.PrivateKey, config); err != nil {
+		return err
+	}
+	e.Subkeys = append(e.Subkeys, subkey)
+	return nil
+}
+
+// AddSigningSubkey generates a new signing subkey and binds it to e with a
+// signature by the primary key, which embeds the cross-signature of the
+// primary key by the subkey, and appends it to e.Subkeys. The subkey is RSA
+// or Ed25519 to match the primary key, unless config.Algorithm asks for the
+// other. The primary private key must have been decrypted.
+// If config is nil, sensible defaults will be used.
+func (e *Entity) AddSigningSubkey(config *packet.Config) error {
+	if e.PrivateKey == nil || e.PrivateKey.Encrypted || e.PrivateKey.PrivateKey == nil {
+		return errors.InvalidArgumentError("AddSigningSubkey needs a decrypted primary private key")
+	}
+
+	algo := e.PrimaryKey.PubKeyAlgo
+	if config != nil && config.Algorithm != 0 {
+		algo = config.Algorithm
+	}
+	currentTime := config.Now()
+	priv, err := newSigningKey(currentTime, algo, config)
+	if err != nil {
+		return err
+	}
+	priv.IsSubkey = true
+	priv.PublicKey.IsSubkey = true
+
+	subkey := Subkey{
+		PublicKey:  &priv.PublicKey,
+		PrivateKey: priv,
+		Sig: &packet.Signature{
+			CreationTime: currentTime,
+			SigType:      packet.SigTypeSubkeyBinding,
+			PubKeyAlgo:   e.PrivateKey.PubKeyAlgo,
+			Hash:         config.Hash(),
+			FlagsValid:   true,
+			FlagSign:     true,
+			IssuerKeyId:  &e.PrimaryKey.KeyId,
+		},
+	}
+	// The cross-signature is embedded in the binding signature, so it
+	// has to be made first.
+	if err := subkey.Sig.CrossSignKey(e.PrimaryKey, priv, config); err != nil {
+		return err
+	}
+	if err := subkey.Sig.SignKey(subkey.PublicKey, e.PrivateKey, config); err != nil {
+		return err
+	}
+	e.Subkeys = append(e.Subkeys, subkey)
+	return nil
+}
+
+// AddUserId adds an identity composed of the given full name, comment and
+// email to e, any of which may be empty but must not contain any of
+// "()<>\x00". The new identity gets a positive certification self-signature
+// that copies the algorithm preferences, key flags and key lifetime of the
+// primary identity's self-signature. The primary private key must have
+// been decrypted.
+// If config is nil, sensible defaults will be used.
+func (e *Entity) AddUserId(name, comment, email string, config *packet.Config) error {
+	if e.PrivateKey == nil || e.PrivateKey.Encrypted || e.PrivateKey.PrivateKey == nil {
+		return errors.InvalidArgumentError("AddUserId needs a decrypted primary private key")
+	}
+	uid := packet.NewUserId(name, comment, email)
+	if uid == nil {
+		return errors.InvalidArgumentError("user id field contained invalid characters")
+	}
+	if _, ok := e.Identities[uid.Id]; ok {
+		return errors.InvalidArgumentError("user id exists already")
 	}
 
 	sig := &packet.Signature{
-		SigType:      packet.SigTypeGenericCert,
-		PubKeyAlgo:   signer.PrivateKey.PubKeyAlgo,
-		Hash:         config.Hash(),
 		CreationTime: config.Now(),
-		IssuerKeyId:  &signer.PrivateKey.KeyId,
+		SigType:      packet.SigTypePositiveCert,
+		PubKeyAlgo:   e.PrivateKey.PubKeyAlgo,
+		Hash:         config.Hash(),
+		IssuerKeyId:  &e.PrimaryKey.KeyId,
+	}
+	if primary := e.PrimaryIdentity(); primary != nil && primary.SelfSignature != nil {
+		self := primary.SelfSignature
+		sig.PreferredHash = self.PreferredHash
+		sig.PreferredSymmetric = self.PreferredSymmetric
+		sig.PreferredCompression = self.PreferredCompression
+		sig.MDC = self.MDC
+		sig.Features = self.Features
+		sig.FlagsValid = self.FlagsValid
+		sig.FlagCertify = self.FlagCertify
+		sig.FlagSign = self.FlagSign
+		sig.FlagEncryptCommunications = self.FlagEncryptCommunications
+		sig.FlagEncryptStorage = self.FlagEncryptStorage
+		sig.FlagAuthenticate = self.FlagAuthenticate
+		sig.KeyLifetimeSecs = self.KeyLifetimeSecs
 	}
-	if err := sig.SignUserId(identity, e.PrimaryKey, signer.PrivateKey, config); err != nil {
+
+	if err := sig.SignUserId(uid.Id, e.PrimaryKey, e.PrivateKey, config); err != nil {
 		return err
 	}
-	ident.Signatures = append(ident.Signatures, sig)
+	if err := e.PrimaryKey.VerifyUserIdSignature(uid.Id, e.PrimaryKey, sig); err != nil {
+		return err
+	}
+
+	e.Identities[uid.Id] = &Identity{
+		Name:          uid.Id,
+		UserId:        uid,
+		SelfSignature: sig,
+	}
 	return nil
 }
 
+// AddPhoto adds a user attribute holding the given JPEG image to e, as
+// GnuPG's addphoto command does. The attribute gets a positive
+// certification self-signature, so the primary private key must have been
+// decrypted.
+// If config is nil, sensible defaults will be used.
+func (e *Entity) AddPhoto(jpeg []byte, config *packet.Config) error {
+	if e.PrivateKey == nil || e.PrivateKey.Encrypted || e.PrivateKey.PrivateKey == nil {
+		return errors.InvalidArgumentError("AddPhoto needs a decrypted primary private key")
+	}
+	uat, err := packet.NewUserAttributeJPEG(jpeg)
+	if err != nil {
+		return err
+	}
+	for _, attr := range e.Attributes {
+		if images := attr.UserAttribute.ImageData(); len(images) == 1 && bytes.Equal(images[0], jpeg) {
+			return errors.InvalidArgumentError("photo exists already")
+		}
+	}
+
+	sig := &packet.Signature{
+		CreationTime: config.Now(),
+		SigType:      packet.SigTypePositiveCert,
+		PubKeyAlgo:   e.PrivateKey.PubKeyAlgo,
+		Hash:         config.Hash(),
+		IssuerKeyId:  &e.PrimaryKey.KeyId,
+	}
+	if err := sig.SignUserAttribute(uat, e.PrimaryKey, e.PrivateKey, config); err != nil {
+		return err
+	}
+	if err := e.PrimaryKey.VerifyUserAttributeSignature(uat, e.PrimaryKey, sig); err != nil {
+		return err
+	}
+
+	e.Attributes = append(e.Attributes, &UserAttribute{
+		UserAttribute: uat,
+		SelfSignature: sig,
+	})
+	return nil
+}
+
+// RevokeUserId revokes the identity id of e with a certification revocation
+// signature made by the primary key, which must have been decrypted. The
+// signature is stored in the identity's Revocation field and serialized
+// with it.
+// If config is nil, sensible defaults will be used.
+func (e *Entity) RevokeUserId(id string, reason packet.ReasonForRevocation, config *packet.Config) error {
+	ident, ok := e.Identities[id]
+	if !ok {
+		return errors.InvalidArgumentError("user id not found")
+	}
+	if e.PrivateKey == nil || e.PrivateKey.Encrypted || e.PrivateKey.PrivateKey == nil {
+		return errors.InvalidArgumentError("RevokeUserId needs a decrypted primary private key")
+	}
+
+	reasonCode := uint8(reason)
+	sig := &packet.Signature{
+		CreationTime:     config.Now(),
+		SigType:          packet.SigTypeIdentityRevocation,
+		PubKeyAlgo:       e.PrivateKey.PubKeyAlgo,
+		Hash:             config.Hash(),
+		IssuerKeyId:      &e.PrimaryKey.KeyId,
+		RevocationReason: &reasonCode,
+	}
+	if err := sig.SignUserId(id, e.PrimaryKey, e.PrivateKey, config); err != nil {
+		return err
+	}
+	ident.Revocation = sig
+	return nil
+}
+
+// RevokeSubkey revokes the subkey of e whose public key is pub, for the
+// given reason, with a subkey revocation signature made by the primary key,
+// which must have been decrypted. The signature is stored in the subkey's
+// Revocation field and serialized with it.
+// If config is nil, sensible defaults will be used.
+func (e *Entity) RevokeSubkey(pub *packet.PublicKey, reason packet.ReasonForRevocation, reasonText string, config *packet.Config) error {
+	var subkey *Subkey
+	for i := range e.Subkeys {
+		if e.Subkeys[i].PublicKey == pub {
+			subkey = &e.Subkeys[i]
+			break
+		}
+	}
+	if subkey == nil {
+		return errors.InvalidArgumentError("subkey not found")
+	}
+	if e.PrivateKey == nil || e.PrivateKey.Encrypted || e.PrivateKey.PrivateKey == nil {
+		return errors.InvalidArgumentError("RevokeSubkey needs a decrypted primary private key")
+	}
+
+	reasonCode := uint8(reason)
+	sig := &packet.Signature{
+		CreationTime:         config.Now(),
+		SigType:              packet.SigTypeSubkeyRevocation,
+		PubKeyAlgo:           e.PrivateKey.PubKeyAlgo,
+		Hash:                 config.Hash(),
+		IssuerKeyId:          &e.PrimaryKey.KeyId,
+		RevocationReason:     &reasonCode,
+		RevocationReasonText: reasonText,
+	}
+	if err := sig.SignKey(subkey.PublicKey, e.PrivateKey, config); err != nil {
+		return err
+	}
+	subkey.Revocation = sig
+	return nil
+}
+
+// SetExpiration makes e's primary key and all of its subkeys expire d from
+// now, or never if d is zero. It replaces the self-signatures of every
+// identity and the binding signature of every subkey with fresh ones that
+// carry the new key lifetime and otherwise keep the preferences and key
+// flags of the old ones. The primary private key, and the private keys of
+// signing subkeys, must have been decrypted.
+// If config is nil, sensible defaults will be used.
+func (e *Entity) SetExpiration(d time.Duration, config *packet.Config) error {
+	if e.PrivateKey == nil || e.PrivateKey.Encrypted || e.PrivateKey.PrivateKey == nil {
+		return errors.InvalidArgumentError("SetExpiration needs a decrypted primary private key")
+	}
+	if d < 0 {
+		return errors.InvalidArgumentError("negative expiration")
+	}
+	now := config.Now()
+
+	// lifetime returns the key lifetime subpacket value for a key created
+	// at created, which is relative to that time.
+	lifetime := func(created time.Time) (*uint32, error) {
+		if d == 0 {
+			return nil, nil
+		}
+		secs := int64(now.Add(d).Sub(created) / time.Second)
+		if secs <= 0 || secs > math.MaxUint32 {
+			return nil, errors.InvalidArgumentError("expiration out of range")
+		}
+		lifetimeSecs := uint32(secs)
+		return &lifetimeSecs, nil
+	}
+
+	primaryLifetime, err := lifetime(e.PrimaryKey.CreationTime)
+	if err != nil {
+		return err
+	}
+	identities := make(map[string]*packet.Signature)
+	for name, ident := range e.Identities {
+		sig := ident.SelfSignature.CopyUnsigned()
+		sig.CreationTime = now
+		sig.KeyLifetimeSecs = primaryLifetime
+		if err := sig.SignUserId(ident.UserId.Id, e.PrimaryKey, e.PrivateKey, config); err != nil {
+			return err
+		}
+		identities[name] = sig
+	}
+
+	subkeys := make([]*packet.Signature, len(e.Subkeys))
+	for i, subkey := range e.Subkeys {
+		sig := subkey.Sig.CopyUnsigned()
+		sig.CreationTime = now
+		if sig.KeyLifetimeSecs, err = lifetime(subkey.PublicKey.CreationTime); err != nil {
+			return err
+		}
+		if sig.FlagSign {
+			if subkey.PrivateKey == nil || subkey.PrivateKey.Encrypted || subkey.PrivateKey.PrivateKey == nil {
+				return errors.InvalidArgumentError("SetExpiration needs the decrypted private key of signing subkey " + subkey.PublicKey.KeyIdString())
+			}
+			if err := sig.CrossSignKey(e.PrimaryKey, subkey.PrivateKey, config); err != nil {
+				return err
+			}
+		}
+		if err := sig.SignKey(subkey.PublicKey, e.PrivateKey, config); err != nil {
+			return err
+		}
+		subkeys[i] = sig
+	}
+
+	// Only replace the signatures once all of them have been made.
+	for name, sig := range identities {
+		e.Identities[name].SelfSignature = sig
+	}
+	for i, sig := range subkeys {
+		e.Subkeys[i].Sig = sig
+	}
+	return nil
+}
+
+// RevalidateBindings re-verifies every subkey's binding signature, including
+// the cross-signature of signing subkeys, against e's primary key. It
+// returns an error for the first subkey whose binding doesn't validate or
+// was made after now. This guards against a subkey ending up in e without a
+// valid binding, e.g. after merging in another copy of the key.
+func (e *Entity) RevalidateBindings(now time.Time) error {
+	for _, subkey := range e.Subkeys {
+		keyId := strconv.FormatUint(subkey.PublicKey.KeyId, 16)
+		if subkey.Sig == nil {
+			return errors.ErrMissingSubkeyBinding.WithDetail(keyId, nil)
+		}
+		if subkey.Sig.CreationTime.After(now) {
+			return errors.StructuralError("binding signature of subkey " + keyId + " is from the future")
+		}
+		if err := e.PrimaryKey.VerifyKeySignature(subkey.PublicKey, subkey.Sig); err != nil {
+			return errors.ErrBadSubkeySignature.WithDetail(keyId, err)
+		}
+	}
+	return nil
+}
+
+// RevocationAnnouncement returns an armored public key block holding only
+// e's primary key and its revocation signatures. That is enough for anyone
+// to verify that the key was revoked, without distributing all of its
+// identities, subkeys and certifications.
+func (e *Entity) RevocationAnnouncement() ([]byte, error) {
+	if len(e.Revocations) == 0 {
+		return nil, errors.InvalidArgumentError("key is not revoked")
+	}
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, PublicKeyType, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = e.PrimaryKey.Serialize(w); err != nil {
+		return nil, err
+	}
+	for _, sig := range e.Revocations {
+		if err = sig.Serialize(w); err != nil {
+			return nil, err
+		}
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Revoke returns a key revocation signature for e, made by its primary key
+// with the given reason. Only the primary private key needs to have been
+// decrypted. The signature can be kept, e.g. serialized with
+// SerializeRevocationCertificate, and applied with ApplyRevocation when
+// the key has to be revoked.
+// If config is nil, sensible defaults will be used.
+func (e *Entity) Revoke(reason packet.ReasonForRevocation, reasonText string, config *packet.Config) (*packet.Signature, error) {
+	if e.PrivateKey == nil || e.PrivateKey.Encrypted || e.PrivateKey.PrivateKey == nil {
+		return nil, errors.InvalidArgumentError("Revoke needs a decrypted primary private key")
+	}
+
+	reasonCode := uint8(reason)
+	sig := &packet.Signature{
+		CreationTime:         config.Now(),
+		SigType:              packet.SigTypeKeyRevocation,
+		PubKeyAlgo:           e.PrivateKey.PubKeyAlgo,
+		Hash:                 config.Hash(),
+		IssuerKeyId:          &e.PrimaryKey.KeyId,
+		RevocationReason:     &reasonCode,
+		RevocationReasonText: reasonText,
+	}
+	if err := sig.RevokeKey(e.PrimaryKey, e.PrivateKey, config); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// ApplyRevocation checks that sig is a revocation of e's primary key made
+// by that key and, if so, adds it to e.Revocations.
+func (e *Entity) ApplyRevocation(sig *packet.Signature) error {
+	if sig.SigType != packet.SigTypeKeyRevocation {
+		return errors.InvalidArgumentError("not a key revocation signature")
+	}
+	if err := e.PrimaryKey.VerifyRevocationSignature(e.PrimaryKey, sig); err != nil {
+		return err
+	}
+	e.Revocations = append(e.Revocations, sig)
+	return nil
+}
+
+// ApplyDesignatedRevocation checks that sig is a revocation of e's primary
+// key made by the primary key of revoker, and that revoker is one of
+// e.DesignatedRevokers. If so, sig is added to e.Revocations and removed
+// from e.UnverifiedRevocations.
+func (e *Entity) ApplyDesignatedRevocation(revoker *Entity, sig *packet.Signature) error {
+	if sig.SigType != packet.SigTypeKeyRevocation {
+		return errors.InvalidArgumentError("not a key revocation signature")
+	}
+	authorized := false
+	for _, desig := range e.DesignatedRevokers {
+		if desig.PublicKeyAlgo == revoker.PrimaryKey.PubKeyAlgo && bytes.Equal(desig.Fingerprint, revoker.PrimaryKey.FullFingerprint()) {
+			authorized = true
+			break
+		}
+	}
+	if !authorized {
+		return errors.SignatureError("revoker is not a designated revoker of the key")
+	}
+	if err := revoker.PrimaryKey.VerifyRevocationSignature(e.PrimaryKey, sig); err != nil {
+		return err
+	}
+
+	unverified := e.UnverifiedRevocations[:0]
+	for _, s := range e.UnverifiedRevocations {
+		if s != sig {
+			unverified = append(unverified, s)
+		}
+	}
+	e.UnverifiedRevocations = unverified
+	e.Revocations = append(e.Revocations, sig)
+	return nil
+}
+
+// designatedRevoker returns the entry of e.DesignatedRevokers for the key
+// that issued sig, or nil if sig wasn't issued by a designated revoker.
+func (e *Entity) designatedRevoker(sig *packet.Signature) *packet.RevocationKey {
+	for _, desig := range e.DesignatedRevokers {
+		if desig.PublicKeyAlgo != sig.PubKeyAlgo {
+			continue
+		}
+		if sig.IssuerFingerprint != nil {
+			if bytes.Equal(sig.IssuerFingerprint, desig.Fingerprint) {
+				return desig
+			}
+			continue
+		}
+		// Version 4 key ids are the end of the fingerprint, later ones
+		// the start.
+		var keyId uint64
+		switch len(desig.Fingerprint) {
+		case 20:
+			keyId = binary.BigEndian.Uint64(desig.Fingerprint[12:])
+		case 32:
+			keyId = binary.BigEndian.Uint64(desig.Fingerprint[:8])
+		default:
+			continue
+		}
+		if sig.IssuerKeyId != nil && *sig.IssuerKeyId == keyId {
+			return desig
+		}
+	}
+	return nil
+}
+
+// SerializeRevocationCertificate writes sig to w as an armored revocation
+// certificate, in the format that GnuPG uses.
+func SerializeRevocationCertificate(w io.Writer, sig *packet.Signature) error {
+	headers := map[string]string{"Comment": "This is a revocation certificate"}
+	aw, err := armor.Encode(w, PublicKeyType, headers)
+	if err != nil {
+		return err
+	}
+	if err = sig.Serialize(aw); err != nil {
+		return err
+	}
+	return aw.Close()
+}
+
+// exportableSignature returns false for local certifications and for
+// signatures that name a designated revoker marked as sensitive. Like GnuPG,
+// we leave such signatures out when exporting a key rather than leak them.
+func exportableSignature(sig *packet.Signature) bool {
+	if sig.NonExportable {
+		return false
+	}
+	return sig.DesignatedRevoker == nil || !sig.DesignatedRevoker.Sensitive()
+}
+
+// ToPublic returns a copy of e without private key material: the
+// PrivateKey fields of the copy and of its subkeys are nil. The identities
+// and subkeys are copied, so that the copy can be changed without affecting
+// e, but the packets themselves are shared.
+func (e *Entity) ToPublic() *Entity {
+	pub := *e
+	pub.PrivateKey = nil
+
+	pub.Identities = make(map[string]*Identity, len(e.Identities))
+	for name, ident := range e.Identities {
+		identCopy := *ident
+		identCopy.Signatures = append([]*packet.Signature(nil), ident.Signatures...)
+		pub.Identities[name] = &identCopy
+	}
+
+	pub.Subkeys = make([]Subkey, len(e.Subkeys))
+	for i, subkey := range e.Subkeys {
+		subkey.PrivateKey = nil
+		pub.Subkeys[i] = subkey
+	}
+	if e.BadSubkeys != nil {
+		pub.BadSubkeys = make([]BadSubkey, len(e.BadSubkeys))
+		for i, subkey := range e.BadSubkeys {
+			subkey.PrivateKey = nil
+			pub.BadSubkeys[i] = subkey
+		}
+	}
+
+	pub.Attributes = append([]*UserAttribute(nil), e.Attributes...)
+	pub.Revocations = append([]*packet.Signature(nil), e.Revocations...)
+	pub.UnverifiedRevocations = append([]*packet.Signature(nil), e.UnverifiedRevocations...)
+	return &pub
+}
+
+// PublicOnly returns the result of ToPublic for each entity of el, for
+// exporting the public keys of a private keyring.
+func (el EntityList) PublicOnly() EntityList {
+	pub := make(EntityList, len(el))
+	for i, e := range el {
+		pub[i] = e.ToPublic()
+	}
+	return pub
+}
+
+// Serialize writes the public part of the given Entity to w. (No private
+// key material will be output). Non-exportable certifications and
+// signatures that name a sensitive designated revoker are omitted. If a
+// self-signature is one of those, the identity or user attribute it
+// belongs to is left out altogether, as it couldn't be used without it.
+func (e *Entity) Serialize(w io.Writer) error {
+	err := e.PrimaryKey.Serialize(w)
+	if err != nil {
+		return err
+	}
+	for _, ident := range e.Identities {
+		if !exportableSignature(ident.SelfSignature) {
+			continue
+		}
+		err = ident.UserId.Serialize(w)
+		if err != nil {
+			return err
+		}
+		err = ident.SelfSignature.Serialize(w)
+		if err != nil {
+			return err
+		}
+		if ident.Revocation != nil {
+			err = ident.Revocation.Serialize(w)
+			if err != nil {
+				return err
+			}
+		}
+		for _, sig := range ident.Signatures {
+			if !exportableSignature(sig) {
+				continue
+			}
+			err = sig.Serialize(w)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, attr := range e.Attributes {
+		err = attr.serialize(w, true, true)
+		if err != nil {
+			return err
+		}
+	}
+	for _, subkey := range e.Subkeys {
+		err = subkey.PublicKey.Serialize(w)
+		if err != nil {
+			return err
+		}
+
+		if subkey.Revocation != nil {
+			err = subkey.Revocation.Serialize(w)
+			if err != nil {
+				return err
+			}
+		}
+		err = subkey.Sig.Serialize(w)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// serialize writes the user attribute packet of attr followed by its
+// self-signature and revocation, and, if certifications is set, the
+// certifications by other keys. With exportOnly set, non-exportable
+// certifications are left out, and nothing at all is written if the
+// self-signature can't be exported.
+func (attr *UserAttribute) serialize(w io.Writer, certifications, exportOnly bool) error {
+	if exportOnly && !exportableSignature(attr.SelfSignature) {
+		return nil
+	}
+	if err := attr.UserAttribute.Serialize(w); err != nil {
+		return err
+	}
+	if err := attr.SelfSignature.Serialize(w); err != nil {
+		return err
+	}
+	if attr.Revocation != nil {
+		if err := attr.Revocation.Serialize(w); err != nil {
+			return err
+		}
+	}
+	if !certifications {
+		return nil
+	}
+	for _, sig := range attr.Signatures {
+		if exportOnly && !exportableSignature(sig) {
+			continue
+		}
+		if err := sig.Serialize(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SerializeMinimal writes the public part of the given Entity to w, keeping
+// only the signatures that are needed to use the key, like GnuPG's
+// export-minimal option: key revocations, self-signatures and revocations of
+// the identities, and the binding signatures and revocations of the
+// subkeys. Certifications by other keys are dropped. As with Serialize,
+// identities and user attributes whose self-signature can't be exported
+// are left out.
+func (e *Entity) SerializeMinimal(w io.Writer) error {
+	if err := e.PrimaryKey.Serialize(w); err != nil {
+		return err
+	}
+	for _, sig := range e.Revocations {
+		if err := sig.Serialize(w); err != nil {
+			return err
+		}
+	}
+	for _, ident := range e.Identities {
+		if !exportableSignature(ident.SelfSignature) {
+			continue
+		}
+		if err := ident.UserId.Serialize(w); err != nil {
+			return err
+		}
+		if err := ident.SelfSignature.Serialize(w); err != nil {
+			return err
+		}
+		if ident.Revocation != nil {
+			if err := ident.Revocation.Serialize(w); err != nil {
+				return err
+			}
+		}
+	}
+	for _, attr := range e.Attributes {
+		if err := attr.serialize(w, false, true); err != nil {
+			return err
+		}
+	}
+	for _, subkey := range e.Subkeys {
+		if err := subkey.PublicKey.Serialize(w); err != nil {
+			return err
+		}
+		if subkey.Revocation != nil {
+			if err := subkey.Revocation.Serialize(w); err != nil {
+				return err
+			}
+		}
+		if err := subkey.Sig.Serialize(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SignIdentity adds a signature to e, from signer, attesting that identity is
+// associated with e. The provided identity must already be an element of
+// e.Identities and the private key of signer must have been decrypted if
+// necessary.
+// If config is nil, sensible defaults will be used.
+func (e *Entity) SignIdentity(identity string, signer *Entity, config *packet.Config) error {
+	return signer.CertifyIdentity(e, identity, packet.SigTypeGenericCert, CertifyOptions{}, config)
+}
+
+// CertifyOptions holds optional settings for CertifyIdentity.
+type CertifyOptions struct {
+	// TrustLevel and TrustAmount, if non-zero, turn the certification
+	// into a trust signature. See RFC 4880, section 5.2.3.13.
+	TrustLevel, TrustAmount uint8
+	// Regex limits the User IDs that the certified key is trusted to
+	// introduce. See RFC 4880, section 5.2.3.14.
+	Regex string
+	// NonExportable makes a local certification, which is not meant to
+	// leave the keyring of the certifier.
+	NonExportable bool
+	// Lifetime, if non-zero, is how long after its creation the
+	// certification expires.
+	Lifetime time.Duration
+}
+
+// CertifyIdentity adds a certification of the given identity of target,
+// made with e's private key, which must be decrypted. level selects the kind
+// of certification and must be one of the SigType*Cert values.
+func (e *Entity) CertifyIdentity(target *Entity, identity string, level packet.SignatureType, opts CertifyOptions, config *packet.Config) error {
+	if e.PrivateKey == nil {
+		return errors.InvalidArgumentError("signing Entity must have a private key")
+	}
+	if e.PrivateKey.Encrypted {
+		return errors.InvalidArgumentError("signing Entity's private key must be decrypted")
+	}
+	ident, ok := target.Identities[identity]
+	if !ok {
+		return errors.InvalidArgumentError("given identity string not found in Entity")
+	}
+	switch level {
+	case packet.SigTypeGenericCert, packet.SigTypePersonaCert, packet.SigTypeCasualCert, packet.SigTypePositiveCert:
+	default:
+		return errors.InvalidArgumentError("certification level must be a certification signature type")
+	}
+	if opts.Regex != "" {
+		if _, err := regexp.Compile(opts.Regex); err != nil {
+			return errors.InvalidArgumentError("bad regular expression: " + err.Error())
+		}
+	}
+	var lifetimeSecs *uint32
+	if opts.Lifetime != 0 {
+		secs := int64(opts.Lifetime / time.Second)
+		if secs <= 0 || secs > math.MaxUint32 {
+			return errors.InvalidArgumentError("certification lifetime out of range")
+		}
+		lifetimeSecs = new(uint32)
+		*lifetimeSecs = uint32(secs)
+	}
+
+	sig := &packet.Signature{
+		SigType:         level,
+		PubKeyAlgo:      e.PrivateKey.PubKeyAlgo,
+		Hash:            config.Hash(),
+		CreationTime:    config.Now(),
+		IssuerKeyId:     &e.PrivateKey.KeyId,
+		TrustLevel:      opts.TrustLevel,
+		TrustAmount:     opts.TrustAmount,
+		Regex:           opts.Regex,
+		NonExportable:   opts.NonExportable,
+		SigLifetimeSecs: lifetimeSecs,
+	}
+	if err := sig.SignUserId(identity, target.PrimaryKey, e.PrivateKey, config); err != nil {
+		return err
+	}
+	ident.Signatures = append(ident.Signatures, sig)
+	return nil
+}
+
+// VerifyTrustPath checks that the identity uid of the last Entity in path
+// can be trusted, given that path[0] is trusted. Each Entity in path must
+// hold a valid certification by its predecessor. Every Entity between the
+// first and the last must have been certified with a trust signature whose
+// level allows it to introduce the rest of the path, and the regular
+// expressions of all those trust signatures must match uid. Certifications
+// that have expired, or that their issuer has since revoked, don't count.
+func VerifyTrustPath(path []*Entity, uid string) error {
+	if len(path) < 2 {
+		return errors.InvalidArgumentError("trust path needs at least two keys")
+	}
+	if _, ok := path[len(path)-1].Identities[uid]; !ok {
+		return errors.InvalidArgumentError("given identity string not found in Entity")
+	}
+
+	now := time.Now()
+	for i := 0; i < len(path)-1; i++ {
+		issuer, subject := path[i], path[i+1]
+		// depth is the number of keys after subject that subject has
+		// to be trusted to introduce.
+		depth := len(path) - 2 - i
+
+		var names []string
+		if depth == 0 {
+			names = []string{uid}
+		} else {
+			for name := range subject.Identities {
+				names = append(names, name)
+			}
+		}
+
+		var lastErr error
+		found := false
+	Names:
+		for _, name := range names {
+			for _, sig := range subject.Identities[name].Signatures {
+				if sig.IssuerKeyId == nil || *sig.IssuerKeyId != issuer.PrimaryKey.KeyId {
+					continue
+				}
+				switch sig.SigType {
+				case packet.SigTypeGenericCert, packet.SigTypePersonaCert, packet.SigTypeCasualCert, packet.SigTypePositiveCert:
+				default:
+					continue
+				}
+				if depth > 0 && (int(sig.TrustLevel) < depth || sig.TrustAmount == 0) {
+					lastErr = errors.SignatureError("trust level too low for key " + subject.PrimaryKey.KeyIdString())
+					continue
+				}
+				if sig.SigExpired(now) {
+					lastErr = errors.ErrSignatureExpired
+					continue
+				}
+				verified := sig
+				if sig.StubbedOutCriticalError == packet.ErrCriticalRegex {
+					// The regular expression is applied below.
+					c := *sig
+					c.StubbedOutCriticalError = nil
+					verified = &c
+				}
+				if err := issuer.PrimaryKey.VerifyUserIdSignature(name, subject.PrimaryKey, verified); err != nil {
+					lastErr = err
+					continue
+				}
+				if certificationRevoked(issuer, subject, name, sig) {
+					lastErr = errors.SignatureError("certification of key " + subject.PrimaryKey.KeyIdString() + " has been revoked")
+					continue
+				}
+				if sig.Regex != "" {
+					re, err := regexp.Compile(sig.Regex)
+					if err != nil {
+						lastErr = errors.UnsupportedError("regular expression in trust signature: " + err.Error())
+						continue
+					}
+					if !re.MatchString(uid) {
+						lastErr = errors.SignatureError("identity is outside the scope of key " + subject.PrimaryKey.KeyIdString())
+						continue
+					}
+				}
+				found = true
+				break Names
+			}
+		}
+		if !found {
+			if lastErr == nil {
+				lastErr = errors.SignatureError("key " + subject.PrimaryKey.KeyIdString() + " is not certified by " + issuer.PrimaryKey.KeyIdString())
+			}
+			return lastErr
+		}
+	}
+	return nil
+}
+
+// certificationRevoked reports whether issuer has revoked its certifications
+// of the identity name of subject since it made sig.
+func certificationRevoked(issuer, subject *Entity, name string, sig *packet.Signature) bool {
+	for _, rev := range subject.Identities[name].Signatures {
+		if rev.SigType != packet.SigTypeIdentityRevocation || rev.IssuerKeyId == nil || *rev.IssuerKeyId != issuer.PrimaryKey.KeyId {
+			continue
+		}
+		if rev.CreationTime.Before(sig.CreationTime) {
+			continue
+		}
+		if issuer.PrimaryKey.VerifyUserIdSignature(name, subject.PrimaryKey, rev) == nil {
+			return true
+		}
+	}
+	return false
+}
+
 // CopySubkeyRevocations copies subkey revocations from the src Entity over
 // to the receiver entity. We need this because `gpg --export-secret-key` does
 // not appear to output subkey revocations.  In this case we need to manually
 // merge with the output of `gpg --export`.
 func (e *Entity) CopySubkeyRevocations(src *Entity) {
-	m := make(map[[20]byte]*packet.Signature)
+	m := make(map[string]*packet.Signature)
 	for _, subkey := range src.Subkeys {
 		if subkey.Revocation != nil {
-			m[subkey.PublicKey.Fingerprint] = subkey.Revocation
+			m[string(subkey.PublicKey.FullFingerprint())] = subkey.Revocation
 		}
 	}
 	for i, subkey := range e.Subkeys {
-		if r := m[subkey.PublicKey.Fingerprint]; r != nil {
+		if r := m[string(subkey.PublicKey.FullFingerprint())]; r != nil {
 			e.Subkeys[i].Revocation = r
 		}
 	}
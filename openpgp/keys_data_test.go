@@ -4041,3 +4041,24 @@ Hhwx2CTl9SDnPSBMiKXsEFRkmwQ2AAsQZLmQZvKBkLZYeBiwf+IY621eYDhZfo+G
 =bNRo
 -----END PGP PUBLIC KEY BLOCK-----
 `
+
+const missingIssuerSelfSigKey = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+xo0EatBLDAEEAKwnU2JmWjSjJ+1Vb4EOasFQhPWIzoCf6LXMPN/weCK0bSieEd5o
+NDIcd9HgeuUOCkxZ2kODyjWJGBtapG0qLOUBa+vM99HQp5IvWetBem5HBUTFKAZc
+shIKpLQ1F1ceC4PFX6DonYRcleeZdMaIo9Msrir9mw0Kpn3efSve/p3LABEBAAHN
+K01pc3NpbmcgSXNzdWVyIDxtaXNzaW5nLWlzc3VlckBleGFtcGxlLmNvbT7CmAQT
+AQgADAUCatBLDAIbAwIZAQAAxmsEAEklV55cfaqkQHbPmnEzI/0WtZhu/Vpwn6V1
+z+LLqzmrXbkHwSKkALIOkkXYwuoVbR19plhRy/JlpDgaPtgafgqrdHBl7V9h3o5n
+nc0dOfOp6LrKNXcV22AgIyluTfD5R0KjayStE81wPiXbPpNVoOTX07SpsCELBGdw
+RzJJCJoj
+=BK/A
+-----END PGP PUBLIC KEY BLOCK-----`
+
+// v5KeyHex is a version 5 EdDSA key with a version 5 self-signature.
+const v5KeyHex = "c637055c91f4e4160000002d092b06010401da470f010107403f098994bdd916ed4053197934e4a87c80733a1280d62f8010992e43ee3b2406cd27456d6d6120476f6c646d616e203c656d6d612e676f6c646d616e406578616d706c652e6e65743ec25e05131608001005025c91f4e40910c62e1b43a65e536700008d24010084229c1b1ab1b0105770359067b5583f8d4187691b914d5e31daac39f4836abf0100c083326c50600d319cc5acc8d0722a031753afb007877f2914063d4b9578290b"
+
+// trustExportHex is a GnuPG export, with --export-options backup, of a key
+// with Trust packets and a trust signature by another key that is limited
+// to example.org identities.
+const trustExportHex = "9833046ad059aa16092b06010401da470f010107409494c998e67e7928bfce36c9cb9c55ca1de0b925fc4d6ee7156180ecb87f17c3b00c000067706701000000000000b423496e74726f6475636572203c696e74726f6475636572406578616d706c652e6f72673eb00c00006770670200000000000088900413160800381621049e1da490201f2cb77acfb3cf840fbcf6ea0a9d7005026ad059aa021b03050b0908070206150a09080b020416020301021e01021780000a0910840fbcf6ea0a9d707c8600ff518f8100298a07e0e3760f47de2ee8af61e0488584bf47055ea431780325446000fe3ab1da152743ba4e4931cd5e7fe62af212e43daea01e39eb28f54cab6750af0eb006000367706700889404101608003c162104f50f26f942a0868e98c76f010d97c59e5e35857b05026ad059b0030501781a863c5b5e3e5d2b5b402e5d6578616d706c655c2e6f72673e2400000a09100d97c59e5e35857b719d00ff6919834267f01e5cda85ee2efcc3cf9b3ee93a98bdbfa585307a24f3deb5dc5f0100bd6f6d76bee2bc3784fab8d7cb0812cf5bdf66135961d976eb92abbd4f86ed00b006000067706700b838046ad059be120a2b0601040197550105010107404f4a5cd28f08e9da32a46496d3fd6aa0220e6a82b4e835c12ae9b862dca631260301080788780418160800201621049e1da490201f2cb77acfb3cf840fbcf6ea0a9d7005026ad059be021b0c000a0910840fbcf6ea0a9d70338500ff7163625525041fa5d330a9caa50ce107ebb1c7259ae1cef0ebf1657e4c186d0201008b94aece63436257789ab57756c4697ef0936ba0685405da09706f69da30b601b006000067706700"
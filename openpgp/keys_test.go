@@ -5,8 +5,13 @@ import (
 	"crypto"
 	"crypto/elliptic"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"image"
+	"image/jpeg"
 	"io"
+	"io/ioutil"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -82,6 +87,40 @@ func TestMissingCrossSignature(t *testing.T) {
 	if !strings.Contains(string(structural), expectedMsg) {
 		t.Fatalf("Unexpected error: %q. Expected it to contain %q", err, expectedMsg)
 	}
+	if !errors.Is(err, pgpErrors.ErrBadSubkeySignature) || !errors.Is(err, pgpErrors.ErrMissingCrossSignature) {
+		t.Fatalf("Unexpected error: %q. Expected a missing cross-signature", err)
+	}
+	if errors.Is(err, pgpErrors.ErrBadCrossSignature) {
+		t.Fatalf("Unexpected error: %q. Did not expect a bad cross-signature", err)
+	}
+}
+
+func TestStructuralErrorWithDetail(t *testing.T) {
+	cause := pgpErrors.SignatureError("RSA verification failure")
+	err := pgpErrors.ErrBadSubkeySignature.WithDetail("C20C31BB", cause)
+	if got, want := err.Error(), "openpgp: invalid data: subkey signature invalid: C20C31BB: openpgp: invalid signature: RSA verification failure"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if !errors.Is(err, pgpErrors.ErrBadSubkeySignature) {
+		t.Errorf("%q does not match its sentinel", err)
+	}
+
+	// Sentinels chained as causes match too, wherever the detail goes.
+	err = pgpErrors.ErrBadSubkeySignature.WithDetail("C20C31BB", pgpErrors.ErrBadCrossSignature.WithDetail("", cause))
+	if !errors.Is(err, pgpErrors.ErrBadSubkeySignature) || !errors.Is(err, pgpErrors.ErrBadCrossSignature) {
+		t.Errorf("%q does not match its sentinels", err)
+	}
+	if errors.Is(err, pgpErrors.ErrMissingCrossSignature) {
+		t.Errorf("%q matches ErrMissingCrossSignature", err)
+	}
+
+	// Only the sentinels themselves match, not ones quoted in the detail.
+	for _, detail := range []string{pgpErrors.ErrWeakHash.Error(), string(pgpErrors.ErrWeakHash)} {
+		err = pgpErrors.ErrBadSubkeySignature.WithDetail(strconv.Quote(detail), nil)
+		if errors.Is(err, pgpErrors.ErrWeakHash) {
+			t.Errorf("%q matches ErrWeakHash", err)
+		}
+	}
 }
 
 func TestInvalidCrossSignature(t *testing.T) {
@@ -115,6 +154,9 @@ func TestInvalidCrossSignature(t *testing.T) {
 	if !strings.Contains(string(structural), expectedMsg) {
 		t.Fatalf("Unexpected error: %q. Expected it to contain %q", err, expectedMsg)
 	}
+	if !errors.Is(err, pgpErrors.ErrBadCrossSignature) {
+		t.Fatalf("Unexpected error: %q. Expected a bad cross-signature", err)
+	}
 }
 
 func TestGoodCrossSignature(t *testing.T) {
@@ -154,7 +196,13 @@ func TestExternallyRevocableKey(t *testing.T) {
 	id := uint64(0xA42704B92866382A)
 	keys := kring.KeysById(id, nil)
 	if len(keys) != 1 {
-		t.Errorf("Expected to find key id %X, but got %d matches", id, len(keys))
+		t.Fatalf("Expected to find key id %X, but got %d matches", id, len(keys))
+	}
+
+	revokers := keys[0].Entity.DesignatedRevokers
+	fp, _ := hex.DecodeString("CE094AA433F7040BB2DDF0BE3893CB843D0FE70C")
+	if len(revokers) != 1 || !bytes.Equal(revokers[0].Fingerprint, fp) || revokers[0].PublicKeyAlgo != packet.PubKeyAlgoRSA {
+		t.Errorf("Unexpected designated revokers: %v", revokers)
 	}
 }
 
@@ -181,6 +229,62 @@ func TestKeyRevocation(t *testing.T) {
 	}
 }
 
+func TestRevocationAnnouncement(t *testing.T) {
+	kring, err := ReadKeyRing(readerFromHex(revokedKeyHex))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entity := kring[0]
+
+	announcement, err := entity.RevocationAnnouncement()
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := armor.Decode(bytes.NewReader(announcement))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Type != PublicKeyType {
+		t.Errorf("got block type %q, want %q", block.Type, PublicKeyType)
+	}
+
+	packets := packet.NewReader(block.Body)
+	p, err := packets.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, ok := p.(*packet.PublicKey)
+	if !ok || pk.Fingerprint != entity.PrimaryKey.Fingerprint {
+		t.Fatalf("got %#v, want the primary key", p)
+	}
+	var revocations int
+	for {
+		p, err = packets.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, ok := p.(*packet.Signature)
+		if !ok || sig.SigType != packet.SigTypeKeyRevocation {
+			t.Fatalf("got %#v, want a key revocation", p)
+		}
+		if err := pk.VerifyRevocationSignature(pk, sig); err != nil {
+			t.Errorf("revocation doesn't verify: %s", err)
+		}
+		revocations++
+	}
+	if revocations != len(entity.Revocations) {
+		t.Errorf("got %d revocations, want %d", revocations, len(entity.Revocations))
+	}
+
+	kring, _ = ReadKeyRing(readerFromHex(testKeys1And2Hex))
+	if _, err := kring[0].RevocationAnnouncement(); err == nil {
+		t.Error("announcement for a key that isn't revoked")
+	}
+}
+
 func TestSubkeyRevocation(t *testing.T) {
 	kring, err := ReadKeyRing(readerFromHex(revokedSubkeyHex))
 	if err != nil {
@@ -262,6 +366,133 @@ func TestKeyWithSubKeyAndBadSelfSigOrder(t *testing.T) {
 
 }
 
+func TestKeyWithMisplacedUserIdSelfSignatures(t *testing.T) {
+	entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.AddUserId("Golang Gopher", "Other Key", "other@golang.com", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.SerializePrivate(ioutil.Discard, nil); err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := entity.Serialize(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// The packets are PUBKEY UID1 SIG1 UID2 SIG2 SUBKEY SUBSIG.
+	var packets []*packet.OpaquePacket
+	or := packet.NewOpaqueReader(buf)
+	for {
+		op, err := or.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, op)
+	}
+	if len(packets) != 7 {
+		t.Fatalf("got %d packets, want 7", len(packets))
+	}
+
+	for i, order := range [][]int{
+		{0, 2, 1, 3, 4, 5, 6}, // SIG1 ahead of UID1
+		{0, 1, 3, 2, 4, 5, 6}, // UID1 UID2 SIG1 SIG2
+		{0, 1, 3, 4, 2, 5, 6}, // UID1 UID2 SIG2 SIG1
+		{0, 2, 4, 1, 3, 5, 6}, // both signatures first
+	} {
+		reordered := new(bytes.Buffer)
+		for _, j := range order {
+			if err := packets[j].Serialize(reordered); err != nil {
+				t.Fatal(err)
+			}
+		}
+		e, err := ReadEntity(packet.NewReader(reordered))
+		if err != nil {
+			t.Errorf("#%d: %s", i, err)
+			continue
+		}
+		if len(e.Identities) != 2 {
+			t.Errorf("#%d: got %d identities, want 2", i, len(e.Identities))
+		}
+		for name, ident := range e.Identities {
+			if ident.SelfSignature == nil {
+				t.Errorf("#%d: %s has no self-signature", i, name)
+			}
+			if len(ident.Signatures) != 0 {
+				t.Errorf("#%d: %s has %d other signatures, want 0", i, name, len(ident.Signatures))
+			}
+		}
+		if len(e.Subkeys) != 1 {
+			t.Errorf("#%d: got %d subkeys, want 1", i, len(e.Subkeys))
+		}
+	}
+}
+
+func TestKeyWithManyMisplacedUserIdSelfSignatures(t *testing.T) {
+	entity, err := NewEntity("Golang Gopher", "0", "no-reply@golang.com", &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := maxMisplacedSelfSignatures + 2
+	for i := 1; i < n; i++ {
+		if err := entity.AddUserId("Golang Gopher", strconv.Itoa(i), "no-reply@golang.com", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf := new(bytes.Buffer)
+	if err := entity.Serialize(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// Move all the User IDs ahead of their self-signatures.
+	var uids, sigs, rest []*packet.OpaquePacket
+	or := packet.NewOpaqueReader(buf)
+	for i := 0; ; i++ {
+		op, err := or.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case i == 0 || i > 2*n:
+			rest = append(rest, op)
+		case i%2 == 1:
+			uids = append(uids, op)
+		default:
+			sigs = append(sigs, op)
+		}
+	}
+	reordered := new(bytes.Buffer)
+	for _, op := range append(append(append(rest[:1:1], uids...), sigs...), rest[1:]...) {
+		if err := op.Serialize(reordered); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e, err := ReadEntity(packet.NewReader(reordered))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The self-signature of the first User ID is too far from it to be
+	// looked for.
+	if len(e.Identities) != n-1 {
+		t.Errorf("got %d identities, want %d", len(e.Identities), n-1)
+	}
+	if _, ok := e.Identities[string(uids[0].Contents)]; ok {
+		t.Errorf("found %s", uids[0].Contents)
+	}
+	for name, ident := range e.Identities {
+		if ident.SelfSignature == nil {
+			t.Errorf("%s has no self-signature", name)
+		}
+	}
+}
+
 func TestKeyUsage(t *testing.T) {
 	kring, err := ReadKeyRing(readerFromHex(subkeyUsageHex))
 	if err != nil {
@@ -360,6 +591,63 @@ func TestIdVerification(t *testing.T) {
 	}
 }
 
+func TestIdVerificationDSAAndECDSA(t *testing.T) {
+	dsaRing, err := ReadKeyRing(readerFromHex(dsaElGamalTestKeysHex))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dsaRing[0].PrivateKey.Decrypt([]byte("passphrase")); err != nil {
+		t.Fatal(err)
+	}
+	eccRing, err := ReadArmoredKeyRing(strings.NewReader(eccKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := eccRing[0].PrivateKey.Decrypt([]byte("abcd")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, signer := range []*Entity{dsaRing[0], eccRing[0]} {
+		algo := signer.PrimaryKey.PubKeyAlgo
+		kring, err := ReadKeyRing(readerFromHex(subkeyUsageHex))
+		if err != nil {
+			t.Fatal(err)
+		}
+		identity := kring[0].PrimaryIdentity().Name
+		if err := kring[0].SignIdentity(identity, signer, nil); err != nil {
+			t.Fatalf("algorithm %d: %s", algo, err)
+		}
+
+		// Check the signature after a round trip, so that the MPIs of
+		// the signature are serialized and parsed again.
+		buf := new(bytes.Buffer)
+		if err := kring[0].Serialize(buf); err != nil {
+			t.Fatal(err)
+		}
+		reread, err := ReadEntity(packet.NewReader(buf))
+		if err != nil {
+			t.Fatalf("algorithm %d: %s", algo, err)
+		}
+
+		checked := false
+		for _, sig := range reread.Identities[identity].Signatures {
+			if sig.IssuerKeyId == nil || *sig.IssuerKeyId != signer.PrimaryKey.KeyId {
+				continue
+			}
+			if sig.PubKeyAlgo != algo {
+				t.Errorf("algorithm %d: signature has algorithm %d", algo, sig.PubKeyAlgo)
+			}
+			if err := signer.PrimaryKey.VerifyUserIdSignature(identity, reread.PrimaryKey, sig); err != nil {
+				t.Errorf("algorithm %d: error verifying identity signature: %s", algo, err)
+			}
+			checked = true
+		}
+		if !checked {
+			t.Errorf("algorithm %d: didn't find identity signature in Entity", algo)
+		}
+	}
+}
+
 func testKey(t *testing.T, key string, which string) {
 	_, err := ReadArmoredKeyRing(strings.NewReader(key))
 	if err != nil {
@@ -367,6 +655,209 @@ func testKey(t *testing.T, key string, which string) {
 	}
 }
 
+func TestTrustPathRegex(t *testing.T) {
+	config := &packet.Config{RSABits: 1024}
+	newEntity := func(name, email string) *Entity {
+		e, err := NewEntity(name, "", email, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+	root := newEntity("Root", "root@example.org")
+	ca := newEntity("Example CA", "ca@example.com")
+	alice := newEntity("Alice", "alice@example.com")
+	mallory := newEntity("Mallory", "mallory@example.net")
+
+	const caId = "Example CA <ca@example.com>"
+	const aliceId = "Alice <alice@example.com>"
+	const malloryId = "Mallory <mallory@example.net>"
+
+	opts := CertifyOptions{TrustLevel: 1, TrustAmount: 120, Regex: `<[^>]+@example\.com>$`}
+	if err := root.CertifyIdentity(ca, caId, packet.SigTypeGenericCert, opts, config); err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.CertifyIdentity(alice, aliceId, packet.SigTypePositiveCert, CertifyOptions{}, config); err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.CertifyIdentity(mallory, malloryId, packet.SigTypePositiveCert, CertifyOptions{}, config); err != nil {
+		t.Fatal(err)
+	}
+
+	// The trust signature survives serialization.
+	var buf bytes.Buffer
+	if err := ca.Identities[caId].Signatures[0].Serialize(&buf); err != nil {
+		t.Fatal(err)
+	}
+	p, err := packet.Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := p.(*packet.Signature)
+	if sig.TrustLevel != 1 || sig.TrustAmount != 120 || sig.Regex != opts.Regex {
+		t.Errorf("got trust %d/%d regex %q after reparsing", sig.TrustLevel, sig.TrustAmount, sig.Regex)
+	}
+	ca.Identities[caId].Signatures[0] = sig
+
+	// The regular expression is critical, so only VerifyTrustPath, which
+	// applies it, accepts the signature.
+	if sig.StubbedOutCriticalError != packet.ErrCriticalRegex {
+		t.Errorf("got critical error %v, want %v", sig.StubbedOutCriticalError, packet.ErrCriticalRegex)
+	}
+	if err := root.PrimaryKey.VerifyUserIdSignature(caId, ca.PrimaryKey, sig); err == nil {
+		t.Error("signature with a critical regular expression verified")
+	}
+
+	if err := VerifyTrustPath([]*Entity{root, ca, alice}, aliceId); err != nil {
+		t.Errorf("in-scope identity rejected: %s", err)
+	}
+	if err := VerifyTrustPath([]*Entity{root, ca, mallory}, malloryId); err == nil {
+		t.Error("out-of-scope identity accepted")
+	}
+	if err := VerifyTrustPath([]*Entity{root, alice}, aliceId); err == nil {
+		t.Error("uncertified identity accepted")
+	}
+
+	// Once root revokes its certification, the CA is no longer trusted,
+	// also after the CA's key has been exported and read back.
+	rev := &packet.Signature{
+		SigType:      packet.SigTypeIdentityRevocation,
+		PubKeyAlgo:   root.PrivateKey.PubKeyAlgo,
+		Hash:         crypto.SHA256,
+		CreationTime: time.Now(),
+		IssuerKeyId:  &root.PrimaryKey.KeyId,
+	}
+	if err := rev.SignUserId(caId, ca.PrimaryKey, root.PrivateKey, config); err != nil {
+		t.Fatal(err)
+	}
+	ca.Identities[caId].Signatures = append(ca.Identities[caId].Signatures, rev)
+	buf.Reset()
+	if err := ca.Serialize(&buf); err != nil {
+		t.Fatal(err)
+	}
+	revokedCA, err := ReadEntity(packet.NewReader(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []*Entity{ca, revokedCA} {
+		if err := VerifyTrustPath([]*Entity{root, e, alice}, aliceId); err == nil {
+			t.Error("path through a revoked certification accepted")
+		}
+	}
+	ca.Identities[caId].Signatures = ca.Identities[caId].Signatures[:1]
+
+	// Nor does an expired certification count.
+	opts.Lifetime = time.Hour
+	expiredConfig := &packet.Config{RSABits: 1024, Time: func() time.Time { return time.Now().Add(-2 * time.Hour) }}
+	ca.Identities[caId].Signatures = nil
+	if err := root.CertifyIdentity(ca, caId, packet.SigTypeGenericCert, opts, expiredConfig); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyTrustPath([]*Entity{root, ca, alice}, aliceId); err != pgpErrors.ErrSignatureExpired {
+		t.Errorf("got %v for a path through an expired certification, want %v", err, pgpErrors.ErrSignatureExpired)
+	}
+
+	// A plain certification doesn't make the CA an introducer.
+	ca.Identities[caId].Signatures = nil
+	if err := root.CertifyIdentity(ca, caId, packet.SigTypeGenericCert, CertifyOptions{}, config); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyTrustPath([]*Entity{root, ca, alice}, aliceId); err == nil {
+		t.Error("path through a non-introducer accepted")
+	}
+}
+
+func TestCertifyIdentityNonExportable(t *testing.T) {
+	config := &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA}
+	certifier, err := NewEntity("Certifier", "", "certifier@example.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := NewEntity("Golang Gopher", "", "gopher@example.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const uid = "Golang Gopher <gopher@example.com>"
+
+	opts := CertifyOptions{NonExportable: true, Lifetime: 24 * time.Hour}
+	if err := certifier.CertifyIdentity(target, uid, packet.SigTypePositiveCert, opts, config); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := target.Identities[uid].Signatures[0].Serialize(&buf); err != nil {
+		t.Fatal(err)
+	}
+	p, err := packet.Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := p.(*packet.Signature)
+	if sig.SigType != packet.SigTypePositiveCert {
+		t.Errorf("got signature type %#x, want %#x", sig.SigType, packet.SigTypePositiveCert)
+	}
+	if !sig.NonExportable {
+		t.Error("certification is exportable")
+	}
+	if sig.SigLifetimeSecs == nil || *sig.SigLifetimeSecs != 24*60*60 {
+		t.Errorf("got signature lifetime %v, want one day", sig.SigLifetimeSecs)
+	}
+	hashed, _ := sig.RawSubpackets()
+	found := false
+	for _, subpacket := range hashed {
+		if subpacket.Type == 4 {
+			found = subpacket.Critical && bytes.Equal(subpacket.Contents, []byte{0})
+		}
+	}
+	if !found {
+		t.Errorf("no critical exportable certification subpacket set to 0 in %+v", hashed)
+	}
+	if err := certifier.PrimaryKey.VerifyUserIdSignature(uid, target.PrimaryKey, sig); err != nil {
+		t.Errorf("certification does not verify: %s", err)
+	}
+
+	if err := certifier.CertifyIdentity(target, uid, packet.SigTypePositiveCert, CertifyOptions{Lifetime: time.Millisecond}, config); err == nil {
+		t.Error("certified with a lifetime under a second")
+	}
+}
+
+func TestSerializeOmitsNonExportable(t *testing.T) {
+	config := &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA}
+	certifier, err := NewEntity("Certifier", "", "certifier@example.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := NewEntity("Golang Gopher", "", "gopher@example.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := target.SerializePrivate(ioutil.Discard, nil); err != nil {
+		t.Fatal(err)
+	}
+	const uid = "Golang Gopher <gopher@example.com>"
+	if err := certifier.CertifyIdentity(target, uid, packet.SigTypePositiveCert, CertifyOptions{NonExportable: true}, config); err != nil {
+		t.Fatal(err)
+	}
+	if err := certifier.CertifyIdentity(target, uid, packet.SigTypeGenericCert, CertifyOptions{}, config); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := target.Serialize(buf); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(target.Identities[uid].Signatures); n != 2 {
+		t.Errorf("got %d certifications in memory, want 2", n)
+	}
+	exported, err := ReadEntity(packet.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigs := exported.Identities[uid].Signatures
+	if len(sigs) != 1 || sigs[0].SigType != packet.SigTypeGenericCert {
+		t.Errorf("got %d exported certifications, want only the exportable one", len(sigs))
+	}
+}
+
 func TestKeyHashMismatch(t *testing.T) {
 	testKey(t, freacky22527Key, "freacky22527Key")
 
@@ -391,10 +882,8 @@ func TestWithBadSubkeySignaturePackets(t *testing.T) {
 
 func TestKeyWithoutUID(t *testing.T) {
 	_, err := ReadArmoredKeyRing(strings.NewReader(noUIDkey))
-	if se, ok := err.(pgpErrors.StructuralError); !ok {
-		t.Fatal("expected a structural error")
-	} else if strings.Index(se.Error(), "entity without any identities") < 0 {
-		t.Fatalf("Got wrong error: %s", se.Error())
+	if !errors.Is(err, pgpErrors.ErrNoIdentities) {
+		t.Fatalf("Got wrong error: %v", err)
 	}
 }
 
@@ -430,29 +919,148 @@ func TestPiotr(t *testing.T) {
 	testKey(t, piotr, "piotr")
 }
 
-func TestOelna(t *testing.T) {
-	testKey(t, oelna, "oelna")
-}
+func TestUserAttributePhoto(t *testing.T) {
+	for _, test := range []struct {
+		name, key string
+	}{{"piotr", piotr}, {"sneak", sneak}} {
+		kring, err := ReadArmoredKeyRing(strings.NewReader(test.key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := kring[0]
+		if len(e.Attributes) != 1 {
+			t.Fatalf("%s: got %d user attributes, want 1", test.name, len(e.Attributes))
+		}
+		attr := e.Attributes[0]
+		images := attr.UserAttribute.ImageData()
+		if len(images) != 1 || !bytes.HasPrefix(images[0], []byte{0xff, 0xd8, 0xff}) {
+			t.Errorf("%s: didn't get a JPEG image", test.name)
+		}
+		if err := e.PrimaryKey.VerifyUserAttributeSignature(attr.UserAttribute, e.PrimaryKey, attr.SelfSignature); err != nil {
+			t.Errorf("%s: bad self-signature: %s", test.name, err)
+		}
 
-type timeoutReader struct {
-	r io.Reader
-	t time.Time
+		buf := new(bytes.Buffer)
+		if err := e.Serialize(buf); err != nil {
+			t.Fatal(err)
+		}
+		reread, err := ReadEntity(packet.NewReader(buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reread.Attributes) != 1 || len(reread.Attributes[0].Signatures) != len(attr.Signatures) {
+			t.Errorf("%s: user attribute not kept by Serialize", test.name)
+		}
+	}
 }
 
-var errTimeout = errors.New("timeout")
+func TestSignUserAttribute(t *testing.T) {
+	e, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uat, err := packet.NewUserAttributePhoto(image.NewGray(image.Rect(0, 0, 8, 8)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := &packet.Signature{
+		SigType:      packet.SigTypePositiveCert,
+		PubKeyAlgo:   e.PrimaryKey.PubKeyAlgo,
+		Hash:         crypto.SHA256,
+		CreationTime: time.Now(),
+		IssuerKeyId:  &e.PrimaryKey.KeyId,
+	}
+	if err := sig.SignUserAttribute(uat, e.PrimaryKey, e.PrivateKey, nil); err != nil {
+		t.Fatal(err)
+	}
+	e.Attributes = append(e.Attributes, &UserAttribute{UserAttribute: uat, SelfSignature: sig})
 
-func (tr timeoutReader) Read(p []byte) (n int, err error) {
-	if time.Now().After(tr.t) {
-		return 0, errTimeout
+	buf := new(bytes.Buffer)
+	if err := e.SerializePrivate(buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	reread, err := ReadEntity(packet.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reread.Attributes) != 1 || !bytes.Equal(reread.Attributes[0].UserAttribute.ImageData()[0], uat.ImageData()[0]) {
+		t.Fatal("user attribute not read back")
+	}
+	if len(reread.Identities) != 1 || reread.PrimaryIdentity().SelfSignature == nil {
+		t.Error("identity lost after the user attribute")
 	}
-	return tr.r.Read(p)
-}
 
-func TestCorrupt(t *testing.T) {
-	sr := strings.NewReader(corrupt)
-	tr := timeoutReader{sr, time.Now().Add(5 * time.Second)}
+	// A signature over the attribute doesn't verify for the User ID.
+	if err := e.PrimaryKey.VerifyUserIdSignature(e.PrimaryIdentity().Name, e.PrimaryKey, sig); err == nil {
+		t.Error("user attribute signature verified over a User ID")
+	}
+}
 
-	_, err := ReadArmoredKeyRing(tr)
+func TestAddPhoto(t *testing.T) {
+	e, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	photo := new(bytes.Buffer)
+	if err := jpeg.Encode(photo, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.AddPhoto(photo.Bytes(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.AddPhoto(photo.Bytes(), nil); err == nil {
+		t.Error("the same photo was added twice")
+	}
+	if err := e.AddPhoto([]byte("not a JPEG"), nil); err == nil {
+		t.Error("no error for an image that isn't a JPEG")
+	}
+
+	buf := new(bytes.Buffer)
+	if err := e.SerializePrivate(buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	reread, err := ReadEntity(packet.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reread.Attributes) != 1 {
+		t.Fatalf("got %d user attributes, want 1", len(reread.Attributes))
+	}
+	attr := reread.Attributes[0]
+	if images := attr.UserAttribute.ImageData(); len(images) != 1 || !bytes.Equal(images[0], photo.Bytes()) {
+		t.Error("photo changed")
+	}
+	if attr.SelfSignature.SigType != packet.SigTypePositiveCert {
+		t.Errorf("got self-signature of type %d", attr.SelfSignature.SigType)
+	}
+	if err := reread.PrimaryKey.VerifyUserAttributeSignature(attr.UserAttribute, reread.PrimaryKey, attr.SelfSignature); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOelna(t *testing.T) {
+	testKey(t, oelna, "oelna")
+}
+
+type timeoutReader struct {
+	r io.Reader
+	t time.Time
+}
+
+var errTimeout = errors.New("timeout")
+
+func (tr timeoutReader) Read(p []byte) (n int, err error) {
+	if time.Now().After(tr.t) {
+		return 0, errTimeout
+	}
+	return tr.r.Read(p)
+}
+
+func TestCorrupt(t *testing.T) {
+	sr := strings.NewReader(corrupt)
+	tr := timeoutReader{sr, time.Now().Add(5 * time.Second)}
+
+	_, err := ReadArmoredKeyRing(tr)
 	if err.Error() != armor.ArmorCorrupt.Error() {
 		t.Fatal("expected armor.ArmorCorrupt, got ", err)
 	}
@@ -540,185 +1148,1955 @@ func TestRevokedUserID(t *testing.T) {
 	}
 }
 
-func TestNewEntityCorrectName(t *testing.T) {
-	entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", nil)
+func TestSelfSignatureMissingIssuer(t *testing.T) {
+	kring, err := ReadArmoredKeyRing(strings.NewReader(missingIssuerSelfSigKey))
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(entity.Identities) != 1 {
-		t.Fatalf("len(entity.Identities) = %d, want 1", len(entity.Identities))
+	if len(kring) != 1 {
+		t.Fatalf("got %d entities, want 1", len(kring))
 	}
-	var got string
-	for _, i := range entity.Identities {
-		got = i.Name
+	entity := kring[0]
+	ident, ok := entity.Identities["Missing Issuer <missing-issuer@example.com>"]
+	if !ok {
+		t.Fatalf("identity not found, got %v", entity.Identities)
 	}
-	want := "Golang Gopher (Test Key) <no-reply@golang.com>"
-	if got != want {
-		t.Fatalf("Identity.Name = %q, want %q", got, want)
+	if ident.SelfSignature.IssuerKeyId == nil || *ident.SelfSignature.IssuerKeyId != entity.PrimaryKey.KeyId {
+		t.Errorf("issuer was not filled in from the primary key")
+	}
+	if keys := kring.KeysById(entity.PrimaryKey.KeyId, nil); len(keys) != 1 {
+		t.Errorf("got %d keys by id, want 1", len(keys))
 	}
 }
 
-func TestNewEntityWithPreferredHash(t *testing.T) {
-	c := &packet.Config{
-		DefaultHash: crypto.SHA256,
+func TestNewEntityFeatures(t *testing.T) {
+	e, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", nil)
+	if err != nil {
+		t.Fatal(err)
 	}
-	entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", c)
+	buf := new(bytes.Buffer)
+	if err := e.SerializePrivate(buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	e, err = ReadEntity(packet.NewReader(buf))
 	if err != nil {
 		t.Fatal(err)
 	}
-	for _, identity := range entity.Identities {
-		if len(identity.SelfSignature.PreferredHash) == 0 {
-			t.Fatal("didn't find a preferred hash in self signature")
-		}
-		ph := hashToHashId(c.DefaultHash)
-		if identity.SelfSignature.PreferredHash[0] != ph {
-			t.Fatalf("Expected preferred hash to be %d, got %d", ph, identity.SelfSignature.PreferredHash[0])
-		}
+	sig := e.PrimaryIdentity().SelfSignature
+	if !sig.MDC || !sig.HasFlagMDC() {
+		t.Error("new entity does not announce MDC support")
+	}
+	if sig.HasFlagAEAD() {
+		t.Error("new entity announces AEAD support")
 	}
 }
 
-func TestNewEntityWithoutPreferredHash(t *testing.T) {
-	entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", nil)
+func TestKeysByFingerprint(t *testing.T) {
+	kring, err := ReadKeyRing(readerFromHex(testKeys1And2Hex))
 	if err != nil {
 		t.Fatal(err)
 	}
-	for _, identity := range entity.Identities {
-		if len(identity.SelfSignature.PreferredHash) != 0 {
-			t.Fatalf("Expected preferred hash to be empty but got length %d", len(identity.SelfSignature.PreferredHash))
-		}
+	v5, err := ReadKeyRing(readerFromHex(v5KeyHex))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kring = append(kring, v5...)
+
+	// Make the second key collide with the first one on the key id.
+	target := kring[0].PrimaryKey
+	kring[1].PrimaryKey.KeyId = target.KeyId
+	if keys := kring.KeysById(target.KeyId, nil); len(keys) != 2 {
+		t.Fatalf("got %d keys by id, want 2", len(keys))
+	}
+
+	keys := kring.KeysByFingerprint(target.Fingerprint[:])
+	if len(keys) != 1 || keys[0].PublicKey != target {
+		t.Errorf("got %d keys by fingerprint, want only the first key", len(keys))
+	}
+
+	subkey := kring[1].Subkeys[0].PublicKey
+	if keys := kring.KeysByFingerprint(subkey.Fingerprint[:]); len(keys) != 1 || keys[0].PublicKey != subkey {
+		t.Errorf("subkey not found by fingerprint")
+	}
+
+	primary := kring[2].PrimaryKey
+	if fp := primary.FullFingerprint(); len(fp) != 32 {
+		t.Fatalf("got a %d byte version 5 fingerprint", len(fp))
+	}
+	if primary.Fingerprint != [20]byte{} {
+		t.Errorf("version 5 key has a version 4 fingerprint %x", primary.Fingerprint)
+	}
+	if keys := kring.KeysByFingerprint(primary.FullFingerprint()); len(keys) != 1 || keys[0].PublicKey != primary {
+		t.Errorf("version 5 key not found by fingerprint")
+	}
+
+	if keys := kring.KeysByFingerprint(nil); len(keys) != 0 {
+		t.Errorf("got %d keys for an empty fingerprint", len(keys))
 	}
 }
 
-func TestNewEntityWithPreferredSymmetric(t *testing.T) {
-	c := &packet.Config{
-		DefaultCipher: packet.CipherAES256,
+func TestNewEntityFixedTime(t *testing.T) {
+	created := time.Date(2015, 3, 14, 15, 9, 26, 0, time.UTC)
+	config := &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA, Time: func() time.Time { return created }}
+	e, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", config)
+	if err != nil {
+		t.Fatal(err)
 	}
-	entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", c)
+	buf := new(bytes.Buffer)
+	if err := e.SerializePrivate(buf, config); err != nil {
+		t.Fatal(err)
+	}
+	e, err = ReadEntity(packet.NewReader(buf))
 	if err != nil {
 		t.Fatal(err)
 	}
-	for _, identity := range entity.Identities {
-		if len(identity.SelfSignature.PreferredSymmetric) == 0 {
-			t.Fatal("didn't find a preferred cipher in self signature")
-		}
-		if identity.SelfSignature.PreferredSymmetric[0] != uint8(c.DefaultCipher) {
-			t.Fatalf("Expected preferred cipher to be %d, got %d", uint8(c.DefaultCipher), identity.SelfSignature.PreferredSymmetric[0])
+
+	times := map[string]time.Time{
+		"primary key":        e.PrimaryKey.CreationTime,
+		"self-signature":     e.PrimaryIdentity().SelfSignature.CreationTime,
+		"subkey":             e.Subkeys[0].PublicKey.CreationTime,
+		"subkey binding sig": e.Subkeys[0].Sig.CreationTime,
+	}
+	for what, got := range times {
+		if !got.Equal(created) {
+			t.Errorf("%s created at %v, want %v", what, got, created)
 		}
 	}
+
+	if _, ok := e.encryptionKey(config.Now()); !ok {
+		t.Error("no encryption key as of the creation time")
+	}
 }
-func TestNewEntityWithoutPreferredSymmetric(t *testing.T) {
-	entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", nil)
+
+func TestEntityListMerge(t *testing.T) {
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	config := &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA, Time: func() time.Time { return created }}
+	e, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", config)
 	if err != nil {
 		t.Fatal(err)
 	}
-	for _, identity := range entity.Identities {
-		if len(identity.SelfSignature.PreferredSymmetric) != 0 {
-			t.Fatalf("Expected preferred cipher to be empty but got length %d", len(identity.SelfSignature.PreferredSymmetric))
-		}
+	buf := new(bytes.Buffer)
+	if err := e.SerializePrivate(buf, config); err != nil {
+		t.Fatal(err)
+	}
+	older, err := ReadKeyRing(buf)
+	if err != nil {
+		t.Fatal(err)
 	}
-}
 
-func TestKeyWithRevokedSubKey(t *testing.T) {
-	// This key contains a revoked sub key:
-	//  pub   rsa1024/0x4CBD826C39074E38 2018-06-14 [SC]
-	//        Key fingerprint = 3F95 169F 3FFA 7D3F 2B47  6F0C 4CBD 826C 3907 4E38
-	//  uid   Golang Gopher <no-reply@golang.com>
-	//  sub   rsa1024/0x945DB1AF61D85727 2018-06-14 [S] [revoked: 2018-06-14]
-	keys, err := ReadArmoredKeyRing(bytes.NewBufferString(keyWithSubKey))
+	// The newer copy has a new expiration, identity and revocation.
+	later := &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA, Time: func() time.Time { return created.Add(time.Hour) }}
+	if err := e.SetExpiration(24*time.Hour, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.AddUserId("Gopher", "Second", "gopher@golang.com", later); err != nil {
+		t.Fatal(err)
+	}
+	revocation, err := e.Revoke(packet.KeySuperseded, "", later)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(keys) != 1 {
-		t.Fatal("Failed to read key with a sub key")
+	buf.Reset()
+	if err := e.Serialize(buf); err != nil {
+		t.Fatal(err)
 	}
-	identity := keys[0].Identities["Golang Gopher <no-reply@golang.com>"]
-	// Test for an issue where Subkey Binding Signatures (RFC 4880 5.2.1) were added to the identity
-	// preceding the Subkey Packet if the Subkey Packet was followed by more than one signature.
-	// For example, the current key has the following layout:
-	//    PUBKEY UID SELFSIG SUBKEY REV SELFSIG
-	// The last SELFSIG would be added to the UID's signatures. This is wrong.
-	if numIdentitySigs, numExpected := len(identity.Signatures), 0; numIdentitySigs != numExpected {
-		t.Fatalf("got %d identity signatures, expected %d", numIdentitySigs, numExpected)
+	newer, err := ReadKeyRing(buf)
+	if err != nil {
+		t.Fatal(err)
 	}
-	if numSubKeys, numExpected := len(keys[0].Subkeys), 1; numSubKeys != numExpected {
-		t.Fatalf("got %d subkeys, expected %d", numSubKeys, numExpected)
+	if err := newer[0].ApplyRevocation(revocation); err != nil {
+		t.Fatal(err)
 	}
-	subKey := keys[0].Subkeys[0]
-	if subKey.Sig == nil {
-		t.Fatalf("subkey signature is nil")
+	unrelated, err := ReadKeyRing(readerFromHex(testKeys1And2Hex))
+	if err != nil {
+		t.Fatal(err)
 	}
-}
 
-// Try decryption when private key is stubbed. Caveat is that when a key is
-// stubbed, privateKey will be non-nil, but privateKey.PrivateKey will be nil.
-// Consumers will often overlook this fact, so make sure exposed API fails
-// gracefully.
-func TestStubbedPrivateKeyDecryption(t *testing.T) {
-	makeECDH := func() *Entity {
-		t.Logf("Making ECDH key")
-		return generateEccKeysForTest(t, elliptic.P521(), elliptic.P521())
+	merged := older.Merge(newer).Merge(unrelated).Merge(newer)
+	if len(merged) != 3 {
+		t.Fatalf("got %d entities, want 3", len(merged))
 	}
-	makeRSA := func() *Entity {
-		t.Logf("Making RSA key")
-		cfg := &packet.Config{RSABits: 768}
-		entity, err := NewEntity("Go-Crypto PGP Test", "Test Only Do Not Use", "alice@example.com", cfg)
-		if err != nil {
-			t.Fatalf("makeRSA failed with %s", err)
+	m := merged[0]
+	if m != older[0] {
+		t.Error("the older copy was not merged into")
+	}
+	if m.PrivateKey == nil {
+		t.Error("private key lost")
+	}
+	if len(m.Identities) != 2 {
+		t.Errorf("got %d identities, want 2", len(m.Identities))
+	}
+	for name, ident := range m.Identities {
+		if ident.SelfSignature.KeyLifetimeSecs == nil {
+			t.Errorf("identity %q kept the older self-signature", name)
 		}
-		return entity
+	}
+	if len(m.Subkeys) != 1 || m.Subkeys[0].PrivateKey == nil {
+		t.Error("subkey private key lost")
+	}
+	if len(m.Revocations) != 1 {
+		t.Errorf("got %d revocations, want 1", len(m.Revocations))
 	}
 
-	funcs := []func() *Entity{makeECDH, makeRSA}
-	for _, f := range funcs {
-		entity := f()
-
-		buf := new(bytes.Buffer)
-		armored, err := armor.Encode(buf, "PGP MESSAGE", nil)
-		writer, err := Encrypt(armored, []*Entity{entity}, nil, nil, nil)
-		if err != nil {
-			t.Fatalf("Failed to Encrypt: %s", err)
-		}
-		msgstr := "Hello Test Encryption TestStubbedPrivateKeyOperations."
-		io.Copy(writer, bytes.NewBufferString(msgstr))
-		if err := writer.Close(); err != nil {
-			t.Fatalf("Failed to writer.Close: %s", err)
-		}
-		if err := armored.Close(); err != nil {
-			t.Fatalf("Failed to armored.Close: %s", err)
+	// Merging the older copy back doesn't undo anything.
+	merged = merged.Merge(older)
+	for name, ident := range merged[0].Identities {
+		if ident.SelfSignature.KeyLifetimeSecs == nil {
+			t.Errorf("identity %q went back to the older self-signature", name)
 		}
+	}
+}
 
-		// Stub the subkey
-		entity.Subkeys[0].PrivateKey.PrivateKey = nil
-
-		var entities EntityList
-		entities = append(entities, entity)
+func TestSerializeMinimal(t *testing.T) {
+	config := &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA}
+	e, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := e.SerializePrivate(buf, config); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := NewEntity("Signer", "", "signer@golang.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ident := e.PrimaryIdentity()
+	if err := e.SignIdentity(ident.Name, signer, config); err != nil {
+		t.Fatal(err)
+	}
+	for len(ident.Signatures) < 1000 {
+		ident.Signatures = append(ident.Signatures, ident.Signatures[0])
+	}
+	revocation, err := e.Revoke(packet.KeyRetired, "", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Revocations = append(e.Revocations, revocation)
 
-		decKeys := entities.DecryptionKeys()
-		if len(decKeys) != 0 {
-			t.Fatalf("Expecting DecryptionKeys() to return empty list")
-		}
+	full, minimal := new(bytes.Buffer), new(bytes.Buffer)
+	if err := e.Serialize(full); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SerializeMinimal(minimal); err != nil {
+		t.Fatal(err)
+	}
+	if minimal.Len()*50 > full.Len() {
+		t.Errorf("minimal export is %d bytes, the full one %d", minimal.Len(), full.Len())
+	}
 
-		block, err := armor.Decode(bytes.NewBuffer(buf.Bytes()))
-		_, err = ReadMessage(block.Body, entities, nil, nil)
-		t.Logf("ReadMessage returned: %s", err)
-		if err == nil {
-			t.Fatalf("Expected a failure in ReadMessage")
-		}
+	m, err := ReadEntity(packet.NewReader(minimal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Identities) != 1 || len(m.PrimaryIdentity().Signatures) != 0 {
+		t.Error("identity or certifications not as expected")
+	}
+	if len(m.Revocations) != 1 {
+		t.Errorf("got %d revocations, want 1", len(m.Revocations))
+	}
 
-		if err != pgpErrors.ErrKeyIncorrect {
-			t.Fatalf("Expecting an error to be ErrKeyIncorrect")
-		}
+	// Without the revocation, the minimal key can still be encrypted to.
+	m.Revocations = nil
+	buf.Reset()
+	w, err := Encrypt(buf, []*Entity{m}, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("message"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	md, err := ReadMessage(buf, EntityList{e}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plaintext, err := ioutil.ReadAll(md.UnverifiedBody); err != nil || string(plaintext) != "message" {
+		t.Errorf("got %q, %v", plaintext, err)
 	}
 }
 
-// See https://github.com/keybase/client/issues/18944; ReadKeyRing used to panic here
-func TestElGamalPrivateKeyFuzz(t *testing.T) {
-	d, err := base64.StdEncoding.DecodeString("xyIEOQTlQxQAAAAAAAAAADZlZ2VWZWU7wgAEfzdGaWxlIFOnxyIELgTlQwEAAAAAAA==")
+func TestReadKeyRingUnknownPackets(t *testing.T) {
+	kring, err := ReadKeyRing(readerFromHex(testKeys1And2Hex))
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, err = ReadKeyRing(bytes.NewBuffer(d))
-	if err == nil {
-		t.Fatal(errors.New("should have gotten an error parsing elgamal sign-or-encrypt private key"))
+	buf := new(bytes.Buffer)
+	(&packet.OpaquePacket{Tag: 60, Contents: []byte("experimental")}).Serialize(buf)
+	kring[0].Serialize(buf)
+	(&packet.OpaquePacket{Tag: 61}).Serialize(buf)
+	kring[1].Serialize(buf)
+
+	el, err := ReadKeyRing(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(el) != 2 {
+		t.Fatalf("got %d entities, want 2", len(el))
+	}
+	for i := range el {
+		if len(el[i].Identities) != len(kring[i].Identities) {
+			t.Errorf("#%d: got %d identities, want %d", i, len(el[i].Identities), len(kring[i].Identities))
+		}
+	}
+	unknown := el[0].UnknownPackets
+	if len(unknown) != 2 || unknown[0].Tag != 60 || string(unknown[0].Contents) != "experimental" || unknown[1].Tag != 61 {
+		t.Errorf("got unknown packets %+v", unknown)
+	}
+	if len(el[1].UnknownPackets) != 0 {
+		t.Errorf("got %d unknown packets in the second entity", len(el[1].UnknownPackets))
+	}
+
+	// The unknown packets skipped with an unreadable entity are not
+	// given to the next one.
+	buf.Reset()
+	packet.NewUserId("Stray", "", "stray@example.com").Serialize(buf)
+	(&packet.OpaquePacket{Tag: 62}).Serialize(buf)
+	kring[1].Serialize(buf)
+	el, err = ReadKeyRing(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(el) != 1 || len(el[0].UnknownPackets) != 0 {
+		t.Errorf("got %d entities, the first with unknown packets %+v", len(el), el[0].UnknownPackets)
+	}
+}
+
+func TestReadKeyRingWithTimestampSignatures(t *testing.T) {
+	config := &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA}
+	entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewEntity("Golang Gopher", "Other", "other@golang.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.SerializePrivate(ioutil.Discard, nil); err != nil {
+		t.Fatal(err)
+	}
+	sign := func(signer *Entity, sigType packet.SignatureType) *packet.Signature {
+		sig := &packet.Signature{
+			SigType:      sigType,
+			PubKeyAlgo:   signer.PrimaryKey.PubKeyAlgo,
+			Hash:         crypto.SHA256,
+			CreationTime: config.Now(),
+			IssuerKeyId:  &signer.PrimaryKey.KeyId,
+		}
+		if err := sig.Sign(crypto.SHA256.New(), signer.PrivateKey, nil); err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	}
+
+	// Put a timestamp signature right after the primary key, and a
+	// timestamp and a third-party confirmation signature after the
+	// user id.
+	for _, ident := range entity.Identities {
+		ident.Signatures = append(ident.Signatures, sign(other, packet.SigTypeTimestamp), sign(other, packet.SigTypeThirdPartyConfirmation))
+	}
+	buf := new(bytes.Buffer)
+	if err := entity.PrimaryKey.Serialize(buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := sign(entity, packet.SigTypeTimestamp).Serialize(buf); err != nil {
+		t.Fatal(err)
+	}
+	var serialized bytes.Buffer
+	if err := entity.Serialize(&serialized); err != nil {
+		t.Fatal(err)
+	}
+	packets := packet.NewOpaqueReader(&serialized)
+	packets.Next()
+	for {
+		op, err := packets.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		op.Serialize(buf)
+	}
+
+	kring, err := ReadKeyRing(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kring) != 1 || len(kring[0].Subkeys) != 1 {
+		t.Fatalf("got %d entities", len(kring))
+	}
+	var sigTypes []packet.SignatureType
+	for _, ident := range kring[0].Identities {
+		for _, sig := range ident.Signatures {
+			sigTypes = append(sigTypes, sig.SigType)
+		}
+	}
+	if len(sigTypes) != 2 || sigTypes[0] != packet.SigTypeTimestamp || sigTypes[1] != packet.SigTypeThirdPartyConfirmation {
+		t.Errorf("got signature types %#x, want timestamp and third-party confirmation", sigTypes)
+	}
+}
+
+func TestReadKeyRingWithTrust(t *testing.T) {
+	kring, err := ReadKeyRing(readerFromHex(trustExportHex))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kring) != 1 {
+		t.Fatalf("got %d entities, want 1", len(kring))
+	}
+	e := kring[0]
+	if len(e.UnknownPackets) != 0 {
+		t.Errorf("got unknown packets %+v", e.UnknownPackets)
+	}
+	if len(e.Subkeys) != 1 {
+		t.Errorf("got %d subkeys, want 1", len(e.Subkeys))
+	}
+	ident := e.Identities["Introducer <introducer@example.org>"]
+	if ident == nil || len(ident.Signatures) != 1 {
+		t.Fatalf("trust signature is missing")
+	}
+	checkTrust := func(sig *packet.Signature) {
+		if sig.TrustLevel != 1 || sig.TrustAmount != 120 {
+			t.Errorf("got trust level %d and amount %d, want 1 and 120", sig.TrustLevel, sig.TrustAmount)
+		}
+		const wantRegex = `<[^>]+[@.]example\.org>$`
+		if sig.Regex != wantRegex {
+			t.Errorf("got regex %q, want %q", sig.Regex, wantRegex)
+		}
+	}
+	checkTrust(ident.Signatures[0])
+
+	buf := new(bytes.Buffer)
+	if err := e.Serialize(buf); err != nil {
+		t.Fatal(err)
+	}
+	reread, err := ReadEntity(packet.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ident = reread.Identities["Introducer <introducer@example.org>"]
+	if ident == nil || len(ident.Signatures) != 1 {
+		t.Fatalf("trust signature was lost")
+	}
+	checkTrust(ident.Signatures[0])
+
+	var before, after bytes.Buffer
+	e.Identities[ident.Name].Signatures[0].Serialize(&before)
+	ident.Signatures[0].Serialize(&after)
+	if !bytes.Equal(before.Bytes(), after.Bytes()) {
+		t.Error("trust signature changed in a round trip")
+	}
+}
+
+func TestNewEntityList(t *testing.T) {
+	kring, err := ReadKeyRing(readerFromHex(testKeys1And2Hex))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	el, err := NewEntityList(kring)
+	if err != nil {
+		t.Fatalf("NewEntityList: %s", err)
+	}
+	if len(el) != len(kring) {
+		t.Errorf("got %d entities, want %d", len(el), len(kring))
+	}
+
+	if _, err := NewEntityList([]*Entity{kring[0], kring[1], kring[0]}); err == nil {
+		t.Error("duplicate fingerprints were not detected")
+	}
+
+	incomplete := *kring[0]
+	incomplete.Identities = map[string]*Identity{}
+	if _, err := NewEntityList([]*Entity{&incomplete}); err == nil {
+		t.Error("entity without identities was accepted")
+	}
+
+	// A new entity is valid before it has been serialized.
+	config := &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA}
+	entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewEntityList([]*Entity{entity}); err != nil {
+		t.Errorf("new entity: %s", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	kring, err := ReadKeyRing(readerFromHex(testKeys1And2Hex))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, e := range kring {
+		if err := e.Validate(nil); err != nil {
+			t.Errorf("#%d: %s", i, err)
+		}
+	}
+
+	created := time.Unix(1500000000, 0)
+	config := &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA, Time: func() time.Time { return created }}
+	entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := entity.SerializePrivate(buf, config); err != nil {
+		t.Fatal(err)
+	}
+	entity, err = ReadEntity(packet.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.Validate(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.Validate(&packet.Config{Time: func() time.Time { return created.Add(-time.Hour) }}); err == nil {
+		t.Error("signatures from the future were accepted")
+	}
+	if err := entity.Validate(&packet.Config{Time: func() time.Time { return created.Add(-30 * time.Second) }, MaxClockSkew: time.Minute}); err != nil {
+		t.Errorf("signatures within the clock skew were rejected: %s", err)
+	}
+
+	// Forget the back-signature of a new signing subkey.
+	if err := entity.AddSigningSubkey(config); err != nil {
+		t.Fatal(err)
+	}
+	sig := entity.Subkeys[len(entity.Subkeys)-1].Sig
+	sig.EmbeddedSignature = nil
+	sig = sig.CopyUnsigned()
+	if err := sig.SignKey(entity.Subkeys[len(entity.Subkeys)-1].PublicKey, entity.PrivateKey, config); err != nil {
+		t.Fatal(err)
+	}
+	entity.Subkeys[len(entity.Subkeys)-1].Sig = sig
+	validateErr := entity.Validate(config)
+	if !errors.Is(validateErr, pgpErrors.ErrMissingCrossSignature) {
+		t.Fatalf("got %v, want a missing cross-signature", validateErr)
+	}
+
+	buf.Reset()
+	if err := entity.Serialize(buf); err != nil {
+		t.Fatal(err)
+	}
+	reread, err := ReadEntity(packet.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reread.BadSubkeys) != 1 {
+		t.Fatalf("got %d bad subkeys, want 1", len(reread.BadSubkeys))
+	}
+	if readErr := reread.BadSubkeys[0].Err; readErr != validateErr {
+		t.Errorf("Validate returned %q, but ReadEntity %q", validateErr, readErr)
+	}
+}
+
+func TestRevalidateBindings(t *testing.T) {
+	kring, err := ReadKeyRing(readerFromHex(testKeys1And2Hex))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for i, e := range kring {
+		if err := e.RevalidateBindings(now); err != nil {
+			t.Errorf("#%d: %s", i, err)
+		}
+	}
+
+	if err := kring[0].RevalidateBindings(time.Unix(0, 0)); err == nil {
+		t.Error("binding signature from the future was accepted")
+	}
+
+	// Attach the second key's subkey to the first key.
+	forged := *kring[0]
+	forged.Subkeys = append([]Subkey{}, kring[0].Subkeys...)
+	forged.Subkeys = append(forged.Subkeys, kring[1].Subkeys[0])
+	if err := forged.RevalidateBindings(now); err == nil {
+		t.Error("subkey bound to another primary key was accepted")
+	}
+}
+
+func TestNewEntityCorrectName(t *testing.T) {
+	entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entity.Identities) != 1 {
+		t.Fatalf("len(entity.Identities) = %d, want 1", len(entity.Identities))
+	}
+	var got string
+	for _, i := range entity.Identities {
+		got = i.Name
+	}
+	want := "Golang Gopher (Test Key) <no-reply@golang.com>"
+	if got != want {
+		t.Fatalf("Identity.Name = %q, want %q", got, want)
+	}
+}
+
+func TestNewEntityWithPreferredHash(t *testing.T) {
+	c := &packet.Config{
+		DefaultHash: crypto.SHA256,
+	}
+	entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, identity := range entity.Identities {
+		if len(identity.SelfSignature.PreferredHash) == 0 {
+			t.Fatal("didn't find a preferred hash in self signature")
+		}
+		ph := hashToHashId(c.DefaultHash)
+		if identity.SelfSignature.PreferredHash[0] != ph {
+			t.Fatalf("Expected preferred hash to be %d, got %d", ph, identity.SelfSignature.PreferredHash[0])
+		}
+	}
+}
+
+func TestNewEntityWithSHA3(t *testing.T) {
+	for _, h := range []crypto.Hash{crypto.SHA3_256, crypto.SHA3_512} {
+		id, ok := packet.HashToHashId(h)
+		if !ok {
+			t.Fatalf("no hash id for %v", h)
+		}
+		if h2, ok := packet.HashIdToHash(id); !ok || h2 != h {
+			t.Fatalf("hash id %d maps to %v, want %v", id, h2, h)
+		}
+
+		for _, algo := range []packet.PublicKeyAlgorithm{packet.PubKeyAlgoEdDSA, packet.PubKeyAlgoRSA} {
+			config := &packet.Config{DefaultHash: h, Algorithm: algo, RSABits: 1024}
+			entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", config)
+			if err != nil {
+				t.Fatalf("%v, %d: %s", h, algo, err)
+			}
+			buf := new(bytes.Buffer)
+			if err := entity.SerializePrivate(buf, config); err != nil {
+				t.Fatal(err)
+			}
+			entity, err = ReadEntity(packet.NewReader(buf))
+			if err != nil {
+				t.Fatalf("%v, %d: %s", h, algo, err)
+			}
+			sig := entity.PrimaryIdentity().SelfSignature
+			if sig.Hash != h || len(sig.PreferredHash) != 1 || sig.PreferredHash[0] != id {
+				t.Errorf("%d: self-signature made with %v, preferring %v", algo, sig.Hash, sig.PreferredHash)
+			}
+			if got := entity.SigningHash(nil); got != h {
+				t.Errorf("%d: SigningHash() = %v, want %v", algo, got, h)
+			}
+
+			buf.Reset()
+			if err := DetachSign(buf, entity, strings.NewReader("message"), nil); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := CheckDetachedSignature(EntityList{entity}, strings.NewReader("message"), buf); err != nil {
+				t.Errorf("%v, %d signature: %s", h, algo, err)
+			}
+		}
+	}
+}
+
+func TestNewEntityWithoutPreferredHash(t *testing.T) {
+	entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, identity := range entity.Identities {
+		if len(identity.SelfSignature.PreferredHash) != 0 {
+			t.Fatalf("Expected preferred hash to be empty but got length %d", len(identity.SelfSignature.PreferredHash))
+		}
+	}
+}
+
+func TestSigningHash(t *testing.T) {
+	c := &packet.Config{
+		DefaultHash: crypto.SHA512,
+	}
+	entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := entity.SigningHash(nil); h != crypto.SHA512 {
+		t.Errorf("got %v, want SHA-512 from the key preferences", h)
+	}
+	if h := entity.SigningHash(&packet.Config{DefaultHash: crypto.SHA512}); h != crypto.SHA512 {
+		t.Errorf("got %v, want SHA-512", h)
+	}
+
+	for _, identity := range entity.Identities {
+		identity.SelfSignature.PreferredHash = []uint8{hashToHashId(crypto.SHA1)}
+	}
+	if h := entity.SigningHash(&packet.Config{DefaultHash: crypto.SHA1}); h != crypto.SHA256 {
+		t.Errorf("got %v, want fallback to SHA-256", h)
+	}
+
+	for _, identity := range entity.Identities {
+		identity.SelfSignature.PreferredHash = nil
+	}
+	if h := entity.SigningHash(&packet.Config{DefaultHash: crypto.SHA384}); h != crypto.SHA384 {
+		t.Errorf("got %v, want configured SHA-384", h)
+	}
+}
+
+func TestSerializeOmitsSensitiveRevoker(t *testing.T) {
+	for _, class := range []byte{0x80, 0xc0} {
+		entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var signed bytes.Buffer
+		if err := entity.SerializePrivate(&signed, nil); err != nil {
+			t.Fatal(err)
+		}
+		for _, ident := range entity.Identities {
+			sig := *ident.SelfSignature
+			sig.DesignatedRevoker = &packet.RevocationKey{
+				Class:         class,
+				PublicKeyAlgo: packet.PubKeyAlgoRSA,
+				Fingerprint:   entity.PrimaryKey.Fingerprint[:],
+			}
+			ident.Signatures = append(ident.Signatures, &sig)
+		}
+
+		var buf bytes.Buffer
+		if err := entity.Serialize(&buf); err != nil {
+			t.Fatal(err)
+		}
+		sigs := 0
+		packets := packet.NewReader(&buf)
+		for {
+			p, err := packets.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := p.(*packet.Signature); ok {
+				sigs++
+			}
+		}
+
+		// Self signature and subkey binding, plus the extra signature
+		// unless its revoker is sensitive.
+		want := 3
+		if class&0x40 != 0 {
+			want = 2
+		}
+		if sigs != want {
+			t.Errorf("class %#x: got %d signatures, want %d", class, sigs, want)
+		}
+	}
+}
+
+func TestSerializeOmitsSensitiveSelfSignature(t *testing.T) {
+	config := &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA}
+	entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.AddUserId("Golang Gopher", "Sensitive", "no-reply@golang.com", config); err != nil {
+		t.Fatal(err)
+	}
+	const sensitiveId = "Golang Gopher (Sensitive) <no-reply@golang.com>"
+	ident := entity.Identities[sensitiveId]
+	ident.SelfSignature.DesignatedRevoker = &packet.RevocationKey{
+		Class:         0xc0,
+		PublicKeyAlgo: packet.PubKeyAlgoEdDSA,
+		Fingerprint:   entity.PrimaryKey.Fingerprint[:],
+	}
+	if err := entity.SerializePrivate(ioutil.Discard, config); err != nil {
+		t.Fatal(err)
+	}
+
+	// The identity whose self-signature names the sensitive revoker is
+	// left out, and the rest of the key can still be read back.
+	for name, serialize := range map[string]func(io.Writer) error{
+		"Serialize":        entity.Serialize,
+		"SerializeMinimal": entity.SerializeMinimal,
+	} {
+		var buf bytes.Buffer
+		if err := serialize(&buf); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		reread, err := ReadEntity(packet.NewReader(&buf))
+		if err != nil {
+			t.Fatalf("%s: error reading the exported key: %s", name, err)
+		}
+		if len(reread.Identities) != 1 || reread.Identities[sensitiveId] != nil {
+			t.Errorf("%s: got identities %v, want only the one without the sensitive revoker", name, reread.Identities)
+		}
+	}
+
+	ident.SelfSignature.DesignatedRevoker.Class = 0x80
+	if err := entity.SerializePrivate(ioutil.Discard, config); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := entity.Serialize(&buf); err != nil {
+		t.Fatal(err)
+	}
+	reread, err := ReadEntity(packet.NewReader(&buf))
+	if err != nil {
+		t.Fatalf("error reading the exported key: %s", err)
+	}
+	if reread.Identities[sensitiveId] == nil {
+		t.Error("identity with a non-sensitive revoker was left out")
+	}
+}
+
+func TestNewEntityWithPreferredSymmetric(t *testing.T) {
+	c := &packet.Config{
+		DefaultCipher: packet.CipherAES256,
+	}
+	entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, identity := range entity.Identities {
+		if len(identity.SelfSignature.PreferredSymmetric) == 0 {
+			t.Fatal("didn't find a preferred cipher in self signature")
+		}
+		if identity.SelfSignature.PreferredSymmetric[0] != uint8(c.DefaultCipher) {
+			t.Fatalf("Expected preferred cipher to be %d, got %d", uint8(c.DefaultCipher), identity.SelfSignature.PreferredSymmetric[0])
+		}
+	}
+}
+func TestNewEntityWithoutPreferredSymmetric(t *testing.T) {
+	entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, identity := range entity.Identities {
+		if len(identity.SelfSignature.PreferredSymmetric) != 0 {
+			t.Fatalf("Expected preferred cipher to be empty but got length %d", len(identity.SelfSignature.PreferredSymmetric))
+		}
+	}
+}
+
+func TestNewEntityWithPreferredCompression(t *testing.T) {
+	c := &packet.Config{
+		Algorithm:              packet.PubKeyAlgoEdDSA,
+		DefaultCompressionAlgo: packet.CompressionZLIB,
+	}
+	entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := entity.SerializePrivate(buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	reread, err := ReadEntity(packet.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, identity := range reread.Identities {
+		if pc := identity.SelfSignature.PreferredCompression; len(pc) != 1 || pc[0] != uint8(c.DefaultCompressionAlgo) {
+			t.Fatalf("got preferred compression %v, want [%d]", pc, c.DefaultCompressionAlgo)
+		}
+	}
+
+	entity, err = NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, identity := range entity.Identities {
+		if len(identity.SelfSignature.PreferredCompression) != 0 {
+			t.Fatalf("Expected preferred compression to be empty but got %v", identity.SelfSignature.PreferredCompression)
+		}
+	}
+}
+
+func TestNewEntityWithKeyServerAndPolicy(t *testing.T) {
+	c := &packet.Config{
+		RSABits:            1024,
+		PreferredKeyServer: "hkps://keys.example.com",
+		PolicyURI:          "https://example.com/policy",
+	}
+	entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := entity.SerializePrivate(buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	reread, err := ReadEntity(packet.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, identity := range reread.Identities {
+		if ks := identity.SelfSignature.PreferredKeyServer; ks != c.PreferredKeyServer {
+			t.Errorf("Expected preferred key server to be %q, got %q", c.PreferredKeyServer, ks)
+		}
+		if uri := identity.SelfSignature.PolicyURI; uri != c.PolicyURI {
+			t.Errorf("Expected policy URI to be %q, got %q", c.PolicyURI, uri)
+		}
+	}
+}
+
+func TestKeyWithRevokedSubKey(t *testing.T) {
+	// This key contains a revoked sub key:
+	//  pub   rsa1024/0x4CBD826C39074E38 2018-06-14 [SC]
+	//        Key fingerprint = 3F95 169F 3FFA 7D3F 2B47  6F0C 4CBD 826C 3907 4E38
+	//  uid   Golang Gopher <no-reply@golang.com>
+	//  sub   rsa1024/0x945DB1AF61D85727 2018-06-14 [S] [revoked: 2018-06-14]
+	keys, err := ReadArmoredKeyRing(bytes.NewBufferString(keyWithSubKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 {
+		t.Fatal("Failed to read key with a sub key")
+	}
+	identity := keys[0].Identities["Golang Gopher <no-reply@golang.com>"]
+	// Test for an issue where Subkey Binding Signatures (RFC 4880 5.2.1) were added to the identity
+	// preceding the Subkey Packet if the Subkey Packet was followed by more than one signature.
+	// For example, the current key has the following layout:
+	//    PUBKEY UID SELFSIG SUBKEY REV SELFSIG
+	// The last SELFSIG would be added to the UID's signatures. This is wrong.
+	if numIdentitySigs, numExpected := len(identity.Signatures), 0; numIdentitySigs != numExpected {
+		t.Fatalf("got %d identity signatures, expected %d", numIdentitySigs, numExpected)
+	}
+	if numSubKeys, numExpected := len(keys[0].Subkeys), 1; numSubKeys != numExpected {
+		t.Fatalf("got %d subkeys, expected %d", numSubKeys, numExpected)
+	}
+	subKey := keys[0].Subkeys[0]
+	if subKey.Sig == nil {
+		t.Fatalf("subkey signature is nil")
+	}
+}
+
+// Try decryption when private key is stubbed. Caveat is that when a key is
+// stubbed, privateKey will be non-nil, but privateKey.PrivateKey will be nil.
+// Consumers will often overlook this fact, so make sure exposed API fails
+// gracefully.
+func TestStubbedPrivateKeyDecryption(t *testing.T) {
+	makeECDH := func() *Entity {
+		t.Logf("Making ECDH key")
+		return generateEccKeysForTest(t, elliptic.P521(), elliptic.P521())
+	}
+	makeRSA := func() *Entity {
+		t.Logf("Making RSA key")
+		cfg := &packet.Config{RSABits: 1024}
+		entity, err := NewEntity("Go-Crypto PGP Test", "Test Only Do Not Use", "alice@example.com", cfg)
+		if err != nil {
+			t.Fatalf("makeRSA failed with %s", err)
+		}
+		return entity
+	}
+
+	funcs := []func() *Entity{makeECDH, makeRSA}
+	for _, f := range funcs {
+		entity := f()
+
+		buf := new(bytes.Buffer)
+		armored, err := armor.Encode(buf, "PGP MESSAGE", nil)
+		writer, err := Encrypt(armored, []*Entity{entity}, nil, nil, nil)
+		if err != nil {
+			t.Fatalf("Failed to Encrypt: %s", err)
+		}
+		msgstr := "Hello Test Encryption TestStubbedPrivateKeyOperations."
+		io.Copy(writer, bytes.NewBufferString(msgstr))
+		if err := writer.Close(); err != nil {
+			t.Fatalf("Failed to writer.Close: %s", err)
+		}
+		if err := armored.Close(); err != nil {
+			t.Fatalf("Failed to armored.Close: %s", err)
+		}
+
+		// Stub the subkey
+		entity.Subkeys[0].PrivateKey.PrivateKey = nil
+
+		var entities EntityList
+		entities = append(entities, entity)
+
+		decKeys := entities.DecryptionKeys()
+		if len(decKeys) != 0 {
+			t.Fatalf("Expecting DecryptionKeys() to return empty list")
+		}
+
+		block, err := armor.Decode(bytes.NewBuffer(buf.Bytes()))
+		_, err = ReadMessage(block.Body, entities, nil, nil)
+		t.Logf("ReadMessage returned: %s", err)
+		if err == nil {
+			t.Fatalf("Expected a failure in ReadMessage")
+		}
+
+		if err != pgpErrors.ErrKeyIncorrect {
+			t.Fatalf("Expecting an error to be ErrKeyIncorrect")
+		}
+	}
+}
+
+// See https://github.com/keybase/client/issues/18944; ReadKeyRing used to panic here
+func TestElGamalPrivateKeyFuzz(t *testing.T) {
+	d, err := base64.StdEncoding.DecodeString("xyIEOQTlQxQAAAAAAAAAADZlZ2VWZWU7wgAEfzdGaWxlIFOnxyIELgTlQwEAAAAAAA==")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ReadKeyRing(bytes.NewBuffer(d))
+	if err == nil {
+		t.Fatal(errors.New("should have gotten an error parsing elgamal sign-or-encrypt private key"))
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", &packet.Config{RSABits: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if c := entity.Capabilities(now); c != "[SCE]" {
+		t.Errorf("got %s, want [SCE]", c)
+	}
+
+	entity.Subkeys[0].Revocation = &packet.Signature{}
+	if c := entity.Capabilities(now); c != "[SC]" {
+		t.Errorf("got %s with revoked subkey, want [SC]", c)
+	}
+
+	kring, err := ReadKeyRing(readerFromHex(revokedKeyHex))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := kring[0].Capabilities(now); c != "[]" {
+		t.Errorf("got %s for revoked key, want []", c)
+	}
+}
+
+func TestSetExpiration(t *testing.T) {
+	now := time.Now()
+	config := &packet.Config{RSABits: 1024, DefaultHash: crypto.SHA512, Time: func() time.Time { return now }}
+	entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.SetExpiration(24*time.Hour, config); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := entity.Serialize(buf); err != nil {
+		t.Fatal(err)
+	}
+	reread, err := ReadEntity(packet.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ident := reread.Identities["Golang Gopher (Test Key) <no-reply@golang.com>"]
+	if ident == nil || ident.SelfSignature.KeyLifetimeSecs == nil {
+		t.Fatal("no key lifetime on the self-signature")
+	}
+	if got := *ident.SelfSignature.KeyLifetimeSecs; got != 24*60*60 {
+		t.Errorf("key lifetime is %d, want %d", got, 24*60*60)
+	}
+	if len(ident.SelfSignature.PreferredHash) != 1 || ident.SelfSignature.PreferredHash[0] != hashToHashId(crypto.SHA512) {
+		t.Errorf("preferred hashes were not kept: %v", ident.SelfSignature.PreferredHash)
+	}
+	if !ident.SelfSignature.FlagsValid || !ident.SelfSignature.FlagSign || !ident.SelfSignature.FlagCertify {
+		t.Error("key flags were not kept")
+	}
+
+	if _, ok := reread.encryptionKey(now.Add(time.Hour)); !ok {
+		t.Error("no encryption key before the expiration")
+	}
+	if _, ok := reread.encryptionKey(now.Add(25 * time.Hour)); ok {
+		t.Error("encryption key found after the expiration")
+	}
+
+	// Zero removes the expiration again.
+	if err := entity.SetExpiration(0, config); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := entity.encryptionKey(now.Add(25 * time.Hour)); !ok {
+		t.Error("no encryption key after removing the expiration")
+	}
+}
+
+func TestAddUserId(t *testing.T) {
+	config := &packet.Config{RSABits: 1024, DefaultHash: crypto.SHA384}
+	entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.AddUserId("Golang Gopher", "Second", "gopher@golang.com", config); err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.AddUserId("Golang Gopher", "Second", "gopher@golang.com", config); err == nil {
+		t.Error("adding the same identity twice succeeded")
+	}
+
+	buf := new(bytes.Buffer)
+	if err := entity.SerializePrivate(buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	reread, err := ReadEntity(packet.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reread.Identities) != 2 {
+		t.Fatalf("got %d identities, want 2", len(reread.Identities))
+	}
+	for name, ident := range reread.Identities {
+		if ident.SelfSignature == nil {
+			t.Errorf("%s: no self-signature", name)
+			continue
+		}
+		if err := reread.PrimaryKey.VerifyUserIdSignature(name, reread.PrimaryKey, ident.SelfSignature); err != nil {
+			t.Errorf("%s: bad self-signature: %s", name, err)
+		}
+	}
+	added := reread.Identities["Golang Gopher (Second) <gopher@golang.com>"]
+	if added == nil {
+		t.Fatal("added identity not found")
+	}
+	if len(added.SelfSignature.PreferredHash) != 1 || added.SelfSignature.PreferredHash[0] != hashToHashId(crypto.SHA384) {
+		t.Errorf("preferred hashes were not copied: %v", added.SelfSignature.PreferredHash)
+	}
+	if added.SelfSignature.IsPrimaryId != nil && *added.SelfSignature.IsPrimaryId {
+		t.Error("added identity is marked as primary")
+	}
+}
+
+func TestPrimaryIdentity(t *testing.T) {
+	entity, err := NewEntity("Golang Gopher", "First", "first@golang.com", &packet.Config{RSABits: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, comment := range []string{"Second", "Third"} {
+		if err := entity.AddUserId("Golang Gopher", comment, strings.ToLower(comment)+"@golang.com", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	const second = "Golang Gopher (Second) <second@golang.com>"
+	for name, ident := range entity.Identities {
+		isPrimary := name == second
+		ident.SelfSignature.IsPrimaryId = &isPrimary
+	}
+
+	buf := new(bytes.Buffer)
+	if err := entity.SerializePrivate(buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	reread, err := ReadEntity(packet.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if got := reread.PrimaryIdentity(); got == nil || got.Name != second {
+			t.Fatalf("got %v, want %s", got, second)
+		}
+	}
+
+	// Without a primary flag, the newest self-signature wins.
+	const third = "Golang Gopher (Third) <third@golang.com>"
+	for name, ident := range reread.Identities {
+		ident.SelfSignature.IsPrimaryId = nil
+		if name == third {
+			ident.SelfSignature.CreationTime = ident.SelfSignature.CreationTime.Add(time.Hour)
+		}
+	}
+	if got := reread.PrimaryIdentity(); got == nil || got.Name != third {
+		t.Errorf("got %v, want %s", got, third)
+	}
+}
+
+func TestRevokeUserId(t *testing.T) {
+	entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", &packet.Config{RSABits: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const old = "Golang Gopher (Old) <old@golang.com>"
+	if err := entity.AddUserId("Golang Gopher", "Old", "old@golang.com", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.RevokeUserId("Nobody <nobody@golang.com>", packet.UserIdNotValid, nil); err == nil {
+		t.Error("revoked an unknown user id")
+	}
+	if err := entity.RevokeUserId(old, packet.UserIdNotValid, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := entity.SerializePrivate(buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	reread, err := ReadEntity(packet.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ident := reread.Identities[old]
+	if ident == nil {
+		t.Fatal("revoked identity not found")
+	}
+	if ident.Revocation == nil {
+		t.Fatal("identity is not revoked after a round trip")
+	}
+	if r := ident.Revocation.RevocationReason; r == nil || *r != uint8(packet.UserIdNotValid) {
+		t.Errorf("bad revocation reason %v", r)
+	}
+	if other := reread.Identities["Golang Gopher (Test Key) <no-reply@golang.com>"]; other == nil || other.Revocation != nil {
+		t.Error("the other identity should not be revoked")
+	}
+}
+
+func TestKeyRevocationStatus(t *testing.T) {
+	config := &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA}
+	entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.AddSigningSubkey(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.SetExpiration(24*time.Hour, config); err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.RevokeSubkey(entity.PrimaryKey, packet.KeyRetired, "", config); err == nil {
+		t.Error("revoked the primary key as a subkey")
+	}
+	if err := entity.RevokeSubkey(entity.Subkeys[1].PublicKey, packet.KeyRetired, "replaced", config); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := entity.SerializePrivate(buf, config); err != nil {
+		t.Fatal(err)
+	}
+	reread, err := ReadEntity(packet.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	el := EntityList{reread}
+	encryptionId, signingId := reread.Subkeys[0].PublicKey.KeyId, reread.Subkeys[1].PublicKey.KeyId
+
+	keys := el.KeysById(encryptionId, nil)
+	if len(keys) != 1 {
+		t.Fatalf("got %d encryption keys, want 1", len(keys))
+	}
+	if rs := keys[0].Revocation(); rs.Revoked {
+		t.Errorf("encryption subkey is revoked: %+v", rs)
+	}
+	now := time.Now()
+	if keys[0].Expired(now) || !keys[0].Expired(now.Add(48*time.Hour)) {
+		t.Error("encryption subkey should expire after a day")
+	}
+
+	if keys := el.KeysByIdUsage(signingId, nil, packet.KeyFlagSign); len(keys) != 0 {
+		t.Errorf("got %d usable signing keys, want none", len(keys))
+	}
+	keys = el.KeysById(signingId, nil)
+	if len(keys) != 1 {
+		t.Fatalf("got %d signing keys, want 1", len(keys))
+	}
+	rs := keys[0].Revocation()
+	if !rs.Revoked || rs.Reason != packet.KeyRetired || rs.ReasonText != "replaced" || rs.Signature == nil {
+		t.Errorf("bad signing subkey revocation: %+v", rs)
+	}
+	if rs.Compromised() {
+		t.Error("retired subkey reported as compromised")
+	}
+
+	// A revocation of the primary key applies to every subkey.
+	revocation, err := entity.Revoke(packet.KeyCompromised, "", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reread.ApplyRevocation(revocation); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []uint64{reread.PrimaryKey.KeyId, encryptionId, signingId} {
+		keys := el.KeysById(id, nil)
+		if len(keys) != 1 {
+			t.Fatalf("key %X: got %d keys, want 1", id, len(keys))
+		}
+		if rs := keys[0].Revocation(); rs.Reason != packet.KeyCompromised || !rs.Compromised() {
+			t.Errorf("key %X: got %+v, want a compromised revocation", id, rs)
+		}
+	}
+
+	// A compromised subkey stays compromised when its primary key is
+	// merely retired.
+	other, err := NewEntity("Golang Gopher", "Other Key", "no-reply@golang.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.AddSigningSubkey(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.RevokeSubkey(other.Subkeys[1].PublicKey, packet.KeyCompromised, "stolen", config); err != nil {
+		t.Fatal(err)
+	}
+	revocation, err = other.Revoke(packet.KeyRetired, "", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.ApplyRevocation(revocation); err != nil {
+		t.Fatal(err)
+	}
+	el = EntityList{other}
+	if rs := el.KeysById(other.Subkeys[1].PublicKey.KeyId, nil)[0].Revocation(); !rs.Compromised() || rs.ReasonText != "stolen" {
+		t.Errorf("got %+v, want the compromised revocation of the subkey", rs)
+	}
+	if rs := el.KeysById(other.Subkeys[0].PublicKey.KeyId, nil)[0].Revocation(); rs.Reason != packet.KeyRetired || rs.Compromised() {
+		t.Errorf("got %+v, want the retired revocation of the primary key", rs)
+	}
+
+	if !(RevocationStatus{Revoked: true}).Compromised() {
+		t.Error("a revocation without a reason should count as compromised")
+	}
+}
+
+func TestRevocationCertificate(t *testing.T) {
+	entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", &packet.Config{RSABits: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := entity.SerializePrivate(buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ReadEntity(packet.NewReader(bytes.NewReader(buf.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub.PrivateKey = nil
+	pub.Subkeys[0].PrivateKey = nil
+
+	// Only the primary key needs to be decrypted.
+	if err := entity.Subkeys[0].PrivateKey.Encrypt([]byte("passphrase"), nil); err != nil {
+		t.Fatal(err)
+	}
+	sig, err := entity.Revoke(packet.KeyCompromised, "lost laptop", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := new(bytes.Buffer)
+	if err := SerializeRevocationCertificate(cert, sig); err != nil {
+		t.Fatal(err)
+	}
+	block, err := armor.Decode(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Type != PublicKeyType {
+		t.Errorf("bad armor type %q", block.Type)
+	}
+	p, err := packet.Read(block.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revocation, ok := p.(*packet.Signature)
+	if !ok {
+		t.Fatalf("got %T, want a signature", p)
+	}
+	if r := revocation.RevocationReason; r == nil || *r != uint8(packet.KeyCompromised) {
+		t.Errorf("bad revocation reason %v", r)
+	}
+	if revocation.RevocationReasonText != "lost laptop" {
+		t.Errorf("bad revocation reason text %q", revocation.RevocationReasonText)
+	}
+
+	if keys := (EntityList{pub}).KeysByIdUsage(pub.PrimaryKey.KeyId, nil, 0); len(keys) == 0 {
+		t.Fatal("key not found before revocation")
+	}
+	other, err := NewEntity("Other Gopher", "", "other@golang.com", &packet.Config{RSABits: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.ApplyRevocation(revocation); err == nil {
+		t.Error("applied a revocation made by another key")
+	}
+	if err := pub.ApplyRevocation(revocation); err != nil {
+		t.Fatal(err)
+	}
+	if keys := (EntityList{pub}).KeysByIdUsage(pub.PrimaryKey.KeyId, nil, 0); len(keys) != 0 {
+		t.Errorf("revoked key still returned: %d keys", len(keys))
+	}
+}
+
+func TestV5Key(t *testing.T) {
+	kring, err := ReadKeyRing(readerFromHex(v5KeyHex))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kring) != 1 {
+		t.Fatalf("got %d entities, want 1", len(kring))
+	}
+	e := kring[0]
+	if e.PrimaryKey.Version != 5 {
+		t.Errorf("got key version %d, want 5", e.PrimaryKey.Version)
+	}
+	if got, want := e.PrimaryKey.KeyIdString(), "C62E1B43A65E5367"; got != want {
+		t.Errorf("got key ID %s, want %s", got, want)
+	}
+	if got, want := hex.EncodeToString(e.PrimaryKey.FullFingerprint()), "c62e1b43a65e536736ddd2c5ec075c428ea2a4311bb6e0eccaccac66cd7f4d37"; got != want {
+		t.Errorf("got fingerprint %s, want %s", got, want)
+	}
+	ident := e.Identities["Emma Goldman <emma.goldman@example.net>"]
+	if ident == nil {
+		t.Fatal("identity not found")
+	}
+	if ident.SelfSignature.Version != 5 {
+		t.Errorf("got self-signature version %d, want 5", ident.SelfSignature.Version)
+	}
+	if err := e.PrimaryKey.VerifyUserIdSignature(ident.Name, e.PrimaryKey, ident.SelfSignature); err != nil {
+		t.Errorf("self-signature does not verify: %s", err)
+	}
+
+	// The key must survive a round trip unchanged.
+	buf := new(bytes.Buffer)
+	if err := e.Serialize(buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(buf.Bytes()); got != v5KeyHex {
+		t.Errorf("serialized key differs:\ngot:  %s\nwant: %s", got, v5KeyHex)
+	}
+}
+
+func TestReadKeyRingFunc(t *testing.T) {
+	// The packet reader skips the unsupported version 7 key, which
+	// leaves its user ID and signature to break the entity before it.
+	// That entity must be reported without losing the key after it.
+	unsupported := strings.Replace(v5KeyHex, "c63705", "c63707", 1)
+	ring := testKeys1And2Hex + unsupported + v5KeyHex
+
+	var ids []string
+	var errs []error
+	err := ReadKeyRingFunc(readerFromHex(ring), func(e *Entity, err error) error {
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		ids = append(ids, e.PrimaryKey.KeyIdString())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] != "A34D7E18C20C31BB" || ids[1] != "C62E1B43A65E5367" {
+		t.Errorf("got keys %v", ids)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("got %d errors, want 1", len(errs))
+	}
+	if _, ok := errs[0].(pgpErrors.StructuralError); !ok {
+		t.Errorf("got %T, want a StructuralError", errs[0])
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = ReadKeyRingFunc(readerFromHex(ring), func(e *Entity, err error) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("got %v after %d calls, want stop after 1", err, calls)
+	}
+}
+
+func TestSignatureNotations(t *testing.T) {
+	entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", &packet.Config{RSABits: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const uid = "Golang Gopher (Test Key) <no-reply@golang.com>"
+	notations := []*packet.Notation{
+		{Name: "policy@golang.com", Value: []byte("gophers only"), IsHumanReadable: true},
+		{Name: "blob@golang.com", Value: []byte{0, 1, 2, 0xff}},
+	}
+	entity.Identities[uid].SelfSignature.Notations = notations
+
+	buf := new(bytes.Buffer)
+	if err := entity.SerializePrivate(buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	reread, err := ReadEntity(packet.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := reread.Identities[uid].SelfSignature
+	if len(sig.Notations) != len(notations) {
+		t.Fatalf("got %d notations, want %d", len(sig.Notations), len(notations))
+	}
+	for i, n := range sig.Notations {
+		want := notations[i]
+		if n.Name != want.Name || !bytes.Equal(n.Value, want.Value) || n.IsHumanReadable != want.IsHumanReadable || n.IsCritical {
+			t.Errorf("#%d: got notation %+v, want %+v", i, n, want)
+		}
+	}
+
+	// Reserializing the parsed signature keeps it unchanged.
+	first, second := new(bytes.Buffer), new(bytes.Buffer)
+	if err := sig.Serialize(first); err != nil {
+		t.Fatal(err)
+	}
+	p, err := packet.Read(bytes.NewReader(first.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.(*packet.Signature).Serialize(second); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Error("signature changed in a round trip")
+	}
+
+	// A critical notation is not understood, so the signature must not
+	// be accepted.
+	entity.Identities[uid].SelfSignature.Notations = []*packet.Notation{
+		{Name: "unknown@golang.com", Value: []byte("x"), IsCritical: true},
+	}
+	buf.Reset()
+	if err := entity.SerializePrivate(buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadEntity(packet.NewReader(buf)); err == nil {
+		t.Error("accepted a self-signature with a critical notation")
+	}
+}
+
+func TestEncryptionKeys(t *testing.T) {
+	created := time.Unix(1500000000, 0)
+	config := &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA, Time: func() time.Time { return created }}
+	entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Add a second encryption subkey and a signing subkey, then swap the
+	// encryption subkeys so that the newest comes first.
+	later := created.Add(24 * time.Hour)
+	config = &packet.Config{Time: func() time.Time { return later }}
+	if err := entity.AddEncryptionSubkey(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.AddSigningSubkey(config); err != nil {
+		t.Fatal(err)
+	}
+	entity.Subkeys[0], entity.Subkeys[1] = entity.Subkeys[1], entity.Subkeys[0]
+
+	keys := entity.EncryptionKeys(later)
+	if len(keys) != 2 {
+		t.Fatalf("got %d encryption keys, want 2", len(keys))
+	}
+	if keys[0].PublicKey != entity.Subkeys[1].PublicKey || keys[1].PublicKey != entity.Subkeys[0].PublicKey {
+		t.Error("encryption keys are not sorted by creation time")
+	}
+	if key, _ := entity.encryptionKey(later); key.PublicKey != keys[1].PublicKey {
+		t.Error("encryptionKey did not pick the newest of EncryptionKeys")
+	}
+
+	expired := uint32(1)
+	entity.Subkeys[1].Sig.KeyLifetimeSecs = &expired
+	if keys := entity.EncryptionKeys(later); len(keys) != 1 || keys[0].PublicKey != entity.Subkeys[0].PublicKey {
+		t.Errorf("got %d encryption keys, want only the unexpired one", len(keys))
+	}
+
+	var visited []*packet.PublicKey
+	EntityList{entity}.ForEachKey(func(key Key) {
+		visited = append(visited, key.PublicKey)
+	})
+	want := []*packet.PublicKey{entity.PrimaryKey, entity.Subkeys[0].PublicKey, entity.Subkeys[1].PublicKey, entity.Subkeys[2].PublicKey}
+	if len(visited) != len(want) {
+		t.Fatalf("ForEachKey visited %d keys, want %d", len(visited), len(want))
+	}
+	for i := range want {
+		if visited[i] != want[i] {
+			t.Errorf("ForEachKey visited key %d out of order", i)
+		}
+	}
+}
+
+func TestAddEncryptionSubkey(t *testing.T) {
+	for _, algo := range []packet.PublicKeyAlgorithm{packet.PubKeyAlgoRSA, packet.PubKeyAlgoEdDSA} {
+		created := time.Unix(1500000000, 0)
+		config := &packet.Config{RSABits: 1024, Algorithm: algo, Time: func() time.Time { return created }}
+		entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Without an algorithm in the config, the subkey must match the
+		// primary key.
+		later := created.Add(24 * time.Hour)
+		config = &packet.Config{RSABits: 1024, Time: func() time.Time { return later }}
+		if err := entity.AddEncryptionSubkey(config); err != nil {
+			t.Fatalf("algo %d: %s", algo, err)
+		}
+		if len(entity.Subkeys) != 2 {
+			t.Fatalf("algo %d: got %d subkeys, want 2", algo, len(entity.Subkeys))
+		}
+		added := entity.Subkeys[1]
+		if added.PublicKey.PubKeyAlgo != entity.Subkeys[0].PublicKey.PubKeyAlgo {
+			t.Errorf("algo %d: got subkey algorithm %d, want %d", algo, added.PublicKey.PubKeyAlgo, entity.Subkeys[0].PublicKey.PubKeyAlgo)
+		}
+
+		buf := new(bytes.Buffer)
+		if err := entity.SerializePrivate(buf, config); err != nil {
+			t.Fatal(err)
+		}
+		reread, err := ReadEntity(packet.NewReader(buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reread.Subkeys) != 2 {
+			t.Fatalf("algo %d: got %d subkeys after a round trip, want 2", algo, len(reread.Subkeys))
+		}
+		key, ok := reread.encryptionKey(later)
+		if !ok {
+			t.Fatalf("algo %d: no encryption key", algo)
+		}
+		if key.PublicKey.KeyId != added.PublicKey.KeyId {
+			t.Errorf("algo %d: got encryption key %X, want the new subkey %X", algo, key.PublicKey.KeyId, added.PublicKey.KeyId)
+		}
+	}
+
+	entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", &packet.Config{RSABits: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entity.PrivateKey.Encrypt([]byte("passphrase"), nil)
+	if err := entity.AddEncryptionSubkey(nil); err == nil {
+		t.Error("added a subkey with an encrypted primary key")
+	}
+}
+
+func TestRejectWeakHashes(t *testing.T) {
+	entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.SerializePrivate(ioutil.Discard, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.AddEncryptionSubkey(&packet.Config{DefaultHash: crypto.SHA1}); err != nil {
+		t.Fatal(err)
+	}
+	weak := entity.Subkeys[1].PublicKey.KeyId
+	buf := new(bytes.Buffer)
+	if err := entity.SerializePrivateWithoutSigning(buf); err != nil {
+		t.Fatal(err)
+	}
+	serialized := buf.Bytes()
+
+	// By default the SHA-1 binding is accepted.
+	e, err := ReadEntity(packet.NewReader(bytes.NewReader(serialized)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e.Subkeys) != 2 || len(e.BadSubkeys) != 0 {
+		t.Fatalf("got %d subkeys and %d bad subkeys, want 2 and 0", len(e.Subkeys), len(e.BadSubkeys))
+	}
+
+	config := &packet.Config{RejectWeakHashes: true}
+	e, err = ReadEntityWithConfig(packet.NewReader(bytes.NewReader(serialized)), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e.Subkeys) != 1 || len(e.BadSubkeys) != 1 {
+		t.Fatalf("got %d subkeys and %d bad subkeys, want 1 and 1", len(e.Subkeys), len(e.BadSubkeys))
+	}
+	bad := e.BadSubkeys[0]
+	if bad.PublicKey.KeyId != weak {
+		t.Errorf("got bad subkey %X, want %X", bad.PublicKey.KeyId, weak)
+	}
+	if !errors.Is(bad.Err, pgpErrors.ErrWeakHash) {
+		t.Errorf("got error %v, want a weak hash error", bad.Err)
+	}
+
+	// A key whose only self-signature uses SHA-1 has no usable identity.
+	config = &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA, DefaultHash: crypto.SHA1}
+	entity, err = NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := entity.SerializePrivate(buf, config); err != nil {
+		t.Fatal(err)
+	}
+	weakKey := append([]byte(nil), buf.Bytes()...)
+	_, err = ReadEntityWithConfig(packet.NewReader(buf), &packet.Config{RejectWeakHashes: true})
+	if !errors.Is(err, pgpErrors.ErrNoIdentities) || !errors.Is(err, pgpErrors.ErrWeakHash) {
+		t.Errorf("got %v, want ErrNoIdentities because of a weak hash", err)
+	}
+
+	// The keyring readers apply the policy too.
+	if _, err := ReadKeyRing(bytes.NewReader(weakKey)); err != nil {
+		t.Errorf("ReadKeyRing: %s", err)
+	}
+	if _, err := ReadKeyRingWithConfig(bytes.NewReader(weakKey), &packet.Config{RejectWeakHashes: true}); !errors.Is(err, pgpErrors.ErrWeakHash) {
+		t.Errorf("ReadKeyRingWithConfig: got %v, want a weak hash error", err)
+	}
+	armored := new(bytes.Buffer)
+	w, err := armor.Encode(armored, PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(weakKey)
+	w.Close()
+	if _, err := ReadArmoredKeyRingWithConfig(armored, &packet.Config{RejectWeakHashes: true}); !errors.Is(err, pgpErrors.ErrWeakHash) {
+		t.Errorf("ReadArmoredKeyRingWithConfig: got %v, want a weak hash error", err)
+	}
+
+	// Another identity with a SHA-1 self-signature is reported on its own.
+	if err := entity.AddUserId("Golang Gopher", "Strong", "no-reply@golang.com", &packet.Config{DefaultHash: crypto.SHA256}); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := entity.SerializePrivate(buf, config); err != nil {
+		t.Fatal(err)
+	}
+	e, err = ReadEntityWithConfig(packet.NewReader(buf), &packet.Config{RejectWeakHashes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const weakId = "Golang Gopher (Test Key) <no-reply@golang.com>"
+	if len(e.Identities) != 1 || e.Identities[weakId] != nil {
+		t.Errorf("got identities %v, want only the one with a SHA-256 self-signature", e.Identities)
+	}
+	if len(e.BadIdentities) != 1 || e.BadIdentities[0].Name != weakId || !errors.Is(e.BadIdentities[0].Err, pgpErrors.ErrWeakHash) {
+		t.Errorf("got bad identities %+v, want %q with a weak hash error", e.BadIdentities, weakId)
+	}
+}
+
+func TestPublicOnly(t *testing.T) {
+	kring, err := ReadKeyRing(readerFromHex(testKeys1And2PrivateHex))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := kring.PublicOnly()
+	if len(pub) != len(kring) {
+		t.Fatalf("got %d entities, want %d", len(pub), len(kring))
+	}
+
+	buf := new(bytes.Buffer)
+	for i, e := range pub {
+		if e.PrivateKey != nil {
+			t.Errorf("#%d: primary private key kept", i)
+		}
+		for j, subkey := range e.Subkeys {
+			if subkey.PrivateKey != nil {
+				t.Errorf("#%d: private key of subkey %d kept", i, j)
+			}
+		}
+		if kring[i].PrivateKey == nil || kring[i].Subkeys[0].PrivateKey == nil {
+			t.Errorf("#%d: private keys removed from the original", i)
+		}
+		if err := e.Serialize(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	or := packet.NewOpaqueReader(bytes.NewReader(buf.Bytes()))
+	for {
+		op, err := or.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if op.Tag == 5 || op.Tag == 7 {
+			t.Errorf("serialized secret key packet with tag %d", op.Tag)
+		}
+	}
+
+	reread, err := ReadKeyRing(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reread) != len(kring) {
+		t.Errorf("got %d entities after reading back, want %d", len(reread), len(kring))
+	}
+	if len(reread.DecryptionKeys()) != 0 {
+		t.Error("decryption keys found after reading back")
+	}
+}
+
+func TestSerializePrivateWithoutSigning(t *testing.T) {
+	// The fixture holds two keys, the second of which is encrypted. An
+	// unknown packet is added to that one.
+	input, _ := hex.DecodeString(testKeys1And2PrivateHex)
+	unknown := &packet.OpaquePacket{Tag: 60, Contents: []byte("experimental")}
+	inputBuf := bytes.NewBuffer(input)
+	unknown.Serialize(inputBuf)
+	input = inputBuf.Bytes()
+
+	kring, err := ReadKeyRing(bytes.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !kring[1].PrivateKey.Encrypted {
+		t.Fatal("second key is not encrypted")
+	}
+	buf := new(bytes.Buffer)
+	if err := kring[1].SerializePrivateWithoutSigning(buf); err != nil {
+		t.Fatal(err)
+	}
+	serialized := append([]byte{}, buf.Bytes()...)
+
+	readPackets := func(r io.Reader) (packets []*packet.OpaquePacket) {
+		or := packet.NewOpaqueReader(r)
+		for {
+			op, err := or.Next()
+			if err == io.EOF {
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			packets = append(packets, op)
+		}
+	}
+	// The second key is written back with the same packet contents, in
+	// the same order, but with new format headers and without the Trust
+	// packets, which are not kept.
+	// The first key is made of the first five other packets.
+	var want []*packet.OpaquePacket
+	trust := 0
+	for _, op := range readPackets(bytes.NewReader(input)) {
+		if op.Tag == 12 {
+			trust++
+			continue
+		}
+		want = append(want, op)
+	}
+	if trust == 0 {
+		t.Fatal("the fixture has no Trust packets")
+	}
+	want = want[5:]
+	got := readPackets(bytes.NewReader(serialized))
+	if len(got) != len(want) {
+		t.Fatalf("got %d packets, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if got[i].Tag != want[i].Tag || !bytes.Equal(got[i].Contents, want[i].Contents) {
+			t.Errorf("packet %d: got tag %d, %x, want tag %d, %x", i, got[i].Tag, got[i].Contents, want[i].Tag, want[i].Contents)
+		}
+	}
+
+	buf.Reset()
+	if err := kring[1].SerializePrivateWithoutSigning(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), serialized) {
+		t.Error("output changed between calls")
+	}
+
+	reread, err := ReadKeyRing(bytes.NewReader(serialized))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reread[0].PrivateKey.Decrypt([]byte("passphrase")); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	reread[0].SerializePrivateWithoutSigning(buf)
+	if bytes.Equal(buf.Bytes(), serialized) {
+		t.Error("decrypted key was serialized encrypted")
+	}
+}
+
+func TestChangePassphrase(t *testing.T) {
+	entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", &packet.Config{RSABits: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldPassphrase, newPassphrase := []byte("old"), []byte("new")
+	if err := entity.ChangePassphrase(nil, oldPassphrase, nil); err != nil {
+		t.Fatal(err)
+	}
+	reload := func() *Entity {
+		buf := new(bytes.Buffer)
+		if err := entity.SerializePrivate(buf, nil); err != nil {
+			t.Fatal(err)
+		}
+		e, err := ReadEntity(packet.NewReader(buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+	entity = reload()
+
+	// A subkey locked with another passphrase makes the change fail
+	// without touching the primary key.
+	subkey := entity.Subkeys[0].PrivateKey
+	if err := subkey.Decrypt(oldPassphrase); err != nil {
+		t.Fatal(err)
+	}
+	if err := subkey.Encrypt([]byte("other"), nil); err != nil {
+		t.Fatal(err)
+	}
+	primary := *entity.PrivateKey
+	if err := entity.ChangePassphrase(oldPassphrase, newPassphrase, nil); err == nil {
+		t.Fatal("changed the passphrase with a wrong one for the subkey")
+	}
+	if err := primary.Decrypt(oldPassphrase); err != nil || !entity.PrivateKey.Encrypted {
+		t.Fatalf("primary key changed by a failed passphrase change: %v", err)
+	}
+
+	if err := subkey.Decrypt([]byte("other")); err != nil {
+		t.Fatal(err)
+	}
+	if err := subkey.Encrypt(oldPassphrase, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.ChangePassphrase(oldPassphrase, newPassphrase, nil); err != nil {
+		t.Fatal(err)
+	}
+	entity = reload()
+	for i, key := range []*packet.PrivateKey{entity.PrivateKey, entity.Subkeys[0].PrivateKey} {
+		if err := key.Decrypt(oldPassphrase); err == nil {
+			t.Errorf("#%d: decrypted with the old passphrase", i)
+		}
+		if err := key.Decrypt(newPassphrase); err != nil {
+			t.Errorf("#%d: failed to decrypt with the new passphrase: %s", i, err)
+		}
+	}
+}
+
+func TestAddSigningSubkey(t *testing.T) {
+	for _, algo := range []packet.PublicKeyAlgorithm{packet.PubKeyAlgoRSA, packet.PubKeyAlgoEdDSA} {
+		created := time.Unix(1500000000, 0)
+		config := &packet.Config{RSABits: 1024, Algorithm: algo, Time: func() time.Time { return created }}
+		entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		later := created.Add(24 * time.Hour)
+		config = &packet.Config{RSABits: 1024, Time: func() time.Time { return later }}
+		if err := entity.AddSigningSubkey(config); err != nil {
+			t.Fatalf("algo %d: %s", algo, err)
+		}
+		added := entity.Subkeys[len(entity.Subkeys)-1]
+		if added.PublicKey.PubKeyAlgo != algo {
+			t.Errorf("algo %d: got subkey algorithm %d", algo, added.PublicKey.PubKeyAlgo)
+		}
+		if added.Sig.EmbeddedSignature == nil {
+			t.Fatalf("algo %d: no cross-signature", algo)
+		}
+		if err := entity.PrimaryKey.VerifyKeySignature(added.PublicKey, added.Sig); err != nil {
+			t.Errorf("algo %d: %s", algo, err)
+		}
+
+		buf := new(bytes.Buffer)
+		if err := entity.SerializePrivate(buf, config); err != nil {
+			t.Fatal(err)
+		}
+		reread, err := ReadEntity(packet.NewReader(buf))
+		if err != nil {
+			t.Fatalf("algo %d: %s", algo, err)
+		}
+		key, ok := reread.signingKey(later)
+		if !ok {
+			t.Fatalf("algo %d: no signing key", algo)
+		}
+		if key.PublicKey.KeyId != added.PublicKey.KeyId {
+			t.Errorf("algo %d: got signing key %X, want the new subkey %X", algo, key.PublicKey.KeyId, added.PublicKey.KeyId)
+		}
 	}
 }
@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/keybase/go-crypto/openpgp/armor"
+	"github.com/keybase/go-crypto/openpgp/errors"
 )
 
 func TestMultisig(t *testing.T) {
@@ -94,6 +95,65 @@ func TestMultisig(t *testing.T) {
 	t.Logf("When trying with bad key, error was: %s", err)
 }
 
+func TestMultisigSignatures(t *testing.T) {
+	kring1, err := ReadArmoredKeyRing(bytes.NewBufferString(testKey1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kring2, err := ReadArmoredKeyRing(bytes.NewBufferString(testKey2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readSignatures := func(keys EntityList) []SignatureResult {
+		sig, err := armor.Decode(strings.NewReader(testSignature))
+		if err != nil {
+			t.Fatal(err)
+		}
+		md, err := ReadMessage(sig.Body, keys, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadAll(md.UnverifiedBody); err != nil {
+			t.Fatal(err)
+		}
+		if len(md.Signatures) != 2 {
+			t.Fatalf("got %d signatures, want 2", len(md.Signatures))
+		}
+		return md.Signatures
+	}
+
+	both := append(append(EntityList{}, kring1...), kring2...)
+	signers := map[uint64]bool{}
+	for i, result := range readSignatures(both) {
+		if result.Err != nil {
+			t.Errorf("#%d: signature error: %s", i, result.Err)
+		}
+		if result.SignedBy == nil || result.SignedBy.PublicKey.KeyId != result.IssuerKeyId {
+			t.Errorf("#%d: signer doesn't match issuer %x", i, result.IssuerKeyId)
+		}
+		if result.Signature == nil && result.SignatureV3 == nil {
+			t.Errorf("#%d: missing signature packet", i)
+		}
+		signers[result.IssuerKeyId] = true
+	}
+	if !signers[kring1[0].PrimaryKey.KeyId] || !signers[kring2[0].PrimaryKey.KeyId] {
+		t.Errorf("got signers %v, want both keys", signers)
+	}
+
+	for _, keys := range []EntityList{kring1, kring2} {
+		for i, result := range readSignatures(keys) {
+			if result.IssuerKeyId == keys[0].PrimaryKey.KeyId {
+				if result.Err != nil {
+					t.Errorf("#%d: signature error: %s", i, result.Err)
+				}
+			} else if result.Err != errors.ErrUnknownIssuer || result.SignedBy != nil {
+				t.Errorf("#%d: got %v for an unknown signer, want ErrUnknownIssuer", i, result.Err)
+			}
+		}
+	}
+}
+
 func TestMultisigMalformed(t *testing.T) {
 	keys, err := ReadArmoredKeyRing(bytes.NewBufferString(testKey2))
 	if err != nil {
@@ -0,0 +1,353 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"strconv"
+
+	"github.com/keybase/go-crypto/hkdf"
+	"github.com/keybase/go-crypto/openpgp/errors"
+)
+
+// AEADEncrypted represents an AEAD Encrypted Data packet. The encrypted
+// contents will consist of more OpenPGP packets. See rfc4880bis-10, section
+// 5.16.
+type AEADEncrypted struct {
+	Cipher        CipherFunction
+	Mode          AEADMode
+	ChunkSizeByte byte // the chunk size is 2^(ChunkSizeByte+6) bytes.
+	IV            []byte
+	contents      io.Reader
+}
+
+const (
+	aeadEncryptedVersion = 1
+
+	// maxChunkSizeByte bounds the amount of ciphertext that has to be
+	// buffered while reading to 4 MiB, the largest chunk GnuPG emits.
+	maxChunkSizeByte = 16
+)
+
+func (ae *AEADEncrypted) parse(r io.Reader) error {
+	var buf [4]byte
+	if _, err := readFull(r, buf[:]); err != nil {
+		return err
+	}
+	if buf[0] != aeadEncryptedVersion {
+		return errors.UnsupportedError("unknown AEADEncrypted version " + strconv.Itoa(int(buf[0])))
+	}
+	ae.Cipher = CipherFunction(buf[1])
+	ae.Mode = AEADMode(buf[2])
+	ae.ChunkSizeByte = buf[3]
+	if ae.Cipher.blockSize() != 16 {
+		return errors.UnsupportedError("unsupported AEAD cipher: " + strconv.Itoa(int(ae.Cipher)))
+	}
+	if ae.Mode.NonceLength() == 0 {
+		return errors.UnsupportedError("unsupported AEAD mode: " + strconv.Itoa(int(ae.Mode)))
+	}
+	if ae.ChunkSizeByte > maxChunkSizeByte {
+		return errors.UnsupportedError("AEAD chunk size too large: " + strconv.Itoa(int(ae.ChunkSizeByte)))
+	}
+
+	ae.IV = make([]byte, ae.Mode.NonceLength())
+	if _, err := readFull(r, ae.IV); err != nil {
+		return err
+	}
+	ae.contents = r
+	return nil
+}
+
+// Decrypt returns a ReadCloser, from which the decrypted contents of the
+// packet can be read. The cipher is taken from the packet header, so c is
+// ignored. The first chunk is decrypted immediately so that an incorrect key
+// results in a KeyIncorrect error being returned.
+func (ae *AEADEncrypted) Decrypt(c CipherFunction, key []byte) (io.ReadCloser, error) {
+	if len(key) != ae.Cipher.KeySize() {
+		return nil, errors.ErrKeyIncorrect
+	}
+
+	return newAEADDecrypter(newAEADCrypter(ae.Cipher, ae.Mode, ae.ChunkSizeByte, key, ae.IV), &ae.contents)
+}
+
+// newAEADDecrypter returns a decrypter for the chunks read from *contents
+// and decrypts the first of them, so that an incorrect key is noticed early.
+func newAEADDecrypter(ac aeadCrypter, contents *io.Reader) (io.ReadCloser, error) {
+	ar := &aeadDecrypter{
+		aeadCrypter: ac,
+		in:          *contents,
+	}
+	ar.ciphertext = make([]byte, ar.chunkSize+2*ar.tagSize)
+	ar.chunk = make([]byte, 0, ar.chunkSize)
+
+	if err := ar.nextChunk(); err != nil {
+		if _, ok := err.(errors.SignatureError); ok && ar.index == 0 {
+			// Put the ciphertext back so that another key can be
+			// tried.
+			*contents = io.MultiReader(bytes.NewReader(ar.ciphertext[:ar.buffered]), *contents)
+			return nil, errors.ErrKeyIncorrect
+		}
+		ar.err = err
+	}
+	return ar, nil
+}
+
+// aeadCrypter holds the state shared by the chunked encrypter and decrypter.
+type aeadCrypter struct {
+	aead      cipher.AEAD
+	chunkSize int
+	tagSize   int
+	iv        []byte
+	prefix    [5]byte // the packet tag and header, used as associated data.
+	index     uint64  // the index of the next chunk.
+	length    uint64  // the number of plaintext bytes processed so far.
+	indexInAD bool    // whether the chunk index is part of the associated data.
+}
+
+func newAEADCrypter(c CipherFunction, mode AEADMode, chunkSizeByte byte, key, iv []byte) aeadCrypter {
+	return aeadCrypter{
+		aead:      mode.new(c.new(key)),
+		chunkSize: 1 << (uint(chunkSizeByte) + 6),
+		tagSize:   mode.TagLength(),
+		iv:        iv,
+		prefix: [5]byte{
+			0xc0 | byte(packetTypeAEADEncrypted),
+			aeadEncryptedVersion,
+			byte(c),
+			byte(mode),
+			chunkSizeByte,
+		},
+		indexInAD: true,
+	}
+}
+
+// newAEADCrypterV2 returns the crypter of a version 2 SymmetricallyEncrypted
+// packet. Its message key and IV are derived from the session key and salt
+// with HKDF, its nonces are the IV followed by the chunk index, and the chunk
+// index is left out of the associated data. See RFC 9580, section 5.13.2.
+func newAEADCrypterV2(c CipherFunction, mode AEADMode, chunkSizeByte byte, sessionKey, salt []byte) aeadCrypter {
+	prefix := [5]byte{
+		0xc0 | byte(packetTypeSymmetricallyEncryptedMDC),
+		symmetricallyEncryptedVersionAEAD,
+		byte(c),
+		byte(mode),
+		chunkSizeByte,
+	}
+	keySize := c.KeySize()
+	derived := make([]byte, keySize+mode.NonceLength()-8)
+	io.ReadFull(hkdf.New(sha256.New, sessionKey, salt, prefix[:]), derived)
+	// Leaving the last 8 bytes zero makes nonce append the index.
+	iv := make([]byte, mode.NonceLength())
+	copy(iv, derived[keySize:])
+
+	ac := newAEADCrypter(c, mode, chunkSizeByte, derived[:keySize], iv)
+	ac.prefix = prefix
+	ac.indexInAD = false
+	return ac
+}
+
+// nonce returns the nonce for the current chunk, which is the IV XORed with
+// the chunk index.
+func (ac *aeadCrypter) nonce() []byte {
+	nonce := make([]byte, len(ac.iv))
+	copy(nonce, ac.iv)
+	var index [8]byte
+	binary.BigEndian.PutUint64(index[:], ac.index)
+	for i := range index {
+		nonce[len(nonce)-8+i] ^= index[i]
+	}
+	return nonce
+}
+
+// associatedData returns the associated data for the current chunk. The
+// final authentication tag additionally covers the total plaintext length.
+func (ac *aeadCrypter) associatedData(final bool) []byte {
+	ad := make([]byte, len(ac.prefix), len(ac.prefix)+16)
+	copy(ad, ac.prefix[:])
+	if ac.indexInAD {
+		var index [8]byte
+		binary.BigEndian.PutUint64(index[:], ac.index)
+		ad = append(ad, index[:]...)
+	}
+	if final {
+		var length [8]byte
+		binary.BigEndian.PutUint64(length[:], ac.length)
+		ad = append(ad, length[:]...)
+	}
+	return ad
+}
+
+// An aeadDecrypter reads and authenticates one chunk at a time. It keeps a
+// chunk and a tag of lookahead so that the last chunk can be told apart from
+// the final authentication tag, which is checked before the last chunk is
+// released.
+type aeadDecrypter struct {
+	aeadCrypter
+	in         io.Reader
+	ciphertext []byte
+	buffered   int
+	chunk      []byte // backing storage for plaintext.
+	plaintext  []byte
+	err        error
+}
+
+func (ar *aeadDecrypter) Read(buf []byte) (n int, err error) {
+	for len(ar.plaintext) == 0 {
+		if ar.err != nil {
+			return 0, ar.err
+		}
+		ar.err = ar.nextChunk()
+	}
+	n = copy(buf, ar.plaintext)
+	ar.plaintext = ar.plaintext[n:]
+	return
+}
+
+// nextChunk decrypts the next chunk into plaintext. Once the final
+// authentication tag has been checked it returns io.EOF.
+func (ar *aeadDecrypter) nextChunk() error {
+	n, err := io.ReadFull(ar.in, ar.ciphertext[ar.buffered:])
+	ar.buffered += n
+	eof := false
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		eof = true
+	} else if err != nil {
+		return err
+	}
+
+	chunkLen := ar.chunkSize + ar.tagSize
+	if eof {
+		chunkLen = ar.buffered - ar.tagSize
+		if chunkLen < 0 || (chunkLen > 0 && chunkLen < ar.tagSize) {
+			return io.ErrUnexpectedEOF
+		}
+	}
+
+	var plaintext []byte
+	if chunkLen > 0 {
+		plaintext, err = ar.aead.Open(ar.chunk[:0], ar.nonce(), ar.ciphertext[:chunkLen], ar.associatedData(false))
+		if err != nil {
+			return errors.SignatureError("AEAD chunk authentication failed")
+		}
+		copy(ar.ciphertext, ar.ciphertext[chunkLen:ar.buffered])
+		ar.buffered -= chunkLen
+		ar.index++
+		ar.length += uint64(len(plaintext))
+	}
+
+	if eof {
+		if _, err := ar.aead.Open(nil, ar.nonce(), ar.ciphertext[:ar.buffered], ar.associatedData(true)); err != nil {
+			return errors.SignatureError("AEAD final authentication tag mismatch")
+		}
+		ar.plaintext = plaintext
+		return io.EOF
+	}
+	ar.plaintext = plaintext
+	return nil
+}
+
+func (ar *aeadDecrypter) Close() error {
+	for ar.err == nil {
+		ar.err = ar.nextChunk()
+	}
+	if ar.err != io.EOF {
+		return ar.err
+	}
+	return nil
+}
+
+// An aeadEncrypter buffers a chunk of plaintext at a time and writes it out
+// encrypted. On close, it emits the final authentication tag.
+type aeadEncrypter struct {
+	aeadCrypter
+	w         io.WriteCloser
+	plaintext []byte
+}
+
+func (aw *aeadEncrypter) Write(buf []byte) (n int, err error) {
+	for len(buf) > 0 {
+		m := copy(aw.plaintext[len(aw.plaintext):aw.chunkSize], buf)
+		aw.plaintext = aw.plaintext[:len(aw.plaintext)+m]
+		buf = buf[m:]
+		n += m
+		if len(aw.plaintext) == aw.chunkSize {
+			if err = aw.sealChunk(); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func (aw *aeadEncrypter) sealChunk() error {
+	ciphertext := aw.aead.Seal(nil, aw.nonce(), aw.plaintext, aw.associatedData(false))
+	if _, err := aw.w.Write(ciphertext); err != nil {
+		return err
+	}
+	aw.index++
+	aw.length += uint64(len(aw.plaintext))
+	aw.plaintext = aw.plaintext[:0]
+	return nil
+}
+
+func (aw *aeadEncrypter) Close() error {
+	if len(aw.plaintext) > 0 {
+		if err := aw.sealChunk(); err != nil {
+			return err
+		}
+	}
+	tag := aw.aead.Seal(nil, aw.nonce(), nil, aw.associatedData(true))
+	if _, err := aw.w.Write(tag); err != nil {
+		return err
+	}
+	return aw.w.Close()
+}
+
+// SerializeAEADEncrypted serializes an AEAD Encrypted Data packet to w and
+// returns a WriteCloser to which the to-be-encrypted packets can be written.
+// The chunk size is 2^(chunkSizeByte+6) bytes.
+// If config is nil, sensible defaults will be used.
+func SerializeAEADEncrypted(w io.Writer, c CipherFunction, mode AEADMode, chunkSizeByte byte, key []byte, config *Config) (contents io.WriteCloser, err error) {
+	if c.KeySize() != len(key) {
+		return nil, errors.InvalidArgumentError("AEADEncrypted.Serialize: bad key length")
+	}
+	if c.blockSize() != 16 {
+		return nil, errors.InvalidArgumentError("AEADEncrypted.Serialize: cipher must have a 16-byte block size")
+	}
+	if mode.NonceLength() == 0 {
+		return nil, errors.UnsupportedError("unsupported AEAD mode: " + strconv.Itoa(int(mode)))
+	}
+	if chunkSizeByte > maxChunkSizeByte {
+		return nil, errors.InvalidArgumentError("AEADEncrypted.Serialize: chunk size too large")
+	}
+
+	ciphertext, err := serializeStreamHeader(noOpCloser{w}, packetTypeAEADEncrypted)
+	if err != nil {
+		return
+	}
+
+	iv := make([]byte, mode.NonceLength())
+	if _, err = io.ReadFull(config.Random(), iv); err != nil {
+		return
+	}
+	header := []byte{aeadEncryptedVersion, byte(c), byte(mode), chunkSizeByte}
+	if _, err = ciphertext.Write(header); err != nil {
+		return
+	}
+	if _, err = ciphertext.Write(iv); err != nil {
+		return
+	}
+
+	aw := &aeadEncrypter{
+		aeadCrypter: newAEADCrypter(c, mode, chunkSizeByte, key, iv),
+		w:           ciphertext,
+	}
+	aw.plaintext = make([]byte, 0, aw.chunkSize)
+	return aw, nil
+}
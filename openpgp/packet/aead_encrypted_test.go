@@ -0,0 +1,142 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"testing"
+
+	"github.com/keybase/go-crypto/openpgp/errors"
+)
+
+func aeadEncrypt(t *testing.T, mode AEADMode, key, plaintext []byte) []byte {
+	buf := new(bytes.Buffer)
+	// A chunk size byte of zero gives 64-byte chunks, so that short
+	// messages still span several of them.
+	w, err := SerializeAEADEncrypted(buf, CipherAES128, mode, 0, key, nil)
+	if err != nil {
+		t.Fatalf("error from SerializeAEADEncrypted: %s", err)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		t.Fatalf("error writing plaintext: %s", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("error closing encrypter: %s", err)
+	}
+	return buf.Bytes()
+}
+
+func aeadDecrypt(key, ciphertext []byte) ([]byte, error) {
+	p, err := Read(bytes.NewReader(ciphertext))
+	if err != nil {
+		return nil, err
+	}
+	ae, ok := p.(*AEADEncrypted)
+	if !ok {
+		return nil, errors.StructuralError("not an AEADEncrypted packet")
+	}
+	r, err := ae.Decrypt(CipherAES128, key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return plaintext, r.Close()
+}
+
+func TestAEADEncryptedRoundTrip(t *testing.T) {
+	key := []byte("0123456789abcdef")
+
+	for _, mode := range []AEADMode{AEADModeEAX, AEADModeOCB} {
+		for _, size := range []int{0, 1, 63, 64, 65, 128, 200, 1000} {
+			plaintext := make([]byte, size)
+			for i := range plaintext {
+				plaintext[i] = byte(i)
+			}
+
+			ciphertext := aeadEncrypt(t, mode, key, plaintext)
+			got, err := aeadDecrypt(key, ciphertext)
+			if err != nil {
+				t.Errorf("mode %d, size %d: error decrypting: %s", mode, size, err)
+				continue
+			}
+			if !bytes.Equal(got, plaintext) {
+				t.Errorf("mode %d, size %d: got %x, want %x", mode, size, got, plaintext)
+			}
+		}
+	}
+}
+
+func TestAEADEncryptedCorruption(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	plaintext := make([]byte, 200)
+	ciphertext := aeadEncrypt(t, AEADModeOCB, key, plaintext)
+
+	// The final chunk holds 8 bytes of plaintext and is followed by the
+	// 16-byte final authentication tag and the zero length that ends the
+	// partial length stream.
+	corrupt := append([]byte{}, ciphertext...)
+	corrupt[len(corrupt)-21] ^= 1
+	if _, err := aeadDecrypt(key, corrupt); err == nil {
+		t.Error("corrupted final chunk was accepted")
+	} else if _, ok := err.(errors.SignatureError); !ok {
+		t.Errorf("corrupted final chunk: expected SignatureError, got %s", err)
+	}
+
+	corrupt = append([]byte{}, ciphertext...)
+	corrupt[len(corrupt)-2] ^= 1
+	if _, err := aeadDecrypt(key, corrupt); err == nil {
+		t.Error("corrupted final tag was accepted")
+	}
+
+	if _, err := aeadDecrypt([]byte("fedcba9876543210"), ciphertext); err != errors.ErrKeyIncorrect {
+		t.Errorf("wrong key: expected ErrKeyIncorrect, got %v", err)
+	}
+}
+
+func TestAEADEncryptedRetry(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	plaintext := make([]byte, 200)
+	p, err := Read(bytes.NewReader(aeadEncrypt(t, AEADModeOCB, key, plaintext)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ae := p.(*AEADEncrypted)
+	if _, err := ae.Decrypt(CipherAES128, []byte("fedcba9876543210")); err != errors.ErrKeyIncorrect {
+		t.Fatalf("wrong key: expected ErrKeyIncorrect, got %v", err)
+	}
+	r, err := ae.Decrypt(CipherAES128, key)
+	if err != nil {
+		t.Fatalf("error decrypting after a wrong key: %s", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Errorf("got %x, want %x", got, plaintext)
+	}
+}
+
+func TestAEADEncryptedEAXVector(t *testing.T) {
+	// This is the AEAD-EAX sample from rfc4880bis-10, appendix A.4.
+	key, _ := hex.DecodeString("86f1efb86952329f24acd3bfd0e5346d")
+	ciphertext, _ := hex.DecodeString(aeadEAXPacketHex)
+	plaintext, err := aeadDecrypt(key, ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A literal data packet holding "Hello, world!\n".
+	const expected = "cb1462000000000048656c6c6f2c20776f726c64210a"
+	if got := hex.EncodeToString(plaintext); got != expected {
+		t.Errorf("got %s, want %s", got, expected)
+	}
+}
+
+const aeadEAXPacketHex = "d44a0107010eb732379f73c4928de25facfe6517ec105dc11a81dc0cb8a2f6f3d90016384a56fc821ae11ae8dbcb49862655dea88d06a81486801b0ff387bd2eab013de1259586906eab2476"
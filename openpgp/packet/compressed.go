@@ -11,12 +11,14 @@ import (
 	"io"
 	"strconv"
 
+	bzip2w "github.com/keybase/go-crypto/openpgp/bzip2"
 	"github.com/keybase/go-crypto/openpgp/errors"
 )
 
 // Compressed represents a compressed OpenPGP packet. The decompressed contents
 // will contain more OpenPGP packets. See RFC 4880, section 5.6.
 type Compressed struct {
+	Algo CompressionAlgo
 	Body io.Reader
 }
 
@@ -36,7 +38,8 @@ type CompressionConfig struct {
 	// slower) compression levels. If Level is less than -1 or
 	// more then 9, a non-nil error will be returned during
 	// encryption. See the constants above for convenient common
-	// settings for Level.
+	// settings for Level. For BZIP2, Level is the block size in
+	// units of 100k, and values outside of 1 to 9 select 9.
 	Level int
 }
 
@@ -47,6 +50,7 @@ func (c *Compressed) parse(r io.Reader) error {
 		return err
 	}
 
+	c.Algo = CompressionAlgo(buf[0])
 	switch buf[0] {
 	case 1:
 		c.Body = flate.NewReader(r)
@@ -110,6 +114,8 @@ func SerializeCompressed(w io.WriteCloser, algo CompressionAlgo, cc *Compression
 		compressor, err = flate.NewWriter(compressed, level)
 	case CompressionZLIB:
 		compressor, err = zlib.NewWriterLevel(compressed, level)
+	case CompressionBZIP2:
+		compressor = bzip2w.NewWriter(compressed, level)
 	default:
 		s := strconv.Itoa(int(algo))
 		err = errors.UnsupportedError("Unsupported compression algorithm: " + s)
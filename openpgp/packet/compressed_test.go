@@ -9,6 +9,7 @@ import (
 	"encoding/hex"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"testing"
 )
 
@@ -37,5 +38,58 @@ func TestCompressed(t *testing.T) {
 	}
 }
 
+func TestSerializeCompressed(t *testing.T) {
+	random := make([]byte, 150000)
+	rand.New(rand.NewSource(1)).Read(random)
+	var runs []byte
+	for i := 0; len(runs) < 250000; i++ {
+		runs = append(runs, bytes.Repeat([]byte{byte(i)}, i%300)...)
+	}
+	inputs := [][]byte{
+		nil,
+		[]byte("a"),
+		[]byte("hello, world\n"),
+		bytes.Repeat([]byte("a"), 1000),
+		bytes.Repeat([]byte("ab"), 100000),
+		random,
+		runs,
+	}
+
+	for _, algo := range []CompressionAlgo{CompressionZIP, CompressionZLIB, CompressionBZIP2} {
+		for i, input := range inputs {
+			buf := new(bytes.Buffer)
+			// Level 1 gives small bzip2 blocks, so that the larger inputs
+			// take several of them.
+			w, err := SerializeCompressed(noOpCloser{buf}, algo, &CompressionConfig{Level: 1})
+			if err != nil {
+				t.Fatalf("algo %d: %s", algo, err)
+			}
+			if _, err := w.Write(input); err != nil {
+				t.Fatalf("algo %d, #%d: %s", algo, i, err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("algo %d, #%d: %s", algo, i, err)
+			}
+
+			p, err := Read(buf)
+			if err != nil {
+				t.Fatalf("algo %d, #%d: %s", algo, i, err)
+			}
+			c := p.(*Compressed)
+			if c.Algo != algo {
+				t.Errorf("algo %d, #%d: got algorithm %d", algo, i, c.Algo)
+			}
+			contents, err := ioutil.ReadAll(c.Body)
+			if err != nil {
+				t.Errorf("algo %d, #%d: %s", algo, i, err)
+				continue
+			}
+			if !bytes.Equal(contents, input) {
+				t.Errorf("algo %d, #%d: got %d bytes back, want %d", algo, i, len(contents), len(input))
+			}
+		}
+	}
+}
+
 const compressedHex = "a3013b2d90c4e02b72e25f727e5e496a5e49b11e1700"
 const compressedExpectedHex = "cb1062004d14c8fe636f6e74656e74732e0a"
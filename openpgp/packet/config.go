@@ -9,6 +9,8 @@ import (
 	"crypto/rand"
 	"io"
 	"time"
+
+	"github.com/keybase/go-crypto/openpgp/s2k"
 )
 
 // Config collects a number of parameters along with sensible defaults.
@@ -28,27 +30,99 @@ type Config struct {
 	Time func() time.Time
 	// DefaultCompressionAlgo is the compression algorithm to be
 	// applied to the plaintext before encryption. If zero, no
-	// compression is done.
+	// compression is done. NewEntity records it as the preferred
+	// compression algorithm of the key.
 	DefaultCompressionAlgo CompressionAlgo
 	// CompressionConfig configures the compression settings.
 	CompressionConfig *CompressionConfig
-	// S2KCount is only used for symmetric encryption. It
-	// determines the strength of the passphrase stretching when
-	// the said passphrase is hashed to produce a key. S2KCount
-	// should be between 1024 and 65011712, inclusive. If Config
-	// is nil or S2KCount is 0, the value 65536 used. Not all
-	// values in the above range can be represented. S2KCount will
-	// be rounded up to the next representable value if it cannot
-	// be encoded exactly. When set, it is strongly encrouraged to
-	// use a value that is at least 65536. See RFC 4880 Section
-	// 3.7.1.3.
+	// S2KCount is used for symmetric encryption and for locking
+	// private keys with a passphrase. It determines the strength of
+	// the passphrase stretching when the said passphrase is hashed
+	// to produce a key. S2KCount should be between 1024 and
+	// 65011712, inclusive. If Config is nil or S2KCount is 0, the
+	// value 65536 used. Not all values in the above range can be
+	// represented. S2KCount will be rounded up to the next
+	// representable value if it cannot be encoded exactly. When
+	// set, it is strongly encrouraged to use a value that is at
+	// least 65536. See RFC 4880 Section 3.7.1.3.
 	S2KCount int
-	// RSABits is the number of bits in new RSA keys made with NewEntity.
-	// If zero, then 2048 bit keys are created.
+	// S2KHash is the hash function used to derive keys from
+	// passphrases with Iterated and Salted S2K. If zero, DefaultHash
+	// applies.
+	S2KHash crypto.Hash
+	// S2KArgon2, if non-nil, selects the Argon2 S2K function with the
+	// given parameters, instead of Iterated and Salted S2K, to derive
+	// keys from passphrases when encrypting symmetrically or locking
+	// private keys. See draft-ietf-openpgp-crypto-refresh, section
+	// 3.7.1.4. Private keys locked with Argon2 are protected with AEAD.
+	S2KArgon2 *s2k.Argon2Config
+	// HideRecipients causes the encrypted key packets of messages
+	// encrypted to public keys to carry the wildcard key ID 0 instead of
+	// the key ID of the recipient, like GnuPG's --hidden-recipient.
+	// Decryption then has to try every private key. See RFC 4880,
+	// section 5.1.
+	HideRecipients bool
+	// RSABits is the number of bits in new RSA keys made with NewEntity,
+	// both for the primary key and the encryption subkey. If zero, then
+	// 2048 bit keys are created. Sizes below 1024 bits are rejected.
 	RSABits int
+	// Algorithm is the public key algorithm of the keys made with
+	// NewEntity. If zero, RSA is used. PubKeyAlgoEdDSA gives an Ed25519
+	// primary key with a Curve25519 ECDH encryption subkey.
+	Algorithm PublicKeyAlgorithm
+	// PreferredKeyServer and PolicyURI, if not empty, are stored in the
+	// self-signature of keys made with NewEntity. See RFC 4880, sections
+	// 5.2.3.18 and 5.2.3.20.
+	PreferredKeyServer string
+	PolicyURI          string
 	// ReuseSignatures tells us to reuse existing Signatures
 	// on serialized output.
 	ReuseSignaturesOnSerialize bool
+	// RequireSigned causes ReadMessage to reject messages that do not
+	// carry a signature.
+	RequireSigned bool
+	// RequireEncrypted causes ReadMessage to reject messages that are
+	// not encrypted.
+	RequireEncrypted bool
+	// RejectLegacyCiphers causes ReadMessage to refuse to decrypt
+	// messages encrypted with TripleDES or CAST5.
+	RejectLegacyCiphers bool
+	// RejectWeakHashes causes ReadEntityWithConfig to ignore
+	// self-signatures and subkey binding signatures that use MD5 or
+	// SHA-1. Subkeys left without a binding end up in BadSubkeys.
+	RejectWeakHashes bool
+	// PassphrasePrompt, if non-nil, is called when an encrypted private
+	// key needs to be unlocked, or when a message may be decrypted with a
+	// passphrase (symmetric is then true). keys lists the encrypted
+	// private keys that would be usable. The returned passphrase is tried
+	// on those keys and on any passphrase-encrypted session keys; if
+	// nothing can be unlocked with it the prompt is called again. An
+	// error aborts the operation.
+	PassphrasePrompt func(keys []*PrivateKey, symmetric bool) ([]byte, error)
+	// SignatureHashSink, if non-nil, receives a copy of the message
+	// contents that ReadMessage hashes while verifying a signature from
+	// a known signer, after any canonicalization of line endings. Write
+	// errors are ignored.
+	SignatureHashSink io.Writer
+	// SignatureVersion selects the version of newly made signatures.
+	// Zero means 4. Version 6 signatures are salted, see RFC 9580,
+	// section 5.2.4, and can only be made by version 6 keys.
+	SignatureVersion int
+	// MinSignatureVersion causes signature verification to fail for
+	// signatures of a lower version, e.g. 4 rejects version 3
+	// signatures. Zero accepts every version.
+	MinSignatureVersion int
+	// DisableMDC causes messages to be encrypted into a plain
+	// Symmetrically Encrypted Data packet, without a modification
+	// detection code, for legacy recipients that can't read anything
+	// else. Such messages are not integrity protected, so this should
+	// only be set when really needed. See RFC 4880, section 5.7.
+	DisableMDC bool
+	// MaxClockSkew is how far in the future, relative to Now, the
+	// creation time of a signature may be before the signature is
+	// rejected as not yet valid. It allows for signers whose clock runs
+	// slightly ahead. Zero tolerates no skew.
+	MaxClockSkew time.Duration
 }
 
 func (c *Config) Random() io.Reader {
@@ -72,6 +146,17 @@ func (c *Config) Cipher() CipherFunction {
 	return c.DefaultCipher
 }
 
+// ClockSkew returns c.MaxClockSkew, or zero if c is nil.
+func (c *Config) ClockSkew() time.Duration {
+	if c == nil {
+		return 0
+	}
+	return c.MaxClockSkew
+}
+
+// Now returns the time given by c.Time, or the current time if it is not
+// set. All time dependent operations, such as creating keys and signatures
+// and checking their expiration, use it.
 func (c *Config) Now() time.Time {
 	if c == nil || c.Time == nil {
 		return time.Now()
@@ -86,6 +171,13 @@ func (c *Config) Compression() CompressionAlgo {
 	return c.DefaultCompressionAlgo
 }
 
+func (c *Config) PublicKeyAlgorithm() PublicKeyAlgorithm {
+	if c == nil || c.Algorithm == 0 {
+		return PubKeyAlgoRSA
+	}
+	return c.Algorithm
+}
+
 func (c *Config) PasswordHashIterations() int {
 	if c == nil || c.S2KCount == 0 {
 		return 0
@@ -93,6 +185,74 @@ func (c *Config) PasswordHashIterations() int {
 	return c.S2KCount
 }
 
+// PasswordHash returns the hash function used to derive keys from
+// passphrases: S2KHash if it is set, or else the result of Hash.
+func (c *Config) PasswordHash() crypto.Hash {
+	if c == nil || c.S2KHash == 0 {
+		return c.Hash()
+	}
+	return c.S2KHash
+}
+
+func (c *Config) Argon2() *s2k.Argon2Config {
+	if c == nil {
+		return nil
+	}
+	return c.S2KArgon2
+}
+
 func (c *Config) ReuseSignatures() bool {
 	return c != nil && c.ReuseSignaturesOnSerialize
 }
+
+func (c *Config) SignedRequired() bool {
+	return c != nil && c.RequireSigned
+}
+
+func (c *Config) EncryptedRequired() bool {
+	return c != nil && c.RequireEncrypted
+}
+
+func (c *Config) LegacyCiphersRejected() bool {
+	return c != nil && c.RejectLegacyCiphers
+}
+
+func (c *Config) RecipientsHidden() bool {
+	return c != nil && c.HideRecipients
+}
+
+func (c *Config) WeakHashesRejected() bool {
+	return c != nil && c.RejectWeakHashes
+}
+
+func (c *Config) Prompt() func(keys []*PrivateKey, symmetric bool) ([]byte, error) {
+	if c == nil {
+		return nil
+	}
+	return c.PassphrasePrompt
+}
+
+func (c *Config) HashSink() io.Writer {
+	if c == nil {
+		return nil
+	}
+	return c.SignatureHashSink
+}
+
+func (c *Config) SigVersion() int {
+	if c == nil || c.SignatureVersion == 0 {
+		return 4
+	}
+	return c.SignatureVersion
+}
+
+func (c *Config) MinSigVersion() int {
+	if c == nil {
+		return 0
+	}
+	return c.MinSignatureVersion
+}
+
+func (c *Config) MDCDisabled() bool {
+	return c != nil && c.DisableMDC
+}
@@ -20,11 +20,14 @@ func ECDHKdfParams(pub *PublicKey) []byte {
 	buf.WriteByte(18) // ECDH TYPE
 	pub.ecdh.serialize(buf)
 	buf.WriteString("Anonymous Sender    ")
-	buf.Write(pub.Fingerprint[:])
+	buf.Write(pub.FullFingerprint())
 	return buf.Bytes()
 }
 
-func decryptKeyECDH(priv *PrivateKey, X, Y *big.Int, C []byte) (out []byte, err error) {
+// decryptKeyECDH unwraps the session key block in C. hasCipherFunc is false
+// for version 6 encrypted key packets, whose blocks don't start with the
+// cipher.
+func decryptKeyECDH(priv *PrivateKey, X, Y *big.Int, C []byte, hasCipherFunc bool) (out []byte, err error) {
 	ecdhpriv, ok := priv.PrivateKey.(*ecdh.PrivateKey)
 	if !ok {
 		return nil, errors.InvalidArgumentError("bad internal ECDH key")
@@ -46,18 +49,31 @@ func decryptKeyECDH(priv *PrivateKey, X, Y *big.Int, C []byte) (out []byte, err
 		return nil, err
 	}
 
-	// We have to "read ahead" to discover real length of the
-	// encryption key and properly unpad buffer.
-	cipherFunc := CipherFunction(decrypted[0])
-	// +3 bytes = 1-byte cipher id and checksum 2-byte checksum.
-	out = ecdh.UnpadBuffer(decrypted, cipherFunc.KeySize()+3)
+	if len(decrypted) == 0 {
+		return nil, errors.InvalidArgumentError("invalid padding while ECDH")
+	}
+	var dataLen int
+	if hasCipherFunc {
+		// We have to "read ahead" to discover real length of the
+		// encryption key and properly unpad buffer.
+		cipherFunc := CipherFunction(decrypted[0])
+		// +3 bytes = 1-byte cipher id and checksum 2-byte checksum.
+		dataLen = cipherFunc.KeySize() + 3
+	} else {
+		// Without the cipher, the length is taken from the padding.
+		dataLen = len(decrypted) - int(decrypted[len(decrypted)-1])
+	}
+	if dataLen < 0 || dataLen > len(decrypted) {
+		return nil, errors.InvalidArgumentError("invalid padding while ECDH")
+	}
+	out = ecdh.UnpadBuffer(decrypted, dataLen)
 	if out == nil {
 		return nil, errors.InvalidArgumentError("invalid padding while ECDH")
 	}
 	return out, nil
 }
 
-func serializeEncryptedKeyECDH(w io.Writer, rand io.Reader, header [10]byte, pub *PublicKey, keyBlock []byte) error {
+func serializeEncryptedKeyECDH(w io.Writer, rand io.Reader, header []byte, pub *PublicKey, keyBlock []byte) error {
 	ecdhpub := pub.PublicKey.(*ecdh.PublicKey)
 	kdfParams := ECDHKdfParams(pub)
 
@@ -16,15 +16,26 @@ import (
 	"github.com/keybase/go-crypto/rsa"
 )
 
-const encryptedKeyVersion = 3
+const (
+	encryptedKeyVersion = 3
+	// encryptedKeyVersion6 packets name the recipient by key version and
+	// fingerprint and leave the cipher to the encrypted data packet. See
+	// RFC 9580, section 5.1.
+	encryptedKeyVersion6 = 6
+)
 
 // EncryptedKey represents a public-key encrypted session key. See RFC 4880,
 // section 5.1.
 type EncryptedKey struct {
-	KeyId      uint64
-	Algo       PublicKeyAlgorithm
-	CipherFunc CipherFunction // only valid after a successful Decrypt
-	Key        []byte         // only valid after a successful Decrypt
+	Version int // 3, or 6 for packets that identify the recipient by fingerprint.
+	KeyId   uint64
+	// KeyVersion and KeyFingerprint identify the recipient of a version 6
+	// packet. They are zero if the recipient is hidden.
+	KeyVersion     int
+	KeyFingerprint []byte
+	Algo           PublicKeyAlgorithm
+	CipherFunc     CipherFunction // only valid after a successful Decrypt of a version 3 packet
+	Key            []byte         // only valid after a successful Decrypt
 
 	encryptedMPI1, encryptedMPI2 parsedMPI
 	ecdh_C                       []byte
@@ -32,15 +43,31 @@ type EncryptedKey struct {
 
 func (e *EncryptedKey) parse(r io.Reader) (err error) {
 	var buf [10]byte
-	_, err = readFull(r, buf[:])
+	_, err = readFull(r, buf[:1])
 	if err != nil {
 		return
 	}
-	if buf[0] != encryptedKeyVersion {
-		return errors.UnsupportedError("unknown EncryptedKey version " + strconv.Itoa(int(buf[0])))
+	e.Version = int(buf[0])
+	switch e.Version {
+	case encryptedKeyVersion:
+		_, err = readFull(r, buf[1:10])
+		if err != nil {
+			return
+		}
+		e.KeyId = binary.BigEndian.Uint64(buf[1:9])
+		e.Algo = PublicKeyAlgorithm(buf[9])
+	case encryptedKeyVersion6:
+		if err = e.parseRecipient(r); err != nil {
+			return
+		}
+		_, err = readFull(r, buf[:1])
+		if err != nil {
+			return
+		}
+		e.Algo = PublicKeyAlgorithm(buf[0])
+	default:
+		return errors.UnsupportedError("unknown EncryptedKey version " + strconv.Itoa(e.Version))
 	}
-	e.KeyId = binary.BigEndian.Uint64(buf[1:9])
-	e.Algo = PublicKeyAlgorithm(buf[9])
 	switch e.Algo {
 	case PubKeyAlgoRSA, PubKeyAlgoRSAEncryptOnly:
 		e.encryptedMPI1.bytes, e.encryptedMPI1.bitLength, err = readMPI(r)
@@ -71,6 +98,33 @@ func (e *EncryptedKey) parse(r io.Reader) (err error) {
 	return err
 }
 
+// parseRecipient reads the key version and fingerprint that identify the
+// recipient of a version 6 packet. A zero length means the recipient is
+// hidden.
+func (e *EncryptedKey) parseRecipient(r io.Reader) (err error) {
+	var buf [1]byte
+	_, err = readFull(r, buf[:])
+	if err != nil || buf[0] == 0 {
+		return
+	}
+	recipient := make([]byte, buf[0])
+	_, err = readFull(r, recipient)
+	if err != nil {
+		return
+	}
+	e.KeyVersion = int(recipient[0])
+	e.KeyFingerprint = recipient[1:]
+	switch {
+	case e.KeyVersion == 4 && len(e.KeyFingerprint) == 20:
+		e.KeyId = binary.BigEndian.Uint64(e.KeyFingerprint[12:20])
+	case e.KeyVersion >= 5 && len(e.KeyFingerprint) == 32:
+		e.KeyId = binary.BigEndian.Uint64(e.KeyFingerprint[:8])
+	default:
+		return errors.StructuralError("bad EncryptedKey recipient")
+	}
+	return
+}
+
 func checksumKeyMaterial(key []byte) uint16 {
 	var checksum uint16
 	for _, v := range key {
@@ -106,7 +160,7 @@ func (e *EncryptedKey) Decrypt(priv *PrivateKey, config *Config) error {
 		if c1 == nil {
 			return errors.InvalidArgumentError("failed to parse EC point for encryption key")
 		}
-		b, err = decryptKeyECDH(priv, c1, c2, e.ecdh_C)
+		b, err = decryptKeyECDH(priv, c1, c2, e.ecdh_C, e.Version != encryptedKeyVersion6)
 	default:
 		err = errors.InvalidArgumentError("cannot decrypted encrypted session key with private key of type " + strconv.Itoa(int(priv.PubKeyAlgo)))
 	}
@@ -115,8 +169,19 @@ func (e *EncryptedKey) Decrypt(priv *PrivateKey, config *Config) error {
 		return err
 	}
 
-	e.CipherFunc = CipherFunction(b[0])
-	e.Key = b[1 : len(b)-2]
+	// Version 6 packets leave out the cipher, which is given by the
+	// encrypted data packet instead.
+	keyStart := 1
+	if e.Version == encryptedKeyVersion6 {
+		keyStart = 0
+	}
+	if len(b) < keyStart+2 {
+		return errors.StructuralError("EncryptedKey too short")
+	}
+	if keyStart == 1 {
+		e.CipherFunc = CipherFunction(b[0])
+	}
+	e.Key = b[keyStart : len(b)-2]
 	expectedChecksum := uint16(b[len(b)-2])<<8 | uint16(b[len(b)-1])
 	checksum := checksumKeyMaterial(e.Key)
 	if checksum != expectedChecksum {
@@ -138,11 +203,9 @@ func (e *EncryptedKey) Serialize(w io.Writer) error {
 		return errors.InvalidArgumentError("don't know how to serialize encrypted key type " + strconv.Itoa(int(e.Algo)))
 	}
 
-	serializeHeader(w, packetTypeEncryptedKey, 1 /* version */ +8 /* key id */ +1 /* algo */ +mpiLen)
-
-	w.Write([]byte{encryptedKeyVersion})
-	binary.Write(w, binary.BigEndian, e.KeyId)
-	w.Write([]byte{byte(e.Algo)})
+	header := e.header()
+	serializeHeader(w, packetTypeEncryptedKey, len(header)+mpiLen)
+	w.Write(header)
 
 	switch e.Algo {
 	case PubKeyAlgoRSA, PubKeyAlgoRSAEncryptOnly:
@@ -156,29 +219,66 @@ func (e *EncryptedKey) Serialize(w io.Writer) error {
 	return nil
 }
 
+// header returns the fields that precede the encrypted session key: the
+// version, the recipient and the public key algorithm.
+func (e *EncryptedKey) header() []byte {
+	if e.Version == encryptedKeyVersion6 {
+		buf := []byte{encryptedKeyVersion6, 0}
+		if len(e.KeyFingerprint) != 0 {
+			buf[1] = byte(1 + len(e.KeyFingerprint))
+			buf = append(buf, byte(e.KeyVersion))
+			buf = append(buf, e.KeyFingerprint...)
+		}
+		return append(buf, byte(e.Algo))
+	}
+	buf := make([]byte, 10)
+	buf[0] = encryptedKeyVersion
+	binary.BigEndian.PutUint64(buf[1:9], e.KeyId)
+	buf[9] = byte(e.Algo)
+	return buf
+}
+
 // SerializeEncryptedKey serializes an encrypted key packet to w that contains
-// key, encrypted to pub.
+// key, encrypted to pub. The key ID of pub is written out unless
+// config.HideRecipients is set.
 // If config is nil, sensible defaults will be used.
 func SerializeEncryptedKey(w io.Writer, pub *PublicKey, cipherFunc CipherFunction, key []byte, config *Config) error {
-	var buf [10]byte
-	buf[0] = encryptedKeyVersion
-	binary.BigEndian.PutUint64(buf[1:9], pub.KeyId)
-	buf[9] = byte(pub.PubKeyAlgo)
+	e := &EncryptedKey{Version: encryptedKeyVersion, Algo: pub.PubKeyAlgo}
+	if !config.RecipientsHidden() {
+		e.KeyId = pub.KeyId
+	}
+	return serializeEncryptedKey(w, pub, e.header(), append([]byte{byte(cipherFunc)}, key...), key, config)
+}
+
+// SerializeEncryptedKeyV6 serializes a version 6 encrypted key packet to w
+// that contains key, encrypted to pub. Such packets don't record the cipher
+// and must be followed by a version 2 SymmetricallyEncrypted packet. The key
+// version and fingerprint of pub are written out unless
+// config.HideRecipients is set.
+// If config is nil, sensible defaults will be used.
+func SerializeEncryptedKeyV6(w io.Writer, pub *PublicKey, key []byte, config *Config) error {
+	e := &EncryptedKey{Version: encryptedKeyVersion6, Algo: pub.PubKeyAlgo}
+	if !config.RecipientsHidden() {
+		e.KeyVersion = pub.Version
+		e.KeyFingerprint = pub.FullFingerprint()
+	}
+	return serializeEncryptedKey(w, pub, e.header(), append([]byte(nil), key...), key, config)
+}
 
-	keyBlock := make([]byte, 1 /* cipher type */ +len(key)+2 /* checksum */)
-	keyBlock[0] = byte(cipherFunc)
-	copy(keyBlock[1:], key)
+// serializeEncryptedKey encrypts keyBlock, which holds the session key and
+// anything that precedes it, followed by the checksum of key, to pub and
+// writes it out after header.
+func serializeEncryptedKey(w io.Writer, pub *PublicKey, header, keyBlock, key []byte, config *Config) error {
 	checksum := checksumKeyMaterial(key)
-	keyBlock[1+len(key)] = byte(checksum >> 8)
-	keyBlock[1+len(key)+1] = byte(checksum)
+	keyBlock = append(keyBlock, byte(checksum>>8), byte(checksum))
 
 	switch pub.PubKeyAlgo {
 	case PubKeyAlgoRSA, PubKeyAlgoRSAEncryptOnly:
-		return serializeEncryptedKeyRSA(w, config.Random(), buf, pub.PublicKey.(*rsa.PublicKey), keyBlock)
+		return serializeEncryptedKeyRSA(w, config.Random(), header, pub.PublicKey.(*rsa.PublicKey), keyBlock)
 	case PubKeyAlgoElGamal:
-		return serializeEncryptedKeyElGamal(w, config.Random(), buf, pub.PublicKey.(*elgamal.PublicKey), keyBlock)
+		return serializeEncryptedKeyElGamal(w, config.Random(), header, pub.PublicKey.(*elgamal.PublicKey), keyBlock)
 	case PubKeyAlgoECDH:
-		return serializeEncryptedKeyECDH(w, config.Random(), buf, pub, keyBlock)
+		return serializeEncryptedKeyECDH(w, config.Random(), header, pub, keyBlock)
 	case PubKeyAlgoDSA, PubKeyAlgoRSASignOnly:
 		return errors.InvalidArgumentError("cannot encrypt to public key of type " + strconv.Itoa(int(pub.PubKeyAlgo)))
 	}
@@ -186,32 +286,32 @@ func SerializeEncryptedKey(w io.Writer, pub *PublicKey, cipherFunc CipherFunctio
 	return errors.UnsupportedError("encrypting a key to public key of type " + strconv.Itoa(int(pub.PubKeyAlgo)))
 }
 
-func serializeEncryptedKeyRSA(w io.Writer, rand io.Reader, header [10]byte, pub *rsa.PublicKey, keyBlock []byte) error {
+func serializeEncryptedKeyRSA(w io.Writer, rand io.Reader, header []byte, pub *rsa.PublicKey, keyBlock []byte) error {
 	cipherText, err := rsa.EncryptPKCS1v15(rand, pub, keyBlock)
 	if err != nil {
 		return errors.InvalidArgumentError("RSA encryption failed: " + err.Error())
 	}
 
-	packetLen := 10 /* header length */ + 2 /* mpi size */ + len(cipherText)
+	packetLen := len(header) + 2 /* mpi size */ + len(cipherText)
 
 	err = serializeHeader(w, packetTypeEncryptedKey, packetLen)
 	if err != nil {
 		return err
 	}
-	_, err = w.Write(header[:])
+	_, err = w.Write(header)
 	if err != nil {
 		return err
 	}
 	return writeMPI(w, 8*uint16(len(cipherText)), cipherText)
 }
 
-func serializeEncryptedKeyElGamal(w io.Writer, rand io.Reader, header [10]byte, pub *elgamal.PublicKey, keyBlock []byte) error {
+func serializeEncryptedKeyElGamal(w io.Writer, rand io.Reader, header []byte, pub *elgamal.PublicKey, keyBlock []byte) error {
 	c1, c2, err := elgamal.Encrypt(rand, pub, keyBlock)
 	if err != nil {
 		return errors.InvalidArgumentError("ElGamal encryption failed: " + err.Error())
 	}
 
-	packetLen := 10 /* header length */
+	packetLen := len(header)
 	packetLen += 2 /* mpi size */ + (c1.BitLen()+7)/8
 	packetLen += 2 /* mpi size */ + (c2.BitLen()+7)/8
 
@@ -219,7 +319,7 @@ func serializeEncryptedKeyElGamal(w io.Writer, rand io.Reader, header [10]byte,
 	if err != nil {
 		return err
 	}
-	_, err = w.Write(header[:])
+	_, err = w.Write(header)
 	if err != nil {
 		return err
 	}
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"math/big"
 	"testing"
+	"time"
 
 	"github.com/keybase/go-crypto/rsa"
 )
@@ -131,6 +132,45 @@ func TestEncryptingEncryptedKey(t *testing.T) {
 	}
 }
 
+func TestEncryptedKeyV6(t *testing.T) {
+	key := []byte{1, 2, 3, 4}
+	pub := NewRSAPublicKey(time.Unix(0x4d3c5c10, 0), &encryptedKeyPub)
+
+	for _, hidden := range []bool{false, true} {
+		buf := new(bytes.Buffer)
+		err := SerializeEncryptedKeyV6(buf, pub, key, &Config{HideRecipients: hidden})
+		if err != nil {
+			t.Fatalf("hidden %v: error writing encrypted key packet: %s", hidden, err)
+		}
+
+		p, err := Read(buf)
+		if err != nil {
+			t.Fatalf("hidden %v: error from Read: %s", hidden, err)
+		}
+		ek, ok := p.(*EncryptedKey)
+		if !ok {
+			t.Fatalf("hidden %v: didn't parse an EncryptedKey, got %#v", hidden, p)
+		}
+		if ek.Version != 6 || ek.Algo != PubKeyAlgoRSA {
+			t.Errorf("hidden %v: unexpected EncryptedKey contents: %#v", hidden, ek)
+		}
+		if hidden {
+			if ek.KeyId != 0 || ek.KeyVersion != 0 || len(ek.KeyFingerprint) != 0 {
+				t.Errorf("hidden recipient was written out: %#v", ek)
+			}
+		} else if ek.KeyId != pub.KeyId || ek.KeyVersion != 4 || !bytes.Equal(ek.KeyFingerprint, pub.Fingerprint[:]) {
+			t.Errorf("unexpected recipient: %#v", ek)
+		}
+
+		if err := ek.Decrypt(encryptedKeyPriv, nil); err != nil {
+			t.Fatalf("hidden %v: error from Decrypt: %s", hidden, err)
+		}
+		if ek.CipherFunc != 0 || !bytes.Equal(ek.Key, key) {
+			t.Errorf("hidden %v: bad key, got %d %x want %x", hidden, ek.CipherFunc, ek.Key, key)
+		}
+	}
+}
+
 func TestSerializingEncryptedKey(t *testing.T) {
 	const encryptedKeyHex = "c18c032a67d68660df41c70104005789d0de26b6a50c985a02a13131ca829c413a35d0e6fa8d6842599252162808ac7439c72151c8c6183e76923fe3299301414d0c25a2f06a2257db3839e7df0ec964773f6e4c4ac7ff3b48c444237166dd46ba8ff443a5410dc670cb486672fdbe7c9dfafb75b4fea83af3a204fe2a7dfa86bd20122b4f3d2646cbeecb8f7be8"
 
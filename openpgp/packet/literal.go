@@ -7,6 +7,7 @@ package packet
 import (
 	"encoding/binary"
 	"io"
+	"time"
 )
 
 // LiteralData represents an encrypted file. See RFC 4880, section 5.9.
@@ -23,6 +24,15 @@ func (l *LiteralData) ForEyesOnly() bool {
 	return l.FileName == "_CONSOLE"
 }
 
+// ModTime returns the time stored in the packet, usually the modification
+// time of the file, or the zero time if it is undefined.
+func (l *LiteralData) ModTime() time.Time {
+	if l.Time == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(l.Time), 0)
+}
+
 func (l *LiteralData) parse(r io.Reader) (err error) {
 	var buf [256]byte
 
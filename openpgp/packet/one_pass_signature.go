@@ -17,24 +17,36 @@ import (
 // OnePassSignature represents a one-pass signature packet. See RFC 4880,
 // section 5.4.
 type OnePassSignature struct {
+	// Version is 3, or 6 for the packets that go with version 6
+	// signatures. Zero is taken to mean 3 when serializing.
+	Version    int
 	SigType    SignatureType
 	Hash       crypto.Hash
 	PubKeyAlgo PublicKeyAlgorithm
 	KeyId      uint64
 	IsLast     bool
+
+	// Salt and KeyFingerprint are only used by version 6 packets: the
+	// salt of the signature, which is hashed ahead of the signed data,
+	// and the fingerprint of the signing key. See RFC 9580, section 5.4.
+	Salt           []byte
+	KeyFingerprint []byte
 }
 
-const onePassSignatureVersion = 3
+const (
+	onePassSignatureVersion  = 3
+	onePassSignatureVersion6 = 6
+)
 
 func (ops *OnePassSignature) parse(r io.Reader) (err error) {
-	var buf [13]byte
-
+	var buf [4]byte
 	_, err = readFull(r, buf[:])
 	if err != nil {
 		return
 	}
-	if buf[0] != onePassSignatureVersion {
-		err = errors.UnsupportedError("one-pass-signature packet version " + strconv.Itoa(int(buf[0])))
+	ops.Version = int(buf[0])
+	if ops.Version != onePassSignatureVersion && ops.Version != onePassSignatureVersion6 {
+		return errors.UnsupportedError("one-pass-signature packet version " + strconv.Itoa(int(buf[0])))
 	}
 
 	var ok bool
@@ -45,30 +57,72 @@ func (ops *OnePassSignature) parse(r io.Reader) (err error) {
 
 	ops.SigType = SignatureType(buf[1])
 	ops.PubKeyAlgo = PublicKeyAlgorithm(buf[3])
-	ops.KeyId = binary.BigEndian.Uint64(buf[4:12])
-	ops.IsLast = buf[12] != 0
+
+	if ops.Version == onePassSignatureVersion6 {
+		salt := new(Signature)
+		salt.Hash = ops.Hash
+		if err = salt.parseSalt(r); err != nil {
+			return
+		}
+		ops.Salt = salt.Salt
+		ops.KeyFingerprint = make([]byte, 32)
+		if _, err = readFull(r, ops.KeyFingerprint); err != nil {
+			return
+		}
+		ops.KeyId = binary.BigEndian.Uint64(ops.KeyFingerprint[:8])
+	} else {
+		var keyId [8]byte
+		if _, err = readFull(r, keyId[:]); err != nil {
+			return
+		}
+		ops.KeyId = binary.BigEndian.Uint64(keyId[:])
+	}
+
+	var isLast [1]byte
+	if _, err = readFull(r, isLast[:]); err != nil {
+		return
+	}
+	ops.IsLast = isLast[0] != 0
 	return
 }
 
 // Serialize marshals the given OnePassSignature to w.
 func (ops *OnePassSignature) Serialize(w io.Writer) error {
-	var buf [13]byte
-	buf[0] = onePassSignatureVersion
-	buf[1] = uint8(ops.SigType)
-	var ok bool
-	buf[2], ok = s2k.HashToHashId(ops.Hash)
+	hashId, ok := s2k.HashToHashId(ops.Hash)
 	if !ok {
 		return errors.UnsupportedError("hash type: " + strconv.Itoa(int(ops.Hash)))
 	}
-	buf[3] = uint8(ops.PubKeyAlgo)
-	binary.BigEndian.PutUint64(buf[4:12], ops.KeyId)
+	buf := []byte{onePassSignatureVersion, uint8(ops.SigType), hashId, uint8(ops.PubKeyAlgo)}
+	switch ops.Version {
+	case 0, onePassSignatureVersion:
+		var keyId [8]byte
+		binary.BigEndian.PutUint64(keyId[:], ops.KeyId)
+		buf = append(buf, keyId[:]...)
+	case onePassSignatureVersion6:
+		if size, err := saltSize(ops.Hash); err != nil {
+			return err
+		} else if len(ops.Salt) != size {
+			return errors.InvalidArgumentError("one-pass signature salt has wrong length")
+		}
+		if len(ops.KeyFingerprint) != 32 {
+			return errors.InvalidArgumentError("one-pass signature needs the fingerprint of a version 6 key")
+		}
+		buf[0] = onePassSignatureVersion6
+		buf = append(buf, byte(len(ops.Salt)))
+		buf = append(buf, ops.Salt...)
+		buf = append(buf, ops.KeyFingerprint...)
+	default:
+		return errors.InvalidArgumentError("one-pass-signature packet version " + strconv.Itoa(ops.Version))
+	}
 	if ops.IsLast {
-		buf[12] = 1
+		buf = append(buf, 1)
+	} else {
+		buf = append(buf, 0)
 	}
 
 	if err := serializeHeader(w, packetTypeOnePassSignature, len(buf)); err != nil {
 		return err
 	}
-	_, err := w.Write(buf[:])
+	_, err := w.Write(buf)
 	return err
 }
@@ -23,6 +23,13 @@ type OpaquePacket struct {
 	Reason error
 	// Binary contents of the packet data
 	Contents []byte
+
+	// headers and lengths record how a packet read by an OpaqueReader
+	// was encoded: each run of lengths[i] bytes of Contents follows the
+	// bytes headers[i], which are the packet header for the first run and
+	// a partial body length for further runs.
+	headers [][]byte
+	lengths []int
 }
 
 func (op *OpaquePacket) parse(r io.Reader) (err error) {
@@ -31,8 +38,23 @@ func (op *OpaquePacket) parse(r io.Reader) (err error) {
 }
 
 // Serialize marshals the packet to a writer in its original form, including
-// the packet header.
+// the packet header. A packet read by an OpaqueReader is written with its
+// original header and partial body lengths, unless its Tag or the length of
+// its Contents have been changed.
 func (op *OpaquePacket) Serialize(w io.Writer) (err error) {
+	if op.encodingMatches() {
+		contents := op.Contents
+		for i, header := range op.headers {
+			if _, err = w.Write(header); err != nil {
+				return
+			}
+			if _, err = w.Write(contents[:op.lengths[i]]); err != nil {
+				return
+			}
+			contents = contents[op.lengths[i]:]
+		}
+		return
+	}
 	err = serializeHeader(w, packetType(op.Tag), len(op.Contents))
 	if err == nil {
 		_, err = w.Write(op.Contents)
@@ -69,18 +91,77 @@ func NewOpaqueReader(r io.Reader) *OpaqueReader {
 
 // Read the next OpaquePacket.
 func (or *OpaqueReader) Next() (op *OpaquePacket, err error) {
-	tag, _, contents, err := readHeader(or.r)
+	raw := new(bytes.Buffer)
+	tag, length, contents, err := readHeader(io.TeeReader(or.r, raw))
 	if err != nil {
 		return
 	}
+	headerLen := raw.Len()
 	op = &OpaquePacket{Tag: uint8(tag), Reason: err}
 	err = op.parse(contents)
 	if err != nil {
 		consumeAll(contents)
+		return
 	}
+	op.recordEncoding(raw.Bytes(), headerLen, length)
 	return
 }
 
+// recordEncoding sets op.headers and op.lengths from raw, the bytes of the
+// whole packet, the first headerLen of which are its header. length is the
+// length read from the header, which is -1 for partial and indeterminate
+// lengths.
+func (op *OpaquePacket) recordEncoding(raw []byte, headerLen int, length int64) {
+	header := raw[:headerLen]
+	raw = raw[headerLen:]
+	if length >= 0 || header[0]&0x40 == 0 {
+		// A fixed length, or an old format packet that extends to the
+		// end of the input.
+		op.headers = [][]byte{header}
+		op.lengths = []int{len(op.Contents)}
+		return
+	}
+
+	// Partial body lengths: read the length of the first part again and
+	// then follow the lengths of the parts.
+	r := bytes.NewReader(header[1:])
+	n, isPartial, err := readLength(r)
+	for err == nil && int64(len(raw)) >= n {
+		op.headers = append(op.headers, header)
+		op.lengths = append(op.lengths, int(n))
+		raw = raw[n:]
+		if !isPartial {
+			return
+		}
+		r = bytes.NewReader(raw)
+		n, isPartial, err = readLength(r)
+		header = raw[:len(raw)-r.Len()]
+		raw = raw[len(header):]
+	}
+	op.headers, op.lengths = nil, nil
+}
+
+// encodingMatches reports whether op.headers and op.lengths still describe
+// op.
+func (op *OpaquePacket) encodingMatches() bool {
+	if len(op.headers) == 0 {
+		return false
+	}
+	tag := op.headers[0][0] & 0x3f
+	if op.headers[0][0]&0x40 == 0 {
+		// Old format packet
+		tag >>= 2
+	}
+	if tag != op.Tag {
+		return false
+	}
+	total := 0
+	for _, n := range op.lengths {
+		total += n
+	}
+	return total == len(op.Contents)
+}
+
 // OpaqueSubpacket represents an unparsed OpenPGP subpacket,
 // as found in signature and user attribute packets.
 type OpaqueSubpacket struct {
@@ -9,8 +9,65 @@ import (
 	"encoding/hex"
 	"io"
 	"testing"
+
+	"github.com/keybase/go-crypto/openpgp/errors"
 )
 
+func TestOpaqueSerializeFidelity(t *testing.T) {
+	var ring []byte
+	for _, h := range []string{UnsupportedKeyHex, privKeyRSAHex, eddsaV5PkDataHex, symmetricallyEncryptedHex} {
+		b, err := hex.DecodeString(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ring = append(ring, b...)
+	}
+	// A literal data packet with partial body lengths.
+	buf := bytes.NewBuffer(ring)
+	w, err := SerializeLiteral(noOpCloser{buf}, true, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(bytes.Repeat([]byte("partial"), 5000))
+	w.Close()
+	ring = buf.Bytes()
+
+	var packets []*OpaquePacket
+	out := new(bytes.Buffer)
+	or := NewOpaqueReader(bytes.NewReader(ring))
+	for {
+		op, err := or.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if err := op.Serialize(out); err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, op)
+	}
+	if !bytes.Equal(out.Bytes(), ring) {
+		t.Errorf("got:\n%x\nwant:\n%x", out.Bytes(), ring)
+	}
+
+	// Changed contents get a new header.
+	literal := packets[len(packets)-1]
+	if literal.Tag != uint8(packetTypeLiteralData) || len(literal.headers) < 2 {
+		t.Fatalf("got %d parts of packet type %d, want a literal data packet with partial lengths", len(literal.headers), literal.Tag)
+	}
+	literal.Contents = literal.Contents[:10]
+	out.Reset()
+	literal.Serialize(out)
+	p, err := Read(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.(*LiteralData); !ok {
+		t.Errorf("got %T, want *LiteralData", p)
+	}
+}
+
 // Test packet.Read error handling in OpaquePacket.Parse,
 // which attempts to re-read an OpaquePacket as a supported
 // Packet type.
@@ -23,6 +80,7 @@ func TestOpaqueParseReason(t *testing.T) {
 	count := 0
 	badPackets := 0
 	var uid *UserId
+	var trust *Trust
 	for {
 		op, err := or.Next()
 		if err == io.EOF {
@@ -36,6 +94,8 @@ func TestOpaqueParseReason(t *testing.T) {
 		switch pkt := p.(type) {
 		case *UserId:
 			uid = pkt
+		case *Trust:
+			trust = pkt
 		case *OpaquePacket:
 			// If an OpaquePacket can't re-parse, packet.Read
 			// certainly had its reasons.
@@ -48,11 +108,14 @@ func TestOpaqueParseReason(t *testing.T) {
 		count++
 	}
 
-	const expectedBad = 3
+	const expectedBad = 2
 	// Test post-conditions, make sure we actually parsed packets as expected.
 	if badPackets != expectedBad {
 		t.Errorf("unexpected # unparseable packets: %d (want %d)", badPackets, expectedBad)
 	}
+	if trust == nil {
+		t.Errorf("failed to find the trust packet in unsupported keyring")
+	}
 	if uid == nil {
 		t.Errorf("failed to find expected UID in unsupported keyring")
 	} else if uid.Id != "Armin M. Warda <warda@nephilim.ruhr.de>" {
@@ -60,6 +123,68 @@ func TestOpaqueParseReason(t *testing.T) {
 	}
 }
 
+func TestReaderSkipsUnknownPackets(t *testing.T) {
+	buf := new(bytes.Buffer)
+	experimental := &OpaquePacket{Tag: 60, Contents: []byte{1, 2, 3}}
+	experimental.Serialize(buf)
+	NewUserId("Alice", "", "alice@example.com").Serialize(buf)
+	(&OpaquePacket{Tag: 63}).Serialize(buf)
+
+	data := buf.Bytes()
+
+	// By default, unknown packets are discarded.
+	if p, err := Read(bytes.NewReader(data)); p != nil || err != errors.UnknownPacketTypeError(60) {
+		t.Errorf("got %T, %v, want an UnknownPacketTypeError only", p, err)
+	}
+	r := NewReader(bytes.NewReader(data))
+	if _, err := r.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if skipped := r.Skipped(); len(skipped) != 0 {
+		t.Errorf("got skipped packets %+v without KeepSkipped", skipped)
+	}
+
+	r = NewReader(bytes.NewReader(data))
+	r.KeepSkipped(3)
+	p, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.(*UserId); !ok {
+		t.Fatalf("got %T, want *UserId", p)
+	}
+	skipped := r.Skipped()
+	if len(skipped) != 1 || skipped[0].Tag != 60 || !bytes.Equal(skipped[0].Contents, experimental.Contents) {
+		t.Fatalf("got skipped packets %+v", skipped)
+	}
+	if _, ok := skipped[0].Reason.(errors.UnknownPacketTypeError); !ok {
+		t.Errorf("got reason %v, want UnknownPacketTypeError", skipped[0].Reason)
+	}
+
+	if _, err := r.Next(); err != io.EOF {
+		t.Fatalf("got %v, want EOF", err)
+	}
+	if skipped := r.Skipped(); len(skipped) != 1 || skipped[0].Tag != 63 {
+		t.Errorf("got skipped packets %+v", skipped)
+	}
+	if skipped := r.Skipped(); len(skipped) != 0 {
+		t.Errorf("packets skipped twice")
+	}
+
+	// Packets past the limit are discarded.
+	r = NewReader(bytes.NewReader(data))
+	r.KeepSkipped(2)
+	if _, err := r.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Fatalf("got %v, want EOF", err)
+	}
+	if skipped := r.Skipped(); len(skipped) != 1 || skipped[0].Tag != 63 {
+		t.Errorf("got skipped packets %+v, want only the empty one", skipped)
+	}
+}
+
 // This key material has public key and signature packet versions modified to
 // an unsupported value (1), so that trying to parse the OpaquePacket to
 // a typed packet will get an error. It also contains a GnuPG trust packet.
@@ -8,6 +8,7 @@ package packet // import "github.com/keybase/go-crypto/openpgp/packet"
 
 import (
 	"bufio"
+	"crypto"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/des"
@@ -16,7 +17,10 @@ import (
 	"math/big"
 
 	"github.com/keybase/go-crypto/cast5"
+	"github.com/keybase/go-crypto/eax"
+	"github.com/keybase/go-crypto/ocb"
 	"github.com/keybase/go-crypto/openpgp/errors"
+	"github.com/keybase/go-crypto/openpgp/s2k"
 	"github.com/keybase/go-crypto/rsa"
 )
 
@@ -90,49 +94,82 @@ func (r *partialLengthReader) Read(p []byte) (n int, err error) {
 
 	n, err = r.r.Read(p[:int(toRead)])
 	r.remaining -= int64(n)
-	if n < int(toRead) && err == io.EOF {
+	// Readers may return io.EOF along with the final bytes, so check
+	// that the packet really ended rather than just the current chunk.
+	if err == io.EOF && (r.remaining > 0 || r.isPartial) {
 		err = io.ErrUnexpectedEOF
 	}
 	return
 }
 
+// partialLengthChunkPower gives the size, as a power of two, of the chunks
+// written by partialLengthWriter.
+const (
+	partialLengthChunkPower = 14
+	partialLengthChunkSize  = 1 << partialLengthChunkPower
+)
+
 // partialLengthWriter writes a stream of data using OpenPGP partial lengths.
-// See RFC 4880, section 4.2.2.4.
+// See RFC 4880, section 4.2.2.4. Data is written in chunks of
+// partialLengthChunkSize bytes, so that the stream is written with bounded
+// memory however large it is and however it is split into writes. Short
+// writes are buffered until a chunk is full, which also keeps the first
+// chunk above the 512 bytes that the RFC requires. Close writes whatever is
+// left as the final, definite length, part of the packet.
 type partialLengthWriter struct {
 	w          io.WriteCloser
+	buf        []byte
 	lengthByte [1]byte
 }
 
 func (w *partialLengthWriter) Write(p []byte) (n int, err error) {
 	for len(p) > 0 {
-		for power := uint(14); power < 32; power-- {
-			l := 1 << power
-			if len(p) >= l {
-				w.lengthByte[0] = 224 + uint8(power)
-				_, err = w.w.Write(w.lengthByte[:])
-				if err != nil {
-					return
-				}
-				var m int
-				m, err = w.w.Write(p[:l])
-				n += m
-				if err != nil {
-					return
-				}
-				p = p[l:]
-				break
+		if len(w.buf) == 0 && len(p) >= partialLengthChunkSize {
+			if err = w.writeChunk(p[:partialLengthChunkSize]); err != nil {
+				return
 			}
+			n += partialLengthChunkSize
+			p = p[partialLengthChunkSize:]
+			continue
+		}
+		if w.buf == nil {
+			w.buf = make([]byte, 0, partialLengthChunkSize)
+		}
+		m := copy(w.buf[len(w.buf):partialLengthChunkSize], p)
+		w.buf = w.buf[:len(w.buf)+m]
+		n += m
+		p = p[m:]
+		if len(w.buf) == partialLengthChunkSize {
+			if err = w.writeChunk(w.buf); err != nil {
+				return
+			}
+			w.buf = w.buf[:0]
 		}
 	}
 	return
 }
 
+// writeChunk writes chunk, which must be partialLengthChunkSize bytes long,
+// as a partial length chunk.
+func (w *partialLengthWriter) writeChunk(chunk []byte) error {
+	w.lengthByte[0] = 224 + partialLengthChunkPower
+	if _, err := w.w.Write(w.lengthByte[:]); err != nil {
+		return err
+	}
+	_, err := w.w.Write(chunk)
+	return err
+}
+
 func (w *partialLengthWriter) Close() error {
-	w.lengthByte[0] = 0
-	_, err := w.w.Write(w.lengthByte[:])
-	if err != nil {
+	var buf [5]byte
+	n := putLength(buf[:], len(w.buf))
+	if _, err := w.w.Write(buf[:n]); err != nil {
 		return err
 	}
+	if _, err := w.w.Write(w.buf); err != nil {
+		return err
+	}
+	w.buf = nil
 	return w.w.Close()
 }
 
@@ -215,30 +252,34 @@ func readHeader(r io.Reader) (tag packetType, length int64, contents io.Reader,
 // 4.2.
 func serializeHeader(w io.Writer, ptype packetType, length int) (err error) {
 	var buf [6]byte
-	var n int
 
 	buf[0] = 0x80 | 0x40 | byte(ptype)
-	if length < 192 {
-		buf[1] = byte(length)
-		n = 2
-	} else if length < 8384 {
-		length -= 192
-		buf[1] = 192 + byte(length>>8)
-		buf[2] = byte(length)
-		n = 3
-	} else {
-		buf[1] = 255
-		buf[2] = byte(length >> 24)
-		buf[3] = byte(length >> 16)
-		buf[4] = byte(length >> 8)
-		buf[5] = byte(length)
-		n = 6
-	}
+	n := 1 + putLength(buf[1:], length)
 
 	_, err = w.Write(buf[:n])
 	return
 }
 
+// putLength encodes length as a new format packet length into buf, which
+// must hold at least five bytes, and returns the number of bytes used.
+func putLength(buf []byte, length int) int {
+	if length < 192 {
+		buf[0] = byte(length)
+		return 1
+	} else if length < 8384 {
+		length -= 192
+		buf[0] = 192 + byte(length>>8)
+		buf[1] = byte(length)
+		return 2
+	}
+	buf[0] = 255
+	buf[1] = byte(length >> 24)
+	buf[2] = byte(length >> 16)
+	buf[3] = byte(length >> 8)
+	buf[4] = byte(length)
+	return 5
+}
+
 // serializeStreamHeader writes an OpenPGP packet header to w where the
 // length of the packet is unknown. It returns a io.WriteCloser which can be
 // used to write the contents of the packet. See RFC 4880, section 4.2.
@@ -295,10 +336,12 @@ const (
 	packetTypeCompressed                packetType = 8
 	packetTypeSymmetricallyEncrypted    packetType = 9
 	packetTypeLiteralData               packetType = 11
+	packetTypeTrust                     packetType = 12
 	packetTypeUserId                    packetType = 13
 	packetTypePublicSubkey              packetType = 14
 	packetTypeUserAttribute             packetType = 17
 	packetTypeSymmetricallyEncryptedMDC packetType = 18
+	packetTypeAEADEncrypted             packetType = 20
 )
 
 // peekVersion detects the version of a public key packet about to
@@ -317,6 +360,13 @@ func peekVersion(r io.Reader) (bufr *bufio.Reader, ver byte, err error) {
 // Read reads a single OpenPGP packet from the given io.Reader. If there is an
 // error parsing a packet, the whole packet is consumed from the input.
 func Read(r io.Reader) (p Packet, err error) {
+	return read(r, -1)
+}
+
+// read is like Read, but a packet of unknown type whose contents are at
+// most keepUnknown bytes long is returned as an OpaquePacket, together
+// with the UnknownPacketTypeError.
+func read(r io.Reader, keepUnknown int) (p Packet, err error) {
 	tag, _, contents, err := readHeader(r)
 	if err != nil {
 		return
@@ -363,6 +413,8 @@ func Read(r io.Reader) (p Packet, err error) {
 		p = new(SymmetricallyEncrypted)
 	case packetTypeLiteralData:
 		p = new(LiteralData)
+	case packetTypeTrust:
+		p = new(Trust)
 	case packetTypeUserId:
 		p = new(UserId)
 	case packetTypeUserAttribute:
@@ -371,8 +423,19 @@ func Read(r io.Reader) (p Packet, err error) {
 		se := new(SymmetricallyEncrypted)
 		se.MDC = true
 		p = se
+	case packetTypeAEADEncrypted:
+		p = new(AEADEncrypted)
 	default:
 		err = errors.UnknownPacketTypeError(tag)
+		if keepUnknown >= 0 {
+			op := &OpaquePacket{Tag: uint8(tag), Reason: err}
+			limited := io.LimitReader(contents, int64(keepUnknown)+1)
+			if op.parse(limited) == nil && len(op.Contents) <= keepUnknown {
+				p = op
+			}
+		}
+		consumeAll(contents)
+		return
 	}
 	if p != nil {
 		err = p.parse(contents)
@@ -388,18 +451,32 @@ func Read(r io.Reader) (p Packet, err error) {
 type SignatureType uint8
 
 const (
-	SigTypeBinary             SignatureType = 0
-	SigTypeText                             = 1
-	SigTypeGenericCert                      = 0x10
-	SigTypePersonaCert                      = 0x11
-	SigTypeCasualCert                       = 0x12
-	SigTypePositiveCert                     = 0x13
-	SigTypeSubkeyBinding                    = 0x18
-	SigTypePrimaryKeyBinding                = 0x19
-	SigTypeDirectSignature                  = 0x1F
-	SigTypeKeyRevocation                    = 0x20
-	SigTypeSubkeyRevocation                 = 0x28
-	SigTypeIdentityRevocation               = 0x30
+	SigTypeBinary                 SignatureType = 0
+	SigTypeText                                 = 1
+	SigTypeGenericCert                          = 0x10
+	SigTypePersonaCert                          = 0x11
+	SigTypeCasualCert                           = 0x12
+	SigTypePositiveCert                         = 0x13
+	SigTypeSubkeyBinding                        = 0x18
+	SigTypePrimaryKeyBinding                    = 0x19
+	SigTypeDirectSignature                      = 0x1F
+	SigTypeKeyRevocation                        = 0x20
+	SigTypeSubkeyRevocation                     = 0x28
+	SigTypeIdentityRevocation                   = 0x30
+	SigTypeTimestamp                            = 0x40
+	SigTypeThirdPartyConfirmation               = 0x50
+)
+
+// ReasonForRevocation is the reason code carried by a revocation signature.
+// See RFC 4880, section 5.2.3.23.
+type ReasonForRevocation uint8
+
+const (
+	NoReason       ReasonForRevocation = 0
+	KeySuperseded  ReasonForRevocation = 1
+	KeyCompromised ReasonForRevocation = 2
+	KeyRetired     ReasonForRevocation = 3
+	UserIdNotValid ReasonForRevocation = 32
 )
 
 // PublicKeyAlgorithm represents the different public key system specified for
@@ -420,6 +497,8 @@ const (
 	PubKeyAlgoBadElGamal     PublicKeyAlgorithm = 20 // Reserved (deprecated, formerly ElGamal Encrypt or Sign)
 	// RFC -1
 	PubKeyAlgoEdDSA          PublicKeyAlgorithm = 22
+	// RFC 9580, section 9.1. Only signature verification is supported.
+	PubKeyAlgoEd25519        PublicKeyAlgorithm = 27
 )
 
 // CanEncrypt returns true if it's possible to encrypt a message to a public
@@ -436,7 +515,7 @@ func (pka PublicKeyAlgorithm) CanEncrypt() bool {
 // sign a message.
 func (pka PublicKeyAlgorithm) CanSign() bool {
 	switch pka {
-	case PubKeyAlgoRSA, PubKeyAlgoRSASignOnly, PubKeyAlgoDSA, PubKeyAlgoECDSA, PubKeyAlgoEdDSA:
+	case PubKeyAlgoRSA, PubKeyAlgoRSASignOnly, PubKeyAlgoDSA, PubKeyAlgoECDSA, PubKeyAlgoEdDSA, PubKeyAlgoEd25519:
 		return true
 	}
 	return false
@@ -471,6 +550,12 @@ func (cipher CipherFunction) KeySize() int {
 	return 0
 }
 
+// IsLegacy reports whether cipher is a 64-bit block cipher (TripleDES or
+// CAST5) that is only supported for compatibility with old messages.
+func (cipher CipherFunction) IsLegacy() bool {
+	return cipher == Cipher3DES || cipher == CipherCAST5
+}
+
 // blockSize returns the block size, in bytes, of cipher.
 func (cipher CipherFunction) blockSize() int {
 	switch cipher {
@@ -497,6 +582,44 @@ func (cipher CipherFunction) new(key []byte) (block cipher.Block) {
 	return
 }
 
+// AEADMode represents the different Authenticated Encryption with Associated
+// Data modes specified for OpenPGP. See rfc4880bis-10, section 9.6.
+type AEADMode uint8
+
+const (
+	AEADModeEAX AEADMode = 1
+	AEADModeOCB AEADMode = 2
+)
+
+// NonceLength returns the length, in bytes, of the nonce used by mode, or 0
+// if mode is not supported.
+func (mode AEADMode) NonceLength() int {
+	switch mode {
+	case AEADModeEAX:
+		return 16
+	case AEADModeOCB:
+		return 15
+	}
+	return 0
+}
+
+// TagLength returns the length, in bytes, of the authentication tag used by
+// mode.
+func (mode AEADMode) TagLength() int {
+	return 16
+}
+
+// new returns a fresh instance of the given mode over block.
+func (mode AEADMode) new(block cipher.Block) (aead cipher.AEAD) {
+	switch mode {
+	case AEADModeEAX:
+		aead, _ = eax.NewEAXWithNonceAndTagSize(block, mode.NonceLength(), mode.TagLength())
+	case AEADModeOCB:
+		aead, _ = ocb.NewOCBWithNonceAndTagSize(block, mode.NonceLength(), mode.TagLength())
+	}
+	return
+}
+
 // readMPI reads a big integer from r. The bit length returned is the bit
 // length that was specified in r. This is preserved so that the integer can be
 // reserialized exactly.
@@ -565,12 +688,23 @@ func padToKeySize(pub *rsa.PublicKey, b []byte) []byte {
 }
 
 // CompressionAlgo Represents the different compression algorithms
-// supported by OpenPGP (except for BZIP2, which is not currently
-// supported). See Section 9.3 of RFC 4880.
+// supported by OpenPGP. See Section 9.3 of RFC 4880.
 type CompressionAlgo uint8
 
 const (
-	CompressionNone CompressionAlgo = 0
-	CompressionZIP  CompressionAlgo = 1
-	CompressionZLIB CompressionAlgo = 2
+	CompressionNone  CompressionAlgo = 0
+	CompressionZIP   CompressionAlgo = 1
+	CompressionZLIB  CompressionAlgo = 2
+	CompressionBZIP2 CompressionAlgo = 3
 )
+
+// HashIdToHash returns the crypto.Hash for an OpenPGP hash algorithm id,
+// such as those listed in Signature.PreferredHash.
+func HashIdToHash(id byte) (h crypto.Hash, ok bool) {
+	return s2k.HashIdToHash(id)
+}
+
+// HashToHashId returns the OpenPGP hash algorithm id of h.
+func HashToHashId(h crypto.Hash) (id byte, ok bool) {
+	return s2k.HashToHashId(h)
+}
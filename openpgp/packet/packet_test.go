@@ -11,6 +11,7 @@ import (
 	"io"
 	"io/ioutil"
 	"testing"
+	"testing/iotest"
 
 	"github.com/keybase/go-crypto/openpgp/errors"
 )
@@ -254,3 +255,109 @@ func TestPartialLengths(t *testing.T) {
 		}
 	}
 }
+
+func TestPartialLengthChunks(t *testing.T) {
+	for _, size := range []int{0, 1, 511, 512, partialLengthChunkSize, 3*partialLengthChunkSize + 100} {
+		for _, step := range []int{1, 7, 1000, 70001} {
+			buf := new(bytes.Buffer)
+			w := &partialLengthWriter{w: noOpCloser{buf}}
+			data := make([]byte, size)
+			for rest := data; len(rest) > 0; {
+				n := len(rest)
+				if n > step {
+					n = step
+				}
+				w.Write(rest[:n])
+				rest = rest[n:]
+			}
+			w.Close()
+
+			// Every chunk but the last must be a partial chunk of the
+			// full size, and the last must hold the remainder.
+			for i := 0; i < size/partialLengthChunkSize; i++ {
+				if b := buf.Next(1 + partialLengthChunkSize); b[0] != 224+partialLengthChunkPower {
+					t.Fatalf("size %d, step %d: chunk %d has length byte %d", size, step, i, b[0])
+				}
+			}
+			length, isPartial, err := readLength(buf)
+			if err != nil || isPartial || int(length) != size%partialLengthChunkSize || int(length) != buf.Len() {
+				t.Errorf("size %d, step %d: final chunk has length %d (partial %t, error %v) and %d bytes follow", size, step, length, isPartial, err, buf.Len())
+			}
+		}
+	}
+}
+
+func TestPartialLengthsEagerEOF(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w, err := SerializeLiteral(noOpCloser{buf}, true, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make([]byte, 3<<20)
+	for i := range want {
+		want[i] = byte(i * 7)
+	}
+	// Uneven writes give a mix of chunk sizes.
+	for rest := want; len(rest) > 0; {
+		n := len(rest)
+		if n > 70001 {
+			n = 70001
+		}
+		w.Write(rest[:n])
+		rest = rest[n:]
+	}
+	w.Close()
+	msg := buf.Bytes()
+
+	p, err := Read(iotest.DataErrReader(bytes.NewReader(msg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(p.(*LiteralData).Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %d bytes, want %d", len(got), len(want))
+	}
+
+	// A stream cut at the end of a partial chunk must not look complete.
+	// The first chunk holds the literal header and the start of the data.
+	cut := 1 + (1 + partialLengthChunkSize)
+	p, err = Read(iotest.DataErrReader(bytes.NewReader(msg[:cut])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ioutil.ReadAll(p.(*LiteralData).Body); err != io.ErrUnexpectedEOF {
+		t.Errorf("got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestReadTrust(t *testing.T) {
+	// A Trust packet for a signature, as GnuPG keeps it in its keyrings.
+	packet, _ := hex.DecodeString("b006000067706700")
+	p, err := Read(bytes.NewReader(packet))
+	if err != nil {
+		t.Fatal(err)
+	}
+	trust, ok := p.(*Trust)
+	if !ok {
+		t.Fatalf("got %T, want *Trust", p)
+	}
+	want := []byte{0, 0, 'g', 'p', 'g', 0}
+	if !bytes.Equal(trust.Contents, want) {
+		t.Errorf("got contents %x, want %x", trust.Contents, want)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := trust.Serialize(buf); err != nil {
+		t.Fatal(err)
+	}
+	p, err = Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trust, ok := p.(*Trust); !ok || !bytes.Equal(trust.Contents, want) {
+		t.Errorf("got %#v after a round trip", p)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"crypto/dsa"
 	"crypto/ecdsa"
 	"crypto/sha1"
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -18,6 +19,7 @@ import (
 	"time"
 
 	"github.com/keybase/go-crypto/ed25519"
+	"github.com/keybase/go-crypto/hkdf"
 	"github.com/keybase/go-crypto/openpgp/ecdh"
 	"github.com/keybase/go-crypto/openpgp/elgamal"
 	"github.com/keybase/go-crypto/openpgp/errors"
@@ -35,6 +37,7 @@ type PrivateKey struct {
 	s2k           func(out, in []byte)
 	PrivateKey    interface{} // An *rsa.PrivateKey or *dsa.PrivateKey.
 	sha1Checksum  bool
+	aead          AEADMode // non-zero for keys protected with AEAD (S2K usage 253).
 	iv            []byte
 	s2kHeader     []byte
 }
@@ -100,11 +103,26 @@ func NewECDHPrivateKey(currentTime time.Time, priv *ecdh.PrivateKey) *PrivateKey
 	return pk
 }
 
+// NewEdDSAPrivateKey returns a PrivateKey that wraps the given Ed25519
+// private key.
+func NewEdDSAPrivateKey(currentTime time.Time, priv ed25519.PrivateKey) *PrivateKey {
+	pk := new(PrivateKey)
+	pk.PublicKey = *NewEdDSAPublicKey(currentTime, priv.Public().(ed25519.PublicKey))
+	eddsaPriv := new(EdDSAPrivateKey)
+	eddsaPriv.PublicKey = pk.PublicKey
+	eddsaPriv.seed = FromBytes(priv.Seed())
+	pk.PrivateKey = eddsaPriv
+	return pk
+}
+
 func (pk *PrivateKey) parse(r io.Reader) (err error) {
 	err = (&pk.PublicKey).parse(r)
 	if err != nil {
 		return
 	}
+	if pk.PublicKey.Version != 4 {
+		return errors.UnsupportedError("private key version " + strconv.Itoa(pk.PublicKey.Version))
+	}
 	var buf [1]byte
 	_, err = readFull(r, buf[:])
 	if err != nil {
@@ -117,17 +135,36 @@ func (pk *PrivateKey) parse(r io.Reader) (err error) {
 	case 0:
 		pk.s2k = nil
 		pk.Encrypted = false
-	case 254, 255:
+	case 253, 254, 255:
 		_, err = readFull(r, buf[:])
 		if err != nil {
 			return
 		}
 		pk.cipher = CipherFunction(buf[0])
 		pk.Encrypted = true
-		pk.s2k, err = s2k.Parse(r)
+		if s2kType == 253 {
+			// AEAD protection, see RFC 9580, section 5.5.3.
+			if _, err = readFull(r, buf[:]); err != nil {
+				return
+			}
+			pk.aead = AEADMode(buf[0])
+			if pk.aead.NonceLength() == 0 {
+				return errors.UnsupportedError("unsupported AEAD mode in private key: " + strconv.Itoa(int(buf[0])))
+			}
+		}
+		// Keep the S2K specifier so that the key can be serialized
+		// again while it is still encrypted.
+		s2kBuf := bytes.NewBuffer(nil)
+		pk.s2k, err = s2k.Parse(io.TeeReader(r, s2kBuf))
 		if err != nil {
 			return
 		}
+		pk.s2kHeader = s2kBuf.Bytes()
+		// RFC 9580, section 3.7.2.1: Argon2 is only used with AEAD
+		// (S2K usage 253), and keys that do otherwise are malformed.
+		if pk.aead == 0 && len(pk.s2kHeader) > 0 && pk.s2kHeader[0] == 4 {
+			return errors.StructuralError("Argon2 S2K in private key without AEAD protection")
+		}
 		if s2kType == 254 {
 			pk.sha1Checksum = true
 		}
@@ -146,6 +183,12 @@ func (pk *PrivateKey) parse(r io.Reader) (err error) {
 		if blockSize == 0 {
 			return errors.UnsupportedError("unsupported cipher in private key: " + strconv.Itoa(int(pk.cipher)))
 		}
+		if pk.aead != 0 {
+			if blockSize != 16 {
+				return errors.UnsupportedError("AEAD protected private key with a cipher of block size " + strconv.Itoa(blockSize))
+			}
+			blockSize = pk.aead.NonceLength()
+		}
 		pk.iv = make([]byte, blockSize)
 		_, err = readFull(r, pk.iv)
 		if err != nil {
@@ -178,23 +221,38 @@ func mod64kHash(d []byte) uint16 {
 // the standard, and sensible, defaults apply.
 //
 // A key will be derived from the given passphrase using S2K Specifier
-// Type 3 (Iterated + Salted, see RFC-4880 Sec. 3.7.1.3). This choice
-// is hardcoded in s2k.Serialize(). S2KCount is hardcoded to 0, which is
-// equivalent to 65536. And the hash algorithm for key-derivation can be
-// set with config. The encrypted PrivateKey, using the algorithm specified
-// in config (if provided), is written out to the encryptedData member.
-// When Serialize() is called, this encryptedData member will be
-// serialized, using S2K Usage value of 254, and thus SHA1 checksum.
+// Type 3 (Iterated + Salted, see RFC-4880 Sec. 3.7.1.3), or Argon2 if
+// config.S2KArgon2 is set. The iteration count and the hash algorithm for
+// key-derivation are config.S2KCount and config.S2KHash. The encrypted
+// PrivateKey, using the algorithm specified in config (if provided), is
+// written out to the encryptedData member. When Serialize() is called,
+// this encryptedData member will be serialized, using S2K Usage value of
+// 254, and thus SHA1 checksum. Argon2 may only be used with AEAD, so such
+// keys are instead protected with OCB and S2K Usage value 253, see RFC
+// 9580, section 5.5.3. A key that is already encrypted must be decrypted
+// first.
 func (pk *PrivateKey) Encrypt(passphrase []byte, config *Config) (err error) {
 	if pk.PrivateKey == nil {
 		return errors.InvalidArgumentError("there is no private key to encrypt")
 	}
+	if pk.Encrypted {
+		return errors.InvalidArgumentError("private key is already encrypted")
+	}
+	if config.Argon2() != nil && config.Cipher().blockSize() != 16 {
+		return errors.InvalidArgumentError("AEAD key protection requires a cipher with a 16-byte block size")
+	}
 
 	pk.sha1Checksum = true
 	pk.cipher = config.Cipher()
+	pk.aead = 0
+	if config.Argon2() != nil {
+		pk.sha1Checksum = false
+		pk.aead = AEADModeOCB
+	}
 	s2kConfig := s2k.Config{
-		Hash:     config.Hash(),
-		S2KCount: 0,
+		Hash:     config.PasswordHash(),
+		S2KCount: config.PasswordHashIterations(),
+		Argon2:   config.Argon2(),
 	}
 	s2kBuf := bytes.NewBuffer(nil)
 	derivedKey := make([]byte, pk.cipher.KeySize())
@@ -209,6 +267,9 @@ func (pk *PrivateKey) Encrypt(passphrase []byte, config *Config) (err error) {
 	// most of the functions needed are private to s2k.
 	pk.s2k, err = s2k.Parse(s2kBuf)
 	pk.iv = make([]byte, pk.cipher.blockSize())
+	if pk.aead != 0 {
+		pk.iv = make([]byte, pk.aead.NonceLength())
+	}
 	if _, err = config.Random().Read(pk.iv); err != nil {
 		return err
 	}
@@ -218,6 +279,16 @@ func (pk *PrivateKey) Encrypt(passphrase []byte, config *Config) (err error) {
 		return err
 	}
 
+	if pk.aead != 0 {
+		aead, ad, err := pk.aeadCrypter(derivedKey)
+		if err != nil {
+			return err
+		}
+		pk.encryptedData = aead.Seal(nil, pk.iv, privateKeyBuf.Bytes(), ad)
+		pk.Encrypted = true
+		return nil
+	}
+
 	checksum := sha1.Sum(privateKeyBuf.Bytes())
 	if _, err = privateKeyBuf.Write(checksum[:]); err != nil {
 		return err
@@ -241,23 +312,25 @@ func (pk *PrivateKey) Serialize(w io.Writer) (err error) {
 
 	privateKeyBuf := bytes.NewBuffer(nil)
 
-	if pk.PrivateKey == nil {
-		_, err = buf.Write([]byte{
-			254,           // SHA-1 Convention
-			9,             // Encryption scheme (AES256)
-			101,           // GNU Extensions
-			2,             // Hash value (SHA1)
-			'G', 'N', 'U', // "GNU" as a string
-			1, // Extension type 1001 (minus 1000)
-		})
-	} else if pk.Encrypted {
+	if pk.Encrypted {
+		s2kUsage := byte(254) // SHA-1 Convention
+		if pk.aead != 0 {
+			s2kUsage = 253
+		} else if !pk.sha1Checksum {
+			s2kUsage = 255
+		}
 		_, err = buf.Write([]byte{
-			254,             // SHA-1 Convention
+			s2kUsage,
 			byte(pk.cipher), // Encryption scheme
 		})
 		if err != nil {
 			return err
 		}
+		if pk.aead != 0 {
+			if err = buf.WriteByte(byte(pk.aead)); err != nil {
+				return err
+			}
+		}
 		if _, err = buf.Write(pk.s2kHeader); err != nil {
 			return err
 		}
@@ -267,6 +340,15 @@ func (pk *PrivateKey) Serialize(w io.Writer) (err error) {
 		if _, err = privateKeyBuf.Write(pk.encryptedData); err != nil {
 			return err
 		}
+	} else if pk.PrivateKey == nil {
+		_, err = buf.Write([]byte{
+			254,           // SHA-1 Convention
+			9,             // Encryption scheme (AES256)
+			101,           // GNU Extensions
+			2,             // Hash value (SHA1)
+			'G', 'N', 'U', // "GNU" as a string
+			1, // Extension type 1001 (minus 1000)
+		})
 	} else {
 		buf.WriteByte(0 /* no encryption */)
 		if err = pk.serializePrivateKey(privateKeyBuf); err != nil {
@@ -362,7 +444,9 @@ func serializeECDHPrivateKey(w io.Writer, priv *ecdh.PrivateKey) error {
 }
 
 func serializeEdDSAPrivateKey(w io.Writer, priv *EdDSAPrivateKey) error {
-	return writeMPI(w, priv.seed.bitLength, priv.seed.bytes)
+	// Written as a proper MPI, without leading zeros; parsing pads the
+	// seed back to its full length.
+	return writeBig(w, new(big.Int).SetBytes(priv.seed.bytes))
 }
 
 // Decrypt decrypts an encrypted private key using a passphrase.
@@ -377,6 +461,17 @@ func (pk *PrivateKey) Decrypt(passphrase []byte) error {
 
 	key := make([]byte, pk.cipher.KeySize())
 	pk.s2k(key, passphrase)
+	if pk.aead != 0 {
+		aead, ad, err := pk.aeadCrypter(key)
+		if err != nil {
+			return err
+		}
+		data, err := aead.Open(nil, pk.iv, pk.encryptedData, ad)
+		if err != nil {
+			return errors.StructuralError("private key checksum failure")
+		}
+		return pk.parsePrivateKey(data)
+	}
 	block := pk.cipher.new(key)
 	cfb := cipher.NewCFBDecrypter(block, pk.iv)
 
@@ -412,6 +507,26 @@ func (pk *PrivateKey) Decrypt(passphrase []byte) error {
 	return pk.parsePrivateKey(data)
 }
 
+// aeadCrypter returns the AEAD that protects the secret key material of a
+// key with S2K usage 253, given the key derived from the passphrase, and
+// the associated data to use with it. See RFC 9580, section 5.5.3.
+func (pk *PrivateKey) aeadCrypter(key []byte) (cipher.AEAD, []byte, error) {
+	tag := byte(0xc0 | packetTypePrivateKey)
+	if pk.IsSubkey {
+		tag = byte(0xc0 | packetTypePrivateSubkey)
+	}
+	info := []byte{tag, byte(pk.PublicKey.Version), byte(pk.cipher), byte(pk.aead)}
+	kek := make([]byte, pk.cipher.KeySize())
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, info), kek); err != nil {
+		return nil, nil, err
+	}
+	ad := bytes.NewBuffer([]byte{tag})
+	if err := pk.PublicKey.serializeWithoutHeaders(ad); err != nil {
+		return nil, nil, err
+	}
+	return pk.aead.new(pk.cipher.new(kek)), ad.Bytes(), nil
+}
+
 func (pk *PrivateKey) parsePrivateKey(data []byte) (err error) {
 	switch pk.PublicKey.PubKeyAlgo {
 	case PubKeyAlgoRSA, PubKeyAlgoRSASignOnly, PubKeyAlgoRSAEncryptOnly:
@@ -552,8 +667,18 @@ func (pk *PrivateKey) parseEdDSAPrivateKey(data []byte) (err error) {
 		return err
 	}
 
-	if bLen := len(eddsaPriv.seed.bytes); bLen != 32 { // 32 bytes private part of ed25519 key.
+	if bLen := len(eddsaPriv.seed.bytes); bLen > 32 { // 32 bytes private part of ed25519 key.
 		return errors.UnsupportedError(fmt.Sprintf("Unexpected EdDSA private key length: %d", bLen))
+	} else if bLen < 32 {
+		// Leading zeros were stripped from the MPI. Only accept the
+		// padded seed if it really belongs to the public key.
+		seed := make([]byte, 32)
+		copy(seed[32-bLen:], eddsaPriv.seed.bytes)
+		pub := ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)
+		if !bytes.Equal(pub, pk.PublicKey.edk.p.bytes[1:]) {
+			return errors.UnsupportedError(fmt.Sprintf("Unexpected EdDSA private key length: %d", bLen))
+		}
+		eddsaPriv.seed.bytes = seed
 	}
 
 	pk.PrivateKey = eddsaPriv
@@ -6,8 +6,12 @@ package packet
 
 import (
 	"bytes"
+	"crypto"
 	"testing"
 	"time"
+
+	"github.com/keybase/go-crypto/openpgp/errors"
+	"github.com/keybase/go-crypto/openpgp/s2k"
 )
 
 var privateKeyTests = []struct {
@@ -89,6 +93,9 @@ func TestPrivateKeyEncrypt(t *testing.T) {
 			t.Errorf("#%d: failed to encrypt: %s", i, err)
 			continue
 		}
+		if err = privKey.Encrypt(oldPassphrase, nil); err == nil {
+			t.Errorf("#%d: encrypted an encrypted key", i)
+		}
 		privKeyBuf := bytes.NewBuffer(nil)
 		if err = privKey.Serialize(privKeyBuf); err != nil {
 			t.Errorf("#%d: failed to serialize: %s", i, err)
@@ -172,6 +179,107 @@ func TestPrivateKeyEncryptThenDecrypt(t *testing.T) {
 	}
 }
 
+func TestPrivateKeyEncryptArgon2(t *testing.T) {
+	packet, err := Read(readerFromHex(privKeyRSAHex))
+	if err != nil {
+		t.Fatalf("failed to parse: %s", err)
+	}
+	privKey := packet.(*PrivateKey)
+	if err = privKey.Decrypt(oldPassphrase); err != nil {
+		t.Fatalf("failed to decrypt: %s", err)
+	}
+
+	config := &Config{S2KArgon2: &s2k.Argon2Config{Passes: 1, Parallelism: 1, MemoryExponent: 8}}
+	if err = privKey.Encrypt(newPassphrase, config); err != nil {
+		t.Fatalf("failed to encrypt: %s", err)
+	}
+	if privKey.s2kHeader[0] != 4 {
+		t.Errorf("S2K type is %d, want 4 (Argon2)", privKey.s2kHeader[0])
+	}
+	privKeyBuf := bytes.NewBuffer(nil)
+	if err = privKey.Serialize(privKeyBuf); err != nil {
+		t.Fatalf("failed to serialize: %s", err)
+	}
+	serialized := append([]byte{}, privKeyBuf.Bytes()...)
+
+	packet2, err := Read(privKeyBuf)
+	if err != nil {
+		t.Fatalf("failed to parse: %s", err)
+	}
+	pKey2 := packet2.(*PrivateKey)
+	if pKey2.aead != AEADModeOCB {
+		t.Errorf("AEAD mode is %d, want OCB", pKey2.aead)
+	}
+	if err = pKey2.Decrypt(oldPassphrase); err == nil {
+		t.Error("decrypted with the old passphrase")
+	}
+	if err = pKey2.Decrypt(newPassphrase); err != nil || pKey2.Encrypted {
+		t.Errorf("failed to decrypt with new passphrase: %s", err)
+	}
+
+	// The public key is authenticated along with the secret key material.
+	serialized[len(serialized)-len(pKey2.encryptedData)-100] ^= 1
+	packet3, err := Read(bytes.NewReader(serialized))
+	if err != nil {
+		t.Fatalf("failed to parse: %s", err)
+	}
+	if err = packet3.(*PrivateKey).Decrypt(newPassphrase); err == nil {
+		t.Error("decrypted a key whose public part was modified")
+	}
+
+	// A key with an Argon2 specifier but without AEAD protection must be
+	// rejected when read.
+	if err = privKey.Decrypt(newPassphrase); err != nil {
+		t.Fatalf("failed to decrypt: %s", err)
+	}
+	if err = privKey.Encrypt(newPassphrase, nil); err != nil {
+		t.Fatalf("failed to encrypt: %s", err)
+	}
+	argon2Header := []byte{4}
+	argon2Header = append(argon2Header, make([]byte, 16)...)
+	privKey.s2kHeader = append(argon2Header, 1, 1, 8)
+	privKeyBuf.Reset()
+	if err = privKey.Serialize(privKeyBuf); err != nil {
+		t.Fatalf("failed to serialize: %s", err)
+	}
+	_, err = Read(privKeyBuf)
+	if _, ok := err.(errors.StructuralError); !ok {
+		t.Errorf("got %v, want StructuralError", err)
+	}
+}
+
+func TestPrivateKeyEncryptS2KCount(t *testing.T) {
+	packet, err := Read(readerFromHex(privKeyRSAHex))
+	if err != nil {
+		t.Fatalf("failed to parse: %s", err)
+	}
+	privKey := packet.(*PrivateKey)
+	if err = privKey.Decrypt(oldPassphrase); err != nil {
+		t.Fatalf("failed to decrypt: %s", err)
+	}
+
+	config := &Config{S2KCount: 65011712, S2KHash: crypto.SHA512}
+	if err = privKey.Encrypt(newPassphrase, config); err != nil {
+		t.Fatalf("failed to encrypt: %s", err)
+	}
+	if h := privKey.s2kHeader; h[0] != 3 || h[1] != 10 || h[10] != 255 {
+		t.Errorf("S2K specifier is %x, want type 3, SHA-512 and the highest count", h)
+	}
+	privKeyBuf := bytes.NewBuffer(nil)
+	if err = privKey.Serialize(privKeyBuf); err != nil {
+		t.Fatalf("failed to serialize: %s", err)
+	}
+
+	packet2, err := Read(privKeyBuf)
+	if err != nil {
+		t.Fatalf("failed to parse: %s", err)
+	}
+	pKey2 := packet2.(*PrivateKey)
+	if err = pKey2.Decrypt(newPassphrase); err != nil || pKey2.Encrypted {
+		t.Errorf("failed to decrypt with new passphrase: %s", err)
+	}
+}
+
 func TestIssue11505(t *testing.T) {
 	// parsing a rsa private key with p or q == 1 used to panic due to a divide by zero
 	_, _ = Read(readerFromHex("9c3004303030300100000011303030000000000000010130303030303030303030303030303030303030303030303030303030303030303030303030303030303030"))
@@ -10,15 +10,20 @@ import (
 	"crypto/dsa"
 	"crypto/ecdsa"
 	"crypto/elliptic"
+	gorsa "crypto/rsa"
 	"crypto/sha1"
-	_ "crypto/sha256"
+	"crypto/sha256"
 	_ "crypto/sha512"
+	"crypto/subtle"
 	"encoding/binary"
+	"encoding/hex"
 	"fmt"
 	"hash"
 	"io"
 	"math/big"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/keybase/go-crypto/brainpool"
@@ -29,6 +34,7 @@ import (
 	"github.com/keybase/go-crypto/openpgp/errors"
 	"github.com/keybase/go-crypto/openpgp/s2k"
 	"github.com/keybase/go-crypto/rsa"
+	_ "github.com/keybase/go-crypto/sha3"
 )
 
 var (
@@ -65,6 +71,11 @@ type ecdsaKey struct {
 
 type edDSAkey struct {
 	ecdsaKey
+
+	// verifier holds the decoded public key, which is made on first use
+	// and then reused by all signature verifications.
+	verifierOnce sync.Once
+	verifier     *ed25519.Verifier
 }
 
 func copyFrontFill(dst, src []byte, length int) int {
@@ -88,11 +99,21 @@ func (e *edDSAkey) Verify(payload []byte, r parsedMPI, s parsedMPI) bool {
 	// Note: it may happen that R + S do not form 64-byte signature buffer that
 	// ed25519 expects, but because we copy it over to an array of exact size,
 	// we will always pass correctly sized slice to Verify. Slice too short
-	// would make ed25519 panic().
-	copyFrontFill(sig[:halfSigSize], r.bytes, halfSigSize)
-	copyFrontFill(sig[halfSigSize:], s.bytes, halfSigSize)
-
-	return ed25519.Verify(key, payload, sig[:])
+	// would make ed25519 panic(). GnuPG strips leading zeros from R and S,
+	// so shorter values are padded; longer ones (once any leading zeros
+	// are dropped) cannot be valid.
+	rBytes := bytes.TrimLeft(r.bytes, "\x00")
+	sBytes := bytes.TrimLeft(s.bytes, "\x00")
+	if len(rBytes) > halfSigSize || len(sBytes) > halfSigSize {
+		return false
+	}
+	copyFrontFill(sig[:halfSigSize], rBytes, halfSigSize)
+	copyFrontFill(sig[halfSigSize:], sBytes, halfSigSize)
+
+	e.verifierOnce.Do(func() {
+		e.verifier = ed25519.NewVerifier(key)
+	})
+	return e.verifier.Verify(payload, sig[:])
 }
 
 // parseOID reads the OID for the curve as defined in RFC 6637, Section 9.
@@ -233,12 +254,20 @@ func (f *ecdhKdf) byteLen() int {
 
 // PublicKey represents an OpenPGP public key. See RFC 4880, section 5.5.2.
 type PublicKey struct {
+	// Version is 4, 5 or 6. Keys made by this package are version 4.
+	Version      int
 	CreationTime time.Time
 	PubKeyAlgo   PublicKeyAlgorithm
 	PublicKey    interface{} // *rsa.PublicKey, *dsa.PublicKey or *ecdsa.PublicKey
-	Fingerprint  [20]byte
-	KeyId        uint64
-	IsSubkey     bool
+	// Fingerprint is the fingerprint of a version 4 key. It is zero for
+	// version 5 and 6 keys, whose longer fingerprint is returned by
+	// FullFingerprint.
+	Fingerprint [20]byte
+	KeyId       uint64
+	IsSubkey    bool
+
+	// sha256Fingerprint is the fingerprint of a version 5 or 6 key.
+	sha256Fingerprint []byte
 
 	n, e, p, q, g, y parsedMPI
 
@@ -274,6 +303,7 @@ func FromBytes(bytes []byte) parsedMPI {
 // NewRSAPublicKey returns a PublicKey that wraps the given rsa.PublicKey.
 func NewRSAPublicKey(creationTime time.Time, pub *rsa.PublicKey) *PublicKey {
 	pk := &PublicKey{
+		Version:      4,
 		CreationTime: creationTime,
 		PubKeyAlgo:   PubKeyAlgoRSA,
 		PublicKey:    pub,
@@ -288,6 +318,7 @@ func NewRSAPublicKey(creationTime time.Time, pub *rsa.PublicKey) *PublicKey {
 // NewDSAPublicKey returns a PublicKey that wraps the given dsa.PublicKey.
 func NewDSAPublicKey(creationTime time.Time, pub *dsa.PublicKey) *PublicKey {
 	pk := &PublicKey{
+		Version:      4,
 		CreationTime: creationTime,
 		PubKeyAlgo:   PubKeyAlgoDSA,
 		PublicKey:    pub,
@@ -301,6 +332,25 @@ func NewDSAPublicKey(creationTime time.Time, pub *dsa.PublicKey) *PublicKey {
 	return pk
 }
 
+// NewEdDSAPublicKey returns a PublicKey that wraps the given Ed25519
+// public key.
+func NewEdDSAPublicKey(creationTime time.Time, pub ed25519.PublicKey) *PublicKey {
+	pk := &PublicKey{
+		Version:      4,
+		CreationTime: creationTime,
+		PubKeyAlgo:   PubKeyAlgoEdDSA,
+		PublicKey:    pub,
+		edk:          new(edDSAkey),
+	}
+	pk.edk.oid = oidEdDSA
+	// The point is stored in native format behind a 0x40 prefix octet.
+	pk.edk.p.bytes = append([]byte{0x40}, pub...)
+	pk.edk.p.bitLength = uint16(7 + 8*len(pub))
+
+	pk.setFingerPrintAndKeyId()
+	return pk
+}
+
 // check EdDSA public key material.
 // There is currently no RFC for it, but it doesn't mean it's not
 // implemented or in use.
@@ -311,12 +361,16 @@ func (e *edDSAkey) check() error {
 	if bLen := len(e.p.bytes); bLen != 33 { // 32 bytes for ed25519 key and 1 byte for 0x40 header
 		return errors.UnsupportedError(fmt.Sprintf("Unexpected EdDSA public key length: %d", bLen))
 	}
+	if e.p.bytes[0] != 0x40 {
+		return errors.UnsupportedError(fmt.Sprintf("Unexpected EdDSA public key prefix: 0x%x", e.p.bytes[0]))
+	}
 	return nil
 }
 
 // NewElGamalPublicKey returns a PublicKey that wraps the given elgamal.PublicKey.
 func NewElGamalPublicKey(creationTime time.Time, pub *elgamal.PublicKey) *PublicKey {
 	pk := &PublicKey{
+		Version:      4,
 		CreationTime: creationTime,
 		PubKeyAlgo:   PubKeyAlgoElGamal,
 		PublicKey:    pub,
@@ -355,6 +409,7 @@ func getCurveOid(curve elliptic.Curve) (res []byte, err error) {
 
 func NewECDSAPublicKey(creationTime time.Time, pub *ecdsa.PublicKey) *PublicKey {
 	pk := &PublicKey{
+		Version:      4,
 		CreationTime: creationTime,
 		PubKeyAlgo:   PubKeyAlgoECDSA,
 		PublicKey:    pub,
@@ -372,6 +427,7 @@ func NewECDSAPublicKey(creationTime time.Time, pub *ecdsa.PublicKey) *PublicKey
 
 func NewECDHPublicKey(creationTime time.Time, pub *ecdh.PublicKey) *PublicKey {
 	pk := &PublicKey{
+		Version:      4,
 		CreationTime: creationTime,
 		PubKeyAlgo:   PubKeyAlgoECDH,
 		PublicKey:    pub,
@@ -393,6 +449,36 @@ func NewECDHPublicKey(creationTime time.Time, pub *ecdh.PublicKey) *PublicKey {
 	return pk
 }
 
+// FingerprintFromPublicKey returns the fingerprint and key ID that the given
+// public key has as a version 4 OpenPGP key created at creationTime, which
+// is part of the fingerprint. pub may be an *rsa.PublicKey from this module
+// or from crypto/rsa, a *dsa.PublicKey, an *ecdsa.PublicKey, an
+// ed25519.PublicKey or an *elgamal.PublicKey. ECDH keys are not supported,
+// since their fingerprint also covers KDF parameters that pub doesn't hold.
+func FingerprintFromPublicKey(creationTime time.Time, pub crypto.PublicKey) (fingerprint []byte, keyId uint64, err error) {
+	var pk *PublicKey
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		pk = NewRSAPublicKey(creationTime, pub)
+	case *gorsa.PublicKey:
+		pk = NewRSAPublicKey(creationTime, &rsa.PublicKey{N: pub.N, E: int64(pub.E)})
+	case *dsa.PublicKey:
+		pk = NewDSAPublicKey(creationTime, pub)
+	case *ecdsa.PublicKey:
+		if _, err := getCurveOid(pub.Curve); err != nil {
+			return nil, 0, err
+		}
+		pk = NewECDSAPublicKey(creationTime, pub)
+	case ed25519.PublicKey:
+		pk = NewEdDSAPublicKey(creationTime, pub)
+	case *elgamal.PublicKey:
+		pk = NewElGamalPublicKey(creationTime, pub)
+	default:
+		return nil, 0, errors.UnsupportedError(fmt.Sprintf("public key type %T", pub))
+	}
+	return pk.Fingerprint[:], pk.KeyId, nil
+}
+
 func (pk *PublicKey) parse(r io.Reader) (err error) {
 	// RFC 4880, section 5.5.2
 	var buf [6]byte
@@ -400,11 +486,32 @@ func (pk *PublicKey) parse(r io.Reader) (err error) {
 	if err != nil {
 		return
 	}
-	if buf[0] != 4 {
-		return errors.UnsupportedError("public key version")
+	if buf[0] != 4 && buf[0] != 5 && buf[0] != 6 {
+		return errors.UnsupportedError("public key version " + strconv.Itoa(int(buf[0])))
 	}
+	pk.Version = int(buf[0])
 	pk.CreationTime = time.Unix(int64(uint32(buf[1])<<24|uint32(buf[2])<<16|uint32(buf[3])<<8|uint32(buf[4])), 0)
 	pk.PubKeyAlgo = PublicKeyAlgorithm(buf[5])
+
+	// Version 5 and 6 keys give the length of the key material, which
+	// must then match what the algorithm specific parsing consumes.
+	var material *bytes.Reader
+	if pk.Version >= 5 {
+		if _, err = readFull(r, buf[:4]); err != nil {
+			return
+		}
+		n := binary.BigEndian.Uint32(buf[:4])
+		if n > maxKeyMaterialLength {
+			return errors.StructuralError("public key material too large")
+		}
+		data := make([]byte, n)
+		if _, err = readFull(r, data); err != nil {
+			return
+		}
+		material = bytes.NewReader(data)
+		r = material
+	}
+
 	switch pk.PubKeyAlgo {
 	case PubKeyAlgoRSA, PubKeyAlgoRSAEncryptOnly, PubKeyAlgoRSASignOnly:
 		err = pk.parseRSA(r)
@@ -445,26 +552,62 @@ func (pk *PublicKey) parse(r io.Reader) (err error) {
 		if err != nil {
 			pk.PublicKey = nil
 		}
+	case PubKeyAlgoEd25519:
+		// RFC 9580, section 5.5.5.9: the raw key, without an MPI
+		// header or curve OID.
+		key := make([]byte, ed25519.PublicKeySize)
+		if _, err = readFull(r, key); err == nil {
+			pk.PublicKey = ed25519.PublicKey(key)
+		}
 	default:
 		err = errors.UnsupportedError("public key type: " + strconv.Itoa(int(pk.PubKeyAlgo)))
 	}
 	if err != nil {
 		return
 	}
+	if material != nil && material.Len() != 0 {
+		return errors.StructuralError("public key material length mismatch")
+	}
 
 	pk.setFingerPrintAndKeyId()
 	return
 }
 
+// maxKeyMaterialLength bounds the key material length of version 5 and 6
+// keys, so that a corrupt length cannot make us allocate gigabytes.
+const maxKeyMaterialLength = 1 << 16
+
 func (pk *PublicKey) setFingerPrintAndKeyId() {
 	// RFC 4880, section 12.2
+	if pk.Version >= 5 {
+		// Version 5 and 6 keys use the whole SHA-256 fingerprint and
+		// take the key ID from its start.
+		fingerPrint := sha256.New()
+		pk.SerializeSignaturePrefix(fingerPrint)
+		pk.serializeWithoutHeaders(fingerPrint)
+		pk.Fingerprint = [20]byte{}
+		pk.sha256Fingerprint = fingerPrint.Sum(nil)
+		pk.KeyId = binary.BigEndian.Uint64(pk.sha256Fingerprint[:8])
+		return
+	}
 	fingerPrint := sha1.New()
 	pk.SerializeSignaturePrefix(fingerPrint)
 	pk.serializeWithoutHeaders(fingerPrint)
 	copy(pk.Fingerprint[:], fingerPrint.Sum(nil))
+	pk.sha256Fingerprint = nil
 	pk.KeyId = binary.BigEndian.Uint64(pk.Fingerprint[12:20])
 }
 
+// FullFingerprint returns the fingerprint of the key, whatever its version:
+// the 20 bytes of Fingerprint for version 4 keys and the 32 byte SHA-256
+// fingerprint for version 5 and 6 keys.
+func (pk *PublicKey) FullFingerprint() []byte {
+	if pk.Version >= 5 {
+		return append([]byte(nil), pk.sha256Fingerprint...)
+	}
+	return append([]byte(nil), pk.Fingerprint[:]...)
+}
+
 // parseRSA parses RSA public key material from the given Reader. See RFC 4880,
 // section 5.5.2.
 func (pk *PublicKey) parseRSA(r io.Reader) (err error) {
@@ -552,38 +695,21 @@ func (pk *PublicKey) parseElGamal(r io.Reader) (err error) {
 // The prefix is used when calculating a signature over this public key. See
 // RFC 4880, section 5.2.4.
 func (pk *PublicKey) SerializeSignaturePrefix(h io.Writer) {
-	var pLength uint16
-	switch pk.PubKeyAlgo {
-	case PubKeyAlgoRSA, PubKeyAlgoRSAEncryptOnly, PubKeyAlgoRSASignOnly:
-		pLength += 2 + uint16(len(pk.n.bytes))
-		pLength += 2 + uint16(len(pk.e.bytes))
-	case PubKeyAlgoDSA:
-		pLength += 2 + uint16(len(pk.p.bytes))
-		pLength += 2 + uint16(len(pk.q.bytes))
-		pLength += 2 + uint16(len(pk.g.bytes))
-		pLength += 2 + uint16(len(pk.y.bytes))
-	case PubKeyAlgoElGamal, PubKeyAlgoBadElGamal:
-		pLength += 2 + uint16(len(pk.p.bytes))
-		pLength += 2 + uint16(len(pk.g.bytes))
-		pLength += 2 + uint16(len(pk.y.bytes))
-	case PubKeyAlgoECDSA:
-		pLength += uint16(pk.ec.byteLen())
-	case PubKeyAlgoECDH:
-		pLength += uint16(pk.ec.byteLen())
-		pLength += uint16(pk.ecdh.byteLen())
-	case PubKeyAlgoEdDSA:
-		pLength += uint16(pk.edk.byteLen())
+	pLength := pk.bodyLength()
+	switch pk.Version {
+	case 5, 6:
+		// Version 5 keys are prefixed with 0x9A, version 6 keys with
+		// 0x9B, and both with a four octet length.
+		h.Write([]byte{0x95 + byte(pk.Version), byte(pLength >> 24), byte(pLength >> 16), byte(pLength >> 8), byte(pLength)})
 	default:
-		panic("unknown public key algorithm")
+		h.Write([]byte{0x99, byte(pLength >> 8), byte(pLength)})
 	}
-	pLength += 6
-	h.Write([]byte{0x99, byte(pLength >> 8), byte(pLength)})
 	return
 }
 
-func (pk *PublicKey) Serialize(w io.Writer) (err error) {
-	length := 6 // 6 byte header
-
+// keyMaterialLength returns the length of the algorithm specific part of
+// the serialized key.
+func (pk *PublicKey) keyMaterialLength() (length int) {
 	switch pk.PubKeyAlgo {
 	case PubKeyAlgoRSA, PubKeyAlgoRSAEncryptOnly, PubKeyAlgoRSASignOnly:
 		length += 2 + len(pk.n.bytes)
@@ -604,15 +730,29 @@ func (pk *PublicKey) Serialize(w io.Writer) (err error) {
 		length += pk.ecdh.byteLen()
 	case PubKeyAlgoEdDSA:
 		length += pk.edk.byteLen()
+	case PubKeyAlgoEd25519:
+		length += ed25519.PublicKeySize
 	default:
 		panic("unknown public key algorithm")
 	}
+	return
+}
+
+// bodyLength returns the length of the key packet without its header.
+func (pk *PublicKey) bodyLength() int {
+	if pk.Version >= 5 {
+		// Version, creation time, algorithm and material length.
+		return 10 + pk.keyMaterialLength()
+	}
+	return 6 + pk.keyMaterialLength()
+}
 
+func (pk *PublicKey) Serialize(w io.Writer) (err error) {
 	packetType := packetTypePublicKey
 	if pk.IsSubkey {
 		packetType = packetTypePublicSubkey
 	}
-	err = serializeHeader(w, packetType, length)
+	err = serializeHeader(w, packetType, pk.bodyLength())
 	if err != nil {
 		return
 	}
@@ -622,16 +762,23 @@ func (pk *PublicKey) Serialize(w io.Writer) (err error) {
 // serializeWithoutHeaders marshals the PublicKey to w in the form of an
 // OpenPGP public key packet, not including the packet header.
 func (pk *PublicKey) serializeWithoutHeaders(w io.Writer) (err error) {
-	var buf [6]byte
+	buf := make([]byte, 6, 10)
 	buf[0] = 4
+	if pk.Version >= 5 {
+		buf[0] = byte(pk.Version)
+	}
 	t := uint32(pk.CreationTime.Unix())
 	buf[1] = byte(t >> 24)
 	buf[2] = byte(t >> 16)
 	buf[3] = byte(t >> 8)
 	buf[4] = byte(t)
 	buf[5] = byte(pk.PubKeyAlgo)
+	if pk.Version >= 5 {
+		n := uint32(pk.keyMaterialLength())
+		buf = append(buf, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
 
-	_, err = w.Write(buf[:])
+	_, err = w.Write(buf)
 	if err != nil {
 		return
 	}
@@ -647,6 +794,9 @@ func (pk *PublicKey) serializeWithoutHeaders(w io.Writer) (err error) {
 		return pk.ec.serialize(w)
 	case PubKeyAlgoEdDSA:
 		return pk.edk.serialize(w)
+	case PubKeyAlgoEd25519:
+		_, err = w.Write(pk.PublicKey.(ed25519.PublicKey))
+		return
 	case PubKeyAlgoECDH:
 		if err = pk.ec.serialize(w); err != nil {
 			return
@@ -667,9 +817,11 @@ func (pk *PublicKey) VerifySignature(signed hash.Hash, sig *Signature) (err erro
 	if !pk.CanSign() {
 		return errors.InvalidArgumentError("public key cannot generate signatures")
 	}
+	if sig.StubbedOutCriticalError != nil {
+		return sig.StubbedOutCriticalError
+	}
 
-	signed.Write(sig.HashSuffix)
-	hashBytes := signed.Sum(nil)
+	hashBytes := sig.Digest(signed)
 
 	// NOTE(maxtaco) 2016-08-22
 	//
@@ -687,6 +839,9 @@ func (pk *PublicKey) VerifySignature(signed hash.Hash, sig *Signature) (err erro
 	if pk.PubKeyAlgo != sig.PubKeyAlgo {
 		return errors.InvalidArgumentError("public key and signature use different algorithms")
 	}
+	if sig.Version == 6 && pk.Version != 6 {
+		return errors.SignatureError("version 6 signature made by a version " + strconv.Itoa(pk.Version) + " key")
+	}
 
 	switch pk.PubKeyAlgo {
 	case PubKeyAlgoRSA, PubKeyAlgoRSASignOnly:
@@ -718,6 +873,12 @@ func (pk *PublicKey) VerifySignature(signed hash.Hash, sig *Signature) (err erro
 			return errors.SignatureError("EdDSA verification failure")
 		}
 		return nil
+	case PubKeyAlgoEd25519:
+		signature := append(append([]byte{}, sig.EdDSASigR.bytes...), sig.EdDSASigS.bytes...)
+		if !ed25519.Verify(pk.PublicKey.(ed25519.PublicKey), hashBytes, signature) {
+			return errors.SignatureError("Ed25519 verification failure")
+		}
+		return nil
 	default:
 		return errors.SignatureError("Unsupported public key algorithm used in signature")
 	}
@@ -770,12 +931,14 @@ func (pk *PublicKey) VerifySignatureV3(signed hash.Hash, sig *SignatureV3) (err
 }
 
 // keySignatureHash returns a Hash of the message that needs to be signed for
-// pk to assert a subkey relationship to signed.
-func keySignatureHash(pk, signed signingKey, hashFunc crypto.Hash) (h hash.Hash, err error) {
+// pk to assert a subkey relationship to signed. salt is nil except for
+// version 6 signatures.
+func keySignatureHash(pk, signed signingKey, hashFunc crypto.Hash, salt []byte) (h hash.Hash, err error) {
 	if !hashFunc.Available() {
 		return nil, errors.UnsupportedError("hash function")
 	}
 	h = hashFunc.New()
+	h.Write(salt)
 
 	updateKeySignatureHash(pk, signed, h)
 
@@ -794,7 +957,7 @@ func updateKeySignatureHash(pk, signed signingKey, h hash.Hash) {
 // VerifyKeySignature returns nil iff sig is a valid signature, made by this
 // public key, of signed.
 func (pk *PublicKey) VerifyKeySignature(signed *PublicKey, sig *Signature) error {
-	h, err := keySignatureHash(pk, signed, sig.Hash)
+	h, err := sig.PrepareVerifyKey(pk, signed)
 	if err != nil {
 		return err
 	}
@@ -819,27 +982,28 @@ func (pk *PublicKey) VerifyKeySignature(signed *PublicKey, sig *Signature) error
 		// Signing subkeys must be cross-signed. See
 		// https://www.gnupg.org/faq/subkey-cross-certify.html.
 		if sig.EmbeddedSignature == nil {
-			return errors.StructuralError("signing subkey is missing cross-signature")
+			return errors.ErrMissingCrossSignature
 		}
 		// Verify the cross-signature. This is calculated over the same
 		// data as the main signature, so we cannot just recursively
 		// call signed.VerifyKeySignature(...)
-		if h, err = keySignatureHash(pk, signed, sig.EmbeddedSignature.Hash); err != nil {
-			return errors.StructuralError("error while hashing for cross-signature: " + err.Error())
+		if h, err = sig.EmbeddedSignature.PrepareVerifyKey(pk, signed); err != nil {
+			return errors.ErrBadCrossSignature.WithDetail("", err)
 		}
 		if err := signed.VerifySignature(h, sig.EmbeddedSignature); err != nil {
-			return errors.StructuralError("error while verifying cross-signature: " + err.Error())
+			return errors.ErrBadCrossSignature.WithDetail("", err)
 		}
 	}
 
 	return nil
 }
 
-func keyRevocationHash(pk signingKey, hashFunc crypto.Hash) (h hash.Hash, err error) {
+func keyRevocationHash(pk signingKey, hashFunc crypto.Hash, salt []byte) (h hash.Hash, err error) {
 	if !hashFunc.Available() {
 		return nil, errors.UnsupportedError("hash function")
 	}
 	h = hashFunc.New()
+	h.Write(salt)
 
 	// RFC 4880, section 5.2.4
 	pk.SerializeSignaturePrefix(h)
@@ -851,7 +1015,7 @@ func keyRevocationHash(pk signingKey, hashFunc crypto.Hash) (h hash.Hash, err er
 // VerifyRevocationSignature returns nil iff sig is a valid signature, made by this
 // public key.
 func (pk *PublicKey) VerifyRevocationSignature(revokedKey *PublicKey, sig *Signature) (err error) {
-	h, err := keyRevocationHash(revokedKey, sig.Hash)
+	h, err := sig.PrepareVerifyRevocation(revokedKey)
 	if err != nil {
 		return err
 	}
@@ -873,11 +1037,12 @@ func (t teeHash) BlockSize() int      { return t.h.BlockSize() }
 
 // userIdSignatureHash returns a Hash of the message that needs to be signed
 // to assert that pk is a valid key for id.
-func userIdSignatureHash(id string, pk *PublicKey, hashFunc crypto.Hash) (h hash.Hash, err error) {
+func userIdSignatureHash(id string, pk *PublicKey, hashFunc crypto.Hash, salt []byte) (h hash.Hash, err error) {
 	if !hashFunc.Available() {
 		return nil, errors.UnsupportedError("hash function")
 	}
 	h = hashFunc.New()
+	h.Write(salt)
 
 	updateUserIdSignatureHash(id, pk, h)
 
@@ -906,7 +1071,43 @@ func updateUserIdSignatureHash(id string, pk *PublicKey, h hash.Hash) {
 // VerifyUserIdSignature returns nil iff sig is a valid signature, made by this
 // public key, that id is the identity of pub.
 func (pk *PublicKey) VerifyUserIdSignature(id string, pub *PublicKey, sig *Signature) (err error) {
-	h, err := userIdSignatureHash(id, pub, sig.Hash)
+	h, err := sig.PrepareVerifyUserId(id, pub)
+	if err != nil {
+		return err
+	}
+	return pk.VerifySignature(h, sig)
+}
+
+// userAttributeSignatureHash returns a Hash of the message that needs to be
+// signed to assert that pk is a valid key for the user attribute uat.
+func userAttributeSignatureHash(uat *UserAttribute, pk *PublicKey, hashFunc crypto.Hash, salt []byte) (h hash.Hash, err error) {
+	if !hashFunc.Available() {
+		return nil, errors.UnsupportedError("hash function")
+	}
+	h = hashFunc.New()
+	h.Write(salt)
+
+	// RFC 4880, section 5.2.4
+	pk.SerializeSignaturePrefix(h)
+	pk.serializeWithoutHeaders(h)
+
+	contents := uat.serializeContents()
+	var buf [5]byte
+	buf[0] = 0xd1
+	buf[1] = byte(len(contents) >> 24)
+	buf[2] = byte(len(contents) >> 16)
+	buf[3] = byte(len(contents) >> 8)
+	buf[4] = byte(len(contents))
+	h.Write(buf[:])
+	h.Write(contents)
+
+	return
+}
+
+// VerifyUserAttributeSignature returns nil iff sig is a valid signature, made
+// by this public key, that uat is a user attribute of pub.
+func (pk *PublicKey) VerifyUserAttributeSignature(uat *UserAttribute, pub *PublicKey, sig *Signature) (err error) {
+	h, err := userAttributeSignatureHash(uat, pub, sig.Hash, sig.Salt)
 	if err != nil {
 		return err
 	}
@@ -923,16 +1124,65 @@ func (pk *PublicKey) VerifyUserIdSignatureV3(id string, pub *PublicKey, sig *Sig
 	return pk.VerifySignatureV3(h, sig)
 }
 
-// KeyIdString returns the public key's fingerprint in capital hex
+// KeyIdString returns the public key's key ID in capital hex
 // (e.g. "6C7EE1B8621CC013").
 func (pk *PublicKey) KeyIdString() string {
-	return fmt.Sprintf("%X", pk.Fingerprint[12:20])
+	return fmt.Sprintf("%016X", pk.KeyId)
 }
 
-// KeyIdShortString returns the short form of public key's fingerprint
+// KeyIdShortString returns the short form of public key's key ID
 // in capital hex, as shown by gpg --list-keys (e.g. "621CC013").
 func (pk *PublicKey) KeyIdShortString() string {
-	return fmt.Sprintf("%X", pk.Fingerprint[16:20])
+	return fmt.Sprintf("%08X", uint32(pk.KeyId))
+}
+
+// FingerprintString returns the public key's fingerprint in capital hex,
+// split into groups of four digits as printed by GnuPG's "Key fingerprint ="
+// line (e.g. "5FB7 4B1D 03B1 E3CB 31BC  2F8A A34D 7E18 C20C 31BB"). For
+// version 4 keys the two halves are separated by an extra space.
+func (pk *PublicKey) FingerprintString() string {
+	digits := fmt.Sprintf("%X", pk.FullFingerprint())
+	var b strings.Builder
+	for i := 0; i < len(digits); i += 4 {
+		if i > 0 {
+			b.WriteByte(' ')
+			if pk.Version == 4 && i == len(digits)/2 {
+				b.WriteByte(' ')
+			}
+		}
+		end := i + 4
+		if end > len(digits) {
+			end = len(digits)
+		}
+		b.WriteString(digits[i:end])
+	}
+	return b.String()
+}
+
+// FingerprintV4Hex returns the 20 byte fingerprint of a version 4 key as 40
+// capital hex digits without separators. It returns the empty string for
+// other key versions.
+func (pk *PublicKey) FingerprintV4Hex() string {
+	if pk.Version != 4 {
+		return ""
+	}
+	return fmt.Sprintf("%X", pk.Fingerprint)
+}
+
+// FingerprintMatches reports whether userInput, typically typed or scanned
+// by a user, is the hex encoding of the public key's fingerprint. Whitespace
+// and an optional "0x" prefix are ignored and the hex digits may be in
+// either case. The comparison is done in constant time.
+func (pk *PublicKey) FingerprintMatches(userInput string) bool {
+	s := strings.Join(strings.Fields(userInput), "")
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		s = s[2:]
+	}
+	fp, err := hex.DecodeString(s)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(fp, pk.FullFingerprint()) == 1
 }
 
 // A parsedMPI is used to store the contents of a big integer, along with the
@@ -977,12 +1227,77 @@ func (pk *PublicKey) BitLength() (bitLength uint16, err error) {
 		// the length. Also, we don't have any PublicKey.Curve object
 		// to look the size up from.
 		bitLength = 256
+	case PubKeyAlgoEd25519:
+		bitLength = 256
 	default:
 		err = errors.InvalidArgumentError("bad public-key algorithm")
 	}
 	return
 }
 
+// curveName returns the name GnuPG uses for the curve with the given OID, or
+// the empty string if the curve is not known.
+func curveName(oid []byte) string {
+	switch {
+	case bytes.Equal(oid, oidCurveP224):
+		return "nistp224"
+	case bytes.Equal(oid, oidCurveP256):
+		return "nistp256"
+	case bytes.Equal(oid, oidCurveP384):
+		return "nistp384"
+	case bytes.Equal(oid, oidCurveP521):
+		return "nistp521"
+	case bytes.Equal(oid, oidCurveP256r1):
+		return "brainpoolP256r1"
+	case bytes.Equal(oid, oidCurveP384r1):
+		return "brainpoolP384r1"
+	case bytes.Equal(oid, oidCurveP512r1):
+		return "brainpoolP512r1"
+	case bytes.Equal(oid, oidEdDSA):
+		return "ed25519"
+	case bytes.Equal(oid, oidCurve25519):
+		return "cv25519"
+	default:
+		return ""
+	}
+}
+
+// AlgorithmName returns a short, human-readable description of the key's
+// algorithm in the style of GnuPG, such as "rsa2048", "dsa1024", "elg2048",
+// "nistp256" or "ed25519". Elliptic curve keys are named after their curve,
+// other keys after their algorithm and bit length.
+func (pk *PublicKey) AlgorithmName() string {
+	var prefix string
+	switch pk.PubKeyAlgo {
+	case PubKeyAlgoRSA, PubKeyAlgoRSAEncryptOnly, PubKeyAlgoRSASignOnly:
+		prefix = "rsa"
+	case PubKeyAlgoDSA:
+		prefix = "dsa"
+	case PubKeyAlgoElGamal, PubKeyAlgoBadElGamal:
+		prefix = "elg"
+	case PubKeyAlgoECDSA, PubKeyAlgoECDH:
+		if pk.ec != nil {
+			if name := curveName(pk.ec.oid); name != "" {
+				return name
+			}
+		}
+		return "unknown curve"
+	case PubKeyAlgoEdDSA:
+		if pk.edk != nil {
+			if name := curveName(pk.edk.oid); name != "" {
+				return name
+			}
+		}
+		return "unknown curve"
+	case PubKeyAlgoEd25519:
+		return "ed25519"
+	default:
+		return "unknown algorithm " + strconv.Itoa(int(pk.PubKeyAlgo))
+	}
+	bitLength, _ := pk.BitLength()
+	return prefix + strconv.Itoa(int(bitLength))
+}
+
 func (pk *PublicKey) ErrorIfDeprecated() error {
 	switch pk.PubKeyAlgo {
 	case PubKeyAlgoBadElGamal:
@@ -9,11 +9,15 @@ import (
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
+	"crypto/rand"
+	gorsa "crypto/rsa"
 	"encoding/hex"
 	"math/big"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/keybase/go-crypto/ed25519"
 	"github.com/keybase/go-crypto/rsa"
 )
 
@@ -29,6 +33,8 @@ var pubKeyTests = []struct {
 	{rsaPkDataHex, rsaFingerprintHex, time.Unix(0x4d3c5c10, 0), PubKeyAlgoRSA, 0xa34d7e18c20c31bb, "A34D7E18C20C31BB", "C20C31BB"},
 	{dsaPkDataHex, dsaFingerprintHex, time.Unix(0x4d432f89, 0), PubKeyAlgoDSA, 0x8e8fbe54062f19ed, "8E8FBE54062F19ED", "062F19ED"},
 	{ecdsaPkDataHex, ecdsaFingerprintHex, time.Unix(0x5071c294, 0), PubKeyAlgoECDSA, 0x43fe956c542ca00b, "43FE956C542CA00B", "542CA00B"},
+	{eddsaV5PkDataHex, eddsaV5FingerprintHex, time.Unix(0x5c91f4e4, 0), PubKeyAlgoEdDSA, 0xc62e1b43a65e5367, "C62E1B43A65E5367", "A65E5367"},
+	{eddsaV6PkDataHex, eddsaV6FingerprintHex, time.Unix(0x5c91f4e4, 0), PubKeyAlgoEdDSA, 0xb7f529c68c993057, "B7F529C68C993057", "8C993057"},
 }
 
 func TestPublicKeyRead(t *testing.T) {
@@ -50,8 +56,11 @@ func TestPublicKeyRead(t *testing.T) {
 			t.Errorf("#%d: bad creation time got:%v want:%v", i, pk.CreationTime, test.creationTime)
 		}
 		expectedFingerprint, _ := hex.DecodeString(test.hexFingerprint)
-		if !bytes.Equal(expectedFingerprint, pk.Fingerprint[:]) {
-			t.Errorf("#%d: bad fingerprint got:%x want:%x", i, pk.Fingerprint[:], expectedFingerprint)
+		if !bytes.Equal(expectedFingerprint, pk.FullFingerprint()) {
+			t.Errorf("#%d: bad fingerprint got:%x want:%x", i, pk.FullFingerprint(), expectedFingerprint)
+		}
+		if pk.Version == 4 && !bytes.Equal(expectedFingerprint, pk.Fingerprint[:]) {
+			t.Errorf("#%d: bad version 4 fingerprint got:%x want:%x", i, pk.Fingerprint, expectedFingerprint)
 		}
 		if pk.KeyId != test.keyId {
 			t.Errorf("#%d: bad keyid got:%x want:%x", i, pk.KeyId, test.keyId)
@@ -65,6 +74,100 @@ func TestPublicKeyRead(t *testing.T) {
 	}
 }
 
+func TestFingerprintFromPublicKey(t *testing.T) {
+	for i, test := range pubKeyTests {
+		p, err := Read(readerFromHex(test.hexData))
+		if err != nil {
+			t.Fatalf("#%d: Read error: %s", i, err)
+		}
+		pk := p.(*PublicKey)
+		if pk.Version != 4 {
+			continue
+		}
+		pubs := []crypto.PublicKey{pk.PublicKey}
+		if rsaPub, ok := pk.PublicKey.(*rsa.PublicKey); ok {
+			pubs = append(pubs, &gorsa.PublicKey{N: rsaPub.N, E: int(rsaPub.E)})
+		}
+		for _, pub := range pubs {
+			fp, keyId, err := FingerprintFromPublicKey(test.creationTime, pub)
+			if err != nil {
+				t.Errorf("#%d: %T: %s", i, pub, err)
+				continue
+			}
+			if got := hex.EncodeToString(fp); got != test.hexFingerprint || keyId != test.keyId {
+				t.Errorf("#%d: %T: got %s (%x), want %s (%x)", i, pub, got, keyId, test.hexFingerprint, test.keyId)
+			}
+		}
+	}
+
+	if _, _, err := FingerprintFromPublicKey(time.Now(), "not a key"); err == nil {
+		t.Error("got a fingerprint for an unsupported key type")
+	}
+}
+
+func TestFingerprintMatches(t *testing.T) {
+	packet, err := Read(readerFromHex(rsaPkDataHex))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk := packet.(*PublicKey)
+
+	tests := []struct {
+		input string
+		ok    bool
+	}{
+		{rsaFingerprintHex, true},
+		{"0x" + rsaFingerprintHex, true},
+		{"5FB7 4B1D 03B1 E3CB 31BC  2F8A A34D 7E18 C20C 31BB", true},
+		{" 5fb74b1d03b1e3cb31bc2f8aa34d7e18c20c31bb\n", true},
+		{"5FB74B1D03B1E3CB31BC2F8AA34D7E18C20C31BC", false},
+		{"A34D7E18C20C31BB", false},
+		{"5FB74B1D03B1E3CB31BC2F8AA34D7E18C20C31BBZ", false},
+		{"", false},
+	}
+	for i, test := range tests {
+		if got := pk.FingerprintMatches(test.input); got != test.ok {
+			t.Errorf("#%d: FingerprintMatches(%q) = %v, want %v", i, test.input, got, test.ok)
+		}
+	}
+}
+
+func TestFingerprintString(t *testing.T) {
+	for i, test := range pubKeyTests {
+		packet, err := Read(readerFromHex(test.hexData))
+		if err != nil {
+			t.Fatalf("#%d: Read error: %s", i, err)
+		}
+		pk := packet.(*PublicKey)
+		s := pk.FingerprintString()
+		if got, want := strings.Join(strings.Fields(s), ""), strings.ToUpper(test.hexFingerprint); got != want {
+			t.Errorf("#%d: FingerprintString() = %q, want the digits of %s", i, s, want)
+		}
+		if !pk.FingerprintMatches(s) {
+			t.Errorf("#%d: FingerprintString() = %q does not match the key", i, s)
+		}
+		v4Hex := pk.FingerprintV4Hex()
+		if pk.Version == 4 && v4Hex != strings.ToUpper(test.hexFingerprint) {
+			t.Errorf("#%d: FingerprintV4Hex() = %q", i, v4Hex)
+		} else if pk.Version != 4 && v4Hex != "" {
+			t.Errorf("#%d: FingerprintV4Hex() = %q for a version %d key", i, v4Hex, pk.Version)
+		}
+	}
+
+	packet, _ := Read(readerFromHex(rsaPkDataHex))
+	if got, want := packet.(*PublicKey).FingerprintString(), "5FB7 4B1D 03B1 E3CB 31BC  2F8A A34D 7E18 C20C 31BB"; got != want {
+		t.Errorf("FingerprintString() = %q, want %q", got, want)
+	}
+}
+
+func TestPublicKeyV5MaterialLength(t *testing.T) {
+	// Claim one octet less key material than the packet holds.
+	data := strings.Replace(eddsaV5PkDataHex, "160000002d", "160000002c", 1)
+	if _, err := Read(readerFromHex(data)); err == nil {
+		t.Error("read a v5 key with a bad key material length")
+	}
+}
+
 func TestPublicKeySerialize(t *testing.T) {
 	for i, test := range pubKeyTests {
 		packet, err := Read(readerFromHex(test.hexData))
@@ -97,6 +200,58 @@ func TestPublicKeySerialize(t *testing.T) {
 	}
 }
 
+func TestPublicKeyAlgorithmName(t *testing.T) {
+	tests := []struct {
+		hexData   string
+		name      string
+		bitLength uint16
+	}{
+		{rsaPkDataHex, "rsa1024", 1024},
+		{dsaPkDataHex, "dsa1024", 1024},
+		{ecdsaPkDataHex, "nistp521", 521},
+		{eddsaV5PkDataHex, "ed25519", 256},
+	}
+	for i, test := range tests {
+		p, err := Read(readerFromHex(test.hexData))
+		if err != nil {
+			t.Errorf("#%d: Read error: %s", i, err)
+			continue
+		}
+		pk := p.(*PublicKey)
+		if name := pk.AlgorithmName(); name != test.name {
+			t.Errorf("#%d: bad algorithm name got:%s want:%s", i, name, test.name)
+		}
+		if bitLength, err := pk.BitLength(); err != nil || bitLength != test.bitLength {
+			t.Errorf("#%d: bad bit length got:%d (%v) want:%d", i, bitLength, err, test.bitLength)
+		}
+	}
+
+	// The ECC test key has an ECDSA primary key and an ECDH subkey, both
+	// on P-384.
+	r := readerFromHex(ecc384PubHex)
+	keys := 0
+	for {
+		p, err := Read(r)
+		if err != nil {
+			break
+		}
+		pk, ok := p.(*PublicKey)
+		if !ok {
+			continue
+		}
+		keys++
+		if name := pk.AlgorithmName(); name != "nistp384" {
+			t.Errorf("%d: bad algorithm name got:%s want:nistp384", pk.PubKeyAlgo, name)
+		}
+		if bitLength, err := pk.BitLength(); err != nil || bitLength != 384 {
+			t.Errorf("%d: bad bit length got:%d (%v) want:384", pk.PubKeyAlgo, bitLength, err)
+		}
+	}
+	if keys != 2 {
+		t.Errorf("found %d keys, want 2", keys)
+	}
+}
+
 func TestEcc384Serialize(t *testing.T) {
 	r := readerFromHex(ecc384PubHex)
 	var w bytes.Buffer
@@ -192,6 +347,100 @@ func TestEcc384Serialize(t *testing.T) {
 	}
 }
 
+func TestEdDSASignatureMPILength(t *testing.T) {
+	_, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv := NewEdDSAPrivateKey(time.Now(), edPriv)
+	sig := &Signature{
+		SigType:      SigTypeBinary,
+		PubKeyAlgo:   PubKeyAlgoEdDSA,
+		Hash:         crypto.SHA256,
+		CreationTime: time.Now(),
+	}
+	h := crypto.SHA256.New()
+	h.Write([]byte("message"))
+	if err := sig.Sign(h, priv, nil); err != nil {
+		t.Fatal(err)
+	}
+	verify := func() error {
+		h := crypto.SHA256.New()
+		h.Write([]byte("message"))
+		return priv.PublicKey.VerifySignature(h, sig)
+	}
+	if err := verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := sig.EdDSASigR.bytes
+	sig.EdDSASigR.bytes = append([]byte{0}, r...)
+	if err := verify(); err != nil {
+		t.Errorf("R with a leading zero: %v", err)
+	}
+	sig.EdDSASigR.bytes = append([]byte{1}, r...)
+	if err := verify(); err == nil {
+		t.Error("33 byte R verified")
+	}
+}
+
+// signEdDSA returns a new Ed25519 key and its signature of message.
+func signEdDSA(tb testing.TB, message string) (*PrivateKey, *Signature) {
+	_, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	priv := NewEdDSAPrivateKey(time.Now(), edPriv)
+	sig := &Signature{
+		SigType:      SigTypeBinary,
+		PubKeyAlgo:   PubKeyAlgoEdDSA,
+		Hash:         crypto.SHA256,
+		CreationTime: time.Now(),
+	}
+	h := crypto.SHA256.New()
+	h.Write([]byte(message))
+	if err := sig.Sign(h, priv, nil); err != nil {
+		tb.Fatal(err)
+	}
+	return priv, sig
+}
+
+func TestEdDSAVerifyConcurrently(t *testing.T) {
+	priv, sig := signEdDSA(t, "message")
+	errs := make(chan error)
+	for i := 0; i < 8; i++ {
+		go func() {
+			h := crypto.SHA256.New()
+			h.Write([]byte("message"))
+			errs <- priv.PublicKey.VerifySignature(h, sig)
+		}()
+	}
+	for i := 0; i < 8; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+
+	h := crypto.SHA256.New()
+	h.Write([]byte("other message"))
+	if err := priv.PublicKey.VerifySignature(h, sig); err == nil {
+		t.Error("signature of a different message verified")
+	}
+}
+
+func BenchmarkVerifyEdDSASignature(b *testing.B) {
+	priv, sig := signEdDSA(b, "message")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h := crypto.SHA256.New()
+		h.Write([]byte("message"))
+		if err := priv.PublicKey.VerifySignature(h, sig); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func TestP256KeyID(t *testing.T) {
 	// Confirm that key IDs are correctly calculated for ECC keys.
 	ecdsaPub := &ecdsa.PublicKey{
@@ -253,4 +502,14 @@ const ecdsaFingerprintHex = "9892270b38b8980b05c8d56d43fe956c542ca00b"
 const ecdsaPkDataHex = "9893045071c29413052b8104002304230401f4867769cedfa52c325018896245443968e52e51d0c2df8d939949cb5b330f2921711fbee1c9b9dddb95d15cb0255e99badeddda7cc23d9ddcaacbc290969b9f24019375d61c2e4e3b36953a28d8b2bc95f78c3f1d592fb24499be348656a7b17e3963187b4361afe497bc5f9f81213f04069f8e1fb9e6a6290ae295ca1a92b894396cb4"
 
 // Source: https://sites.google.com/site/brainhub/pgpecckeys#TOC-ECC-NIST-P-384-key
+const eddsaV5FingerprintHex = "c62e1b43a65e536736ddd2c5ec075c428ea2a4311bb6e0eccaccac66cd7f4d37"
+
+const eddsaV5PkDataHex = "c637055c91f4e4160000002d092b06010401da470f010107403f098994bdd916ed4053197934e4a87c80733a1280d62f8010992e43ee3b2406"
+
+// eddsaV6PkDataHex is the key above with its version changed to 6, which
+// only changes the fingerprint prefix.
+const eddsaV6FingerprintHex = "b7f529c68c99305701dde47304cb2e291086181b4c0470289830338ee67226b7"
+
+const eddsaV6PkDataHex = "c637065c91f4e4160000002d092b06010401da470f010107403f098994bdd916ed4053197934e4a87c80733a1280d62f8010992e43ee3b2406"
+
 const ecc384PubHex = `99006f044d53059213052b81040022030304f6b8c5aced5b84ef9f4a209db2e4a9dfb70d28cb8c10ecd57674a9fa5a67389942b62d5e51367df4c7bfd3f8e500feecf07ed265a621a8ebbbe53e947ec78c677eba143bd1533c2b350e1c29f82313e1e1108eba063be1e64b10e6950e799c2db42465635f6473615f64685f333834203c6f70656e70677040627261696e6875622e6f72673e8900cb04101309005305024d530592301480000000002000077072656665727265642d656d61696c2d656e636f64696e67407067702e636f6d7067706d696d65040b090807021901051b03000000021602051e010000000415090a08000a0910098033880f54719fca2b0180aa37350968bd5f115afd8ce7bc7b103822152dbff06d0afcda835329510905b98cb469ba208faab87c7412b799e7b633017f58364ea480e8a1a3f253a0c5f22c446e8be9a9fce6210136ee30811abbd49139de28b5bdf8dc36d06ae748579e9ff503b90073044d53059212052b810400220303042faa84024a20b6735c4897efa5bfb41bf85b7eefeab5ca0cb9ffc8ea04a46acb25534a577694f9e25340a4ab5223a9dd1eda530c8aa2e6718db10d7e672558c7736fe09369ea5739a2a3554bf16d41faa50562f11c6d39bbd5dffb6b9a9ec9180301090989008404181309000c05024d530592051b0c000000000a0910098033880f54719f80970180eee7a6d8fcee41ee4f9289df17f9bcf9d955dca25c583b94336f3a2b2d4986dc5cf417b8d2dc86f741a9e1a6d236c0e3017d1c76575458a0cfb93ae8a2b274fcc65ceecd7a91eec83656ba13219969f06945b48c56bd04152c3a0553c5f2f4bd1267`
@@ -15,6 +15,11 @@ import (
 type Reader struct {
 	q       []Packet
 	readers []io.Reader
+	skipped []*OpaquePacket
+	// If keepSkipped is set, skipped packets are kept while their
+	// contents fit in the remaining skippedRoom bytes.
+	keepSkipped bool
+	skippedRoom int
 }
 
 // New io.Readers are pushed when a compressed or encrypted packet is processed
@@ -26,7 +31,8 @@ type Reader struct {
 const maxReaders = 32
 
 // Next returns the most recently unread Packet, or reads another packet from
-// the top-most io.Reader. Unknown packet types are skipped.
+// the top-most io.Reader. Unknown packet types are skipped, see
+// KeepSkipped.
 func (r *Reader) Next() (p Packet, err error) {
 	if len(r.q) > 0 {
 		p = r.q[len(r.q)-1]
@@ -35,7 +41,11 @@ func (r *Reader) Next() (p Packet, err error) {
 	}
 
 	for len(r.readers) > 0 {
-		p, err = Read(r.readers[len(r.readers)-1])
+		keep := -1
+		if r.keepSkipped {
+			keep = r.skippedRoom
+		}
+		p, err = read(r.readers[len(r.readers)-1], keep)
 		if err == nil {
 			return
 		}
@@ -46,10 +56,32 @@ func (r *Reader) Next() (p Packet, err error) {
 		if _, ok := err.(errors.UnknownPacketTypeError); !ok {
 			return nil, err
 		}
+		if op, ok := p.(*OpaquePacket); ok {
+			r.skipped = append(r.skipped, op)
+			r.skippedRoom -= len(op.Contents)
+		}
 	}
 	return nil, io.EOF
 }
 
+// KeepSkipped makes Next keep the packets of unknown type that it skips,
+// until they are collected by Skipped, and discards those kept so far.
+// Packets are only kept while their contents total at most limit bytes;
+// later ones are discarded. A negative limit stops keeping packets.
+func (r *Reader) KeepSkipped(limit int) {
+	r.skipped = nil
+	r.keepSkipped = limit >= 0
+	r.skippedRoom = limit
+}
+
+// Skipped returns the packets of unknown type that Next has skipped and
+// kept since the previous call to Skipped or KeepSkipped.
+func (r *Reader) Skipped() []*OpaquePacket {
+	skipped := r.skipped
+	r.skipped = nil
+	return skipped
+}
+
 // Push causes the Reader to start reading from a new io.Reader. When an EOF
 // error is seen from the new io.Reader, it is popped and the Reader continues
 // to read from the next most recent io.Reader. Push returns a StructuralError
@@ -27,6 +27,15 @@ const (
 	KeyFlagSign
 	KeyFlagEncryptCommunications
 	KeyFlagEncryptStorage
+	KeyFlagSplitKey
+	KeyFlagAuthenticate
+)
+
+const (
+	// See RFC 4880, section 5.2.3.24 and draft-ietf-openpgp-rfc4880bis,
+	// section 5.2.3.25 for details.
+	FeatureMDC = 1 << iota
+	FeatureAEAD
 )
 
 // Signer can be implemented by application code to do actual signing.
@@ -45,6 +54,35 @@ type RevocationKey struct {
 	Fingerprint   []byte
 }
 
+// Sensitive reports whether the revocation information is marked as
+// sensitive (class bit 0x40), in which case it should not be exported.
+func (r *RevocationKey) Sensitive() bool {
+	return r.Class&0x40 != 0
+}
+
+// Notation is a notation data subpacket of a signature, a name/value pair
+// set by the signer. See RFC 4880, section 5.2.3.16.
+type Notation struct {
+	Name  string
+	Value []byte
+	// IsHumanReadable is set if Value is UTF-8 text.
+	IsHumanReadable bool
+	// IsCritical marks the subpacket as critical. Since this package
+	// does not understand any notation, signatures with critical
+	// notations fail to verify.
+	IsCritical bool
+}
+
+// SignatureTarget identifies the signature that a signature refers to, for
+// example the one a third-party confirmation or a revocation applies to. See
+// RFC 4880, section 5.2.3.25.
+type SignatureTarget struct {
+	PubKeyAlgo PublicKeyAlgorithm
+	// Hash is zero if the hash algorithm is not known to this package.
+	Hash      crypto.Hash
+	HashValue []byte
+}
+
 // KeyFlagBits holds boolean whether any usage flags were provided in
 // the signature and BitField with KeyFlag* flags.
 type KeyFlagBits struct {
@@ -54,6 +92,10 @@ type KeyFlagBits struct {
 
 // Signature represents a signature. See RFC 4880, section 5.2.
 type Signature struct {
+	// Version is 4, 5 or 6; version 5 signatures can be read but not
+	// made. Zero, in a signature that is being made, means the version
+	// given by Config.SignatureVersion.
+	Version    int
 	SigType    SignatureType
 	PubKeyAlgo PublicKeyAlgorithm
 	Hash       crypto.Hash
@@ -64,6 +106,9 @@ type Signature struct {
 	// of bad signed data.
 	HashTag      [2]byte
 	CreationTime time.Time
+	// Salt is hashed in before the signed data in version 6 signatures.
+	// It is generated when signing if not set.
+	Salt []byte
 
 	RSASignature         parsedMPI
 	DSASigR, DSASigS     parsedMPI
@@ -83,10 +128,15 @@ type Signature struct {
 	IsPrimaryId                                             *bool
 	IssuerFingerprint                                       []byte
 
+	// KeyServerPrefs holds the flags of the key server preferences
+	// subpacket, see RFC 4880, section 5.2.3.17, and HasFlagNoModify.
+	KeyServerPrefs []byte
+
 	// FlagsValid is set if any flags were given. See RFC 4880, section
 	// 5.2.3.21 for details.
 	FlagsValid                                                           bool
 	FlagCertify, FlagSign, FlagEncryptCommunications, FlagEncryptStorage bool
+	FlagAuthenticate                                                     bool
 
 	// RevocationReason is set if this signature has been revoked.
 	// See RFC 4880, section 5.2.3.23 for details.
@@ -99,10 +149,29 @@ type Signature struct {
 	// Regex is a regex that can match a PGP UID. See RFC 4880, 5.2.3.14 for details
 	Regex string
 
+	// NonExportable marks a certification as local to the keyring it is
+	// made in, with a critical exportable certification subpacket set to
+	// zero. See RFC 4880, section 5.2.3.11.
+	NonExportable bool
+
+	// Notations holds the notation data of the hashed subpackets, in
+	// order. See RFC 4880, section 5.2.3.16.
+	Notations []*Notation
+
+	// TrustLevel and TrustAmount make this a trust signature, see RFC
+	// 4880, section 5.2.3.13. A level of 1 makes the signed key a trusted
+	// introducer, 2 a meta introducer and so on. The subpacket is only
+	// written if either value is non-zero.
+	TrustLevel, TrustAmount uint8
+
 	// MDC is set if this signature has a feature packet that indicates
 	// support for MDC subpackets.
 	MDC bool
 
+	// Features holds the flags of the features subpacket, see
+	// HasFlagMDC and HasFlagAEAD.
+	Features []byte
+
 	// EmbeddedSignature, if non-nil, is a signature of the parent key, by
 	// this key. This prevents an attacker from claiming another's signing
 	// subkey as their own.
@@ -118,9 +187,19 @@ type Signature struct {
 	// revocation for this key).
 	DesignatedRevoker *RevocationKey
 
+	// SignatureTarget, if non-nil, is the signature this signature refers
+	// to.
+	SignatureTarget *SignatureTarget
+
 	outSubpackets []outputSubpacket
 }
 
+// ErrCriticalRegex is the StubbedOutCriticalError of a signature whose only
+// unsupported critical subpacket is a regular expression. Such trust
+// signatures are only honoured by openpgp.VerifyTrustPath, which applies the
+// expression.
+var ErrCriticalRegex error = errors.UnsupportedError("regular expression in critical subpacket")
+
 func (sig *Signature) parse(r io.Reader) (err error) {
 	// RFC 4880, section 5.2.3
 	var buf [5]byte
@@ -128,19 +207,26 @@ func (sig *Signature) parse(r io.Reader) (err error) {
 	if err != nil {
 		return
 	}
-	if buf[0] != 4 {
+	if buf[0] != 4 && buf[0] != 5 && buf[0] != 6 {
 		err = errors.UnsupportedError("signature packet version " + strconv.Itoa(int(buf[0])))
 		return
 	}
+	sig.Version = int(buf[0])
+
+	// Version 6 signatures use four octet subpacket area lengths.
+	lengthLen := 2
+	if sig.Version == 6 {
+		lengthLen = 4
+	}
 
-	_, err = readFull(r, buf[:5])
+	_, err = readFull(r, buf[:3])
 	if err != nil {
 		return
 	}
 	sig.SigType = SignatureType(buf[0])
 	sig.PubKeyAlgo = PublicKeyAlgorithm(buf[1])
 	switch sig.PubKeyAlgo {
-	case PubKeyAlgoRSA, PubKeyAlgoRSASignOnly, PubKeyAlgoDSA, PubKeyAlgoECDSA, PubKeyAlgoEdDSA:
+	case PubKeyAlgoRSA, PubKeyAlgoRSASignOnly, PubKeyAlgoDSA, PubKeyAlgoECDSA, PubKeyAlgoEdDSA, PubKeyAlgoEd25519:
 	default:
 		err = errors.UnsupportedError("public key algorithm " + strconv.Itoa(int(sig.PubKeyAlgo)))
 		return
@@ -152,35 +238,36 @@ func (sig *Signature) parse(r io.Reader) (err error) {
 		return errors.UnsupportedError("hash function " + strconv.Itoa(int(buf[2])))
 	}
 
-	hashedSubpacketsLength := int(buf[3])<<8 | int(buf[4])
-	l := 6 + hashedSubpacketsLength
-	sig.HashSuffix = make([]byte, l+6)
-	sig.HashSuffix[0] = 4
-	copy(sig.HashSuffix[1:], buf[:5])
-	hashedSubpackets := sig.HashSuffix[6:l]
+	hashedSubpacketsLength, err := readSubpacketsLength(r, lengthLen)
+	if err != nil {
+		return
+	}
+	prefix := 4 + lengthLen
+	l := prefix + hashedSubpacketsLength
+	sig.HashSuffix = make([]byte, l+sig.trailerLength())
+	sig.HashSuffix[0] = byte(sig.Version)
+	copy(sig.HashSuffix[1:], buf[:3])
+	putSubpacketsLength(sig.HashSuffix[4:prefix], hashedSubpacketsLength)
+	hashedSubpackets := sig.HashSuffix[prefix:l]
 	_, err = readFull(r, hashedSubpackets)
 	if err != nil {
 		return
 	}
 	// See RFC 4880, section 5.2.4
 	trailer := sig.HashSuffix[l:]
-	trailer[0] = 4
+	trailer[0] = byte(sig.Version)
 	trailer[1] = 0xff
-	trailer[2] = uint8(l >> 24)
-	trailer[3] = uint8(l >> 16)
-	trailer[4] = uint8(l >> 8)
-	trailer[5] = uint8(l)
+	putSubpacketsLength(trailer[2:], l)
 
 	err = parseSignatureSubpackets(sig, hashedSubpackets, true)
 	if err != nil {
 		return
 	}
 
-	_, err = readFull(r, buf[:2])
+	unhashedSubpacketsLength, err := readSubpacketsLength(r, lengthLen)
 	if err != nil {
 		return
 	}
-	unhashedSubpacketsLength := int(buf[0])<<8 | int(buf[1])
 	unhashedSubpackets := make([]byte, unhashedSubpacketsLength)
 	_, err = readFull(r, unhashedSubpackets)
 	if err != nil {
@@ -196,6 +283,12 @@ func (sig *Signature) parse(r io.Reader) (err error) {
 		return
 	}
 
+	if sig.Version == 6 {
+		if err = sig.parseSalt(r); err != nil {
+			return
+		}
+	}
+
 	switch sig.PubKeyAlgo {
 	case PubKeyAlgoRSA, PubKeyAlgoRSASignOnly:
 		sig.RSASignature.bytes, sig.RSASignature.bitLength, err = readMPI(r)
@@ -214,12 +307,92 @@ func (sig *Signature) parse(r io.Reader) (err error) {
 		if err == nil {
 			sig.ECDSASigS.bytes, sig.ECDSASigS.bitLength, err = readMPI(r)
 		}
+	case PubKeyAlgoEd25519:
+		// RFC 9580, section 5.2.3: the 64 octets of R and S without
+		// MPI headers. They are kept in the EdDSA fields.
+		var signature [64]byte
+		if _, err = readFull(r, signature[:]); err == nil {
+			sig.EdDSASigR = FromBytes(signature[:32])
+			sig.EdDSASigS = FromBytes(signature[32:])
+		}
 	default:
 		panic("unreachable")
 	}
 	return
 }
 
+// trailerLength returns the length of the trailer that follows the hashed
+// part of the signature packet in HashSuffix. Version 5 signatures hash an
+// eight octet length there, the others a four octet one.
+func (sig *Signature) trailerLength() int {
+	if sig.Version == 5 {
+		return 10
+	}
+	return 6
+}
+
+// maxSubpacketsLength bounds the four octet subpacket area lengths of
+// version 6 signatures, so that a corrupt length cannot make us allocate
+// gigabytes before hitting EOF.
+const maxSubpacketsLength = 1 << 20
+
+// readSubpacketsLength reads the big-endian length of a subpacket area,
+// which is n (2 or 4) octets long.
+func readSubpacketsLength(r io.Reader, n int) (length int, err error) {
+	var buf [4]byte
+	if _, err = readFull(r, buf[:n]); err != nil {
+		return
+	}
+	for _, b := range buf[:n] {
+		length = length<<8 | int(b)
+	}
+	if length > maxSubpacketsLength {
+		err = errors.StructuralError("signature subpacket area too large")
+	}
+	return
+}
+
+// putSubpacketsLength is the inverse of readSubpacketsLength, writing
+// length into all of to.
+func putSubpacketsLength(to []byte, length int) {
+	for i := len(to) - 1; i >= 0; i-- {
+		to[i] = byte(length)
+		length >>= 8
+	}
+}
+
+// parseSalt reads the salt of a version 6 signature.
+func (sig *Signature) parseSalt(r io.Reader) (err error) {
+	var buf [1]byte
+	if _, err = readFull(r, buf[:]); err != nil {
+		return
+	}
+	size, err := saltSize(sig.Hash)
+	if err != nil {
+		return
+	}
+	if int(buf[0]) != size {
+		return errors.StructuralError("signature salt has wrong length")
+	}
+	sig.Salt = make([]byte, size)
+	_, err = readFull(r, sig.Salt)
+	return
+}
+
+// saltSize returns the length of the salt that a version 6 signature
+// using hashFunc must have.
+func saltSize(hashFunc crypto.Hash) (int, error) {
+	switch hashFunc {
+	case crypto.SHA224, crypto.SHA256, crypto.SHA3_256:
+		return 16, nil
+	case crypto.SHA384:
+		return 24, nil
+	case crypto.SHA512, crypto.SHA3_512:
+		return 32, nil
+	}
+	return 0, errors.UnsupportedError("hash function for v6 signature: " + strconv.Itoa(int(hashFunc)))
+}
+
 // parseSignatureSubpackets parses subpackets of the main signature packet. See
 // RFC 4880, section 5.2.3.1.
 func parseSignatureSubpackets(sig *Signature, subpackets []byte, isHashed bool) (err error) {
@@ -242,19 +415,24 @@ type signatureSubpacketType uint8
 const (
 	creationTimeSubpacket        signatureSubpacketType = 2
 	signatureExpirationSubpacket signatureSubpacketType = 3
+	exportableCertSubpacket      signatureSubpacketType = 4
+	trustSubpacket               signatureSubpacketType = 5
 	regularExpressionSubpacket   signatureSubpacketType = 6
 	keyExpirationSubpacket       signatureSubpacketType = 9
 	prefSymmetricAlgosSubpacket  signatureSubpacketType = 11
 	revocationKey                signatureSubpacketType = 12
 	issuerSubpacket              signatureSubpacketType = 16
+	notationDataSubpacket        signatureSubpacketType = 20
 	prefHashAlgosSubpacket       signatureSubpacketType = 21
 	prefCompressionSubpacket     signatureSubpacketType = 22
+	keyServerPrefsSubpacket      signatureSubpacketType = 23
 	prefKeyServerSubpacket       signatureSubpacketType = 24
 	primaryUserIdSubpacket       signatureSubpacketType = 25
 	policyURISubpacket           signatureSubpacketType = 26
 	keyFlagsSubpacket            signatureSubpacketType = 27
 	reasonForRevocationSubpacket signatureSubpacketType = 29
 	featuresSubpacket            signatureSubpacketType = 30
+	signatureTargetSubpacket     signatureSubpacketType = 31
 	embeddedSignatureSubpacket   signatureSubpacketType = 32
 	issuerFingerprint            signatureSubpacketType = 33
 )
@@ -399,6 +577,9 @@ func parseSignatureSubpacket(sig *Signature, subpacket []byte, isHashed bool) (r
 			if subpacket[0]&KeyFlagEncryptStorage != 0 {
 				sig.FlagEncryptStorage = true
 			}
+			if subpacket[0]&KeyFlagAuthenticate != 0 {
+				sig.FlagAuthenticate = true
+			}
 		}
 	case reasonForRevocationSubpacket:
 		// Reason For Revocation, section 5.2.3.23
@@ -415,9 +596,9 @@ func parseSignatureSubpacket(sig *Signature, subpacket []byte, isHashed bool) (r
 	case featuresSubpacket:
 		// Features subpacket, section 5.2.3.24 specifies a very general
 		// mechanism for OpenPGP implementations to signal support for new
-		// features. In practice, the subpacket is used exclusively to
-		// indicate support for MDC-protected encryption.
-		sig.MDC = len(subpacket) >= 1 && subpacket[0]&1 == 1
+		// features, such as MDC-protected and AEAD encryption.
+		sig.MDC = len(subpacket) >= 1 && subpacket[0]&FeatureMDC != 0
+		sig.Features = append([]byte{}, subpacket...)
 	case embeddedSignatureSubpacket:
 		// Only usage is in signatures that cross-certify
 		// signing subkeys. section 5.2.3.26 describes the
@@ -440,23 +621,92 @@ func parseSignatureSubpacket(sig *Signature, subpacket []byte, isHashed bool) (r
 		// See RFC 4880, Section 5.2.3.20
 		sig.PolicyURI = string(subpacket[:])
 	case regularExpressionSubpacket:
-		sig.Regex = string(subpacket[:])
-		if isCritical {
-			sig.StubbedOutCriticalError = errors.UnsupportedError("regex support is stubbed out")
+		// Regular expression, section 5.2.3.14. The expression is
+		// null-terminated.
+		if !isHashed {
+			return
 		}
+		sig.Regex = string(bytes.TrimSuffix(subpacket, []byte{0}))
+		if isCritical && sig.StubbedOutCriticalError == nil {
+			sig.StubbedOutCriticalError = ErrCriticalRegex
+		}
+	case exportableCertSubpacket:
+		// Exportable certification, section 5.2.3.11
+		if !isHashed {
+			return
+		}
+		if len(subpacket) != 1 {
+			err = errors.StructuralError("exportable certification subpacket with bad length")
+			return
+		}
+		sig.NonExportable = subpacket[0] == 0
+	case trustSubpacket:
+		// Trust signature, section 5.2.3.13
+		if !isHashed {
+			return
+		}
+		if len(subpacket) != 2 {
+			err = errors.StructuralError("trust signature subpacket with bad length")
+			return
+		}
+		sig.TrustLevel = subpacket[0]
+		sig.TrustAmount = subpacket[1]
 	case prefKeyServerSubpacket:
 		sig.PreferredKeyServer = string(subpacket[:])
+	case keyServerPrefsSubpacket:
+		// Key server preferences, section 5.2.3.17
+		if !isHashed {
+			return
+		}
+		sig.KeyServerPrefs = append([]byte{}, subpacket...)
+	case notationDataSubpacket:
+		// Notation data, section 5.2.3.16
+		if !isHashed {
+			return
+		}
+		if len(subpacket) < 8 {
+			err = errors.StructuralError("notation data subpacket with bad length")
+			return
+		}
+		nameLength := int(binary.BigEndian.Uint16(subpacket[4:6]))
+		valueLength := int(binary.BigEndian.Uint16(subpacket[6:8]))
+		if len(subpacket) != 8+nameLength+valueLength {
+			err = errors.StructuralError("notation data subpacket with bad length")
+			return
+		}
+		notation := &Notation{
+			Name:            string(subpacket[8 : 8+nameLength]),
+			Value:           append([]byte{}, subpacket[8+nameLength:]...),
+			IsHumanReadable: subpacket[0]&0x80 != 0,
+			IsCritical:      isCritical,
+		}
+		sig.Notations = append(sig.Notations, notation)
+		if isCritical && (sig.StubbedOutCriticalError == nil || sig.StubbedOutCriticalError == ErrCriticalRegex) {
+			sig.StubbedOutCriticalError = errors.UnsupportedError("unknown critical notation " + strconv.Quote(notation.Name))
+		}
 	case issuerFingerprint:
 		// The first byte is how many bytes the fingerprint is, but we'll just
 		// read until the end of the subpacket, so we'll ignore it.
 		sig.IssuerFingerprint = append([]byte{}, subpacket[1:]...)
+	case signatureTargetSubpacket:
+		// Signature target, section 5.2.3.25
+		if len(subpacket) < 2 {
+			err = errors.StructuralError("signature target subpacket with bad length")
+			return
+		}
+		hash, _ := s2k.HashIdToHash(subpacket[1])
+		sig.SignatureTarget = &SignatureTarget{
+			PubKeyAlgo: PublicKeyAlgorithm(subpacket[0]),
+			Hash:       hash,
+			HashValue:  append([]byte{}, subpacket[2:]...),
+		}
 	case revocationKey:
 		// Authorizes the specified key to issue revocation signatures
 		// for a key.
 
-		// TODO: Class octet must have bit 0x80 set. If the bit 0x40
-		// is set, then this means that the revocation information is
-		// sensitive.
+		// TODO: Class octet must have bit 0x80 set. Bit 0x40 marks
+		// the revocation information as sensitive, see
+		// RevocationKey.Sensitive.
 		sig.DesignatedRevoker = &RevocationKey{
 			Class:         subpacket[0],
 			PublicKeyAlgo: PublicKeyAlgorithm(subpacket[1]),
@@ -526,6 +776,9 @@ func serializeSubpackets(to []byte, subpackets []outputSubpacket, hashed bool) {
 		if subpacket.hashed == hashed {
 			n := serializeSubpacketLength(to, len(subpacket.contents)+1)
 			to[n] = byte(subpacket.subpacketType)
+			if subpacket.isCritical {
+				to[n] |= 0x80
+			}
 			to = to[1+n:]
 			n = copy(to, subpacket.contents)
 			to = to[n:]
@@ -534,6 +787,34 @@ func serializeSubpackets(to []byte, subpackets []outputSubpacket, hashed bool) {
 	return
 }
 
+// HasFlagNoModify reports whether the key holder asked, through the key
+// server preferences, that only they may modify the key on a key server.
+func (sig *Signature) HasFlagNoModify() bool {
+	return len(sig.KeyServerPrefs) > 0 && sig.KeyServerPrefs[0]&0x80 != 0
+}
+
+// HasFlagMDC reports whether the features subpacket announces support for
+// Symmetrically Encrypted Integrity Protected Data packets.
+func (sig *Signature) HasFlagMDC() bool {
+	return len(sig.Features) > 0 && sig.Features[0]&FeatureMDC != 0
+}
+
+// HasFlagAEAD reports whether the features subpacket announces support for
+// AEAD Encrypted Data packets.
+func (sig *Signature) HasFlagAEAD() bool {
+	return len(sig.Features) > 0 && sig.Features[0]&FeatureAEAD != 0
+}
+
+// SigExpired returns whether sig has expired, as given by its signature
+// expiration time subpacket.
+func (sig *Signature) SigExpired(currentTime time.Time) bool {
+	if sig.SigLifetimeSecs == nil || *sig.SigLifetimeSecs == 0 {
+		return false
+	}
+	expiry := sig.CreationTime.Add(time.Duration(*sig.SigLifetimeSecs) * time.Second)
+	return currentTime.After(expiry)
+}
+
 // KeyExpired returns whether sig is a self-signature of a key that has
 // expired.
 func (sig *Signature) KeyExpired(currentTime time.Time) bool {
@@ -564,14 +845,59 @@ func (sig *Signature) ExpiresBeforeOther(other *Signature) bool {
 	return getExpiryDate(other).After(getExpiryDate(sig))
 }
 
+// CandidateIssuers returns the key ids that sig claims to be issued by: the
+// issuer key id subpacket, followed by the key id derived from the issuer
+// fingerprint subpacket, which is its last eight bytes for version 4 keys
+// and its first eight bytes otherwise. The two should agree, but when they
+// don't, both are listed, which helps to diagnose signatures that can't be
+// matched to a key.
+func (sig *Signature) CandidateIssuers() (ids []uint64) {
+	if sig.IssuerKeyId != nil {
+		ids = append(ids, *sig.IssuerKeyId)
+	}
+	if fp := sig.IssuerFingerprint; len(fp) >= 8 {
+		id := binary.BigEndian.Uint64(fp[len(fp)-8:])
+		if len(fp) == 32 {
+			id = binary.BigEndian.Uint64(fp[:8])
+		}
+		if len(ids) == 0 || ids[0] != id {
+			ids = append(ids, id)
+		}
+	}
+	return
+}
+
+// IsSelfSignature reports whether sig claims to be issued by primary, the
+// primary key of the key that sig appears on. Both the issuer key id and
+// the issuer fingerprint are checked if present, and at least one of them
+// must be. The signature itself is not verified.
+func (sig *Signature) IsSelfSignature(primary *PublicKey) bool {
+	if sig.IssuerKeyId == nil && sig.IssuerFingerprint == nil {
+		return false
+	}
+	if sig.IssuerKeyId != nil && *sig.IssuerKeyId != primary.KeyId {
+		return false
+	}
+	if sig.IssuerFingerprint != nil && !bytes.Equal(sig.IssuerFingerprint, primary.FullFingerprint()) {
+		return false
+	}
+	return true
+}
+
 // buildHashSuffix constructs the HashSuffix member of sig in preparation for signing.
 func (sig *Signature) buildHashSuffix() (err error) {
 	hashedSubpacketsLen := subpacketsLength(sig.outSubpackets, true)
 
+	lengthLen := 2
+	if sig.Version == 6 {
+		lengthLen = 4
+	}
+
 	var ok bool
-	l := 6 + hashedSubpacketsLen
-	sig.HashSuffix = make([]byte, l+6)
-	sig.HashSuffix[0] = 4
+	prefix := 4 + lengthLen
+	l := prefix + hashedSubpacketsLen
+	sig.HashSuffix = make([]byte, l+sig.trailerLength())
+	sig.HashSuffix[0] = byte(sig.Version)
 	sig.HashSuffix[1] = uint8(sig.SigType)
 	sig.HashSuffix[2] = uint8(sig.PubKeyAlgo)
 	sig.HashSuffix[3], ok = s2k.HashToHashId(sig.Hash)
@@ -579,19 +905,99 @@ func (sig *Signature) buildHashSuffix() (err error) {
 		sig.HashSuffix = nil
 		return errors.InvalidArgumentError("hash cannot be represented in OpenPGP: " + strconv.Itoa(int(sig.Hash)))
 	}
-	sig.HashSuffix[4] = byte(hashedSubpacketsLen >> 8)
-	sig.HashSuffix[5] = byte(hashedSubpacketsLen)
-	serializeSubpackets(sig.HashSuffix[6:l], sig.outSubpackets, true)
+	putSubpacketsLength(sig.HashSuffix[4:prefix], hashedSubpacketsLen)
+	serializeSubpackets(sig.HashSuffix[prefix:l], sig.outSubpackets, true)
 	trailer := sig.HashSuffix[l:]
-	trailer[0] = 4
+	trailer[0] = byte(sig.Version)
 	trailer[1] = 0xff
-	trailer[2] = byte(l >> 24)
-	trailer[3] = byte(l >> 16)
-	trailer[4] = byte(l >> 8)
-	trailer[5] = byte(l)
+	putSubpacketsLength(trailer[2:], l)
 	return
 }
 
+// prepareSalt sets sig.Version from config if it is unset and, for a
+// version 6 signature without a salt, generates a fresh one.
+func (sig *Signature) prepareSalt(config *Config) error {
+	if sig.Version == 0 {
+		sig.Version = config.SigVersion()
+	}
+	switch sig.Version {
+	case 4:
+		return nil
+	case 6:
+	default:
+		return errors.UnsupportedError("signature version " + strconv.Itoa(sig.Version))
+	}
+	size, err := saltSize(sig.Hash)
+	if err != nil {
+		return err
+	}
+	if sig.Salt != nil {
+		if len(sig.Salt) != size {
+			return errors.InvalidArgumentError("signature salt has wrong length")
+		}
+		return nil
+	}
+	sig.Salt = make([]byte, size)
+	_, err = io.ReadFull(config.Random(), sig.Salt)
+	return err
+}
+
+// PrepareHash must be called on h, before any signed data is written to
+// it, when making or verifying a signature over arbitrary data. For
+// version 6 signatures it writes the salt, generating one from config
+// if sig has none yet; for version 4 signatures it does nothing. The
+// key and user id signing and verification methods do this themselves.
+func (sig *Signature) PrepareHash(h hash.Hash, config *Config) error {
+	if err := sig.prepareSalt(config); err != nil {
+		return err
+	}
+	h.Write(sig.Salt)
+	return nil
+}
+
+// PrepareVerify returns a new hash of sig's hash function, with the salt of
+// a version 6 signature already written to it, to which the signed data
+// should be written. The digest that sig is verified against is then given
+// by Digest. The hashes for key, user id and revocation signatures are
+// returned by PrepareVerifyKey, PrepareVerifyUserId and
+// PrepareVerifyRevocation.
+func (sig *Signature) PrepareVerify() (hash.Hash, error) {
+	if !sig.Hash.Available() {
+		return nil, errors.UnsupportedError("hash function")
+	}
+	h := sig.Hash.New()
+	h.Write(sig.Salt)
+	return h, nil
+}
+
+// PrepareVerifyKey returns the hash, with pk and signed written to it, that
+// a subkey binding signature made by pk over signed is verified with.
+func (sig *Signature) PrepareVerifyKey(pk, signed *PublicKey) (hash.Hash, error) {
+	return keySignatureHash(pk, signed, sig.Hash, sig.Salt)
+}
+
+// PrepareVerifyUserId returns the hash, with pub and id written to it, that
+// a certification of id as an identity of pub is verified with.
+func (sig *Signature) PrepareVerifyUserId(id string, pub *PublicKey) (hash.Hash, error) {
+	return userIdSignatureHash(id, pub, sig.Hash, sig.Salt)
+}
+
+// PrepareVerifyRevocation returns the hash, with revokedKey written to it,
+// that a key revocation or direct key signature over revokedKey is verified
+// with.
+func (sig *Signature) PrepareVerifyRevocation(revokedKey *PublicKey) (hash.Hash, error) {
+	return keyRevocationHash(revokedKey, sig.Hash, sig.Salt)
+}
+
+// Digest writes sig's trailer, HashSuffix, to h, which holds the signed
+// data, and returns the resulting digest. This is the value that the
+// signature itself is checked against by PublicKey.VerifySignature. h can't
+// be used afterwards.
+func (sig *Signature) Digest(h hash.Hash) []byte {
+	h.Write(sig.HashSuffix)
+	return h.Sum(nil)
+}
+
 func (sig *Signature) signPrepareHash(h hash.Hash) (digest []byte, err error) {
 	err = sig.buildHashSuffix()
 	if err != nil {
@@ -616,6 +1022,23 @@ func (sig *Signature) Sign(h hash.Hash, priv *PrivateKey, config *Config) (err e
 		return
 	}
 
+	if sig.Version == 0 {
+		sig.Version = config.SigVersion()
+	}
+	if sig.Version == 6 && sig.Salt == nil {
+		err = errors.InvalidArgumentError("v6 signature has no salt, call PrepareHash first")
+		return
+	}
+
+	if sig.RevocationReason != nil {
+		switch sig.SigType {
+		case SigTypeKeyRevocation, SigTypeSubkeyRevocation, SigTypeIdentityRevocation:
+		default:
+			err = errors.InvalidArgumentError("reason for revocation on a non-revocation signature")
+			return
+		}
+	}
+
 	sig.outSubpackets = sig.buildSubpackets()
 	digest, err := sig.signPrepareHash(h)
 	if err != nil {
@@ -633,6 +1056,12 @@ func (sig *Signature) Sign(h hash.Hash, priv *PrivateKey, config *Config) (err e
 		err = errors.InvalidArgumentError("signature pub key algo does not match priv key")
 		return
 	}
+	// RFC 9580, section 5.2: version 6 signatures are only made by
+	// version 6 keys.
+	if sig.Version == 6 && priv.Version != 6 {
+		err = errors.InvalidArgumentError("version 6 signature requested for a version " + strconv.Itoa(priv.Version) + " key")
+		return
+	}
 
 	switch priv.PubKeyAlgo {
 	case PubKeyAlgoRSA, PubKeyAlgoRSASignOnly:
@@ -650,10 +1079,8 @@ func (sig *Signature) Sign(h hash.Hash, priv *PrivateKey, config *Config) (err e
 		if err != nil {
 			return err
 		}
-		sig.DSASigR.bytes = r.Bytes()
-		sig.DSASigR.bitLength = uint16(8 * len(sig.DSASigR.bytes))
-		sig.DSASigS.bytes = s.Bytes()
-		sig.DSASigS.bitLength = uint16(8 * len(sig.DSASigS.bytes))
+		sig.DSASigR = FromBig(r)
+		sig.DSASigS = FromBig(s)
 	case PubKeyAlgoECDSA:
 		r, s, err := ecdsa.Sign(config.Random(), priv.PrivateKey.(*ecdsa.PrivateKey), digest)
 		if err != nil {
@@ -680,7 +1107,25 @@ func (sig *Signature) Sign(h hash.Hash, priv *PrivateKey, config *Config) (err e
 // Serialize to write it out.
 // If config is nil, sensible defaults will be used.
 func (sig *Signature) SignUserId(id string, pub *PublicKey, priv *PrivateKey, config *Config) error {
-	h, err := userIdSignatureHash(id, pub, sig.Hash)
+	if err := sig.prepareSalt(config); err != nil {
+		return err
+	}
+	h, err := userIdSignatureHash(id, pub, sig.Hash, sig.Salt)
+	if err != nil {
+		return err
+	}
+	return sig.Sign(h, priv, config)
+}
+
+// SignUserAttribute computes a signature from priv, asserting that pub is a
+// valid key for the user attribute uat. On success, the signature is stored
+// in sig. Call Serialize to write it out.
+// If config is nil, sensible defaults will be used.
+func (sig *Signature) SignUserAttribute(uat *UserAttribute, pub *PublicKey, priv *PrivateKey, config *Config) error {
+	if err := sig.prepareSalt(config); err != nil {
+		return err
+	}
+	h, err := userAttributeSignatureHash(uat, pub, sig.Hash, sig.Salt)
 	if err != nil {
 		return err
 	}
@@ -692,6 +1137,9 @@ func (sig *Signature) SignUserId(id string, pub *PublicKey, priv *PrivateKey, co
 // Call Serialize to write it out.
 // If config is nil, sensible defaults will be used.
 func (sig *Signature) SignUserIdWithSigner(id string, pub *PublicKey, s Signer, config *Config) error {
+	if err := sig.PrepareHash(s, config); err != nil {
+		return err
+	}
 	updateUserIdSignatureHash(id, pub, s)
 
 	return sig.Sign(s, nil, config)
@@ -701,7 +1149,24 @@ func (sig *Signature) SignUserIdWithSigner(id string, pub *PublicKey, s Signer,
 // success, the signature is stored in sig. Call Serialize to write it out.
 // If config is nil, sensible defaults will be used.
 func (sig *Signature) SignKey(pub *PublicKey, priv *PrivateKey, config *Config) error {
-	h, err := keySignatureHash(&priv.PublicKey, pub, sig.Hash)
+	if err := sig.prepareSalt(config); err != nil {
+		return err
+	}
+	h, err := keySignatureHash(&priv.PublicKey, pub, sig.Hash, sig.Salt)
+	if err != nil {
+		return err
+	}
+	return sig.Sign(h, priv, config)
+}
+
+// RevokeKey computes a revocation signature of pub from priv. On success,
+// the signature is stored in sig. Call Serialize to write it out.
+// If config is nil, sensible defaults will be used.
+func (sig *Signature) RevokeKey(pub *PublicKey, priv *PrivateKey, config *Config) error {
+	if err := sig.prepareSalt(config); err != nil {
+		return err
+	}
+	h, err := keyRevocationHash(pub, sig.Hash, sig.Salt)
 	if err != nil {
 		return err
 	}
@@ -712,11 +1177,32 @@ func (sig *Signature) SignKey(pub *PublicKey, priv *PrivateKey, config *Config)
 // signeePubKey is a subkey. On success, the signature is stored in sig. Call
 // Serialize to write it out. If config is nil, sensible defaults will be used.
 func (sig *Signature) SignKeyWithSigner(signeePubKey *PublicKey, signerPubKey *PublicKey, s Signer, config *Config) error {
+	if err := sig.PrepareHash(s, config); err != nil {
+		return err
+	}
 	updateKeySignatureHash(signerPubKey, signeePubKey, s)
 
 	return sig.Sign(s, nil, config)
 }
 
+// CopyUnsigned returns a copy of sig without its signature values, salt and
+// embedded signature, so that it can be signed again, e.g. with a changed
+// key lifetime. Only the subpackets that Signature has fields for are kept.
+func (sig *Signature) CopyUnsigned() *Signature {
+	c := *sig
+	c.HashSuffix = nil
+	c.HashTag = [2]byte{}
+	c.Salt = nil
+	c.RSASignature = parsedMPI{}
+	c.DSASigR, c.DSASigS = parsedMPI{}, parsedMPI{}
+	c.ECDSASigR, c.ECDSASigS = parsedMPI{}, parsedMPI{}
+	c.EdDSASigR, c.EdDSASigS = parsedMPI{}, parsedMPI{}
+	c.rawSubpackets = nil
+	c.outSubpackets = nil
+	c.EmbeddedSignature = nil
+	return &c
+}
+
 // CrossSignKey creates PrimaryKeyBinding signature in sig.EmbeddedSignature by
 // signing `primary` key's hash using `priv` subkey private key. Primary public
 // key is the `signee` here.
@@ -731,8 +1217,11 @@ func (sig *Signature) CrossSignKey(primary *PublicKey, priv *PrivateKey, config
 		PubKeyAlgo:   priv.PubKeyAlgo,
 		Hash:         sig.Hash,
 	}
+	if err := sig.EmbeddedSignature.prepareSalt(config); err != nil {
+		return err
+	}
 
-	h, err := keySignatureHash(primary, &priv.PublicKey, sig.Hash)
+	h, err := keySignatureHash(primary, &priv.PublicKey, sig.Hash, sig.EmbeddedSignature.Salt)
 	if err != nil {
 		return err
 	}
@@ -765,28 +1254,35 @@ func (sig *Signature) Serialize(w io.Writer) (err error) {
 	case PubKeyAlgoECDSA:
 		sigLength = 2 + len(sig.ECDSASigR.bytes)
 		sigLength += 2 + len(sig.ECDSASigS.bytes)
+	case PubKeyAlgoEd25519:
+		sigLength = len(sig.EdDSASigR.bytes) + len(sig.EdDSASigS.bytes)
 	default:
 		panic("impossible")
 	}
 
+	lengthLen := 2
+	if sig.Version == 6 {
+		lengthLen = 4
+		sigLength += 1 + len(sig.Salt)
+	}
+
 	unhashedSubpacketsLen := subpacketsLength(sig.outSubpackets, false)
-	length := len(sig.HashSuffix) - 6 /* trailer not included */ +
-		2 /* length of unhashed subpackets */ + unhashedSubpacketsLen +
+	length := len(sig.HashSuffix) - sig.trailerLength() +
+		lengthLen /* length of unhashed subpackets */ + unhashedSubpacketsLen +
 		2 /* hash tag */ + sigLength
 	err = serializeHeader(w, packetTypeSignature, length)
 	if err != nil {
 		return
 	}
 
-	_, err = w.Write(sig.HashSuffix[:len(sig.HashSuffix)-6])
+	_, err = w.Write(sig.HashSuffix[:len(sig.HashSuffix)-sig.trailerLength()])
 	if err != nil {
 		return
 	}
 
-	unhashedSubpackets := make([]byte, 2+unhashedSubpacketsLen)
-	unhashedSubpackets[0] = byte(unhashedSubpacketsLen >> 8)
-	unhashedSubpackets[1] = byte(unhashedSubpacketsLen)
-	serializeSubpackets(unhashedSubpackets[2:], sig.outSubpackets, false)
+	unhashedSubpackets := make([]byte, lengthLen+unhashedSubpacketsLen)
+	putSubpacketsLength(unhashedSubpackets[:lengthLen], unhashedSubpacketsLen)
+	serializeSubpackets(unhashedSubpackets[lengthLen:], sig.outSubpackets, false)
 
 	_, err = w.Write(unhashedSubpackets)
 	if err != nil {
@@ -796,6 +1292,12 @@ func (sig *Signature) Serialize(w io.Writer) (err error) {
 	if err != nil {
 		return
 	}
+	if sig.Version == 6 {
+		_, err = w.Write(append([]byte{byte(len(sig.Salt))}, sig.Salt...))
+		if err != nil {
+			return
+		}
+	}
 
 	switch sig.PubKeyAlgo {
 	case PubKeyAlgoRSA, PubKeyAlgoRSASignOnly:
@@ -806,12 +1308,50 @@ func (sig *Signature) Serialize(w io.Writer) (err error) {
 		err = writeMPIs(w, sig.EdDSASigR, sig.EdDSASigS)
 	case PubKeyAlgoECDSA:
 		err = writeMPIs(w, sig.ECDSASigR, sig.ECDSASigS)
+	case PubKeyAlgoEd25519:
+		if _, err = w.Write(sig.EdDSASigR.bytes); err == nil {
+			_, err = w.Write(sig.EdDSASigS.bytes)
+		}
 	default:
 		panic("impossible")
 	}
 	return
 }
 
+// A Subpacket is a signature subpacket as it appears in a signature, without
+// interpretation. See RFC 4880, section 5.2.3.1.
+type Subpacket struct {
+	Type     uint8
+	Critical bool
+	Contents []byte
+}
+
+// RawSubpackets returns the subpackets of the hashed and of the unhashed
+// area of sig, in order, including those that Signature has no field for.
+// For a signature that failed to parse because of an unsupported subpacket,
+// as returned alongside the error by Read, the list stops with that
+// subpacket. A signature that has been signed but not parsed lists the
+// subpackets that Serialize writes.
+func (sig *Signature) RawSubpackets() (hashed, unhashed []Subpacket) {
+	subpackets := sig.rawSubpackets
+	if len(subpackets) == 0 {
+		subpackets = sig.outSubpackets
+	}
+	for _, subpacket := range subpackets {
+		raw := Subpacket{
+			Type:     uint8(subpacket.subpacketType),
+			Critical: subpacket.isCritical,
+			Contents: append([]byte{}, subpacket.contents...),
+		}
+		if subpacket.hashed {
+			hashed = append(hashed, raw)
+		} else {
+			unhashed = append(unhashed, raw)
+		}
+	}
+	return
+}
+
 // outputSubpacket represents a subpacket to be marshaled.
 type outputSubpacket struct {
 	hashed        bool // true if this subpacket is in the hashed area.
@@ -831,12 +1371,51 @@ func (sig *Signature) buildSubpackets() (subpackets []outputSubpacket) {
 		subpackets = append(subpackets, outputSubpacket{true, issuerSubpacket, false, keyId})
 	}
 
+	if fp := sig.IssuerFingerprint; len(fp) > 0 {
+		// The fingerprint is prefixed by the version of the issuer's key.
+		version := byte(4)
+		if len(fp) == 32 {
+			version = 5
+			if sig.Version == 6 {
+				version = 6
+			}
+		}
+		subpackets = append(subpackets, outputSubpacket{true, issuerFingerprint, false, append([]byte{version}, fp...)})
+	}
+
 	if sig.SigLifetimeSecs != nil && *sig.SigLifetimeSecs != 0 {
 		sigLifetime := make([]byte, 4)
 		binary.BigEndian.PutUint32(sigLifetime, *sig.SigLifetimeSecs)
 		subpackets = append(subpackets, outputSubpacket{true, signatureExpirationSubpacket, true, sigLifetime})
 	}
 
+	// Trust signatures and their scope only make sense on certifications.
+
+	if sig.NonExportable {
+		subpackets = append(subpackets, outputSubpacket{true, exportableCertSubpacket, true, []byte{0}})
+	}
+
+	if sig.TrustLevel != 0 || sig.TrustAmount != 0 {
+		subpackets = append(subpackets, outputSubpacket{true, trustSubpacket, false, []byte{sig.TrustLevel, sig.TrustAmount}})
+	}
+
+	if sig.Regex != "" {
+		regex := append([]byte(sig.Regex), 0)
+		subpackets = append(subpackets, outputSubpacket{true, regularExpressionSubpacket, true, regex})
+	}
+
+	for _, notation := range sig.Notations {
+		contents := make([]byte, 8, 8+len(notation.Name)+len(notation.Value))
+		if notation.IsHumanReadable {
+			contents[0] = 0x80
+		}
+		binary.BigEndian.PutUint16(contents[4:6], uint16(len(notation.Name)))
+		binary.BigEndian.PutUint16(contents[6:8], uint16(len(notation.Value)))
+		contents = append(contents, notation.Name...)
+		contents = append(contents, notation.Value...)
+		subpackets = append(subpackets, outputSubpacket{true, notationDataSubpacket, notation.IsCritical, contents})
+	}
+
 	// Key flags may only appear in self-signatures or certification signatures.
 
 	if sig.FlagsValid {
@@ -851,6 +1430,18 @@ func (sig *Signature) buildSubpackets() (subpackets []outputSubpacket) {
 		subpackets = append(subpackets, outputSubpacket{true, keyExpirationSubpacket, true, keyLifetime})
 	}
 
+	if len(sig.KeyServerPrefs) > 0 {
+		subpackets = append(subpackets, outputSubpacket{true, keyServerPrefsSubpacket, false, sig.KeyServerPrefs})
+	}
+
+	if sig.PreferredKeyServer != "" {
+		subpackets = append(subpackets, outputSubpacket{true, prefKeyServerSubpacket, false, []byte(sig.PreferredKeyServer)})
+	}
+
+	if sig.PolicyURI != "" {
+		subpackets = append(subpackets, outputSubpacket{true, policyURISubpacket, false, []byte(sig.PolicyURI)})
+	}
+
 	if sig.IsPrimaryId != nil && *sig.IsPrimaryId {
 		subpackets = append(subpackets, outputSubpacket{true, primaryUserIdSubpacket, false, []byte{1}})
 	}
@@ -867,6 +1458,24 @@ func (sig *Signature) buildSubpackets() (subpackets []outputSubpacket) {
 		subpackets = append(subpackets, outputSubpacket{true, prefCompressionSubpacket, false, sig.PreferredCompression})
 	}
 
+	if len(sig.Features) > 0 {
+		subpackets = append(subpackets, outputSubpacket{true, featuresSubpacket, false, sig.Features})
+	}
+
+	// Revocation reasons only make sense on revocation signatures, which
+	// Sign enforces.
+	if sig.RevocationReason != nil {
+		reason := append([]byte{*sig.RevocationReason}, sig.RevocationReasonText...)
+		subpackets = append(subpackets, outputSubpacket{true, reasonForRevocationSubpacket, false, reason})
+	}
+
+	if target := sig.SignatureTarget; target != nil {
+		if hashId, ok := s2k.HashToHashId(target.Hash); ok {
+			contents := append([]byte{byte(target.PubKeyAlgo), hashId}, target.HashValue...)
+			subpackets = append(subpackets, outputSubpacket{true, signatureTargetSubpacket, false, contents})
+		}
+	}
+
 	if sig.EmbeddedSignature != nil {
 		buf := bytes.NewBuffer(nil)
 		if err := sig.EmbeddedSignature.Serialize(buf); err == nil {
@@ -896,6 +1505,9 @@ func (sig *Signature) GetKeyFlags() (ret KeyFlagBits) {
 	if sig.FlagEncryptStorage {
 		ret.BitField |= KeyFlagEncryptStorage
 	}
+	if sig.FlagAuthenticate {
+		ret.BitField |= KeyFlagAuthenticate
+	}
 	return ret
 }
 
@@ -915,6 +1527,10 @@ func (f *KeyFlagBits) HasFlagEncryptStorage() bool {
 	return f.BitField&KeyFlagEncryptStorage != 0
 }
 
+func (f *KeyFlagBits) HasFlagAuthenticate() bool {
+	return f.BitField&KeyFlagAuthenticate != 0
+}
+
 func (f *KeyFlagBits) Merge(other KeyFlagBits) {
 	if other.Valid {
 		f.Valid = true
@@ -7,10 +7,19 @@ package packet
 import (
 	"bytes"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
+	"hash"
+	"io"
+	"math/big"
 	"testing"
+	"time"
 
+	"github.com/keybase/go-crypto/ed25519"
 	"github.com/keybase/go-crypto/openpgp/errors"
+	"github.com/keybase/go-crypto/rsa"
 )
 
 func TestSignatureRead(t *testing.T) {
@@ -41,6 +50,198 @@ func TestSignatureReserialize(t *testing.T) {
 	}
 }
 
+func TestSignatureKeyServerPrefs(t *testing.T) {
+	packet, err := Read(readerFromHex(noModifySignatureHex))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := packet.(*Signature)
+	if !sig.HasFlagNoModify() {
+		t.Errorf("no-modify flag not set, key server preferences: %x", sig.KeyServerPrefs)
+	}
+
+	// The fixture uses an old format packet header, so only compare the
+	// bodies.
+	out := new(bytes.Buffer)
+	if err := sig.Serialize(out); err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := hex.DecodeString(noModifySignatureHex)
+	if !bytes.Equal(expected[2:], out.Bytes()[2:]) {
+		t.Errorf("output doesn't match input (got vs expected):\n%s\n%s", hex.Dump(out.Bytes()), hex.Dump(expected))
+	}
+
+	sig = &Signature{KeyServerPrefs: []byte{0}}
+	if sig.HasFlagNoModify() {
+		t.Error("no-modify flag set without the 0x80 bit")
+	}
+}
+
+func TestCandidateIssuers(t *testing.T) {
+	packet, _ := Read(readerFromHex(signatureDataHex))
+	sig := packet.(*Signature)
+	if ids := sig.CandidateIssuers(); len(ids) != 1 || ids[0] != 0xab105c91af38fb15 {
+		t.Errorf("got %x, want [ab105c91af38fb15]", ids)
+	}
+
+	fp, _ := hex.DecodeString("5fb74b1d03b1e3cb31bc2f8aab105c91af38fb15")
+	sig.IssuerFingerprint = fp
+	if ids := sig.CandidateIssuers(); len(ids) != 1 {
+		t.Errorf("got %x, want a single issuer", ids)
+	}
+
+	fp[19] ^= 1
+	if ids := sig.CandidateIssuers(); len(ids) != 2 || ids[1] != 0xab105c91af38fb14 {
+		t.Errorf("got %x, want both issuers", ids)
+	}
+
+	sig.IssuerKeyId = nil
+	if ids := sig.CandidateIssuers(); len(ids) != 1 || ids[0] != 0xab105c91af38fb14 {
+		t.Errorf("got %x, want only the fingerprint issuer", ids)
+	}
+
+	sig.IssuerFingerprint, _ = hex.DecodeString(eddsaV5FingerprintHex)
+	if ids := sig.CandidateIssuers(); len(ids) != 1 || ids[0] != 0xc62e1b43a65e5367 {
+		t.Errorf("got %x, want the leading key id of the version 5 fingerprint", ids)
+	}
+}
+
+func TestSignatureIssuerFingerprint(t *testing.T) {
+	_, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv := NewEdDSAPrivateKey(time.Now(), edPriv)
+	for _, fp := range [][]byte{priv.Fingerprint[:], bytes.Repeat([]byte{0xab}, 32)} {
+		sig := &Signature{
+			SigType:           SigTypeBinary,
+			PubKeyAlgo:        PubKeyAlgoEdDSA,
+			Hash:              crypto.SHA256,
+			CreationTime:      time.Now(),
+			IssuerFingerprint: fp,
+		}
+		if err := sig.Sign(crypto.SHA256.New(), priv, nil); err != nil {
+			t.Fatal(err)
+		}
+		buf := new(bytes.Buffer)
+		if err := sig.Serialize(buf); err != nil {
+			t.Fatal(err)
+		}
+		p, err := Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.(*Signature).IssuerFingerprint; !bytes.Equal(got, fp) {
+			t.Errorf("got issuer fingerprint %x, want %x", got, fp)
+		}
+	}
+}
+
+func TestSignatureTarget(t *testing.T) {
+	_, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv := NewEdDSAPrivateKey(time.Now(), edPriv)
+	target := &SignatureTarget{
+		PubKeyAlgo: PubKeyAlgoRSA,
+		Hash:       crypto.SHA256,
+		HashValue:  bytes.Repeat([]byte{0x5a}, 32),
+	}
+	sig := &Signature{
+		SigType:         SigTypeThirdPartyConfirmation,
+		PubKeyAlgo:      PubKeyAlgoEdDSA,
+		Hash:            crypto.SHA256,
+		CreationTime:    time.Now(),
+		SignatureTarget: target,
+	}
+	if err := sig.Sign(crypto.SHA256.New(), priv, nil); err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := sig.Serialize(buf); err != nil {
+		t.Fatal(err)
+	}
+	serialized := append([]byte{}, buf.Bytes()...)
+
+	p, err := Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := p.(*Signature).SignatureTarget
+	if got == nil || got.PubKeyAlgo != target.PubKeyAlgo || got.Hash != target.Hash || !bytes.Equal(got.HashValue, target.HashValue) {
+		t.Fatalf("got signature target %+v, want %+v", got, target)
+	}
+
+	buf.Reset()
+	if err := p.(*Signature).Serialize(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), serialized) {
+		t.Errorf("output doesn't match input (got vs expected):\n%s\n%s", hex.Dump(buf.Bytes()), hex.Dump(serialized))
+	}
+}
+
+func TestSignatureRawSubpackets(t *testing.T) {
+	p, err := Read(readerFromHex(signatureDataHex))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashed, unhashed := p.(*Signature).RawSubpackets()
+	if len(hashed) != 1 || hashed[0].Type != 2 || hashed[0].Critical || hex.EncodeToString(hashed[0].Contents) != "4cb45112" {
+		t.Errorf("got hashed subpackets %+v, want only the creation time", hashed)
+	}
+	if len(unhashed) != 1 || unhashed[0].Type != 16 || unhashed[0].Critical || hex.EncodeToString(unhashed[0].Contents) != "ab105c91af38fb15" {
+		t.Errorf("got unhashed subpackets %+v, want only the issuer", unhashed)
+	}
+
+	// A critical subpacket of unknown type 100 makes parsing fail, but
+	// the partly parsed signature shows it.
+	p, err = Read(readerFromHex("881604130108000905024cb4511202e4ab00000000000801"))
+	if _, ok := err.(errors.UnsupportedError); !ok {
+		t.Fatalf("got %v, want an UnsupportedError", err)
+	}
+	hashed, _ = p.(*Signature).RawSubpackets()
+	if len(hashed) != 2 || hashed[1].Type != 100 || !hashed[1].Critical || !bytes.Equal(hashed[1].Contents, []byte{0xab}) {
+		t.Errorf("got hashed subpackets %+v, want the unknown critical one last", hashed)
+	}
+}
+
+func TestIsSelfSignature(t *testing.T) {
+	p, _ := Read(readerFromHex(signatureDataHex))
+	sig := p.(*Signature)
+
+	pk := new(PublicKey)
+	fp, _ := hex.DecodeString("5fb74b1d03b1e3cb31bc2f8aab105c91af38fb15")
+	copy(pk.Fingerprint[:], fp)
+	pk.KeyId = 0xab105c91af38fb15
+	other := &PublicKey{KeyId: 0xa34d7e18c20c31bb}
+
+	if !sig.IsSelfSignature(pk) {
+		t.Error("issuer key id not matched")
+	}
+	if sig.IsSelfSignature(other) {
+		t.Error("other key matched")
+	}
+
+	sig.IssuerFingerprint = fp
+	if !sig.IsSelfSignature(pk) {
+		t.Error("issuer key id and fingerprint not matched")
+	}
+	sig.IssuerKeyId = nil
+	if !sig.IsSelfSignature(pk) {
+		t.Error("issuer fingerprint not matched")
+	}
+	sig.IssuerFingerprint = other.Fingerprint[:]
+	if sig.IsSelfSignature(pk) {
+		t.Error("mismatched fingerprint matched")
+	}
+	sig.IssuerFingerprint = nil
+	if sig.IsSelfSignature(pk) {
+		t.Error("signature without issuer matched")
+	}
+}
+
 func TestSignWithNilPrivateKey(t *testing.T) {
 	sig := new(Signature)
 	hash := crypto.SHA256.New()
@@ -71,4 +272,276 @@ func TestSignWithNilPrivateKey(t *testing.T) {
 	}
 }
 
+func TestSignRevocationReason(t *testing.T) {
+	rsaPriv, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv := NewRSAPrivateKey(time.Now(), rsaPriv)
+	reason := uint8(KeyRetired)
+	for _, test := range []struct {
+		sigType SignatureType
+		ok      bool
+	}{
+		{SigTypeBinary, false},
+		{SigTypePositiveCert, false},
+		{SigTypeKeyRevocation, true},
+		{SigTypeSubkeyRevocation, true},
+		{SigTypeIdentityRevocation, true},
+	} {
+		sig := &Signature{
+			SigType:          test.sigType,
+			PubKeyAlgo:       PubKeyAlgoRSA,
+			Hash:             crypto.SHA256,
+			CreationTime:     time.Now(),
+			RevocationReason: &reason,
+		}
+		err := sig.Sign(crypto.SHA256.New(), priv, nil)
+		if test.ok && err != nil {
+			t.Errorf("signature type %#x: %s", test.sigType, err)
+		}
+		if _, isInvalidArgumentError := err.(errors.InvalidArgumentError); !test.ok && !isInvalidArgumentError {
+			t.Errorf("signature type %#x: got %v, want InvalidArgumentError", test.sigType, err)
+		}
+	}
+}
+
+func TestSignatureDigest(t *testing.T) {
+	// A data signature.
+	priv := NewRSAPrivateKey(time.Unix(1500000000, 0), encryptedKeyRSAPriv)
+	data := []byte("signed data")
+	sig := &Signature{
+		SigType:      SigTypeBinary,
+		PubKeyAlgo:   PubKeyAlgoRSA,
+		Hash:         crypto.SHA256,
+		CreationTime: time.Unix(1500000000, 0),
+	}
+	h := crypto.SHA256.New()
+	h.Write(data)
+	if err := sig.Sign(h, priv, nil); err != nil {
+		t.Fatal(err)
+	}
+	h, err := sig.PrepareVerify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Write(data)
+	digest := sig.Digest(h)
+	expected := sha256.Sum256(append(append([]byte{}, data...), sig.HashSuffix...))
+	if !bytes.Equal(digest, expected[:]) {
+		t.Errorf("data signature: got digest %x, want %x", digest, expected)
+	}
+	if err := rsa.VerifyPKCS1v15(&encryptedKeyPub, crypto.SHA256, digest, sig.RSASignature.bytes); err != nil {
+		t.Errorf("data signature: digest doesn't verify: %s", err)
+	}
+
+	// The user id self-signature and the subkey binding signature of an
+	// ECDSA key.
+	r := readerFromHex(ecc384PubHex)
+	var packets []Packet
+	for {
+		p, err := Read(r)
+		if err != nil {
+			break
+		}
+		packets = append(packets, p)
+	}
+	if len(packets) != 5 {
+		t.Fatalf("got %d packets, want 5", len(packets))
+	}
+	pk := packets[0].(*PublicKey)
+	uid := packets[1].(*UserId)
+	subkey := packets[3].(*PublicKey)
+
+	serializeKey := func(buf *bytes.Buffer, key *PublicKey) {
+		key.SerializeSignaturePrefix(buf)
+		key.serializeWithoutHeaders(buf)
+	}
+	signed := new(bytes.Buffer)
+	serializeKey(signed, pk)
+	signed.Write([]byte{0xb4, 0, 0, 0, byte(len(uid.Id))})
+	signed.WriteString(uid.Id)
+	uidSig := packets[2].(*Signature)
+	if h, err = uidSig.PrepareVerifyUserId(uid.Id, pk); err != nil {
+		t.Fatal(err)
+	}
+	testECDSADigest(t, "self-signature", pk, uidSig, h, signed.Bytes())
+
+	signed.Reset()
+	serializeKey(signed, pk)
+	serializeKey(signed, subkey)
+	bindingSig := packets[4].(*Signature)
+	if h, err = bindingSig.PrepareVerifyKey(pk, subkey); err != nil {
+		t.Fatal(err)
+	}
+	testECDSADigest(t, "binding signature", pk, bindingSig, h, signed.Bytes())
+}
+
+// testECDSADigest checks that the digest of h matches the hash of signed and
+// sig's trailer, and that the signature verifies over it.
+func testECDSADigest(t *testing.T, which string, pk *PublicKey, sig *Signature, h hash.Hash, signed []byte) {
+	digest := sig.Digest(h)
+	expected := sig.Hash.New()
+	expected.Write(signed)
+	expected.Write(sig.HashSuffix)
+	if !bytes.Equal(digest, expected.Sum(nil)) {
+		t.Errorf("%s: got digest %x, want %x", which, digest, expected.Sum(nil))
+	}
+	if !bytes.Equal(digest[:2], sig.HashTag[:]) {
+		t.Errorf("%s: digest %x doesn't match hash tag %x", which, digest, sig.HashTag)
+	}
+	r := new(big.Int).SetBytes(sig.ECDSASigR.bytes)
+	s := new(big.Int).SetBytes(sig.ECDSASigS.bytes)
+	if !ecdsa.Verify(pk.PublicKey.(*ecdsa.PublicKey), digest, r, s) {
+		t.Errorf("%s: digest doesn't verify", which)
+	}
+}
+
 const signatureDataHex = "c2c05c04000102000605024cb45112000a0910ab105c91af38fb158f8d07ff5596ea368c5efe015bed6e78348c0f033c931d5f2ce5db54ce7f2a7e4b4ad64db758d65a7a71773edeab7ba2a9e0908e6a94a1175edd86c1d843279f045b021a6971a72702fcbd650efc393c5474d5b59a15f96d2eaad4c4c426797e0dcca2803ef41c6ff234d403eec38f31d610c344c06f2401c262f0993b2e66cad8a81ebc4322c723e0d4ba09fe917e8777658307ad8329adacba821420741009dfe87f007759f0982275d028a392c6ed983a0d846f890b36148c7358bdb8a516007fac760261ecd06076813831a36d0459075d1befa245ae7f7fb103d92ca759e9498fe60ef8078a39a3beda510deea251ea9f0a7f0df6ef42060f20780360686f3e400e"
+
+// noModifySignatureHex is a GnuPG generated user ID self-signature that sets
+// the no-modify key server preference.
+const noModifySignatureHex = "889604131608003e1621045fbc31d6a272bbe85e63c8342e545dd2191d2db205026ad05333021b03050903c26700050b0908070206150a09080b020416020301021e01021780000a09102e545dd2191d2db2fff400ff782d74159352b4bfb3ca5bc2dc16ad5ff5ecb9417d015462e34eb3582ec3ffd900ff65de333129323ee45eb737dfa9197b448d9351849375bf66a6bd13bed5e31808"
+
+func TestSignatureV6Keys(t *testing.T) {
+	rsaPriv, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv := NewRSAPrivateKey(time.Now(), rsaPriv)
+	newSig := func() *Signature {
+		return &Signature{
+			Version:      6,
+			SigType:      SigTypeBinary,
+			PubKeyAlgo:   PubKeyAlgoRSA,
+			Hash:         crypto.SHA256,
+			CreationTime: time.Now(),
+		}
+	}
+
+	sig := newSig()
+	h := crypto.SHA256.New()
+	if err := sig.PrepareHash(h, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := sig.Sign(h, priv, nil); err == nil {
+		t.Error("made a version 6 signature with a version 4 key")
+	}
+
+	v4Pub := priv.PublicKey
+	priv.PublicKey.Version = 6
+	priv.PublicKey.setFingerPrintAndKeyId()
+	sig = newSig()
+	h = crypto.SHA256.New()
+	if err := sig.PrepareHash(h, nil); err != nil {
+		t.Fatal(err)
+	}
+	h.Write([]byte("hello"))
+	if err := sig.Sign(h, priv, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	verify := func(pk *PublicKey) error {
+		h, err := sig.PrepareVerify()
+		if err != nil {
+			return err
+		}
+		h.Write([]byte("hello"))
+		return pk.VerifySignature(h, sig)
+	}
+	if err := verify(&priv.PublicKey); err != nil {
+		t.Errorf("error verifying with the version 6 key: %s", err)
+	}
+	if err := verify(&v4Pub); err == nil {
+		t.Error("version 6 signature verified with a version 4 key")
+	}
+}
+
+// rawKey is a signingKey for a serialized key packet body of a version 6
+// key, which lets the binding signature of the RFC 9580 sample certificate
+// be checked without parsing its X25519 subkey.
+type rawKey []byte
+
+func (k rawKey) SerializeSignaturePrefix(w io.Writer) {
+	w.Write([]byte{0x9b, byte(len(k) >> 24), byte(len(k) >> 16), byte(len(k) >> 8), byte(len(k))})
+}
+
+func (k rawKey) serializeWithoutHeaders(w io.Writer) error {
+	_, err := w.Write(k)
+	return err
+}
+
+func TestSignatureV6SampleCertificate(t *testing.T) {
+	// The sample version 6 certificate of RFC 9580, appendix A.3: an
+	// Ed25519 primary key with a direct key signature and an X25519
+	// subkey with its binding signature.
+	cert, _ := hex.DecodeString(v6SampleCertificateHex)
+	var packets [][]byte
+	for len(cert) > 0 {
+		n := 2 + int(cert[1])
+		packets = append(packets, cert[:n])
+		cert = cert[n:]
+	}
+	if len(packets) != 4 {
+		t.Fatalf("got %d packets, want 4", len(packets))
+	}
+
+	p, err := Read(bytes.NewReader(packets[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk := p.(*PublicKey)
+	const fingerprint = "cb186c4f0609a697e4d52dfa6c722b0c1f1e27c18a56708f6525ec27bad9acc9"
+	if pk.Version != 6 || pk.PubKeyAlgo != PubKeyAlgoEd25519 || hex.EncodeToString(pk.FullFingerprint()) != fingerprint {
+		t.Fatalf("got version %d, algorithm %d, fingerprint %x", pk.Version, pk.PubKeyAlgo, pk.FullFingerprint())
+	}
+
+	for i, signed := range []signingKey{nil, rawKey(packets[2][2:])} {
+		packet := packets[2*i+1]
+		p, err := Read(bytes.NewReader(packet))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig := p.(*Signature)
+		if sig.Version != 6 || len(sig.Salt) != 32 {
+			t.Fatalf("signature %d: got version %d with a %d byte salt", i, sig.Version, len(sig.Salt))
+		}
+
+		signedHash := func() hash.Hash {
+			var h hash.Hash
+			if signed == nil {
+				h, err = keyRevocationHash(pk, sig.Hash, sig.Salt)
+			} else {
+				h, err = keySignatureHash(pk, signed, sig.Hash, sig.Salt)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			return h
+		}
+		if got := sig.Digest(signedHash())[:2]; !bytes.Equal(got, sig.HashTag[:]) {
+			t.Errorf("signature %d: digest starts with %x, want hash tag %x", i, got, sig.HashTag)
+		}
+		if err := pk.VerifySignature(signedHash(), sig); err != nil {
+			t.Errorf("signature %d: %s", i, err)
+		}
+
+		buf := new(bytes.Buffer)
+		if err := sig.Serialize(buf); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), packet) {
+			t.Errorf("signature %d: got %x after a round trip, want %x", i, buf.Bytes(), packet)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	if err := pk.Serialize(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), packets[0]) {
+		t.Errorf("got key %x after a round trip, want %x", buf.Bytes(), packets[0])
+	}
+}
+
+const v6SampleCertificateHex = "c62a0663877fe31b00000020f94da7bb48d60a61e567706a6587d0331999bb9d891a08242ead84543df895a3c2b1061f1b0a00000042058263877fe3030b090705150a0e080c021600029b03021e09222106cb186c4f0609a697e4d52dfa6c722b0c1f1e27c18a56708f6525ec27bad9acc905270902070200000000ad2820103e2d7d227ec0e6d7ce4471db36bfc97083253690271498a7ef0576c07faae14585b3b903b0127ec4fda2f023045a2ec76bcb4f9571a9651e14aee1137a1d668442c88f951e33c4ffd33fb9a17d511eed758fc6d9cc50cb5fd793b2039d5804ce2a0663877fe319000000208693248367f9e5015db922f8f48095dda784987f2d5985b12fbad16caf5e4435c29b06181b0a0000002c058263877fe3029b0c222106cb186c4f0609a697e4d52dfa6c722b0c1f1e27c18a56708f6525ec27bad9acc900000000040120a6e9186d9d5935fc8fe56314cdb527486a5a5120f9b762a235a729f039010a56516b673700c4334835daf631a1633c63cd56f9b1c1c3cd3923c9165645d4eaf14e8be1d6beffe2adaee87c9bb5e8d9e852485c96452b934997b9b66fca5e0606"
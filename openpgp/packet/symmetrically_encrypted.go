@@ -19,12 +19,26 @@ import (
 // encrypted contents will consist of more OpenPGP packets. See RFC 4880,
 // sections 5.7 and 5.13.
 type SymmetricallyEncrypted struct {
-	MDC      bool // true iff this is a type 18 packet and thus has an embedded MAC.
+	MDC     bool // true iff this is a type 18 packet and thus has an embedded MAC.
+	Version int  // the version of a type 18 packet: 1, or 2 for AEAD protection.
+
+	// The following fields are only used by version 2 packets, which are
+	// protected with AEAD rather than an MDC. See RFC 9580, section 5.13.2.
+	Cipher        CipherFunction
+	Mode          AEADMode
+	ChunkSizeByte byte // the chunk size is 2^(ChunkSizeByte+6) bytes.
+	Salt          []byte
+
 	contents io.Reader
 	prefix   []byte
 }
 
-const symmetricallyEncryptedVersion = 1
+const (
+	symmetricallyEncryptedVersion     = 1
+	symmetricallyEncryptedVersionAEAD = 2
+
+	symmetricallyEncryptedSaltSize = 32
+)
 
 func (se *SymmetricallyEncrypted) parse(r io.Reader) error {
 	if se.MDC {
@@ -34,7 +48,14 @@ func (se *SymmetricallyEncrypted) parse(r io.Reader) error {
 		if err != nil {
 			return err
 		}
-		if buf[0] != symmetricallyEncryptedVersion {
+		se.Version = int(buf[0])
+		switch se.Version {
+		case symmetricallyEncryptedVersion:
+		case symmetricallyEncryptedVersionAEAD:
+			if err := se.parseAEADHeader(r); err != nil {
+				return err
+			}
+		default:
 			return errors.UnsupportedError("unknown SymmetricallyEncrypted version")
 		}
 	}
@@ -42,10 +63,43 @@ func (se *SymmetricallyEncrypted) parse(r io.Reader) error {
 	return nil
 }
 
+// parseAEADHeader reads the cipher, AEAD mode, chunk size and salt of a
+// version 2 packet.
+func (se *SymmetricallyEncrypted) parseAEADHeader(r io.Reader) error {
+	var buf [3]byte
+	if _, err := readFull(r, buf[:]); err != nil {
+		return err
+	}
+	se.Cipher = CipherFunction(buf[0])
+	se.Mode = AEADMode(buf[1])
+	se.ChunkSizeByte = buf[2]
+	if se.Cipher.blockSize() != 16 {
+		return errors.UnsupportedError("unsupported AEAD cipher: " + strconv.Itoa(int(se.Cipher)))
+	}
+	if se.Mode.NonceLength() == 0 {
+		return errors.UnsupportedError("unsupported AEAD mode: " + strconv.Itoa(int(se.Mode)))
+	}
+	if se.ChunkSizeByte > maxChunkSizeByte {
+		return errors.UnsupportedError("AEAD chunk size too large: " + strconv.Itoa(int(se.ChunkSizeByte)))
+	}
+	se.Salt = make([]byte, symmetricallyEncryptedSaltSize)
+	_, err := readFull(r, se.Salt)
+	return err
+}
+
 // Decrypt returns a ReadCloser, from which the decrypted contents of the
 // packet can be read. An incorrect key can, with high probability, be detected
 // immediately and this will result in a KeyIncorrect error being returned.
+// Version 2 packets name their cipher in the packet header, so c is ignored
+// for them.
 func (se *SymmetricallyEncrypted) Decrypt(c CipherFunction, key []byte) (io.ReadCloser, error) {
+	if se.Version == symmetricallyEncryptedVersionAEAD {
+		if len(key) != se.Cipher.KeySize() {
+			return nil, errors.ErrKeyIncorrect
+		}
+		return newAEADDecrypter(newAEADCrypterV2(se.Cipher, se.Mode, se.ChunkSizeByte, key, se.Salt), &se.contents)
+	}
+
 	keySize := c.KeySize()
 	if keySize == 0 {
 		return nil, errors.UnsupportedError("unknown cipher: " + strconv.Itoa(int(c)))
@@ -145,6 +199,7 @@ func (ser *seMDCReader) Read(buf []byte) (n int, err error) {
 		}
 
 		if err != nil {
+			ser.error = err == io.ErrUnexpectedEOF
 			n = 0
 			return
 		}
@@ -153,25 +208,30 @@ func (ser *seMDCReader) Read(buf []byte) (n int, err error) {
 	// If it's a short read then we read into a temporary buffer and shift
 	// the data into the caller's buffer.
 	if len(buf) <= mdcTrailerSize {
-		n, err = readFull(ser.in, ser.scratch[:len(buf)])
+		// This doesn't use readFull as it has to tell a clean EOF from
+		// a truncated packet.
+		n = 0
+		for n < len(buf) && err == nil {
+			var m int
+			m, err = ser.in.Read(ser.scratch[n:len(buf)])
+			n += m
+		}
 		copy(buf, ser.trailer[:n])
 		ser.h.Write(buf[:n])
 		copy(ser.trailer[:], ser.trailer[n:])
 		copy(ser.trailer[mdcTrailerSize-n:], ser.scratch[:])
-		if n < len(buf) {
-			ser.eof = true
-			err = io.EOF
-		}
-		return
+	} else {
+		n, err = ser.in.Read(buf[mdcTrailerSize:])
+		copy(buf, ser.trailer[:])
+		ser.h.Write(buf[:n])
+		copy(ser.trailer[:], buf[n:])
 	}
 
-	n, err = ser.in.Read(buf[mdcTrailerSize:])
-	copy(buf, ser.trailer[:])
-	ser.h.Write(buf[:n])
-	copy(ser.trailer[:], buf[n:])
-
-	if err == io.EOF {
+	switch err {
+	case io.EOF:
 		ser.eof = true
+	case io.ErrUnexpectedEOF:
+		ser.error = true
 	}
 	return
 }
@@ -179,9 +239,11 @@ func (ser *seMDCReader) Read(buf []byte) (n int, err error) {
 // This is a new-format packet tag byte for a type 19 (MDC) packet.
 const mdcPacketTagByte = byte(0x80) | 0x40 | 19
 
+// Close checks the MDC. A message that ends before the MDC packet, so that
+// the contents read may be incomplete, results in errors.ErrTruncated.
 func (ser *seMDCReader) Close() error {
 	if ser.error {
-		return errors.SignatureError("error during reading")
+		return errors.ErrTruncated
 	}
 
 	for !ser.eof {
@@ -191,6 +253,9 @@ func (ser *seMDCReader) Close() error {
 		if err == io.EOF {
 			break
 		}
+		if err == io.ErrUnexpectedEOF {
+			return errors.ErrTruncated
+		}
 		if err != nil {
 			return errors.SignatureError("error during reading")
 		}
@@ -252,13 +317,16 @@ func (c noOpCloser) Close() error {
 
 // SerializeSymmetricallyEncrypted serializes a symmetrically encrypted packet
 // to w and returns a WriteCloser to which the to-be-encrypted packets can be
-// written.
+// written. The packet carries an MDC, unless config.DisableMDC is set.
 // If config is nil, sensible defaults will be used.
 func SerializeSymmetricallyEncrypted(w io.Writer, c CipherFunction, key []byte, config *Config) (contents io.WriteCloser, err error) {
 	if c.KeySize() != len(key) {
 		return nil, errors.InvalidArgumentError("SymmetricallyEncrypted.Serialize: bad key length")
 	}
 	writeCloser := noOpCloser{w}
+	if config.MDCDisabled() {
+		return serializeSymmetricallyEncryptedNoMDC(writeCloser, c, key, config)
+	}
 	ciphertext, err := serializeStreamHeader(writeCloser, packetTypeSymmetricallyEncryptedMDC)
 	if err != nil {
 		return
@@ -289,3 +357,69 @@ func SerializeSymmetricallyEncrypted(w io.Writer, c CipherFunction, key []byte,
 	contents = &seMDCWriter{w: plaintext, h: h}
 	return
 }
+
+// serializeSymmetricallyEncryptedNoMDC writes the header of a type 9 packet,
+// which has no version byte and uses OCFB mode with resynchronization, and
+// returns a WriteCloser for its contents.
+func serializeSymmetricallyEncryptedNoMDC(w io.WriteCloser, c CipherFunction, key []byte, config *Config) (contents io.WriteCloser, err error) {
+	ciphertext, err := serializeStreamHeader(w, packetTypeSymmetricallyEncrypted)
+	if err != nil {
+		return
+	}
+
+	iv := make([]byte, c.blockSize())
+	_, err = config.Random().Read(iv)
+	if err != nil {
+		return
+	}
+	s, prefix := NewOCFBEncrypter(c.new(key), iv, OCFBResync)
+	_, err = ciphertext.Write(prefix)
+	if err != nil {
+		return
+	}
+	return cipher.StreamWriter{S: s, W: ciphertext}, nil
+}
+
+// SerializeSymmetricallyEncryptedV2 serializes a version 2 symmetrically
+// encrypted packet, which is protected with AEAD, to w and returns a
+// WriteCloser to which the to-be-encrypted packets can be written. The chunk
+// size is 2^(chunkSizeByte+6) bytes. See RFC 9580, section 5.13.2.
+// If config is nil, sensible defaults will be used.
+func SerializeSymmetricallyEncryptedV2(w io.Writer, c CipherFunction, mode AEADMode, chunkSizeByte byte, key []byte, config *Config) (contents io.WriteCloser, err error) {
+	if c.KeySize() != len(key) {
+		return nil, errors.InvalidArgumentError("SymmetricallyEncrypted.Serialize: bad key length")
+	}
+	if c.blockSize() != 16 {
+		return nil, errors.InvalidArgumentError("SymmetricallyEncrypted.Serialize: cipher must have a 16-byte block size")
+	}
+	if mode.NonceLength() == 0 {
+		return nil, errors.UnsupportedError("unsupported AEAD mode: " + strconv.Itoa(int(mode)))
+	}
+	if chunkSizeByte > maxChunkSizeByte {
+		return nil, errors.InvalidArgumentError("SymmetricallyEncrypted.Serialize: chunk size too large")
+	}
+
+	ciphertext, err := serializeStreamHeader(noOpCloser{w}, packetTypeSymmetricallyEncryptedMDC)
+	if err != nil {
+		return
+	}
+
+	salt := make([]byte, symmetricallyEncryptedSaltSize)
+	if _, err = io.ReadFull(config.Random(), salt); err != nil {
+		return
+	}
+	header := []byte{symmetricallyEncryptedVersionAEAD, byte(c), byte(mode), chunkSizeByte}
+	if _, err = ciphertext.Write(header); err != nil {
+		return
+	}
+	if _, err = ciphertext.Write(salt); err != nil {
+		return
+	}
+
+	aw := &aeadEncrypter{
+		aeadCrypter: newAEADCrypterV2(c, mode, chunkSizeByte, key, salt),
+		w:           ciphertext,
+	}
+	aw.plaintext = make([]byte, 0, aw.chunkSize)
+	return aw, nil
+}
@@ -76,6 +76,25 @@ func testMDCReader(t *testing.T) {
 	}
 }
 
+func TestMDCReaderTruncated(t *testing.T) {
+	mdcPlaintext, _ := hex.DecodeString(mdcPlaintextHex)
+
+	for _, cut := range []int{1, 21, 22, 23, 40} {
+		for stride := 1; stride < len(mdcPlaintext)/2; stride++ {
+			// A spanReader returns io.ErrUnexpectedEOF for the
+			// missing bytes, like the body of a truncated packet.
+			r := &spanReader{&testReader{data: mdcPlaintext[:len(mdcPlaintext)-cut], stride: stride}, int64(len(mdcPlaintext))}
+			mdcReader := &seMDCReader{in: r, h: sha1.New()}
+			if _, err := ioutil.ReadAll(mdcReader); err != io.ErrUnexpectedEOF {
+				t.Errorf("cut: %d, stride: %d: expected io.ErrUnexpectedEOF, got: %v", cut, stride, err)
+			}
+			if err := mdcReader.Close(); err != errors.ErrTruncated {
+				t.Errorf("cut: %d, stride: %d: expected ErrTruncated on Close, got: %v", cut, stride, err)
+			}
+		}
+	}
+}
+
 const mdcPlaintextHex = "a302789c3b2d93c4e0eb9aba22283539b3203335af44a134afb800c849cb4c4de10200aff40b45d31432c80cb384299a0655966d6939dfdeed1dddf980"
 
 func TestSerialize(t *testing.T) {
@@ -122,3 +141,183 @@ func TestSerialize(t *testing.T) {
 		t.Errorf("contents not equal got: %x want: %x", contentsCopy.Bytes(), contents)
 	}
 }
+
+func TestSerializeNoMDC(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	c := CipherAES128
+	key := make([]byte, c.KeySize())
+
+	w, err := SerializeSymmetricallyEncrypted(buf, c, key, &Config{DisableMDC: true})
+	if err != nil {
+		t.Fatalf("error from SerializeSymmetricallyEncrypted: %s", err)
+	}
+	contents := []byte("hello world\n")
+	w.Write(contents)
+	w.Close()
+
+	if tag := packetType(buf.Bytes()[0] & 0x3f); tag != packetTypeSymmetricallyEncrypted {
+		t.Fatalf("got packet type %d, want %d", tag, packetTypeSymmetricallyEncrypted)
+	}
+	p, err := Read(buf)
+	if err != nil {
+		t.Fatalf("error from Read: %s", err)
+	}
+	se, ok := p.(*SymmetricallyEncrypted)
+	if !ok || se.MDC {
+		t.Fatalf("didn't read a *SymmetricallyEncrypted without MDC")
+	}
+	r, err := se.Decrypt(c, key)
+	if err != nil {
+		t.Fatalf("error from Decrypt: %s", err)
+	}
+	contentsCopy, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("error reading contents: %s", err)
+	}
+	if !bytes.Equal(contentsCopy, contents) {
+		t.Errorf("contents not equal got: %x want: %x", contentsCopy, contents)
+	}
+}
+
+func TestSerializeV2(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	contents := make([]byte, 200)
+	for i := range contents {
+		contents[i] = byte(i)
+	}
+
+	for _, mode := range []AEADMode{AEADModeEAX, AEADModeOCB} {
+		buf := bytes.NewBuffer(nil)
+		// 64-byte chunks, so that the contents span several of them.
+		w, err := SerializeSymmetricallyEncryptedV2(buf, CipherAES128, mode, 0, key, nil)
+		if err != nil {
+			t.Fatalf("mode %d: error from SerializeSymmetricallyEncryptedV2: %s", mode, err)
+		}
+		w.Write(contents)
+		w.Close()
+		ciphertext := buf.Bytes()
+
+		p, err := Read(bytes.NewReader(ciphertext))
+		if err != nil {
+			t.Fatalf("mode %d: error from Read: %s", mode, err)
+		}
+		se, ok := p.(*SymmetricallyEncrypted)
+		if !ok || !se.MDC || se.Version != 2 || se.Cipher != CipherAES128 || se.Mode != mode {
+			t.Fatalf("mode %d: didn't read a version 2 *SymmetricallyEncrypted: %#v", mode, p)
+		}
+		if _, err := se.Decrypt(CipherAES128, []byte("fedcba9876543210")); err != errors.ErrKeyIncorrect {
+			t.Errorf("mode %d: wrong key: expected ErrKeyIncorrect, got %v", mode, err)
+		}
+		// The cipher comes from the packet, so the one given is ignored.
+		r, err := se.Decrypt(CipherCAST5, key)
+		if err != nil {
+			t.Fatalf("mode %d: error from Decrypt: %s", mode, err)
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("mode %d: error reading contents: %s", mode, err)
+		}
+		if !bytes.Equal(got, contents) {
+			t.Errorf("mode %d: contents not equal got: %x want: %x", mode, got, contents)
+		}
+
+		corrupt := append([]byte{}, ciphertext...)
+		corrupt[len(corrupt)-30] ^= 1
+		p, _ = Read(bytes.NewReader(corrupt))
+		r, err = p.(*SymmetricallyEncrypted).Decrypt(CipherAES128, key)
+		if err == nil {
+			_, err = ioutil.ReadAll(r)
+		}
+		if _, ok := err.(errors.SignatureError); !ok {
+			t.Errorf("mode %d: corruption: expected SignatureError, got %v", mode, err)
+		}
+	}
+}
+
+// The samples of RFC 9580, appendices A.9 and A.10, encrypt a literal data
+// packet holding "Hello, world!", followed by a padding packet, with AES-128.
+const sampleV2LiteralHex = "cb1362000000000048656c6c6f2c20776f726c6421"
+
+// sampleV2EAXHex is the AEAD-EAX sample packet, from appendix A.9.4, and
+// sampleV2EAXKeyHex its session key.
+const sampleV2EAXHex = "d26902070106" +
+	"9ff90e3b321964f3a42913c8dcc6619325015227efb7eaeaa49f04c2e674175d" +
+	"4a3d226ed6afcb9ca9ac122c1470e11c63d4c0ab241c6a938ad48bf99a5a99b9" +
+	"0bba8325de61047540258ab7959a95ad051dda96eb15431dfef5f5e2255ca782" +
+	"61546e339a"
+const sampleV2EAXKeyHex = "3881bafe985412459b86c36f98cb9a5e"
+
+func TestDecryptV2Sample(t *testing.T) {
+	sample, _ := hex.DecodeString(sampleV2EAXHex)
+	key, _ := hex.DecodeString(sampleV2EAXKeyHex)
+	p, err := Read(bytes.NewReader(sample))
+	if err != nil {
+		t.Fatalf("error from Read: %s", err)
+	}
+	se, ok := p.(*SymmetricallyEncrypted)
+	if !ok || se.Version != 2 || se.Cipher != CipherAES128 || se.Mode != AEADModeEAX || se.ChunkSizeByte != 6 {
+		t.Fatalf("didn't read the sample version 2 *SymmetricallyEncrypted: %#v", p)
+	}
+	if !bytes.Equal(se.Salt, sample[6:38]) {
+		t.Errorf("bad salt got: %x want: %x", se.Salt, sample[6:38])
+	}
+	r, err := se.Decrypt(CipherAES128, key)
+	if err != nil {
+		t.Fatalf("error from Decrypt: %s", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("error reading contents: %s", err)
+	}
+	literal, _ := hex.DecodeString(sampleV2LiteralHex)
+	if !bytes.HasPrefix(got, literal) || len(got) != len(literal)+16 || got[len(literal)] != 0xd5 {
+		t.Errorf("bad contents got: %x want: %x followed by a padding packet", got, literal)
+	}
+}
+
+func TestSerializeV2Sample(t *testing.T) {
+	// The AEAD-OCB sample of appendix A.10.4: its session key, salt and
+	// first block of ciphertext. The sample's padding packet isn't
+	// reproduced here, so only the first block, which holds literal
+	// data, encrypts the same.
+	key, _ := hex.DecodeString("28e79ab82397d3c63de24ac217d7b791")
+	salt, _ := hex.DecodeString("20a661f731fc9a3032b5623326027e3a5d8db5748ebeff0b0c5910d09ecdd641")
+	firstBlock, _ := hex.DecodeString("ff9fd38562758035bc49754ce1bf3fff")
+	literal, _ := hex.DecodeString(sampleV2LiteralHex)
+
+	buf := new(bytes.Buffer)
+	config := &Config{Rand: bytes.NewReader(salt)}
+	w, err := SerializeSymmetricallyEncryptedV2(buf, CipherAES128, AEADModeOCB, 6, key, config)
+	if err != nil {
+		t.Fatalf("error from SerializeSymmetricallyEncryptedV2: %s", err)
+	}
+	w.Write(literal)
+	w.Close()
+
+	i := bytes.Index(buf.Bytes(), salt)
+	if i < 4 {
+		t.Fatalf("salt not found in %x", buf.Bytes())
+	}
+	if header := buf.Bytes()[i-4 : i]; !bytes.Equal(header, []byte{2, 7, 2, 6}) {
+		t.Errorf("bad header got: %x want: 02070206", header)
+	}
+	if got := buf.Bytes()[i+len(salt):]; !bytes.HasPrefix(got, firstBlock) {
+		t.Errorf("bad ciphertext got: %x want: %x...", got, firstBlock)
+	}
+
+	p, err := Read(buf)
+	if err != nil {
+		t.Fatalf("error from Read: %s", err)
+	}
+	r, err := p.(*SymmetricallyEncrypted).Decrypt(CipherAES128, key)
+	if err != nil {
+		t.Fatalf("error from Decrypt: %s", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("error reading contents: %s", err)
+	}
+	if !bytes.Equal(got, literal) {
+		t.Errorf("contents not equal got: %x want: %x", got, literal)
+	}
+}
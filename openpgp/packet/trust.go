@@ -0,0 +1,32 @@
+// Copyright 2012 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"io"
+	"io/ioutil"
+)
+
+// Trust represents a Trust packet. Implementations like GnuPG keep these in
+// local keyrings, after keys, user ids and signatures, to cache trust
+// computations. Their contents are implementation defined and they should
+// not be sent to others. See RFC 4880, section 5.10.
+type Trust struct {
+	Contents []byte
+}
+
+func (t *Trust) parse(r io.Reader) (err error) {
+	t.Contents, err = ioutil.ReadAll(r)
+	return
+}
+
+// Serialize marshals t to w, including the packet header.
+func (t *Trust) Serialize(w io.Writer) (err error) {
+	err = serializeHeader(w, packetTypeTrust, len(t.Contents))
+	if err == nil {
+		_, err = w.Write(t.Contents)
+	}
+	return
+}
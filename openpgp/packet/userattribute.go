@@ -10,6 +10,8 @@ import (
 	"image/jpeg"
 	"io"
 	"io/ioutil"
+
+	"github.com/keybase/go-crypto/openpgp/errors"
 )
 
 const UserAttrImageSubpacket = 1
@@ -28,15 +30,7 @@ func NewUserAttributePhoto(photos ...image.Image) (uat *UserAttribute, err error
 	uat = new(UserAttribute)
 	for _, photo := range photos {
 		var buf bytes.Buffer
-		// RFC 4880, Section 5.12.1.
-		data := []byte{
-			0x10, 0x00, // Little-endian image header length (16 bytes)
-			0x01,       // Image header version 1
-			0x01,       // JPEG
-			0, 0, 0, 0, // 12 reserved octets, must be all zero.
-			0, 0, 0, 0,
-			0, 0, 0, 0}
-		if _, err = buf.Write(data); err != nil {
+		if _, err = buf.Write(imageHeader); err != nil {
 			return
 		}
 		if err = jpeg.Encode(&buf, photo, nil); err != nil {
@@ -49,6 +43,32 @@ func NewUserAttributePhoto(photos ...image.Image) (uat *UserAttribute, err error
 	return
 }
 
+// imageHeader is the header of an image subpacket holding a JPEG image. See
+// RFC 4880, section 5.12.1.
+var imageHeader = []byte{
+	0x10, 0x00, // Little-endian image header length (16 bytes)
+	0x01,       // Image header version 1
+	0x01,       // JPEG
+	0, 0, 0, 0, // 12 reserved octets, must be all zero.
+	0, 0, 0, 0,
+	0, 0, 0, 0}
+
+// NewUserAttributeJPEG creates a user attribute packet containing the given
+// JPEG images, which are stored as they are rather than re-encoded.
+func NewUserAttributeJPEG(images ...[]byte) (*UserAttribute, error) {
+	uat := new(UserAttribute)
+	for _, data := range images {
+		if !bytes.HasPrefix(data, []byte{0xff, 0xd8}) {
+			return nil, errors.InvalidArgumentError("image is not a JPEG")
+		}
+		contents := append(append([]byte{}, imageHeader...), data...)
+		uat.Contents = append(uat.Contents, &OpaqueSubpacket{
+			SubType:  UserAttrImageSubpacket,
+			Contents: contents})
+	}
+	return uat, nil
+}
+
 // NewUserAttribute creates a new user attribute packet containing the given subpackets.
 func NewUserAttribute(contents ...*OpaqueSubpacket) *UserAttribute {
 	return &UserAttribute{Contents: contents}
@@ -67,15 +87,22 @@ func (uat *UserAttribute) parse(r io.Reader) (err error) {
 // Serialize marshals the user attribute to w in the form of an OpenPGP packet, including
 // header.
 func (uat *UserAttribute) Serialize(w io.Writer) (err error) {
+	contents := uat.serializeContents()
+	if err = serializeHeader(w, packetTypeUserAttribute, len(contents)); err != nil {
+		return err
+	}
+	_, err = w.Write(contents)
+	return
+}
+
+// serializeContents returns the body of the user attribute packet, which is
+// also what signatures over it are computed on.
+func (uat *UserAttribute) serializeContents() []byte {
 	var buf bytes.Buffer
 	for _, sp := range uat.Contents {
 		sp.Serialize(&buf)
 	}
-	if err = serializeHeader(w, packetTypeUserAttribute, buf.Len()); err != nil {
-		return err
-	}
-	_, err = w.Write(buf.Bytes())
-	return
+	return buf.Bytes()
 }
 
 // ImageData returns zero or more byte slices, each containing
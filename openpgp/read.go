@@ -9,9 +9,13 @@ import (
 	"crypto"
 	"crypto/hmac"
 	_ "crypto/sha256"
+	"encoding"
 	"hash"
 	"io"
+	"runtime"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/keybase/go-crypto/openpgp/armor"
 	"github.com/keybase/go-crypto/openpgp/errors"
@@ -38,15 +42,17 @@ func readArmored(r io.Reader, expectedType string) (body io.Reader, err error) {
 // MessageDetails contains the result of parsing an OpenPGP encrypted and/or
 // signed message.
 type MessageDetails struct {
-	IsEncrypted              bool                // true if the message was encrypted.
-	EncryptedToKeyIds        []uint64            // the list of recipient key ids.
-	IsSymmetricallyEncrypted bool                // true if a passphrase could have decrypted the message.
-	DecryptedWith            Key                 // the private key used to decrypt the message, if any.
-	IsSigned                 bool                // true if the message is signed.
-	SignedByKeyId            uint64              // the key id of the signer, if any.
-	SignedBy                 *Key                // the key of the signer, if available.
-	LiteralData              *packet.LiteralData // the metadata of the contents
-	UnverifiedBody           io.Reader           // the contents of the message.
+	IsEncrypted              bool                  // true if the message was encrypted.
+	EncryptedToKeyIds        []uint64              // the list of recipient key ids.
+	IsSymmetricallyEncrypted bool                  // true if a passphrase could have decrypted the message.
+	DecryptedWith            Key                   // the private key used to decrypt the message, if any.
+	SymmetricAlgo            packet.CipherFunction // the cipher used to encrypt the message body, if any.
+	IntegrityProtected       bool                  // true if the encrypted message body is protected by an MDC or AEAD.
+	IsSigned                 bool                  // true if the message is signed.
+	SignedByKeyId            uint64                // the key id of the signer, if any.
+	SignedBy                 *Key                  // the key of the signer, if available.
+	LiteralData              *packet.LiteralData   // the metadata of the contents
+	UnverifiedBody           io.Reader             // the contents of the message.
 
 	// If IsSigned is true and SignedBy is non-zero then the signature will
 	// be verified as UnverifiedBody is read. The signature cannot be
@@ -61,12 +67,36 @@ type MessageDetails struct {
 	Signature      *packet.Signature   // the signature packet itself, if v4 (default)
 	SignatureV3    *packet.SignatureV3 // the signature packet if it is a v2 or v3 signature
 
+	// SignatureCreationTime is the time at which the signature was made.
+	// SignatureExpired is set if the signature expired before the
+	// current time of the config; SignatureError is then
+	// errors.ErrSignatureExpired.
+	SignatureCreationTime time.Time
+	SignatureExpired      bool
+
 	// Does the Message include multiple signatures? Also called "nested signatures".
 	MultiSig bool
 
+	// Signatures holds the result of checking each of the signatures of
+	// the message, in the order of their one-pass signature packets,
+	// once UnverifiedBody has been read to EOF. Unlike the fields above it
+	// covers every signer, not only SignedBy. Signatures by keys that
+	// aren't in the keyring fail with errors.ErrUnknownIssuer.
+	Signatures []SignatureResult
+
 	decrypted io.ReadCloser
 }
 
+// A SignatureResult holds the outcome of checking one signature of a message
+// that may have several signers.
+type SignatureResult struct {
+	IssuerKeyId uint64              // the key id of the signer.
+	SignedBy    *Key                // the key of the signer, if available.
+	Signature   *packet.Signature   // the signature packet, if v4 or later.
+	SignatureV3 *packet.SignatureV3 // the signature packet, if v2 or v3.
+	Err         error               // nil if the signature is good.
+}
+
 // A PromptFunction is used as a callback by functions that may need to decrypt
 // a private key, or prompt for a passphrase. It is called with a list of
 // acceptable, encrypted private keys and a boolean that indicates whether a
@@ -83,16 +113,34 @@ type keyEnvelopePair struct {
 	encryptedKey *packet.EncryptedKey
 }
 
+// encryptedData is implemented by the packets that can hold the encrypted
+// body of a message: SymmetricallyEncrypted and AEADEncrypted.
+type encryptedData interface {
+	Decrypt(packet.CipherFunction, []byte) (io.ReadCloser, error)
+}
+
 // ReadMessage parses an OpenPGP message that may be signed and/or encrypted.
 // The given KeyRing should contain both public keys (for signature
 // verification) and, possibly encrypted, private keys for decrypting.
-// If config is nil, sensible defaults will be used.
+// If prompt is nil and config has a PassphrasePrompt, that is used to
+// unlock private keys and to obtain passphrases when they are needed.
+// If config is nil, sensible defaults will be used. If config sets
+// RequireEncrypted or RequireSigned, messages that lack the required
+// protection are rejected with errors.ErrMessageNotEncrypted or
+// errors.ErrMessageNotSigned.
 func ReadMessage(r io.Reader, keyring KeyRing, prompt PromptFunction, config *packet.Config) (md *MessageDetails, err error) {
 	var p packet.Packet
 
 	var symKeys []*packet.SymmetricKeyEncrypted
 	var pubKeys []keyEnvelopePair
-	var se *packet.SymmetricallyEncrypted
+	var se encryptedData
+	// forV1 and forV2 record whether there are session key packets that
+	// go with version 1 and version 2 SymmetricallyEncrypted packets.
+	var forV1, forV2 bool
+
+	if prompt == nil {
+		prompt = configPrompt(config)
+	}
 
 	packets := packet.NewReader(r)
 	md = new(MessageDetails)
@@ -113,9 +161,15 @@ ParsePackets:
 			// This packet contains the decryption key encrypted with a passphrase.
 			md.IsSymmetricallyEncrypted = true
 			symKeys = append(symKeys, p)
+			forV1 = true
 		case *packet.EncryptedKey:
 			// This packet contains the decryption key encrypted to a public key.
 			md.EncryptedToKeyIds = append(md.EncryptedToKeyIds, p.KeyId)
+			if p.Version == 6 {
+				forV2 = true
+			} else {
+				forV1 = true
+			}
 			switch p.Algo {
 			case packet.PubKeyAlgoRSA, packet.PubKeyAlgoRSAEncryptOnly, packet.PubKeyAlgoElGamal, packet.PubKeyAlgoECDH:
 				break
@@ -126,21 +180,36 @@ ParsePackets:
 			if p.KeyId == 0 {
 				keys = keyring.DecryptionKeys()
 			} else {
-				keys = keyring.KeysById(p.KeyId, nil)
+				keys = keyring.KeysById(p.KeyId, p.KeyFingerprint)
 			}
 			for _, k := range keys {
 				pubKeys = append(pubKeys, keyEnvelopePair{k, p})
 			}
 		case *packet.SymmetricallyEncrypted:
+			// Version 3 encrypted key packets and version 4 symmetric
+			// key encrypted packets go with version 1 packets, and
+			// version 6 encrypted key packets with version 2 ones. See
+			// RFC 9580, sections 5.1 and 5.3.
+			if p.Version == 2 && forV1 || p.Version != 2 && forV2 {
+				return nil, errors.StructuralError("session key packet version doesn't match the encrypted data")
+			}
 			se = p
+			md.IntegrityProtected = p.MDC
+			break ParsePackets
+		case *packet.AEADEncrypted:
+			se = p
+			md.IntegrityProtected = true
 			break ParsePackets
 		case *packet.Compressed, *packet.LiteralData, *packet.OnePassSignature:
 			// This message isn't encrypted.
 			if len(symKeys) != 0 || len(pubKeys) != 0 {
 				return nil, errors.StructuralError("key material not followed by encrypted message")
 			}
+			if config.EncryptedRequired() {
+				return nil, errors.ErrMessageNotEncrypted
+			}
 			packets.Unread(p)
-			return readSignedMessage(packets, nil, keyring)
+			return checkSignedPolicy(packets, nil, keyring, config)
 		}
 	}
 
@@ -174,16 +243,21 @@ FindKey:
 				if len(pk.encryptedKey.Key) == 0 {
 					continue
 				}
+				cipherFunc := dataCipher(se, pk.encryptedKey.CipherFunc)
+				if err := checkCipherPolicy(cipherFunc, config); err != nil {
+					return nil, err
+				}
 				decrypted, err = se.Decrypt(pk.encryptedKey.CipherFunc, pk.encryptedKey.Key)
 				if err != nil && err != errors.ErrKeyIncorrect {
 					return nil, err
 				}
 				if decrypted != nil {
 					md.DecryptedWith = pk.key
+					md.SymmetricAlgo = cipherFunc
 					break FindKey
 				}
 			} else {
-				fpr := string(pk.key.PublicKey.Fingerprint[:])
+				fpr := string(pk.key.PublicKey.FullFingerprint())
 				if v := candidateFingerprints[fpr]; v {
 					continue
 				}
@@ -210,11 +284,15 @@ FindKey:
 			for _, s := range symKeys {
 				key, cipherFunc, err := s.Decrypt(passphrase)
 				if err == nil {
+					if err := checkCipherPolicy(dataCipher(se, cipherFunc), config); err != nil {
+						return nil, err
+					}
 					decrypted, err = se.Decrypt(cipherFunc, key)
 					if err != nil && err != errors.ErrKeyIncorrect {
 						return nil, err
 					}
 					if decrypted != nil {
+						md.SymmetricAlgo = dataCipher(se, cipherFunc)
 						break FindKey
 					}
 				}
@@ -227,13 +305,90 @@ FindKey:
 	if err := packets.Push(decrypted); err != nil {
 		return nil, err
 	}
-	return readSignedMessage(packets, md, keyring)
+	return checkSignedPolicy(packets, md, keyring, config)
+}
+
+// configPrompt adapts the PassphrasePrompt of config, if any, to a
+// PromptFunction. The passphrase it gets is used to try to unlock the
+// candidate keys and is then returned for use with symmetric keys.
+func configPrompt(config *packet.Config) PromptFunction {
+	passphrasePrompt := config.Prompt()
+	if passphrasePrompt == nil {
+		return nil
+	}
+	return func(keys []Key, symmetric bool) ([]byte, error) {
+		privs := make([]*packet.PrivateKey, len(keys))
+		for i, k := range keys {
+			privs[i] = k.PrivateKey
+		}
+		passphrase, err := passphrasePrompt(privs, symmetric)
+		if err != nil {
+			return nil, err
+		}
+		for _, priv := range privs {
+			// A wrong passphrase leaves the key encrypted, and
+			// ReadMessage will prompt again.
+			priv.Decrypt(passphrase)
+		}
+		return passphrase, nil
+	}
+}
+
+// dataCipher returns the cipher that se is encrypted with, given the cipher
+// that came with its session key. AEAD protected packets name their own
+// cipher, and version 6 encrypted key packets rely on that.
+func dataCipher(se encryptedData, cipherFunc packet.CipherFunction) packet.CipherFunction {
+	switch se := se.(type) {
+	case *packet.AEADEncrypted:
+		return se.Cipher
+	case *packet.SymmetricallyEncrypted:
+		if se.Version == 2 {
+			return se.Cipher
+		}
+	}
+	return cipherFunc
+}
+
+// checkCipherPolicy returns an error if config rejects messages encrypted
+// with cipherFunc.
+func checkCipherPolicy(cipherFunc packet.CipherFunction, config *packet.Config) error {
+	if config.LegacyCiphersRejected() && cipherFunc.IsLegacy() {
+		return errors.UnsupportedError("legacy cipher rejected by policy: " + strconv.Itoa(int(cipherFunc)))
+	}
+	return nil
+}
+
+// checkSignatureVersion enforces config.MinSignatureVersion on sig, which
+// is a *packet.Signature or a *packet.SignatureV3.
+func checkSignatureVersion(sig packet.Packet, config *packet.Config) error {
+	version := 3
+	if s, ok := sig.(*packet.Signature); ok {
+		version = s.Version
+	}
+	if min := config.MinSigVersion(); version < min {
+		return errors.UnsupportedError("signature version " + strconv.Itoa(version) + " rejected by policy, minimum is " + strconv.Itoa(min))
+	}
+	return nil
+}
+
+// checkSignedPolicy calls readSignedMessage and then enforces
+// config.RequireSigned on the result. Only the presence of a signature is
+// checked here; the signature itself can only be verified once
+// UnverifiedBody has been consumed.
+func checkSignedPolicy(packets *packet.Reader, mdin *MessageDetails, keyring KeyRing, config *packet.Config) (md *MessageDetails, err error) {
+	if md, err = readSignedMessage(packets, mdin, keyring, config); err != nil {
+		return nil, err
+	}
+	if config.SignedRequired() && !md.IsSigned {
+		return nil, errors.ErrMessageNotSigned
+	}
+	return md, nil
 }
 
 // readSignedMessage reads a possibly signed message if mdin is non-zero then
 // that structure is updated and returned. Otherwise a fresh MessageDetails is
 // used.
-func readSignedMessage(packets *packet.Reader, mdin *MessageDetails, keyring KeyRing) (md *MessageDetails, err error) {
+func readSignedMessage(packets *packet.Reader, mdin *MessageDetails, keyring KeyRing, config *packet.Config) (md *MessageDetails, err error) {
 	if mdin == nil {
 		mdin = new(MessageDetails)
 	}
@@ -242,6 +397,8 @@ func readSignedMessage(packets *packet.Reader, mdin *MessageDetails, keyring Key
 	var p packet.Packet
 	var h hash.Hash
 	var wrappedHash hash.Hash
+	var checks []signatureCheck
+	signedBy := -1
 FindLiteralData:
 	for {
 		p, err = packets.Next()
@@ -254,6 +411,12 @@ FindLiteralData:
 				return nil, err
 			}
 		case *packet.OnePassSignature:
+			check := signatureCheck{keyId: p.KeyId, salt: p.Salt}
+			keys := keyring.KeysByIdUsage(p.KeyId, p.KeyFingerprint, packet.KeyFlagSign)
+			if len(keys) > 0 {
+				check.key = &keys[0]
+			}
+
 			if md.IsSigned {
 				// If IsSigned is set, it means we have multiple
 				// OnePassSignature packets.
@@ -262,31 +425,52 @@ FindLiteralData:
 					// We've already found the signature we were looking
 					// for, made by key that we had in keyring and can
 					// check signature against. Continue with that instead
-					// of trying to find another.
+					// of trying to find another, which is only checked
+					// for MessageDetails.Signatures.
+					if check.key != nil {
+						check.h, check.wrappedHash, check.err = hashForSignature(p.Hash, p.SigType)
+						if check.err == nil {
+							check.h.Write(p.Salt)
+						}
+					}
+					checks = append(checks, check)
 					continue FindLiteralData
 				}
 			}
 
-			h, wrappedHash, err = hashForSignature(p.Hash, p.SigType)
+			h, wrappedHash, err = hashForSignatureTee(p.Hash, p.SigType, config.HashSink())
 			if err != nil {
 				md = nil
 				return
 			}
+			// The salt of a version 6 signature goes ahead of the
+			// message, but isn't part of it as far as the sink is
+			// concerned.
+			h.Write(p.Salt)
 
 			md.IsSigned = true
 			md.SignedByKeyId = p.KeyId
-			keys := keyring.KeysByIdUsage(p.KeyId, nil, packet.KeyFlagSign)
-			if len(keys) > 0 {
-				md.SignedBy = &keys[0]
+			if check.key != nil {
+				md.SignedBy = check.key
+				check.h, check.wrappedHash = h, wrappedHash
+				signedBy = len(checks)
 			}
+			checks = append(checks, check)
 		case *packet.LiteralData:
 			md.LiteralData = p
 			break FindLiteralData
 		}
 	}
 
-	if md.SignedBy != nil {
-		md.UnverifiedBody = &signatureCheckReader{packets, h, wrappedHash, md}
+	if md.IsSigned {
+		md.UnverifiedBody = &signatureCheckReader{
+			packets:  packets,
+			h:        h,
+			md:       md,
+			config:   config,
+			checks:   checks,
+			signedBy: signedBy,
+		}
 	} else if md.decrypted != nil {
 		md.UnverifiedBody = checkReader{md}
 	} else {
@@ -308,7 +492,9 @@ func hashForSignature(hashId crypto.Hash, sigType packet.SignatureType) (hash.Ha
 	h := hashId.New()
 
 	switch sigType {
-	case packet.SigTypeBinary:
+	case packet.SigTypeBinary, packet.SigTypeTimestamp:
+		// A timestamp signature is made over the document like a
+		// binary signature, but only vouches for its creation time.
 		return h, h, nil
 	case packet.SigTypeText:
 		return h, NewCanonicalTextHash(h), nil
@@ -317,16 +503,42 @@ func hashForSignature(hashId crypto.Hash, sigType packet.SignatureType) (hash.Ha
 	return nil, nil, errors.UnsupportedError("unsupported signature type: " + strconv.Itoa(int(sigType)))
 }
 
+// hashForSignatureTee is like hashForSignature, but if sink is non-nil then
+// the message bytes written to the signature hash, after canonicalization,
+// are also written to sink. The signature trailer is not.
+func hashForSignatureTee(hashId crypto.Hash, sigType packet.SignatureType, sink io.Writer) (hash.Hash, hash.Hash, error) {
+	h, wrappedHash, err := hashForSignature(hashId, sigType)
+	if err != nil || sink == nil {
+		return h, wrappedHash, err
+	}
+	if sigType == packet.SigTypeText {
+		return h, NewCanonicalTextHash(teeHash{h, sink}), nil
+	}
+	return h, teeHash{h, sink}, nil
+}
+
+// teeHash is a hash.Hash that copies the data it hashes to w.
+type teeHash struct {
+	hash.Hash
+	w io.Writer
+}
+
+func (t teeHash) Write(b []byte) (int, error) {
+	t.w.Write(b)
+	return t.Hash.Write(b)
+}
+
 // checkReader wraps an io.Reader from a LiteralData packet. When it sees EOF
 // it closes the ReadCloser from any SymmetricallyEncrypted packet to trigger
-// MDC checks.
+// MDC checks. If the message was cut short, that gives a more specific
+// error, such as errors.ErrTruncated, than io.ErrUnexpectedEOF.
 type checkReader struct {
 	md *MessageDetails
 }
 
 func (cr checkReader) Read(buf []byte) (n int, err error) {
 	n, err = cr.md.LiteralData.Body.Read(buf)
-	if err == io.EOF {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
 		mdcErr := cr.md.decrypted.Close()
 		if mdcErr != nil {
 			err = mdcErr
@@ -337,91 +549,378 @@ func (cr checkReader) Read(buf []byte) (n int, err error) {
 
 // signatureCheckReader wraps an io.Reader from a LiteralData packet and hashes
 // the data as it is read. When it sees an EOF from the underlying io.Reader
-// it parses and checks a trailing Signature packet and triggers any MDC checks.
+// it parses and checks the trailing Signature packets and triggers any MDC
+// checks.
 type signatureCheckReader struct {
-	packets        *packet.Reader
+	packets *packet.Reader
+	h       hash.Hash
+	md      *MessageDetails
+	config  *packet.Config
+	// checks has an entry for each OnePassSignature packet and signedBy
+	// is the index of the one made by md.SignedBy, or -1.
+	checks   []signatureCheck
+	signedBy int
+	// sigs holds the packets read after the literal data so far and
+	// signedBySig the index of the one checked against md.SignedBy, or
+	// -1.
+	sigs        []packet.Packet
+	signedBySig int
+}
+
+// A signatureCheck hashes the message for one OnePassSignature packet. The
+// hash is only computed if the key of the signer is known.
+type signatureCheck struct {
+	keyId uint64
+	// salt is the salt of a version 6 signature, which the signature
+	// packet must repeat.
+	salt           []byte
+	key            *Key
 	h, wrappedHash hash.Hash
-	md             *MessageDetails
+	err            error
 }
 
 func (scr *signatureCheckReader) Read(buf []byte) (n int, err error) {
 	n, err = scr.md.LiteralData.Body.Read(buf)
-	scr.wrappedHash.Write(buf[:n])
+	for _, check := range scr.checks {
+		if check.wrappedHash != nil {
+			check.wrappedHash.Write(buf[:n])
+		}
+	}
+	if err == io.ErrUnexpectedEOF {
+		// The message was truncated, so the signature can't be checked.
+		if scr.md.decrypted != nil {
+			if mdcErr := scr.md.decrypted.Close(); mdcErr != nil {
+				err = mdcErr
+			}
+		}
+		if scr.md.SignedBy != nil {
+			scr.md.SignatureError = err
+		}
+		scr.md.Signatures = make([]SignatureResult, len(scr.checks))
+		for i, check := range scr.checks {
+			scr.md.Signatures[i] = SignatureResult{IssuerKeyId: check.keyId, SignedBy: check.key, Err: err}
+		}
+		return
+	}
 	if err == io.EOF {
-		for {
-			var p packet.Packet
-			p, scr.md.SignatureError = scr.packets.Next()
-			if scr.md.SignatureError != nil {
-				if scr.md.MultiSig {
-					// If we are in MultiSig, we might have found other
-					// signature that cannot be verified using our key.
-					// Clear Signature field so it's clear for consumers
-					// that this message failed to verify.
-					scr.md.Signature = nil
+		var readErr error
+		scr.signedBySig = -1
+		if scr.md.SignedBy != nil {
+			readErr = scr.checkSignedBy()
+		}
+		scr.checkSignatures()
+
+		// The SymmetricallyEncrypted packet, if any, might have an
+		// unsigned hash of its own. In order to check this we need to
+		// close that Reader.
+		if scr.md.decrypted != nil {
+			mdcErr := scr.md.decrypted.Close()
+			if mdcErr != nil {
+				if readErr != nil {
+					// A truncated message may end inside the
+					// signature.
+					scr.md.SignatureError = mdcErr
 				}
-				return
+				err = mdcErr
 			}
+		}
+	}
+	return
+}
 
-			var ok bool
-			if scr.md.Signature, ok = p.(*packet.Signature); ok {
-				var err error
-				if keyID := scr.md.Signature.IssuerKeyId; keyID != nil {
-					if *keyID != scr.md.SignedBy.PublicKey.KeyId {
-						if scr.md.MultiSig {
-							continue // try again to find a sig we can verify
-						}
-						err = errors.StructuralError("bad key id")
+// nextSignature reads the next packet after the literal data and keeps it in
+// scr.sigs.
+func (scr *signatureCheckReader) nextSignature() (packet.Packet, error) {
+	p, err := scr.packets.Next()
+	if err != nil {
+		return nil, err
+	}
+	scr.sigs = append(scr.sigs, p)
+	return p, nil
+}
+
+// checkSignedBy finds the signature made by md.SignedBy and checks it. It
+// returns the error, if any, from reading the signature packets.
+func (scr *signatureCheckReader) checkSignedBy() error {
+	for {
+		p, err := scr.nextSignature()
+		if err != nil {
+			scr.md.SignatureError = err
+			if scr.md.MultiSig {
+				// If we are in MultiSig, we might have found other
+				// signature that cannot be verified using our key.
+				// Clear Signature field so it's clear for consumers
+				// that this message failed to verify.
+				scr.md.Signature = nil
+			}
+			return err
+		}
+
+		var ok bool
+		if scr.md.Signature, ok = p.(*packet.Signature); ok {
+			var err error
+			if keyID := scr.md.Signature.IssuerKeyId; keyID != nil {
+				if *keyID != scr.md.SignedBy.PublicKey.KeyId {
+					if scr.md.MultiSig {
+						continue // try again to find a sig we can verify
 					}
+					err = errors.StructuralError("bad key id")
 				}
-				if fingerprint := scr.md.Signature.IssuerFingerprint; fingerprint != nil {
-					if !hmac.Equal(fingerprint, scr.md.SignedBy.PublicKey.Fingerprint[:]) {
-						if scr.md.MultiSig {
-							continue // try again to find a sig we can verify
-						}
-						err = errors.StructuralError("bad key fingerprint")
+			}
+			if fingerprint := scr.md.Signature.IssuerFingerprint; fingerprint != nil {
+				if !hmac.Equal(fingerprint, scr.md.SignedBy.PublicKey.FullFingerprint()) {
+					if scr.md.MultiSig {
+						continue // try again to find a sig we can verify
 					}
+					err = errors.StructuralError("bad key fingerprint")
 				}
-				if err == nil {
-					err = scr.md.SignedBy.PublicKey.VerifySignature(scr.h, scr.md.Signature)
-				}
-				scr.md.SignatureError = err
-			} else if scr.md.SignatureV3, ok = p.(*packet.SignatureV3); ok {
+			}
+			if err == nil {
+				err = checkSignatureVersion(p, scr.config)
+			}
+			if err == nil {
+				err = checkSignatureSalt(scr.md.Signature, scr.checks[scr.signedBy].salt)
+			}
+			if err == nil {
+				err = scr.md.SignedBy.PublicKey.VerifySignature(scr.h, scr.md.Signature)
+			}
+			scr.md.SignatureCreationTime = scr.md.Signature.CreationTime
+			if err == nil {
+				err = checkSignatureTime(scr.md.Signature, scr.config)
+				scr.md.SignatureExpired = err == errors.ErrSignatureExpired
+			}
+			scr.md.SignatureError = err
+		} else if scr.md.SignatureV3, ok = p.(*packet.SignatureV3); ok {
+			scr.md.SignatureCreationTime = scr.md.SignatureV3.CreationTime
+			scr.md.SignatureError = checkSignatureVersion(p, scr.config)
+			if scr.md.SignatureError == nil {
 				scr.md.SignatureError = scr.md.SignedBy.PublicKey.VerifySignatureV3(scr.h, scr.md.SignatureV3)
-			} else {
-				scr.md.SignatureError = errors.StructuralError("LiteralData not followed by Signature")
-				return
 			}
+		} else {
+			scr.md.SignatureError = errors.StructuralError("LiteralData not followed by Signature")
+			return nil
+		}
+
+		// Parse only one packet by default, unless message is MultiSig. Then
+		// we ask for more packets after discovering non-matching signature,
+		// until we find one that we can verify.
+		scr.signedBySig = len(scr.sigs) - 1
+		return nil
+	}
+}
 
-			// Parse only one packet by default, unless message is MultiSig. Then
-			// we ask for more packets after discovering non-matching signature,
-			// until we find one that we can verify.
+// checkSignatures reads the remaining signature packets, pairs each of them
+// with its OnePassSignature packet and fills in md.Signatures. The result for
+// md.SignedBy is the one from checkSignedBy.
+func (scr *signatureCheckReader) checkSignatures() {
+	var readErr error
+	for len(scr.sigs) < len(scr.checks) {
+		if _, readErr = scr.nextSignature(); readErr != nil {
 			break
 		}
+	}
+	if readErr == nil || readErr == io.EOF {
+		readErr = errors.StructuralError("OnePassSignature not matched by Signature")
+	}
 
-		// The SymmetricallyEncrypted packet, if any, might have an
-		// unsigned hash of its own. In order to check this we need to
-		// close that Reader.
-		if scr.md.decrypted != nil {
-			mdcErr := scr.md.decrypted.Close()
-			if mdcErr != nil {
-				err = mdcErr
+	used := make([]bool, len(scr.sigs))
+	if scr.signedBySig >= 0 {
+		used[scr.signedBySig] = true
+	}
+	results := make([]SignatureResult, len(scr.checks))
+	// The signature packets are in the reverse order of their
+	// OnePassSignature packets. See RFC 4880, section 5.4.
+	for i := len(scr.checks) - 1; i >= 0; i-- {
+		check := &scr.checks[i]
+		result := &results[i]
+		result.IssuerKeyId = check.keyId
+		result.SignedBy = check.key
+		if i == scr.signedBy {
+			result.Signature = scr.md.Signature
+			result.SignatureV3 = scr.md.SignatureV3
+			result.Err = scr.md.SignatureError
+			continue
+		}
+
+		j := 0
+		for ; j < len(scr.sigs); j++ {
+			if !used[j] && signatureIssuedBy(scr.sigs[j], check.keyId) {
+				break
 			}
 		}
+		if j == len(scr.sigs) {
+			result.Err = readErr
+			continue
+		}
+		used[j] = true
+		p := scr.sigs[j]
+		result.Signature, _ = p.(*packet.Signature)
+		result.SignatureV3, _ = p.(*packet.SignatureV3)
+
+		switch {
+		case check.key == nil:
+			result.Err = errors.ErrUnknownIssuer
+		case check.err != nil:
+			result.Err = check.err
+		default:
+			result.Err = scr.verify(check, p)
+		}
 	}
-	return
+	scr.md.Signatures = results
+}
+
+// verify checks the signature packet p against the hash and key of check.
+func (scr *signatureCheckReader) verify(check *signatureCheck, p packet.Packet) error {
+	if err := checkSignatureVersion(p, scr.config); err != nil {
+		return err
+	}
+	switch sig := p.(type) {
+	case *packet.Signature:
+		if fingerprint := sig.IssuerFingerprint; fingerprint != nil {
+			if !hmac.Equal(fingerprint, check.key.PublicKey.FullFingerprint()) {
+				return errors.StructuralError("bad key fingerprint")
+			}
+		}
+		if err := checkSignatureSalt(sig, check.salt); err != nil {
+			return err
+		}
+		if err := check.key.PublicKey.VerifySignature(check.h, sig); err != nil {
+			return err
+		}
+		return checkSignatureTime(sig, scr.config)
+	case *packet.SignatureV3:
+		return check.key.PublicKey.VerifySignatureV3(check.h, sig)
+	}
+	return errors.StructuralError("LiteralData not followed by Signature")
+}
+
+// checkSignatureSalt returns a StructuralError unless sig has the salt given
+// by its one-pass signature packet, which was hashed ahead of the message.
+func checkSignatureSalt(sig *packet.Signature, salt []byte) error {
+	if !hmac.Equal(sig.Salt, salt) {
+		return errors.StructuralError("signature salt doesn't match one-pass signature")
+	}
+	return nil
+}
+
+// checkSignatureTime returns ErrSignatureNotYetValid if sig was made after
+// config.Now(), beyond the clock skew config allows, and ErrSignatureExpired
+// if it has expired.
+func checkSignatureTime(sig *packet.Signature, config *packet.Config) error {
+	now := config.Now()
+	if sig.CreationTime.After(now.Add(config.ClockSkew())) {
+		return errors.ErrSignatureNotYetValid
+	}
+	if sig.SigExpired(now) {
+		return errors.ErrSignatureExpired
+	}
+	return nil
+}
+
+// signatureIssuedBy reports whether the signature packet p may have been
+// made by the key with the given id. A signature without an issuer may have
+// been made by any key.
+func signatureIssuedBy(p packet.Packet, keyId uint64) bool {
+	switch sig := p.(type) {
+	case *packet.Signature:
+		ids := sig.CandidateIssuers()
+		if len(ids) == 0 {
+			return true
+		}
+		for _, id := range ids {
+			if id == keyId {
+				return true
+			}
+		}
+	case *packet.SignatureV3:
+		return sig.IssuerKeyId == keyId
+	}
+	return false
 }
 
 // CheckDetachedSignature takes a signed file and a detached signature and
 // returns the signer if the signature is valid. If the signer isn't known,
-// ErrUnknownIssuer is returned.
+// ErrUnknownIssuer is returned. A signature that has expired, or that was
+// made after the current time, fails with ErrSignatureExpired or
+// ErrSignatureNotYetValid.
 func CheckDetachedSignature(keyring KeyRing, signed, signature io.Reader) (signer *Entity, err error) {
-	signer, _, err = checkDetachedSignature(keyring, signed, signature)
+	signer, _, err = checkDetachedSignature(keyring, signed, signature, nil)
+	return signer, err
+}
+
+// CheckDetachedSignatureWithConfig is like CheckDetachedSignature but
+// applies the verification policy in config, such as
+// MinSignatureVersion.
+func CheckDetachedSignatureWithConfig(keyring KeyRing, signed, signature io.Reader, config *packet.Config) (signer *Entity, err error) {
+	signer, _, err = checkDetachedSignature(keyring, signed, signature, config)
 	return signer, err
 }
 
-func checkDetachedSignature(keyring KeyRing, signed, signature io.Reader) (signer *Entity, issuer *uint64, err error) {
+// CheckDetachedSignatureKey is like CheckDetachedSignatureWithConfig but
+// returns the key, primary key or subkey, that made the signature. The
+// signature is read once and only the keys whose key ID, and issuer
+// fingerprint if the signature carries one, match are tried.
+func CheckDetachedSignatureKey(keyring KeyRing, signed, signature io.Reader, config *packet.Config) (key *Key, err error) {
+	key, _, err = checkDetachedSignatureKey(keyring, signed, signature, config)
+	return key, err
+}
+
+// A DetachedSignature pairs signed data with its detached signature, for
+// VerifyBatch.
+type DetachedSignature struct {
+	Signed, Signature io.Reader
+}
+
+// A VerifyResult holds the outcome of checking one DetachedSignature: the
+// key that made the signature or, if it isn't valid, the error.
+type VerifyResult struct {
+	Key *Key
+	Err error
+}
+
+// VerifyBatch checks each of items like CheckDetachedSignatureKey and
+// returns their results in the same order. The items are checked by a pool
+// of runtime.GOMAXPROCS(0) goroutines, so none of the readers of two items
+// may be the same, and keyring must be safe for concurrent use, as an
+// EntityList is as long as it isn't modified.
+func VerifyBatch(keyring KeyRing, items []DetachedSignature, config *packet.Config) []VerifyResult {
+	results := make([]VerifyResult, len(items))
+
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(items) {
+		workers = len(items)
+	}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				key, _, err := checkDetachedSignatureKey(keyring, items[i].Signed, items[i].Signature, config)
+				results[i] = VerifyResult{Key: key, Err: err}
+			}
+		}()
+	}
+	for i := range items {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	return results
+}
+
+func checkDetachedSignature(keyring KeyRing, signed, signature io.Reader, config *packet.Config) (signer *Entity, issuer *uint64, err error) {
+	key, issuer, err := checkDetachedSignatureKey(keyring, signed, signature, config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key.Entity, issuer, nil
+}
+
+func checkDetachedSignatureKey(keyring KeyRing, signed, signature io.Reader, config *packet.Config) (signer *Key, issuer *uint64, err error) {
 	var issuerKeyId uint64
-	var issuerFingerprint []byte
 	var hashFunc crypto.Hash
 	var sigType packet.SignatureType
 	var keys []Key
@@ -439,22 +938,27 @@ func checkDetachedSignature(keyring KeyRing, signed, signature io.Reader) (signe
 
 		switch sig := p.(type) {
 		case *packet.Signature:
-			if sig.IssuerKeyId == nil {
+			// The key id may also be given by the issuer fingerprint
+			// alone, which then has to match as well.
+			ids := sig.CandidateIssuers()
+			if len(ids) == 0 {
 				return nil, nil, errors.StructuralError("signature doesn't have an issuer")
 			}
-			issuerKeyId = *sig.IssuerKeyId
+			issuerKeyId, keys = signingKeysByIds(keyring, ids, sig.IssuerFingerprint)
 			hashFunc = sig.Hash
 			sigType = sig.SigType
-			issuerFingerprint = sig.IssuerFingerprint
 		case *packet.SignatureV3:
 			issuerKeyId = sig.IssuerKeyId
+			keys = keyring.KeysByIdUsage(issuerKeyId, nil, packet.KeyFlagSign)
 			hashFunc = sig.Hash
 			sigType = sig.SigType
 		default:
 			return nil, nil, errors.StructuralError("non signature packet found")
 		}
+		if err = checkSignatureVersion(p, config); err != nil {
+			return nil, nil, err
+		}
 
-		keys = keyring.KeysByIdUsage(issuerKeyId, issuerFingerprint, packet.KeyFlagSign)
 		if len(keys) > 0 {
 			break
 		}
@@ -468,12 +972,58 @@ func checkDetachedSignature(keyring KeyRing, signed, signature io.Reader) (signe
 	if err != nil {
 		return nil, nil, err
 	}
+	if sig, ok := p.(*packet.Signature); ok {
+		if err := sig.PrepareHash(h, nil); err != nil {
+			return nil, nil, err
+		}
+	}
 
 	if _, err := io.Copy(wrappedHash, signed); err != nil && err != io.EOF {
 		return nil, nil, err
 	}
 
-	for _, key := range keys {
+	signer, err = verifyWithKeys(keys, h, p)
+	if err != nil {
+		return nil, nil, err
+	}
+	if sig, ok := p.(*packet.Signature); ok {
+		if err := checkSignatureTime(sig, config); err != nil {
+			return nil, nil, err
+		}
+	}
+	return signer, &issuerKeyId, nil
+}
+
+// signingKeysByIds returns the first of the candidate issuer key ids that
+// keyring has signing keys for, and those keys. If there are none, it
+// returns ids[0] and no keys. fp is the issuer fingerprint, if any, which
+// the keys must match too.
+func signingKeysByIds(keyring KeyRing, ids []uint64, fp []byte) (uint64, []Key) {
+	for _, id := range ids {
+		if keys := keyring.KeysByIdUsage(id, fp, packet.KeyFlagSign); len(keys) > 0 {
+			return id, keys
+		}
+	}
+	return ids[0], nil
+}
+
+// verifyWithKeys checks the signature packet p, given the hash h of the
+// signed data, against each of keys in turn and returns the first key that
+// made it.
+func verifyWithKeys(keys []Key, h hash.Hash, p packet.Packet) (signer *Key, err error) {
+	// Verifying consumes the hash, so keep its state around in case
+	// several keys share the issuer's key ID.
+	var state []byte
+	if m, ok := h.(encoding.BinaryMarshaler); ok && len(keys) > 1 {
+		state, _ = m.MarshalBinary()
+	}
+
+	for i, key := range keys {
+		if i > 0 && state != nil {
+			if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+				return nil, err
+			}
+		}
 		switch sig := p.(type) {
 		case *packet.Signature:
 			err = key.PublicKey.VerifySignature(h, sig)
@@ -484,24 +1034,125 @@ func checkDetachedSignature(keyring KeyRing, signed, signature io.Reader) (signe
 		}
 
 		if err == nil {
-			return key.Entity, &issuerKeyId, nil
+			return &key, nil
 		}
 	}
 
-	return nil, nil, err
+	return nil, err
+}
+
+// CheckDetachedSignatures checks every signature in the detached signature
+// file signature over signed, which is read once, and returns their results
+// in the order of the signature packets. Unlike CheckDetachedSignature it
+// doesn't stop at the first signature made by a key in keyring; signatures
+// by unknown keys fail with errors.ErrUnknownIssuer. As with
+// CheckDetachedSignature, the times of the signatures are checked too.
+func CheckDetachedSignatures(keyring KeyRing, signed, signature io.Reader, config *packet.Config) (results []SignatureResult, err error) {
+	type detachedCheck struct {
+		p    packet.Packet
+		keys []Key
+		h    hash.Hash
+	}
+	var checks []detachedCheck
+	var writers []io.Writer
+
+	packets := packet.NewReader(signature)
+	for {
+		p, err := packets.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		check := detachedCheck{p: p}
+		var result SignatureResult
+		var hashFunc crypto.Hash
+		var sigType packet.SignatureType
+		switch sig := p.(type) {
+		case *packet.Signature:
+			ids := sig.CandidateIssuers()
+			if len(ids) == 0 {
+				return nil, errors.StructuralError("signature doesn't have an issuer")
+			}
+			result.IssuerKeyId, check.keys = signingKeysByIds(keyring, ids, sig.IssuerFingerprint)
+			result.Signature = sig
+			hashFunc = sig.Hash
+			sigType = sig.SigType
+		case *packet.SignatureV3:
+			result.IssuerKeyId = sig.IssuerKeyId
+			result.SignatureV3 = sig
+			check.keys = keyring.KeysByIdUsage(sig.IssuerKeyId, nil, packet.KeyFlagSign)
+			hashFunc = sig.Hash
+			sigType = sig.SigType
+		default:
+			return nil, errors.StructuralError("non signature packet found")
+		}
+
+		result.Err = checkSignatureVersion(p, config)
+		if result.Err == nil && len(check.keys) == 0 {
+			result.Err = errors.ErrUnknownIssuer
+		}
+		if result.Err == nil {
+			var wrappedHash hash.Hash
+			check.h, wrappedHash, result.Err = hashForSignature(hashFunc, sigType)
+			if sig, ok := p.(*packet.Signature); ok && result.Err == nil {
+				result.Err = sig.PrepareHash(check.h, nil)
+			}
+			if result.Err == nil {
+				writers = append(writers, wrappedHash)
+			}
+		}
+		if result.Err != nil {
+			check.h = nil
+		}
+		checks = append(checks, check)
+		results = append(results, result)
+	}
+	if len(results) == 0 {
+		return nil, errors.StructuralError("no signature found")
+	}
+
+	if _, err := io.Copy(io.MultiWriter(writers...), signed); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	for i, check := range checks {
+		if check.h == nil {
+			continue
+		}
+		key, err := verifyWithKeys(check.keys, check.h, check.p)
+		if key == nil {
+			key = &check.keys[0]
+		} else if sig, ok := check.p.(*packet.Signature); ok {
+			err = checkSignatureTime(sig, config)
+		}
+		results[i].SignedBy = key
+		results[i].Err = err
+	}
+	return results, nil
 }
 
 // CheckArmoredDetachedSignature performs the same actions as
 // CheckDetachedSignature but expects the signature to be armored.
 func CheckArmoredDetachedSignature(keyring KeyRing, signed, signature io.Reader) (signer *Entity, err error) {
-	signer, _, err = checkArmoredDetachedSignature(keyring, signed, signature)
+	signer, _, err = checkArmoredDetachedSignature(keyring, signed, signature, nil)
+	return signer, err
+}
+
+// CheckArmoredDetachedSignatureWithConfig is like
+// CheckArmoredDetachedSignature but applies the verification policy in
+// config.
+func CheckArmoredDetachedSignatureWithConfig(keyring KeyRing, signed, signature io.Reader, config *packet.Config) (signer *Entity, err error) {
+	signer, _, err = checkArmoredDetachedSignature(keyring, signed, signature, config)
 	return signer, err
 }
 
-func checkArmoredDetachedSignature(keyring KeyRing, signed, signature io.Reader) (signer *Entity, issuer *uint64, err error) {
+func checkArmoredDetachedSignature(keyring KeyRing, signed, signature io.Reader, config *packet.Config) (signer *Entity, issuer *uint64, err error) {
 	body, err := readArmored(signature, SignatureType)
 	if err != nil {
 		return
 	}
-	return checkDetachedSignature(keyring, signed, body)
+	return checkDetachedSignature(keyring, signed, body, config)
 }
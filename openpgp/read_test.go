@@ -6,6 +6,7 @@ package openpgp
 
 import (
 	"bytes"
+	"crypto"
 	_ "crypto/sha512"
 	"encoding/hex"
 	"fmt"
@@ -140,6 +141,34 @@ func TestTextSignedMessage(t *testing.T) {
 	checkSignedMessage(t, signedTextMessageHex, signedTextInput)
 }
 
+func TestSignatureHashSink(t *testing.T) {
+	kring, _ := ReadKeyRing(readerFromHex(testKeys1And2Hex))
+
+	for i, test := range []struct {
+		signedHex, expected string
+	}{
+		{signedMessageHex, signedInput},
+		{signedTextMessageHex, signedTextInput},
+	} {
+		var sink bytes.Buffer
+		config := &packet.Config{SignatureHashSink: &sink}
+		md, err := ReadMessage(readerFromHex(test.signedHex), kring, nil, config)
+		if err != nil {
+			t.Errorf("#%d: %s", i, err)
+			continue
+		}
+		if _, err := ioutil.ReadAll(md.UnverifiedBody); err != nil {
+			t.Errorf("#%d: error reading UnverifiedBody: %s", i, err)
+		}
+		if md.SignatureError != nil {
+			t.Errorf("#%d: failed to validate: %s", i, md.SignatureError)
+		}
+		if sink.String() != test.expected {
+			t.Errorf("#%d: bad sink contents got:%q want:%q", i, sink.String(), test.expected)
+		}
+	}
+}
+
 // The reader should detect "compressed quines", which are compressed
 // packets that expand into themselves and cause an infinite recursive
 // parsing loop.
@@ -227,6 +256,74 @@ func TestSignedEncryptedMessage(t *testing.T) {
 	}
 }
 
+func TestConfigPassphrasePrompt(t *testing.T) {
+	test := signedEncryptedMessageTests[0]
+	kring, _ := ReadKeyRing(readerFromHex(test.keyRingHex))
+
+	calls := 0
+	config := &packet.Config{
+		PassphrasePrompt: func(keys []*packet.PrivateKey, symmetric bool) ([]byte, error) {
+			calls++
+			if symmetric {
+				t.Errorf("prompt: message was marked as symmetrically encrypted")
+			}
+			if len(keys) == 0 || keys[0].KeyId != test.encryptedToKeyId {
+				t.Errorf("prompt: unexpected keys %v", keys)
+			}
+			if calls == 1 {
+				return []byte("wrong"), nil
+			}
+			return []byte("passphrase"), nil
+		},
+	}
+
+	md, err := ReadMessage(readerFromHex(test.messageHex), kring, nil, config)
+	if err != nil {
+		t.Fatalf("error reading message: %s", err)
+	}
+	if calls != 2 {
+		t.Errorf("prompt called %d times, want 2", calls)
+	}
+	contents, err := ioutil.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		t.Errorf("error reading UnverifiedBody: %s", err)
+	}
+	if string(contents) != "Signed and encrypted message\n" {
+		t.Errorf("bad UnverifiedBody: %q", contents)
+	}
+
+	// Keys that are already unlocked don't cause a prompt.
+	calls = 0
+	if _, err := ReadMessage(readerFromHex(test.messageHex), kring, nil, config); err != nil {
+		t.Fatalf("error reading message: %s", err)
+	}
+	if calls != 0 {
+		t.Errorf("prompt called %d times for an unlocked key", calls)
+	}
+
+	config = &packet.Config{
+		PassphrasePrompt: func(keys []*packet.PrivateKey, symmetric bool) ([]byte, error) {
+			if !symmetric {
+				t.Errorf("symmetric is not set")
+			}
+			return []byte("password"), nil
+		},
+	}
+	if _, err := ReadMessage(readerFromHex(symmetricallyEncryptedCompressedHex), nil, nil, config); err != nil {
+		t.Errorf("ReadMessage: %s", err)
+	}
+
+	abort := errors.InvalidArgumentError("cancelled")
+	config = &packet.Config{
+		PassphrasePrompt: func(keys []*packet.PrivateKey, symmetric bool) ([]byte, error) {
+			return nil, abort
+		},
+	}
+	if _, err := ReadMessage(readerFromHex(symmetricallyEncryptedCompressedHex), nil, nil, config); err != abort {
+		t.Errorf("got %v, want the prompt's error", err)
+	}
+}
+
 func TestUnspecifiedRecipient(t *testing.T) {
 	expected := "Recipient unspecified\n"
 	kring, _ := ReadKeyRing(readerFromHex(testKeys1And2PrivateHex))
@@ -246,6 +343,221 @@ func TestUnspecifiedRecipient(t *testing.T) {
 	}
 }
 
+func TestUnspecifiedRecipientLockedKey(t *testing.T) {
+	entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.SerializePrivate(ioutil.Discard, nil); err != nil {
+		t.Fatal(err)
+	}
+	subkeyId := entity.Subkeys[0].PublicKey.KeyId
+
+	buf := new(bytes.Buffer)
+	w, err := Encrypt(buf, []*Entity{entity}, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "hidden recipient")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	addressed := buf.Bytes()
+
+	// Hide the recipient by zeroing the key ID of the encrypted key packet.
+	anonymous := new(bytes.Buffer)
+	or := packet.NewOpaqueReader(bytes.NewReader(addressed))
+	for {
+		op, err := or.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if op.Tag == 1 {
+			copy(op.Contents[1:9], make([]byte, 8))
+		}
+		if err := op.Serialize(anonymous); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := entity.Subkeys[0].PrivateKey.Encrypt([]byte("passphrase"), nil); err != nil {
+		t.Fatal(err)
+	}
+	buf = new(bytes.Buffer)
+	if err := entity.SerializePrivateWithoutSigning(buf); err != nil {
+		t.Fatal(err)
+	}
+	serialized := buf.Bytes()
+
+	for i, msg := range [][]byte{addressed, anonymous.Bytes()} {
+		locked, err := ReadEntity(packet.NewReader(bytes.NewReader(serialized)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		calls := 0
+		config := &packet.Config{
+			PassphrasePrompt: func(keys []*packet.PrivateKey, symmetric bool) ([]byte, error) {
+				calls++
+				if len(keys) != 1 || keys[0].KeyId != subkeyId {
+					t.Errorf("prompt: unexpected keys %v", keys)
+				}
+				return []byte("passphrase"), nil
+			},
+		}
+		md, err := ReadMessage(bytes.NewReader(msg), EntityList{locked}, nil, config)
+		if err != nil {
+			t.Fatalf("#%d: error reading message: %s", i, err)
+		}
+		if calls != 1 {
+			t.Errorf("prompt called %d times, want 1", calls)
+		}
+		if md.DecryptedWith.PublicKey.KeyId != subkeyId {
+			t.Errorf("decrypted with %X, want %X", md.DecryptedWith.PublicKey.KeyId, subkeyId)
+		}
+		contents, err := ioutil.ReadAll(md.UnverifiedBody)
+		if err != nil {
+			t.Errorf("error reading UnverifiedBody: %s", err)
+		}
+		if string(contents) != "hidden recipient" {
+			t.Errorf("bad UnverifiedBody: %q", contents)
+		}
+	}
+}
+
+func TestTruncatedMessage(t *testing.T) {
+	entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.SerializePrivate(ioutil.Discard, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, signed := range []*Entity{nil, entity} {
+		buf := new(bytes.Buffer)
+		w, err := Encrypt(buf, []*Entity{entity}, signed, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, strings.Repeat("truncated message\n", 100))
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		truncated := buf.Bytes()[:buf.Len()-100]
+
+		md, err := ReadMessage(bytes.NewReader(truncated), EntityList{entity}, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadAll(md.UnverifiedBody); err != errors.ErrTruncated {
+			t.Errorf("signed: %t: expected ErrTruncated, got: %v", signed != nil, err)
+		}
+		if signed != nil && md.SignatureError != errors.ErrTruncated {
+			t.Errorf("expected ErrTruncated as signature error, got: %v", md.SignatureError)
+		}
+	}
+}
+
+func TestReadV6EncryptedMessage(t *testing.T) {
+	rsaKeys, err := ReadKeyRing(readerFromHex(testKeys1And2PrivateHex))
+	if err != nil {
+		t.Fatal(err)
+	}
+	eccEntity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := eccEntity.SerializePrivate(ioutil.Discard, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	const message = "v6 encrypted message\n"
+	sessionKey := []byte("0123456789abcdef0123456789abcdef")
+	for _, kring := range []EntityList{rsaKeys, {eccEntity}} {
+		recipient := kring[0].Subkeys[0].PublicKey
+
+		buf := new(bytes.Buffer)
+		if err := packet.SerializeEncryptedKeyV6(buf, recipient, sessionKey, nil); err != nil {
+			t.Fatal(err)
+		}
+		w, err := packet.SerializeSymmetricallyEncryptedV2(buf, packet.CipherAES256, packet.AEADModeOCB, 0, sessionKey, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lit, err := packet.SerializeLiteral(w, true, "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(lit, message)
+		if err := lit.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		md, err := ReadMessage(buf, kring, nil, nil)
+		if err != nil {
+			t.Fatalf("%d: %s", recipient.PubKeyAlgo, err)
+		}
+		if len(md.EncryptedToKeyIds) != 1 || md.EncryptedToKeyIds[0] != recipient.KeyId {
+			t.Errorf("%d: bad EncryptedToKeyIds: %x", recipient.PubKeyAlgo, md.EncryptedToKeyIds)
+		}
+		if md.DecryptedWith.PublicKey != recipient || md.SymmetricAlgo != packet.CipherAES256 || !md.IntegrityProtected {
+			t.Errorf("%d: bad MessageDetails: %#v", recipient.PubKeyAlgo, md)
+		}
+		contents, err := ioutil.ReadAll(md.UnverifiedBody)
+		if err != nil {
+			t.Fatalf("%d: error reading UnverifiedBody: %s", recipient.PubKeyAlgo, err)
+		}
+		if string(contents) != message {
+			t.Errorf("%d: bad UnverifiedBody got:%q want:%q", recipient.PubKeyAlgo, contents, message)
+		}
+	}
+}
+
+func TestReadMismatchedEncryptedKeyVersion(t *testing.T) {
+	kring, err := ReadKeyRing(readerFromHex(testKeys1And2PrivateHex))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient := kring[0].Subkeys[0].PublicKey
+	sessionKey := []byte("0123456789abcdef0123456789abcdef")
+
+	for i, v6 := range []bool{false, true} {
+		buf := new(bytes.Buffer)
+		var w io.WriteCloser
+		if v6 {
+			// A version 6 encrypted key packet with version 1 data.
+			if err := packet.SerializeEncryptedKeyV6(buf, recipient, sessionKey, nil); err != nil {
+				t.Fatal(err)
+			}
+			w, err = packet.SerializeSymmetricallyEncrypted(buf, packet.CipherAES256, sessionKey, nil)
+		} else {
+			// A version 3 encrypted key packet with version 2 data.
+			if err := packet.SerializeEncryptedKey(buf, recipient, packet.CipherAES256, sessionKey, nil); err != nil {
+				t.Fatal(err)
+			}
+			w, err = packet.SerializeSymmetricallyEncryptedV2(buf, packet.CipherAES256, packet.AEADModeOCB, 0, sessionKey, nil)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		lit, err := packet.SerializeLiteral(w, true, "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(lit, "mismatched message\n")
+		if err := lit.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = ReadMessage(buf, kring, nil, nil)
+		if _, ok := err.(errors.StructuralError); !ok {
+			t.Errorf("#%d: expected StructuralError, got %v", i, err)
+		}
+	}
+}
+
 func TestSymmetricallyEncrypted(t *testing.T) {
 	firstTimeCalled := true
 
@@ -288,6 +600,199 @@ func TestSymmetricallyEncrypted(t *testing.T) {
 	}
 }
 
+func TestPasswordAndKeyEncrypted(t *testing.T) {
+	// The message is encrypted both to privKeyCv25519GnuPG and with a
+	// passphrase, so either can decrypt it.
+	priv, err := ReadArmoredKeyRing(strings.NewReader(privKeyCv25519GnuPG))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ReadArmoredKeyRing(strings.NewReader(privKeyCv25519GnuPG))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range pub {
+		e.PrivateKey = nil
+		for i := range e.Subkeys {
+			e.Subkeys[i].PrivateKey = nil
+		}
+	}
+
+	for i, test := range []struct {
+		keyring EntityList
+		prompts int
+	}{
+		{priv, 0},
+		{pub, 1},
+	} {
+		prompts := 0
+		prompt := func(keys []Key, symmetric bool) ([]byte, error) {
+			prompts++
+			if !symmetric {
+				t.Errorf("#%d: symmetric is not set", i)
+			}
+			return []byte("pw"), nil
+		}
+		block, err := armor.Decode(strings.NewReader(passwordAndKeyEncryptedMessage))
+		if err != nil {
+			t.Fatal(err)
+		}
+		md, err := ReadMessage(block.Body, test.keyring, prompt, nil)
+		if err != nil {
+			t.Errorf("#%d: ReadMessage: %s", i, err)
+			continue
+		}
+		contents, err := ioutil.ReadAll(md.UnverifiedBody)
+		if err != nil {
+			t.Errorf("#%d: ReadAll: %s", i, err)
+		}
+		if string(contents) != "mixed msg\n" {
+			t.Errorf("#%d: got contents %q", i, contents)
+		}
+		if prompts != test.prompts {
+			t.Errorf("#%d: prompted %d times, want %d", i, prompts, test.prompts)
+		}
+	}
+}
+
+// GnuPG 2.2 can't produce AEAD Encrypted Data packets, so the message is
+// built here from its packets.
+func TestAEADEncrypted(t *testing.T) {
+	const message = "AEAD encrypted message"
+
+	buf := new(bytes.Buffer)
+	key, err := packet.SerializeSymmetricKeyEncrypted(buf, []byte("password"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := packet.SerializeAEADEncrypted(buf, packet.CipherAES128, packet.AEADModeOCB, 0, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	literal, err := packet.SerializeLiteral(encrypted, true, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := literal.Write([]byte(strings.Repeat(message, 10))); err != nil {
+		t.Fatal(err)
+	}
+	if err := literal.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := buf.Bytes()
+
+	readMessage := func(ciphertext []byte) ([]byte, error) {
+		passphrases := [][]byte{[]byte("wrongpassword"), []byte("password")}
+		prompt := func(keys []Key, symmetric bool) ([]byte, error) {
+			if len(passphrases) == 0 {
+				return nil, errors.ErrKeyIncorrect
+			}
+			passphrase := passphrases[0]
+			passphrases = passphrases[1:]
+			return passphrase, nil
+		}
+		md, err := ReadMessage(bytes.NewReader(ciphertext), nil, prompt, nil)
+		if err != nil {
+			return nil, err
+		}
+		if md.SymmetricAlgo != packet.CipherAES128 {
+			t.Errorf("SymmetricAlgo is %d, want %d", md.SymmetricAlgo, packet.CipherAES128)
+		}
+		return ioutil.ReadAll(md.UnverifiedBody)
+	}
+
+	contents, err := readMessage(ciphertext)
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	if string(contents) != strings.Repeat(message, 10) {
+		t.Errorf("bad UnverifiedBody got:%s want:%s", contents, strings.Repeat(message, 10))
+	}
+
+	// Corrupt the last byte of the final chunk, just before the final
+	// authentication tag and the end of the partial length stream.
+	corrupt := append([]byte{}, ciphertext...)
+	corrupt[len(corrupt)-18] ^= 1
+	if _, err := readMessage(corrupt); err == nil {
+		t.Error("corrupted final chunk was accepted")
+	}
+}
+
+func TestReadMessagePolicy(t *testing.T) {
+	kring, _ := ReadKeyRing(readerFromHex(testKeys1And2Hex))
+
+	config := &packet.Config{RequireSigned: true}
+	if _, err := ReadMessage(readerFromHex(signedMessageHex), kring, nil, config); err != nil {
+		t.Errorf("signed message rejected with RequireSigned: %s", err)
+	}
+
+	config = &packet.Config{RequireEncrypted: true}
+	if _, err := ReadMessage(readerFromHex(signedMessageHex), kring, nil, config); err != errors.ErrMessageNotEncrypted {
+		t.Errorf("got %v, want ErrMessageNotEncrypted", err)
+	}
+
+	prompt := func(keys []Key, symmetric bool) ([]byte, error) {
+		return []byte("password"), nil
+	}
+	config = &packet.Config{RequireEncrypted: true}
+	if _, err := ReadMessage(readerFromHex(symmetricallyEncryptedCompressedHex), nil, prompt, config); err != nil {
+		t.Errorf("encrypted message rejected with RequireEncrypted: %s", err)
+	}
+
+	config = &packet.Config{RequireEncrypted: true, RequireSigned: true}
+	if _, err := ReadMessage(readerFromHex(symmetricallyEncryptedCompressedHex), nil, prompt, config); err != errors.ErrMessageNotSigned {
+		t.Errorf("got %v, want ErrMessageNotSigned", err)
+	}
+}
+
+func TestLegacyCipher(t *testing.T) {
+	prompt := func(keys []Key, symmetric bool) ([]byte, error) {
+		return []byte("password"), nil
+	}
+
+	md, err := ReadMessage(readerFromHex(symmetricallyEncryptedCompressedHex), nil, prompt, nil)
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	if md.SymmetricAlgo != packet.CipherCAST5 || !md.SymmetricAlgo.IsLegacy() {
+		t.Errorf("got SymmetricAlgo %d, want legacy CAST5", md.SymmetricAlgo)
+	}
+	contents, err := ioutil.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		t.Errorf("ReadAll: %s", err)
+	}
+	if string(contents) != "Symmetrically encrypted.\n" {
+		t.Errorf("bad contents: %q", contents)
+	}
+
+	config := &packet.Config{RejectLegacyCiphers: true}
+	_, err = ReadMessage(readerFromHex(symmetricallyEncryptedCompressedHex), nil, prompt, config)
+	if _, ok := err.(errors.UnsupportedError); !ok {
+		t.Errorf("got %v, want UnsupportedError", err)
+	}
+
+	buf := new(bytes.Buffer)
+	w, err := SymmetricallyEncrypt(buf, []byte("password"), nil, &packet.Config{DefaultCipher: packet.Cipher3DES})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("3DES"))
+	w.Close()
+	ciphertext := buf.Bytes()
+
+	md, err = ReadMessage(bytes.NewReader(ciphertext), nil, prompt, nil)
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	if md.SymmetricAlgo != packet.Cipher3DES || !md.SymmetricAlgo.IsLegacy() {
+		t.Errorf("got SymmetricAlgo %d, want legacy 3DES", md.SymmetricAlgo)
+	}
+	_, err = ReadMessage(bytes.NewReader(ciphertext), nil, prompt, config)
+	if _, ok := err.(errors.UnsupportedError); !ok {
+		t.Errorf("got %v, want UnsupportedError", err)
+	}
+}
+
 func testDetachedSignature(t *testing.T, kring KeyRing, signature io.Reader, sigInput, tag string, expectedSignerKeyId uint64) {
 	signed := bytes.NewBufferString(sigInput)
 	signer, err := CheckDetachedSignature(kring, signed, signature)
@@ -320,11 +825,322 @@ func TestDetachedSignature(t *testing.T) {
 	}
 }
 
+func TestMinSignatureVersion(t *testing.T) {
+	kring, _ := ReadKeyRing(readerFromHex(testKeys1And2Hex))
+	config := &packet.Config{MinSignatureVersion: 4}
+
+	_, err := CheckDetachedSignatureWithConfig(kring, bytes.NewBufferString(signedInput), readerFromHex(detachedSignatureV3TextHex), config)
+	if _, ok := err.(errors.UnsupportedError); !ok {
+		t.Errorf("v3 signature: got %v, want UnsupportedError", err)
+	}
+	signer, err := CheckDetachedSignatureWithConfig(kring, bytes.NewBufferString(signedInput), readerFromHex(detachedSignatureHex), config)
+	if err != nil || signer == nil {
+		t.Errorf("v4 signature: got %v", err)
+	}
+
+	block, err := armor.Decode(strings.NewReader(signedMessageV3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ReadArmoredKeyRing(strings.NewReader(keyV4forVerifyingSignedMessageV3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	md, err := ReadMessage(block.Body, key, nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(md.UnverifiedBody); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := md.SignatureError.(errors.UnsupportedError); !ok {
+		t.Errorf("v3 message: got %v, want UnsupportedError", md.SignatureError)
+	}
+}
+
 func TestDetachedSignatureDSA(t *testing.T) {
 	kring, _ := ReadKeyRing(readerFromHex(dsaTestKeyHex))
 	testDetachedSignature(t, kring, readerFromHex(detachedSignatureDSAHex), signedInput, "binary", testKey3KeyId)
 }
 
+func TestCheckDetachedTimestampSignature(t *testing.T) {
+	config := &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA}
+	signer, err := NewEntity("Golang Gopher", "Signer", "signer@golang.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const message = "timestamped message"
+	sig := &packet.Signature{
+		SigType:      packet.SigTypeTimestamp,
+		PubKeyAlgo:   signer.PrivateKey.PubKeyAlgo,
+		Hash:         crypto.SHA256,
+		CreationTime: config.Now(),
+		IssuerKeyId:  &signer.PrimaryKey.KeyId,
+	}
+	h := crypto.SHA256.New()
+	h.Write([]byte(message))
+	if err := sig.Sign(h, signer.PrivateKey, config); err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := sig.Serialize(buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CheckDetachedSignature(EntityList{signer}, strings.NewReader(message), buf); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCheckDetachedSignatureKey(t *testing.T) {
+	config := &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA}
+	signer, err := NewEntity("Golang Gopher", "Signer", "signer@golang.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoy, err := NewEntity("Golang Gopher", "Decoy", "decoy@golang.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoy.PrimaryKey.KeyId = signer.PrimaryKey.KeyId
+
+	const message = "signed message"
+	sign := func(withKeyId, withFingerprint bool) []byte {
+		sig := &packet.Signature{
+			SigType:      packet.SigTypeBinary,
+			PubKeyAlgo:   signer.PrivateKey.PubKeyAlgo,
+			Hash:         crypto.SHA256,
+			CreationTime: config.Now(),
+		}
+		if withKeyId {
+			sig.IssuerKeyId = &signer.PrimaryKey.KeyId
+		}
+		if withFingerprint {
+			sig.IssuerFingerprint = signer.PrimaryKey.Fingerprint[:]
+		}
+		h := crypto.SHA256.New()
+		h.Write([]byte(message))
+		if err := sig.Sign(h, signer.PrivateKey, config); err != nil {
+			t.Fatal(err)
+		}
+		buf := new(bytes.Buffer)
+		if err := sig.Serialize(buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	withFingerprint, withoutFingerprint := sign(true, true), sign(true, false)
+	onlyFingerprint := sign(false, true)
+
+	// A large keyring, in which only the decoy and the signer share the
+	// issuer's key ID.
+	var kring EntityList
+	for i := 0; i < 10000; i++ {
+		e := *decoy
+		pk := *decoy.PrimaryKey
+		pk.KeyId = uint64(i)
+		e.PrimaryKey = &pk
+		kring = append(kring, &e)
+	}
+	kring = append(kring, decoy, signer)
+
+	for i, sig := range [][]byte{withFingerprint, withoutFingerprint, onlyFingerprint} {
+		key, err := CheckDetachedSignatureKey(kring, strings.NewReader(message), bytes.NewReader(sig), nil)
+		if err != nil {
+			t.Fatalf("#%d: %s", i, err)
+		}
+		if key.Entity != signer || key.PublicKey != signer.PrimaryKey {
+			t.Errorf("#%d: got key %s of the wrong entity", i, key.PublicKey.KeyIdString())
+		}
+	}
+
+	// With the issuer fingerprint the decoy is not even tried.
+	for _, sig := range [][]byte{withFingerprint, onlyFingerprint} {
+		_, err = CheckDetachedSignatureKey(EntityList{decoy}, strings.NewReader(message), bytes.NewReader(sig), nil)
+		if err != errors.ErrUnknownIssuer {
+			t.Errorf("got %v, want ErrUnknownIssuer", err)
+		}
+	}
+	_, err = CheckDetachedSignatureKey(EntityList{decoy}, strings.NewReader(message), bytes.NewReader(withoutFingerprint), nil)
+	if _, ok := err.(errors.SignatureError); !ok {
+		t.Errorf("got %v, want SignatureError", err)
+	}
+}
+
+func TestVerifyBatch(t *testing.T) {
+	config := &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA}
+	signer, err := NewEntity("Golang Gopher", "Signer", "signer@golang.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kring, _ := ReadKeyRing(readerFromHex(testKeys1And2Hex))
+	kring = append(kring, signer)
+
+	const n = 100
+	items := make([]DetachedSignature, n)
+	for i := range items {
+		message := fmt.Sprintf("message %d", i)
+		sig := new(bytes.Buffer)
+		if err := DetachSign(sig, signer, strings.NewReader(message), config); err != nil {
+			t.Fatal(err)
+		}
+		if i%3 == 0 {
+			message += " tampered"
+		}
+		items[i] = DetachedSignature{strings.NewReader(message), sig}
+	}
+
+	results := VerifyBatch(kring, items, nil)
+	if len(results) != n {
+		t.Fatalf("got %d results, want %d", len(results), n)
+	}
+	for i, result := range results {
+		if i%3 == 0 {
+			if _, ok := result.Err.(errors.SignatureError); !ok {
+				t.Errorf("#%d: got %v, want SignatureError", i, result.Err)
+			}
+			continue
+		}
+		if result.Err != nil {
+			t.Errorf("#%d: %s", i, result.Err)
+		} else if result.Key.Entity != signer {
+			t.Errorf("#%d: got key %s of the wrong entity", i, result.Key.PublicKey.KeyIdString())
+		}
+	}
+
+	if results := VerifyBatch(kring, nil, nil); len(results) != 0 {
+		t.Errorf("got %d results for no items", len(results))
+	}
+}
+
+func TestCheckDetachedSignatures(t *testing.T) {
+	config := &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA}
+	var signers EntityList
+	for _, name := range []string{"First", "Second", "Unknown"} {
+		signer, err := NewEntity("Golang Gopher", name, "signer@golang.com", config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signers = append(signers, signer)
+	}
+
+	// The second signature is over a different message.
+	const message = "signed by several keys"
+	sigs := new(bytes.Buffer)
+	for i, signer := range signers {
+		signed := message
+		if i == 1 {
+			signed += " tampered"
+		}
+		if err := DetachSign(sigs, signer, strings.NewReader(signed), config); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := CheckDetachedSignatures(signers[:2], strings.NewReader(message), sigs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+	for i, result := range results {
+		if result.IssuerKeyId != signers[i].PrimaryKey.KeyId || result.Signature == nil {
+			t.Errorf("#%d: got issuer %x, want %x", i, result.IssuerKeyId, signers[i].PrimaryKey.KeyId)
+		}
+	}
+	if results[0].Err != nil || results[0].SignedBy == nil || results[0].SignedBy.Entity != signers[0] {
+		t.Errorf("first signature: got %v, want a good signature", results[0].Err)
+	}
+	if _, ok := results[1].Err.(errors.SignatureError); !ok || results[1].SignedBy == nil {
+		t.Errorf("second signature: got %v, want SignatureError", results[1].Err)
+	}
+	if results[2].Err != errors.ErrUnknownIssuer || results[2].SignedBy != nil {
+		t.Errorf("third signature: got %v, want ErrUnknownIssuer", results[2].Err)
+	}
+
+	if _, err := CheckDetachedSignatures(signers, strings.NewReader(message), new(bytes.Buffer), nil); err == nil {
+		t.Error("no error for a file without signatures")
+	}
+}
+
+func TestCheckDetachedSignaturesIssuerFingerprint(t *testing.T) {
+	signer, err := NewEntity("Golang Gopher", "Signer", "signer@golang.com", &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kring := EntityList{signer}
+
+	// The issuer key id doesn't match the key, but the issuer fingerprint
+	// does, so the key is found through the fingerprint.
+	const message = "signed with a misleading issuer key id"
+	bogus := signer.PrimaryKey.KeyId + 1
+	sig := &packet.Signature{
+		SigType:           packet.SigTypeBinary,
+		PubKeyAlgo:        signer.PrivateKey.PubKeyAlgo,
+		Hash:              crypto.SHA256,
+		CreationTime:      time.Now(),
+		IssuerKeyId:       &bogus,
+		IssuerFingerprint: signer.PrimaryKey.Fingerprint[:],
+	}
+	h := crypto.SHA256.New()
+	h.Write([]byte(message))
+	if err := sig.Sign(h, signer.PrivateKey, nil); err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := sig.Serialize(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := CheckDetachedSignatures(kring, strings.NewReader(message), bytes.NewReader(buf.Bytes()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := results[0]; result.Err != nil || result.SignedBy == nil || result.SignedBy.Entity != signer || result.IssuerKeyId != signer.PrimaryKey.KeyId {
+		t.Errorf("got issuer %x and error %v, want a good signature by %x", result.IssuerKeyId, result.Err, signer.PrimaryKey.KeyId)
+	}
+	if _, err := CheckDetachedSignature(kring, strings.NewReader(message), bytes.NewReader(buf.Bytes())); err != nil {
+		t.Errorf("CheckDetachedSignature: %s", err)
+	}
+}
+
+func TestDetachedSignatureTime(t *testing.T) {
+	config := &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA}
+	signer, err := NewEntity("Golang Gopher", "Signer", "signer@golang.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kring := EntityList{signer}
+
+	// The signature is made an hour from now.
+	const message = "signed in the future"
+	later := time.Now().Add(time.Hour)
+	sig := new(bytes.Buffer)
+	if err := DetachSign(sig, signer, strings.NewReader(message), &packet.Config{Time: func() time.Time { return later }}); err != nil {
+		t.Fatal(err)
+	}
+	verify := func(config *packet.Config) []error {
+		_, err1 := CheckDetachedSignatureWithConfig(kring, strings.NewReader(message), bytes.NewReader(sig.Bytes()), config)
+		results := VerifyBatch(kring, []DetachedSignature{{strings.NewReader(message), bytes.NewReader(sig.Bytes())}}, config)
+		all, err := CheckDetachedSignatures(kring, strings.NewReader(message), bytes.NewReader(sig.Bytes()), config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return []error{err1, results[0].Err, all[0].Err}
+	}
+
+	for i, err := range verify(nil) {
+		if err != errors.ErrSignatureNotYetValid {
+			t.Errorf("#%d: got %v, want %v", i, err, errors.ErrSignatureNotYetValid)
+		}
+	}
+	for i, err := range verify(&packet.Config{Time: func() time.Time { return later }}) {
+		if err != nil {
+			t.Errorf("#%d: %s", i, err)
+		}
+	}
+}
+
 func TestMultipleSignaturePacketsDSA(t *testing.T) {
 	kring, _ := ReadKeyRing(readerFromHex(dsaTestKeyHex))
 	testDetachedSignature(t, kring, readerFromHex(missingHashFunctionHex+detachedSignatureDSAHex), signedInput, "binary", testKey3KeyId)
@@ -723,7 +1539,7 @@ func testSignWithRevokedSubkey(t *testing.T, privArmored, pubArmored, passphrase
 	}
 	var ring EntityList
 	ring = append(ring, priv)
-	signer, issuer, err := checkArmoredDetachedSignature(ring, strings.NewReader(detachedMsg), strings.NewReader(sig))
+	signer, issuer, err := checkArmoredDetachedSignature(ring, strings.NewReader(detachedMsg), strings.NewReader(sig), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -756,7 +1572,7 @@ func testSignWithRevokedSubkey(t *testing.T, privArmored, pubArmored, passphrase
 	}
 	var ring2 EntityList
 	ring2 = append(ring2, priv2)
-	signer, issuer, err = checkArmoredDetachedSignature(ring2, strings.NewReader(detachedMsg), strings.NewReader(sig))
+	signer, issuer, err = checkArmoredDetachedSignature(ring2, strings.NewReader(detachedMsg), strings.NewReader(sig), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2722,3 +3538,130 @@ mMB49eTAMg/MogyFA8SUCbStPAEAxdxSKX1hFYFP4N8ML8BgLOJG4PXAdQ8wnfXD
 vJxN/AQ=
 -----END PGP PUBLIC KEY BLOCK-----
 `
+
+// passwordAndKeyEncryptedMessage is "mixed msg\n", encrypted by GnuPG 2.2
+// to privKeyCv25519GnuPG and with the passphrase "pw".
+const passwordAndKeyEncryptedMessage = `-----BEGIN PGP MESSAGE-----
+
+hF4DPaOQHMqNr8oSAQdAHMW7+UBxc1B08ZRr9TlHpsbsYKgvVupqf7HmYjDmlQ4w
+GvQrnuZJSdGfeMgUWV3U/U+IcH+vXcX4mELWuUXmmqZaaEHkYTDJbRWw17dZRvZ7
+jC4ECQMCjZVIKGTWn9v/hQzc5ZZqYe9LHaU+UrO8yGLjH/5wZeYE3XQbV9+PbqGx
+0kUBdumN8gemD7j8jJQ+11KfHCltOlxqayW7sgI2z8SP3Z2Nx2Kkbrq9HJ9bHsNy
+05De4dH0bBXmpJw9VFkYyONQjt6Dpfw=
+=4PTx
+-----END PGP MESSAGE-----`
+
+func TestSignatureExpiration(t *testing.T) {
+	kring, _ := ReadKeyRing(readerFromHex(testKeys1And2PrivateHex))
+	signer := kring[0].PrivateKey
+	const message = "expiring signature"
+	created := time.Unix(1500000000, 0)
+	lifetime := uint32(3600)
+
+	// Build a one-pass signed message by hand, as there is no way to set
+	// a signature lifetime through Config.
+	buf := new(bytes.Buffer)
+	ops := &packet.OnePassSignature{
+		SigType:    packet.SigTypeBinary,
+		Hash:       crypto.SHA256,
+		PubKeyAlgo: signer.PubKeyAlgo,
+		KeyId:      signer.KeyId,
+		IsLast:     true,
+	}
+	if err := ops.Serialize(buf); err != nil {
+		t.Fatal(err)
+	}
+	literal, err := packet.SerializeLiteral(noOpCloser{buf}, true, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	literal.Write([]byte(message))
+	literal.Close()
+	sig := &packet.Signature{
+		SigType:         packet.SigTypeBinary,
+		PubKeyAlgo:      signer.PubKeyAlgo,
+		Hash:            crypto.SHA256,
+		CreationTime:    created,
+		SigLifetimeSecs: &lifetime,
+		IssuerKeyId:     &signer.KeyId,
+	}
+	h := crypto.SHA256.New()
+	h.Write([]byte(message))
+	if err := sig.Sign(h, signer, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := sig.Serialize(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		now     time.Time
+		expired bool
+	}{
+		{created.Add(30 * time.Minute), false},
+		{created.Add(2 * time.Hour), true},
+	} {
+		now := test.now
+		config := &packet.Config{Time: func() time.Time { return now }}
+		md, err := ReadMessage(bytes.NewReader(buf.Bytes()), kring, nil, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadAll(md.UnverifiedBody); err != nil {
+			t.Fatal(err)
+		}
+		if !md.SignatureCreationTime.Equal(created) {
+			t.Errorf("got creation time %s, want %s", md.SignatureCreationTime, created)
+		}
+		if md.SignatureExpired != test.expired {
+			t.Errorf("at %s: got SignatureExpired %t, want %t", now, md.SignatureExpired, test.expired)
+		}
+		wantErr := error(nil)
+		if test.expired {
+			wantErr = errors.ErrSignatureExpired
+		}
+		if md.SignatureError != wantErr {
+			t.Errorf("at %s: got SignatureError %v, want %v", now, md.SignatureError, wantErr)
+		}
+	}
+}
+
+func TestSignatureClockSkew(t *testing.T) {
+	kring, _ := ReadKeyRing(readerFromHex(testKeys1And2PrivateHex))
+	const message = "signed by a clock that runs fast"
+	now := time.Unix(1500000000, 0)
+
+	// The signer's clock is 30 seconds ahead of ours.
+	buf := new(bytes.Buffer)
+	w, err := Encrypt(buf, kring[:1], kring[0], nil, &packet.Config{Time: func() time.Time { return now.Add(30 * time.Second) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, message)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		skew    time.Duration
+		wantErr error
+	}{
+		{time.Minute, nil},
+		{0, errors.ErrSignatureNotYetValid},
+	} {
+		config := &packet.Config{Time: func() time.Time { return now }, MaxClockSkew: test.skew}
+		md, err := ReadMessage(bytes.NewReader(buf.Bytes()), kring, nil, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadAll(md.UnverifiedBody); err != nil {
+			t.Fatal(err)
+		}
+		if md.SignatureError != test.wantErr {
+			t.Errorf("skew %s: got SignatureError %v, want %v", test.skew, md.SignatureError, test.wantErr)
+		}
+		if md.SignatureExpired {
+			t.Errorf("skew %s: SignatureExpired set", test.skew)
+		}
+	}
+}
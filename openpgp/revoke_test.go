@@ -49,6 +49,35 @@ func TestKeyRevocation2(t *testing.T) {
 	}
 }
 
+func TestRevocationReasons(t *testing.T) {
+	el, err := ReadArmoredKeyRing(bytes.NewBufferString(supersededKey))
+	if err != nil || len(el) != 1 {
+		t.Fatalf("Failed to read key: %v", err)
+	}
+	entity := el[0]
+	if len(entity.Revocations) != 1 {
+		t.Fatalf("Expected to see one revocation, got %d", len(entity.Revocations))
+	}
+	revocation := entity.Revocations[0]
+	if r := revocation.RevocationReason; r == nil || packet.ReasonForRevocation(*r) != packet.KeySuperseded {
+		t.Errorf("Unexpected key revocation reason %v", r)
+	}
+	if revocation.RevocationReasonText != "replaced by a new key" {
+		t.Errorf("Unexpected key revocation text %q", revocation.RevocationReasonText)
+	}
+
+	if len(entity.Subkeys) != 1 || entity.Subkeys[0].Revocation == nil {
+		t.Fatal("Expected a revoked subkey")
+	}
+	revocation = entity.Subkeys[0].Revocation
+	if r := revocation.RevocationReason; r == nil || packet.ReasonForRevocation(*r) != packet.KeyRetired {
+		t.Errorf("Unexpected subkey revocation reason %v", r)
+	}
+	if revocation.RevocationReasonText != "subkey no longer used" {
+		t.Errorf("Unexpected subkey revocation text %q", revocation.RevocationReasonText)
+	}
+}
+
 func TestRevokedIdentityKey(t *testing.T) {
 	el, err := ReadArmoredKeyRing(bytes.NewBufferString(revokedIdentityKey))
 	if err != nil || len(el) != 1 {
@@ -133,6 +162,62 @@ func TestDesignatedRevoker2(t *testing.T) {
 	}
 }
 
+func TestApplyDesignatedRevocation(t *testing.T) {
+	el, err := ReadArmoredKeyRing(bytes.NewBufferString(designatedRevokedKey2))
+	if err != nil || len(el) != 1 {
+		t.Fatalf("Failed to read key: %v", err)
+	}
+	entity := el[0]
+	revokerList, err := ReadArmoredKeyRing(bytes.NewBufferString(designatedRevoker1))
+	if err != nil || len(revokerList) != 1 {
+		t.Fatalf("Failed to read revoker's key: %v", err)
+	}
+	if len(entity.DesignatedRevokers) != 1 || !bytes.Equal(entity.DesignatedRevokers[0].Fingerprint, revokerList[0].PrimaryKey.Fingerprint[:]) {
+		t.Fatalf("Unexpected designated revokers: %v", entity.DesignatedRevokers)
+	}
+	rev := entity.UnverifiedRevocations[0]
+
+	// Only the designated revoker may revoke the key.
+	other, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.ApplyDesignatedRevocation(other, rev); err == nil {
+		t.Fatal("Accepted revocation from a key that is not a designated revoker")
+	}
+
+	id := entity.PrimaryKey.KeyId
+	if keys := el.KeysByIdUsage(id, nil, 0); len(keys) != 1 {
+		t.Fatalf("Expected KeysByIdUsage to find key %X, but got %d matches", id, len(keys))
+	}
+	if err := entity.ApplyDesignatedRevocation(revokerList[0], rev); err != nil {
+		t.Fatal(err)
+	}
+	if len(entity.Revocations) != 1 || len(entity.UnverifiedRevocations) != 0 {
+		t.Errorf("Got %d revocations and %d unverified revocations", len(entity.Revocations), len(entity.UnverifiedRevocations))
+	}
+	if keys := el.KeysByIdUsage(id, nil, 0); len(keys) != 0 {
+		t.Errorf("Expected KeysByIdUsage to skip revoked key %X, but got %d matches", id, len(keys))
+	}
+	if err := entity.Validate(nil); err != nil {
+		t.Errorf("Validate failed on designated revocation: %v", err)
+	}
+
+	// A revocation by any other key doesn't validate.
+	forged := *rev
+	forged.IssuerKeyId = &other.PrimaryKey.KeyId
+	forged.IssuerFingerprint = nil
+	entity.Revocations = append(entity.Revocations, &forged)
+	if err := entity.Validate(nil); err == nil {
+		t.Error("Validate accepted a revocation from a key that is not a designated revoker")
+	}
+	entity.Revocations = entity.Revocations[:1]
+	entity.DesignatedRevokers = nil
+	if err := entity.Validate(nil); err == nil {
+		t.Error("Validate accepted a designated revocation without the designated revoker")
+	}
+}
+
 func TestNoopFindDesignated(t *testing.T) {
 	// Test calling FindVerifiedDesignatedRevoke on key that does not
 	// have any UnverifiedRevocations.
@@ -313,3 +398,26 @@ i7W/Rh1FjTXpL+d7zPqzAJ0Vzhg3SkrLt8/VGRRSJRUMpb4bPw==
 =riYc
 -----END PGP PUBLIC KEY BLOCK-----
 `
+
+// supersededKey was revoked by GnuPG as superseded, after its subkey had
+// been revoked as no longer used.
+const supersededKey = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mDMEatBXehYJKwYBBAHaRw8BAQdAWrR1PhwYYBElO4QJxtcCXQZ+W9zdmmcCMllB
+yVhRsh2IjQQgFggANRYhBMMGbYgnMFJ2YWmxCaEb29dUMgzhBQJq0Fd6Fx0BcmVw
+bGFjZWQgYnkgYSBuZXcga2V5AAoJEKEb29dUMgzhKLYA/3WrV734A+bMX6QplD2C
+t+1kWTKQtDXrGcAvnFiL25hcAQCAOjpP9JxOxgQIW7i4UQfjFX6ifh4WoyWFXvZ6
+BLJ7ALQSU3VwZXJzZWRlZCA8c0BlLmQ+iJAEExYIADgWIQTDBm2IJzBSdmFpsQmh
+G9vXVDIM4QUCatBXegIbAwULCQgHAgYVCgkICwIEFgIDAQIeAQIXgAAKCRChG9vX
+VDIM4SJpAQCGE6uM/FkhhQkY5+2BAXcemgIxux+reKs+M+ohIfAExAEA21V3MMBy
+azNxfdSlJ36NPKhiBP9pEiCirkBhY9PXJgm4OARq0Fd6EgorBgEEAZdVAQUBAQdA
+3WGT9zgbBtqf+yg10NdTBqPxO9o8JEV9JFAqhVrzFg4DAQgHiI0EKBYIADUWIQTD
+Bm2IJzBSdmFpsQmhG9vXVDIM4QUCatBXehcdA3N1YmtleSBubyBsb25nZXIgdXNl
+ZAAKCRChG9vXVDIM4YBDAQCICtSXxepoIubIAkgHkiZl2BBmh8icVl9SkAFtGcHc
+4AD/YcFcb56FqhZdJL5LNzkkooHzTrHHxs2N8aTgqQwsYgCIeAQYFggAIBYhBMMG
+bYgnMFJ2YWmxCaEb29dUMgzhBQJq0Fd6AhsMAAoJEKEb29dUMgzhmxEA/jGcOjHK
+9v0SBEYT+QZPTW4mD/xVA3Pt2FQ4RjItW/r0AP9im6RVqPEuAX4C3WJg54gTyqEp
+zJ+O3AQUQF7IZzYBAg==
+=BGNJ
+-----END PGP PUBLIC KEY BLOCK-----
+`
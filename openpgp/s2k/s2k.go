@@ -12,13 +12,14 @@ import (
 	"io"
 	"strconv"
 
+	"github.com/keybase/go-crypto/argon2"
 	"github.com/keybase/go-crypto/openpgp/errors"
 )
 
 // Config collects configuration parameters for s2k key-stretching
 // transformatioms. A nil *Config is valid and results in all default
-// values. Currently, Config is used only by the Serialize function in
-// this package.
+// values. Currently, Config is used only by the Serialize and
+// SerializeWithSalt functions in this package.
 type Config struct {
 	// Hash is the default hash function to be used. If
 	// nil, SHA1 is used.
@@ -34,6 +35,26 @@ type Config struct {
 	// use a value that is at least 65536. See RFC 4880 Section
 	// 3.7.1.3.
 	S2KCount int
+	// Argon2 selects the Argon2 S2K function with the given parameters
+	// instead of Iterated and Salted S2K. If nil, Iterated and Salted
+	// S2K is used.
+	Argon2 *Argon2Config
+}
+
+// Argon2Config collects the parameters of the Argon2 S2K function. A nil
+// *Argon2Config is valid and results in all default values, which are the
+// second recommended option of RFC 9106: 3 passes, 4 lanes and 64 MiB of
+// memory. See draft-ietf-openpgp-crypto-refresh, section 3.7.1.4.
+type Argon2Config struct {
+	// Passes is the number of passes over the memory, t. If zero, 3
+	// is used.
+	Passes uint8
+	// Parallelism is the number of lanes, p. If zero, 4 is used.
+	Parallelism uint8
+	// MemoryExponent is the base-2 logarithm of the memory size in
+	// KiB. It must be at least 3 + ceil(log2(p)) and at most 20. If
+	// zero, 16 (64 MiB) is used.
+	MemoryExponent uint8
 }
 
 func (c *Config) hash() crypto.Hash {
@@ -45,6 +66,34 @@ func (c *Config) hash() crypto.Hash {
 	return c.Hash
 }
 
+func (c *Config) argon2() *Argon2Config {
+	if c == nil {
+		return nil
+	}
+	return c.Argon2
+}
+
+func (c *Argon2Config) passes() uint8 {
+	if c == nil || c.Passes == 0 {
+		return 3
+	}
+	return c.Passes
+}
+
+func (c *Argon2Config) parallelism() uint8 {
+	if c == nil || c.Parallelism == 0 {
+		return 4
+	}
+	return c.Parallelism
+}
+
+func (c *Argon2Config) memoryExponent() uint8 {
+	if c == nil || c.MemoryExponent == 0 {
+		return 16
+	}
+	return c.MemoryExponent
+}
+
 func (c *Config) encodedCount() uint8 {
 	if c == nil || c.S2KCount == 0 {
 		return 96 // The common case. Correspoding to 65536
@@ -62,6 +111,13 @@ func (c *Config) encodedCount() uint8 {
 	return encodeCount(i)
 }
 
+// Iterations returns the number of bytes that Iterated and Salted S2K
+// hashes with c. This is S2KCount, or its default, after clamping it to the
+// allowed range and rounding it up to a representable value.
+func (c *Config) Iterations() int {
+	return decodeCount(c.encodedCount())
+}
+
 // encodeCount converts an iterative "count" in the range 1024 to
 // 65011712, inclusive, to an encoded count. The return value is the
 // octet that is actually stored in the GPG file. encodeCount panics
@@ -151,6 +207,61 @@ func Iterated(out []byte, h hash.Hash, in []byte, salt []byte, count int) {
 	}
 }
 
+const (
+	// argon2S2KType is the S2K specifier type of Argon2. See
+	// draft-ietf-openpgp-crypto-refresh, section 3.7.1.4.
+	argon2S2KType  = 4
+	argon2SaltSize = 16
+
+	// maxArgon2MemoryExponent caps the memory that an S2K specifier read
+	// from a packet can make us allocate at 1 GiB.
+	maxArgon2MemoryExponent = 20
+)
+
+// Argon2 writes to out the result of computing the Argon2 S2K function using
+// Argon2id with the given passphrase, salt and parameters. The memory used is
+// 2^memoryExponent KiB.
+func Argon2(out []byte, in []byte, salt []byte, passes, parallelism, memoryExponent uint8) {
+	key := argon2.IDKey(in, salt, uint32(passes), uint32(1)<<memoryExponent, parallelism, uint32(len(out)))
+	copy(out, key)
+}
+
+// checkArgon2Params returns an error if the Argon2 parameters are invalid or
+// would need more memory than we are willing to allocate.
+func checkArgon2Params(passes, parallelism, memoryExponent uint8) error {
+	if passes == 0 {
+		return errors.StructuralError("Argon2 S2K with zero passes")
+	}
+	if parallelism == 0 {
+		return errors.StructuralError("Argon2 S2K with zero parallelism")
+	}
+	if memoryExponent > maxArgon2MemoryExponent {
+		return errors.UnsupportedError("Argon2 S2K memory exponent too large: " + strconv.Itoa(int(memoryExponent)))
+	}
+	// The memory must be at least 8 KiB per lane.
+	if uint32(1)<<memoryExponent < 8*uint32(parallelism) {
+		return errors.StructuralError("Argon2 S2K memory too small for parallelism")
+	}
+	return nil
+}
+
+func parseArgon2(r io.Reader) (f func(out, in []byte), err error) {
+	var buf [argon2SaltSize + 3]byte
+	_, err = io.ReadFull(r, buf[:])
+	if err != nil {
+		return
+	}
+	salt := buf[:argon2SaltSize]
+	passes, parallelism, memoryExponent := buf[argon2SaltSize], buf[argon2SaltSize+1], buf[argon2SaltSize+2]
+	if err = checkArgon2Params(passes, parallelism, memoryExponent); err != nil {
+		return nil, err
+	}
+	f = func(out, in []byte) {
+		Argon2(out, in, salt, passes, parallelism, memoryExponent)
+	}
+	return f, nil
+}
+
 func parseGNUExtensions(r io.Reader) (f func(out, in []byte), err error) {
 	var buf [9]byte
 
@@ -203,7 +314,17 @@ func parseGNUExtensions(r io.Reader) (f func(out, in []byte), err error) {
 func Parse(r io.Reader) (f func(out, in []byte), err error) {
 	var buf [9]byte
 
-	_, err = io.ReadFull(r, buf[:2])
+	_, err = io.ReadFull(r, buf[:1])
+	if err != nil {
+		return
+	}
+
+	// Argon2 has no hash octet.
+	if buf[0] == argon2S2KType {
+		return parseArgon2(r)
+	}
+
+	_, err = io.ReadFull(r, buf[1:2])
 	if err != nil {
 		return
 	}
@@ -258,26 +379,70 @@ func Parse(r io.Reader) (f func(out, in []byte), err error) {
 // w. The key stretching can be configured with c, which may be
 // nil. In that case, sensible defaults will be used.
 func Serialize(w io.Writer, key []byte, rand io.Reader, passphrase []byte, c *Config) error {
+	salt := make([]byte, SaltSize(c))
+	if _, err := io.ReadFull(rand, salt); err != nil {
+		return err
+	}
+	return SerializeWithSalt(w, key, salt, passphrase, c)
+}
+
+// SaltSize returns the length of the salt that the S2K function selected by
+// c, which may be nil, takes.
+func SaltSize(c *Config) int {
+	if c.argon2() != nil {
+		return argon2SaltSize
+	}
+	return 8
+}
+
+// SerializeWithSalt is like Serialize but uses the given salt instead of a
+// random one, which must be SaltSize(c) bytes long. It is meant for
+// reproducing test vectors: reusing a salt for different passphrases or
+// messages weakens the protection that S2K gives.
+func SerializeWithSalt(w io.Writer, key []byte, salt []byte, passphrase []byte, c *Config) error {
+	if len(salt) != SaltSize(c) {
+		return errors.InvalidArgumentError("S2K salt has the wrong length")
+	}
+	if a := c.argon2(); a != nil {
+		return serializeArgon2(w, key, salt, passphrase, a)
+	}
+
 	var buf [11]byte
 	buf[0] = 3 /* iterated and salted */
 	buf[1], _ = HashToHashId(c.hash())
-	salt := buf[2:10]
-	if _, err := io.ReadFull(rand, salt); err != nil {
+	copy(buf[2:10], salt)
+	buf[10] = c.encodedCount()
+	if _, err := w.Write(buf[:]); err != nil {
 		return err
 	}
-	encodedCount := c.encodedCount()
-	count := decodeCount(encodedCount)
-	buf[10] = encodedCount
+
+	Iterated(key, c.hash().New(), passphrase, salt, c.Iterations())
+	return nil
+}
+
+func serializeArgon2(w io.Writer, key []byte, salt []byte, passphrase []byte, c *Argon2Config) error {
+	passes, parallelism, memoryExponent := c.passes(), c.parallelism(), c.memoryExponent()
+	if err := checkArgon2Params(passes, parallelism, memoryExponent); err != nil {
+		return err
+	}
+
+	var buf [1 + argon2SaltSize + 3]byte
+	buf[0] = argon2S2KType
+	copy(buf[1:1+argon2SaltSize], salt)
+	buf[1+argon2SaltSize] = passes
+	buf[2+argon2SaltSize] = parallelism
+	buf[3+argon2SaltSize] = memoryExponent
 	if _, err := w.Write(buf[:]); err != nil {
 		return err
 	}
 
-	Iterated(key, c.hash().New(), passphrase, salt, count)
+	Argon2(key, passphrase, salt, passes, parallelism, memoryExponent)
 	return nil
 }
 
 // hashToHashIdMapping contains pairs relating OpenPGP's hash identifier with
-// Go's crypto.Hash type. See RFC 4880, section 9.4.
+// Go's crypto.Hash type. See RFC 4880, section 9.4, and RFC 9580, section
+// 9.5, for the SHA-3 identifiers.
 var hashToHashIdMapping = []struct {
 	id   byte
 	hash crypto.Hash
@@ -290,6 +455,8 @@ var hashToHashIdMapping = []struct {
 	{9, crypto.SHA384, "SHA384"},
 	{10, crypto.SHA512, "SHA512"},
 	{11, crypto.SHA224, "SHA224"},
+	{12, crypto.SHA3_256, "SHA3-256"},
+	{14, crypto.SHA3_512, "SHA3-512"},
 }
 
 // HashIdToHash returns a crypto.Hash which corresponds to the given OpenPGP
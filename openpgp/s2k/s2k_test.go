@@ -135,3 +135,63 @@ func testSerializeConfig(t *testing.T, c *Config) {
 		t.Errorf("keys don't match: %x (serialied) vs %x (parsed)", key, key2)
 	}
 }
+
+func TestSerializeWithSalt(t *testing.T) {
+	// This is the iterated and salted test vector from parseTests.
+	salt, _ := hex.DecodeString("0102030405060708")
+	c := &Config{Hash: crypto.SHA1, S2KCount: 17 << 21}
+	if n := c.Iterations(); n != 17<<21 {
+		t.Errorf("got %d iterations, want %d", n, 17<<21)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	key := make([]byte, 4)
+	if err := SerializeWithSalt(buf, key, salt, []byte("hello"), c); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := hex.EncodeToString(buf.Bytes()), "03020102030405060708f1"; got != want {
+		t.Errorf("got descriptor %s, want %s", got, want)
+	}
+	if got, want := hex.EncodeToString(key), "f2a57b7c"; got != want {
+		t.Errorf("got key %s, want %s", got, want)
+	}
+
+	if err := SerializeWithSalt(buf, key, salt[:4], []byte("hello"), c); err == nil {
+		t.Error("short salt was accepted")
+	}
+	if err := SerializeWithSalt(buf, key, salt, []byte("hello"), &Config{Argon2: &Argon2Config{}}); err == nil {
+		t.Error("short Argon2 salt was accepted")
+	}
+}
+
+func TestSerializeArgon2(t *testing.T) {
+	testSerializeConfig(t, &Config{Argon2: &Argon2Config{Passes: 1, Parallelism: 1, MemoryExponent: 6}})
+	testSerializeConfig(t, &Config{Argon2: &Argon2Config{Passes: 2, Parallelism: 4, MemoryExponent: 5}})
+}
+
+func TestParseArgon2Bounds(t *testing.T) {
+	salt := "000102030405060708090a0b0c0d0e0f"
+	tests := []struct {
+		params string
+		ok     bool
+	}{
+		{"010106", true},
+		{"010405", true},
+		{"000106", false}, // no passes
+		{"010006", false}, // no parallelism
+		{"010404", false}, // less than 8 KiB per lane
+		{"010114", true},
+		{"010115", false}, // 2 GiB
+		{"0101ff", false},
+	}
+
+	for i, test := range tests {
+		spec, _ := hex.DecodeString("04" + salt + test.params)
+		_, err := Parse(bytes.NewBuffer(spec))
+		if test.ok && err != nil {
+			t.Errorf("%d: Parse returned error: %s", i, err)
+		} else if !test.ok && err == nil {
+			t.Errorf("%d: Parse accepted invalid parameters", i)
+		}
+	}
+}
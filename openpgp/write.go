@@ -72,6 +72,9 @@ func SignWithSigner(s packet.Signer, w io.Writer, message io.Reader, sigType pac
 	sig.IssuerKeyId = &keyId
 
 	s.Reset()
+	if err = sig.PrepareHash(s, config); err != nil {
+		return
+	}
 
 	wrapped := s.(hash.Hash)
 
@@ -92,7 +95,10 @@ func SignWithSigner(s packet.Signer, w io.Writer, message io.Reader, sigType pac
 }
 
 func detachSign(w io.Writer, signer *Entity, message io.Reader, sigType packet.SignatureType, config *packet.Config) (err error) {
-	signerSubkey, ok := signer.signingKey(config.Now())
+	signerSubkey, ok, err := signingKeyWithPrompt(signer, config)
+	if err != nil {
+		return
+	}
 	if !ok {
 		err = errors.InvalidArgumentError("no valid signing keys")
 		return
@@ -107,7 +113,7 @@ func detachSign(w io.Writer, signer *Entity, message io.Reader, sigType packet.S
 	sig := new(packet.Signature)
 	sig.SigType = sigType
 	sig.PubKeyAlgo = signerSubkey.PrivateKey.PubKeyAlgo
-	sig.Hash = config.Hash()
+	sig.Hash = signer.SigningHash(config)
 	sig.CreationTime = config.Now()
 	sig.IssuerKeyId = &signerSubkey.PrivateKey.KeyId
 
@@ -115,6 +121,9 @@ func detachSign(w io.Writer, signer *Entity, message io.Reader, sigType packet.S
 	if err != nil {
 		return
 	}
+	if err = sig.PrepareHash(h, config); err != nil {
+		return
+	}
 	io.Copy(wrappedHash, message)
 
 	err = sig.Sign(h, signerSubkey.PrivateKey, config)
@@ -125,11 +134,51 @@ func detachSign(w io.Writer, signer *Entity, message io.Reader, sigType packet.S
 	return sig.Serialize(w)
 }
 
+// signingKeyWithPrompt is like e.signingKey, but if no unlocked signing
+// key is available and config has a PassphrasePrompt, it asks for a
+// passphrase to unlock e's encrypted signing keys, as often as needed.
+func signingKeyWithPrompt(e *Entity, config *packet.Config) (Key, bool, error) {
+	prompt := config.Prompt()
+	for {
+		key, ok := e.signingKey(config.Now())
+		if ok || prompt == nil {
+			return key, ok, nil
+		}
+
+		var locked []*packet.PrivateKey
+		if e.PrivateKey != nil && e.PrivateKey.Encrypted && e.PrimaryKey.PubKeyAlgo.CanSign() {
+			locked = append(locked, e.PrivateKey)
+		}
+		for _, subkey := range e.Subkeys {
+			if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted && subkey.PublicKey.PubKeyAlgo.CanSign() {
+				locked = append(locked, subkey.PrivateKey)
+			}
+		}
+		if len(locked) == 0 {
+			return key, false, nil
+		}
+
+		passphrase, err := prompt(locked, false)
+		if err != nil {
+			return Key{}, false, err
+		}
+		for _, priv := range locked {
+			priv.Decrypt(passphrase)
+		}
+	}
+}
+
 // FileHints contains metadata about encrypted files. This metadata is, itself,
 // encrypted.
 type FileHints struct {
 	// IsBinary can be set to hint that the contents are binary data.
 	IsBinary bool
+	// IsText marks the contents as text, overriding IsBinary. The line
+	// endings of the contents are canonicalised to CRLF, whether or not
+	// the message is signed, and a signed message carries a text
+	// signature (type 0x01), so that it verifies whatever the line
+	// endings of the recipient's platform.
+	IsText bool
 	// FileName hints at the name of the file that should be written. It's
 	// truncated to 255 bytes if longer. It may be empty to suggest that the
 	// file should not be written to disk. It may be equal to "_CONSOLE" to
@@ -173,7 +222,14 @@ func SymmetricallyEncrypt(ciphertext io.Writer, passphrase []byte, hints *FileHi
 	if !hints.ModTime.IsZero() {
 		epochSeconds = uint32(hints.ModTime.Unix())
 	}
-	return packet.SerializeLiteral(literaldata, hints.IsBinary, hints.FileName, epochSeconds)
+	plaintext, err = packet.SerializeLiteral(literaldata, hints.isBinary(), hints.FileName, epochSeconds)
+	if err != nil {
+		return
+	}
+	if hints.IsText {
+		plaintext = newCanonicalTextWriteCloser(plaintext)
+	}
+	return
 }
 
 // intersectPreferences mutates and returns a prefix of a that contains only
@@ -201,15 +257,49 @@ func hashToHashId(h crypto.Hash) uint8 {
 	return v
 }
 
+// aeadChunkSizeByte selects 256 KiB chunks for AEAD encrypted messages.
+const aeadChunkSizeByte = 12
+
 // Encrypt encrypts a message to a number of recipients and, optionally, signs
 // it. hints contains optional information, that is also encrypted, that aids
 // the recipients in processing the message. The resulting WriteCloser must
-// be closed after the contents of the file have been written.
+// be closed after the contents of the file have been written. The message is
+// streamed to ciphertext as it is written, using a bounded amount of memory
+// whatever its size.
 // If config is nil, sensible defaults will be used.
 func Encrypt(ciphertext io.Writer, to []*Entity, signed *Entity, hints *FileHints, config *packet.Config) (plaintext io.WriteCloser, err error) {
+	encryptKeys := make([]Key, len(to))
+	for i := range to {
+		var ok bool
+		encryptKeys[i], ok = to[i].encryptionKey(config.Now())
+		if !ok {
+			return nil, errors.InvalidArgumentError("cannot encrypt a message to key id " + strconv.FormatUint(to[i].PrimaryKey.KeyId, 16) + " because it has no encryption keys")
+		}
+	}
+	return encrypt(ciphertext, encryptKeys, signed, hints, config)
+}
+
+// EncryptToKeys is like Encrypt, but encrypts the message to exactly the
+// given keys, for example subkeys found with KeysById, instead of picking an
+// encryption key of each recipient. The preferences of a key's Entity, if
+// set, are taken into account as in Encrypt.
+// If config is nil, sensible defaults will be used.
+func EncryptToKeys(ciphertext io.Writer, to []Key, signed *Entity, hints *FileHints, config *packet.Config) (plaintext io.WriteCloser, err error) {
+	for _, key := range to {
+		if key.PublicKey == nil || !key.PublicKey.PubKeyAlgo.CanEncrypt() {
+			return nil, errors.InvalidArgumentError("cannot encrypt a message to a key that can't encrypt")
+		}
+	}
+	return encrypt(ciphertext, to, signed, hints, config)
+}
+
+func encrypt(ciphertext io.Writer, encryptKeys []Key, signed *Entity, hints *FileHints, config *packet.Config) (plaintext io.WriteCloser, err error) {
 	var signer *packet.PrivateKey
 	if signed != nil {
-		signKey, ok := signed.signingKey(config.Now())
+		signKey, ok, err := signingKeyWithPrompt(signed, config)
+		if err != nil {
+			return nil, err
+		}
 		if !ok {
 			return nil, errors.InvalidArgumentError("no valid signing keys")
 		}
@@ -222,11 +312,14 @@ func Encrypt(ciphertext io.Writer, to []*Entity, signed *Entity, hints *FileHint
 		}
 	}
 
-	// These are the possible ciphers that we'll use for the message.
+	// These are the possible ciphers that we'll use for the message,
+	// strongest first.
 	candidateCiphers := []uint8{
-		uint8(packet.CipherAES128),
 		uint8(packet.CipherAES256),
+		uint8(packet.CipherAES192),
+		uint8(packet.CipherAES128),
 		uint8(packet.CipherCAST5),
+		uint8(packet.Cipher3DES),
 	}
 	// These are the possible hash functions that we'll use for the signature.
 	candidateHashes := []uint8{
@@ -236,6 +329,14 @@ func Encrypt(ciphertext io.Writer, to []*Entity, signed *Entity, hints *FileHint
 		hashToHashId(crypto.RIPEMD160),
 	}
 
+	// These are the possible compression algorithms that we'll use.
+	candidateCompression := []uint8{
+		uint8(packet.CompressionZLIB),
+		uint8(packet.CompressionZIP),
+		uint8(packet.CompressionBZIP2),
+		uint8(packet.CompressionNone),
+	}
+
 	// If no preferences were specified, assume something safe and reasonable.
 	defaultCiphers := []uint8{
 		uint8(packet.CipherAES128),
@@ -250,26 +351,49 @@ func Encrypt(ciphertext io.Writer, to []*Entity, signed *Entity, hints *FileHint
 		hashToHashId(crypto.RIPEMD160),
 	}
 
-	encryptKeys := make([]Key, len(to))
-	for i := range to {
-		var ok bool
-		encryptKeys[i], ok = to[i].encryptionKey(config.Now())
-		if !ok {
-			return nil, errors.InvalidArgumentError("cannot encrypt a message to key id " + strconv.FormatUint(to[i].PrimaryKey.KeyId, 16) + " because it has no encryption keys")
-		}
+	// RFC 4880, section 5.2.3.9: if no compression preference is given,
+	// ZIP is preferred.
+	defaultCompression := []uint8{
+		uint8(packet.CompressionZIP),
+	}
 
-		sig := to[i].primaryIdentity().SelfSignature
+	// AEAD encryption is only used if every recipient announces support
+	// for it in its features subpacket. Otherwise the message is sent in
+	// a Symmetrically Encrypted Integrity Protected Data packet, which is
+	// also used for keys that don't announce MDC support, since all
+	// current implementations can read it. config.DisableMDC overrides
+	// both, see packet.SerializeSymmetricallyEncrypted.
+	aeadSupported := len(encryptKeys) > 0
+
+	for _, key := range encryptKeys {
+		sig := new(packet.Signature)
+		if key.Entity != nil {
+			if i := key.Entity.PrimaryIdentity(); i != nil && i.SelfSignature != nil {
+				sig = i.SelfSignature
+			}
+		}
 
 		preferredSymmetric := sig.PreferredSymmetric
 		if len(preferredSymmetric) == 0 {
 			preferredSymmetric = defaultCiphers
 		}
+		// RFC 4880, section 13.2: TripleDES is tacitly at the end of
+		// every list of preferred ciphers.
+		preferredSymmetric = append(append([]uint8{}, preferredSymmetric...), uint8(packet.Cipher3DES))
 		preferredHashes := sig.PreferredHash
 		if len(preferredHashes) == 0 {
 			preferredHashes = defaultHashes
 		}
+		preferredCompression := sig.PreferredCompression
+		if len(preferredCompression) == 0 {
+			preferredCompression = defaultCompression
+		}
+		// Uncompressed data is always acceptable.
+		preferredCompression = append([]uint8{uint8(packet.CompressionNone)}, preferredCompression...)
 		candidateCiphers = intersectPreferences(candidateCiphers, preferredSymmetric)
 		candidateHashes = intersectPreferences(candidateHashes, preferredHashes)
+		candidateCompression = intersectPreferences(candidateCompression, preferredCompression)
+		aeadSupported = aeadSupported && sig.HasFlagAEAD()
 	}
 
 	if len(candidateCiphers) == 0 {
@@ -290,10 +414,10 @@ func Encrypt(ciphertext io.Writer, to []*Entity, signed *Entity, hints *FileHint
 		}
 	}
 
-	var hash crypto.Hash
+	var hashType crypto.Hash
 	for _, hashId := range candidateHashes {
 		if h, ok := s2k.HashIdToHash(hashId); ok && h.Available() {
-			hash = h
+			hashType = h
 			break
 		}
 	}
@@ -302,13 +426,13 @@ func Encrypt(ciphertext io.Writer, to []*Entity, signed *Entity, hints *FileHint
 	if configuredHash := config.Hash(); configuredHash.Available() {
 		for _, hashId := range candidateHashes {
 			if h, ok := s2k.HashIdToHash(hashId); ok && h == configuredHash {
-				hash = h
+				hashType = h
 				break
 			}
 		}
 	}
 
-	if hash == 0 {
+	if hashType == 0 {
 		hashId := candidateHashes[0]
 		name, ok := s2k.HashIdToString(hashId)
 		if !ok {
@@ -317,6 +441,20 @@ func Encrypt(ciphertext io.Writer, to []*Entity, signed *Entity, hints *FileHint
 		return nil, errors.InvalidArgumentError("cannot encrypt because no candidate hash functions are compiled in. (Wanted " + name + " in this case.)")
 	}
 
+	// Only compress if config asks for it. If the configured algorithm
+	// is shared by all recipients we'll use that, otherwise the best one
+	// that is.
+	compression := packet.CompressionNone
+	if configuredCompression := config.Compression(); configuredCompression != packet.CompressionNone {
+		compression = packet.CompressionAlgo(candidateCompression[0])
+		for _, c := range candidateCompression {
+			if packet.CompressionAlgo(c) == configuredCompression {
+				compression = configuredCompression
+				break
+			}
+		}
+	}
+
 	symKey := make([]byte, cipher.KeySize())
 	if _, err := io.ReadFull(config.Random(), symKey); err != nil {
 		return nil, err
@@ -328,21 +466,24 @@ func Encrypt(ciphertext io.Writer, to []*Entity, signed *Entity, hints *FileHint
 		}
 	}
 
-	encryptedData, err := packet.SerializeSymmetricallyEncrypted(ciphertext, cipher, symKey, config)
+	var encryptedData io.WriteCloser
+	if aeadSupported && !cipher.IsLegacy() && !config.MDCDisabled() {
+		encryptedData, err = packet.SerializeAEADEncrypted(ciphertext, cipher, packet.AEADModeOCB, aeadChunkSizeByte, symKey, config)
+	} else {
+		encryptedData, err = packet.SerializeSymmetricallyEncrypted(ciphertext, cipher, symKey, config)
+	}
 	if err != nil {
 		return
 	}
 
-	if signer != nil {
-		ops := &packet.OnePassSignature{
-			SigType:    packet.SigTypeBinary,
-			Hash:       hash,
-			PubKeyAlgo: signer.PubKeyAlgo,
-			KeyId:      signer.KeyId,
-			IsLast:     true,
+	if compression != packet.CompressionNone {
+		var compConfig *packet.CompressionConfig
+		if config != nil {
+			compConfig = config.CompressionConfig
 		}
-		if err := ops.Serialize(encryptedData); err != nil {
-			return nil, err
+		encryptedData, err = packet.SerializeCompressed(encryptedData, compression, compConfig)
+		if err != nil {
+			return
 		}
 	}
 
@@ -350,6 +491,19 @@ func Encrypt(ciphertext io.Writer, to []*Entity, signed *Entity, hints *FileHint
 		hints = &FileHints{}
 	}
 
+	var sig *packet.Signature
+	var sigHash hash.Hash
+	if signer != nil {
+		var ops *packet.OnePassSignature
+		sig, sigHash, ops, err = newOnePassSignature(signer, hashType, hints.sigType(), config)
+		if err != nil {
+			return nil, err
+		}
+		if err := ops.Serialize(encryptedData); err != nil {
+			return nil, err
+		}
+	}
+
 	w := encryptedData
 	if signer != nil {
 		// If we need to write a signature packet after the literal
@@ -362,43 +516,103 @@ func Encrypt(ciphertext io.Writer, to []*Entity, signed *Entity, hints *FileHint
 	if !hints.ModTime.IsZero() {
 		epochSeconds = uint32(hints.ModTime.Unix())
 	}
-	literalData, err := packet.SerializeLiteral(w, hints.IsBinary, hints.FileName, epochSeconds)
+	literalData, err := packet.SerializeLiteral(w, hints.isBinary(), hints.FileName, epochSeconds)
 	if err != nil {
 		return nil, err
 	}
 
 	if signer != nil {
-		return signatureWriter{encryptedData, literalData, hash, hash.New(), signer, config}, nil
+		return newSignatureWriter(encryptedData, literalData, sig, sigHash, signer, config), nil
+	}
+	if hints.IsText {
+		return newCanonicalTextWriteCloser(literalData), nil
 	}
 	return literalData, nil
 }
 
+// isBinary reports whether the literal data packet should be marked as
+// binary.
+func (hints *FileHints) isBinary() bool {
+	return hints.IsBinary && !hints.IsText
+}
+
+// sigType returns the type of the signature over the contents.
+func (hints *FileHints) sigType() packet.SignatureType {
+	if hints.IsText {
+		return packet.SigTypeText
+	}
+	return packet.SigTypeBinary
+}
+
+// newOnePassSignature starts the signature that signer makes over the
+// contents of a one-pass signed message. It returns the signature, the hash
+// that the contents are to be written to and the one-pass signature packet
+// that goes ahead of them. For version 6 signatures, the salt has already
+// been written to the hash and is carried by the one-pass signature packet.
+func newOnePassSignature(signer *packet.PrivateKey, hashType crypto.Hash, sigType packet.SignatureType, config *packet.Config) (*packet.Signature, hash.Hash, *packet.OnePassSignature, error) {
+	sig := &packet.Signature{
+		Version:     config.SigVersion(),
+		SigType:     sigType,
+		PubKeyAlgo:  signer.PubKeyAlgo,
+		Hash:        hashType,
+		IssuerKeyId: &signer.KeyId,
+	}
+	// Signature.Sign would refuse this too, but only once the message
+	// has been written out.
+	if sig.Version == 6 && signer.Version != 6 {
+		return nil, nil, nil, errors.InvalidArgumentError("version 6 signature requested for a version " + strconv.Itoa(signer.Version) + " key")
+	}
+	h := hashType.New()
+	if err := sig.PrepareHash(h, config); err != nil {
+		return nil, nil, nil, err
+	}
+	ops := &packet.OnePassSignature{
+		SigType:    sigType,
+		Hash:       hashType,
+		PubKeyAlgo: signer.PubKeyAlgo,
+		KeyId:      signer.KeyId,
+		IsLast:     true,
+	}
+	if sig.Version == 6 {
+		ops.Version = 6
+		ops.Salt = sig.Salt
+		ops.KeyFingerprint = signer.FullFingerprint()
+	}
+	return sig, h, ops, nil
+}
+
 // signatureWriter hashes the contents of a message while passing it along to
 // literalData. When closed, it closes literalData, writes a signature packet
 // to encryptedData and then also closes encryptedData.
 type signatureWriter struct {
 	encryptedData io.WriteCloser
 	literalData   io.WriteCloser
-	hashType      crypto.Hash
+	sig           *packet.Signature
 	h             hash.Hash
-	signer        *packet.PrivateKey
-	config        *packet.Config
+	// contents is where Write sends the contents: to both h and
+	// literalData, after canonicalising line endings for text signatures.
+	contents io.Writer
+	signer   *packet.PrivateKey
+	config   *packet.Config
+}
+
+func newSignatureWriter(encryptedData, literalData io.WriteCloser, sig *packet.Signature, h hash.Hash, signer *packet.PrivateKey, config *packet.Config) signatureWriter {
+	contents := io.MultiWriter(h, literalData)
+	if sig.SigType == packet.SigTypeText {
+		// Text literal data is stored with CRLF line endings, and
+		// that is what GnuPG hashes when it checks the signature.
+		contents = &canonicalTextWriter{w: contents}
+	}
+	return signatureWriter{encryptedData, literalData, sig, h, contents, signer, config}
 }
 
 func (s signatureWriter) Write(data []byte) (int, error) {
-	s.h.Write(data)
-	return s.literalData.Write(data)
+	return s.contents.Write(data)
 }
 
 func (s signatureWriter) Close() error {
-	sig := &packet.Signature{
-		SigType:      packet.SigTypeBinary,
-		PubKeyAlgo:   s.signer.PubKeyAlgo,
-		Hash:         s.hashType,
-		CreationTime: s.config.Now(),
-		IssuerKeyId:  &s.signer.KeyId,
-	}
-
+	sig := s.sig
+	sig.CreationTime = s.config.Now()
 	if err := sig.Sign(s.h, s.signer, s.config); err != nil {
 		return err
 	}
@@ -443,7 +657,10 @@ func AttachedSign(out io.WriteCloser, signed Entity, hints *FileHints,
 
 	var signer *packet.PrivateKey
 
-	signKey, ok := signed.signingKey(config.Now())
+	signKey, ok, err := signingKeyWithPrompt(&signed, config)
+	if err != nil {
+		return
+	}
 	if !ok {
 		err = errors.InvalidArgumentError("no valid signing keys")
 		return
@@ -469,16 +686,12 @@ func AttachedSign(out io.WriteCloser, signed Entity, hints *FileHints,
 		}
 	}
 
-	hasher := config.Hash() // defaults to SHA-256
+	hasher := signed.SigningHash(config) // defaults to SHA-256
 
-	ops := &packet.OnePassSignature{
-		SigType:    packet.SigTypeBinary,
-		Hash:       hasher,
-		PubKeyAlgo: signer.PubKeyAlgo,
-		KeyId:      signer.KeyId,
-		IsLast:     true,
+	sig, sigHash, ops, err := newOnePassSignature(signer, hasher, hints.sigType(), config)
+	if err != nil {
+		return
 	}
-
 	if err = ops.Serialize(out); err != nil {
 		return
 	}
@@ -491,7 +704,7 @@ func AttachedSign(out io.WriteCloser, signed Entity, hints *FileHints,
 	// We don't want the literal serializer to closer the output stream
 	// since we're going to need to write to it when we finish up the
 	// signature stuff.
-	in, err = packet.SerializeLiteral(noOpCloser{out}, hints.IsBinary, hints.FileName, epochSeconds)
+	in, err = packet.SerializeLiteral(noOpCloser{out}, hints.isBinary(), hints.FileName, epochSeconds)
 
 	if err != nil {
 		return
@@ -500,7 +713,19 @@ func AttachedSign(out io.WriteCloser, signed Entity, hints *FileHints,
 	// If we need to write a signature packet after the literal
 	// data then we need to stop literalData from closing
 	// encryptedData.
-	in = signatureWriter{out, in, hasher, hasher.New(), signer, config}
+	in = newSignatureWriter(out, in, sig, sigHash, signer, config)
 
 	return
 }
+
+// SignMessage returns a WriteCloser to which the message to be signed is
+// written. The message is written to w as a one-pass signed message, made of
+// a one-pass signature packet, the literal data and the signature, once the
+// WriteCloser is closed. Unlike with AttachedSign, w isn't closed.
+// If config is nil, sensible defaults will be used.
+func SignMessage(w io.Writer, signer *Entity, hints *FileHints, config *packet.Config) (io.WriteCloser, error) {
+	if signer == nil {
+		return nil, errors.InvalidArgumentError("no signer given")
+	}
+	return AttachedSign(noOpCloser{w}, *signer, hints, config)
+}
@@ -16,6 +16,7 @@ import (
 	"time"
 
 	"github.com/keybase/go-crypto/openpgp/armor"
+	"github.com/keybase/go-crypto/openpgp/errors"
 	"github.com/keybase/go-crypto/openpgp/packet"
 	"github.com/keybase/go-crypto/rsa"
 )
@@ -83,6 +84,111 @@ func (s *TestRSASigner) Sign(sig *packet.Signature) (err error) {
 	return
 }
 
+func TestSignDetachedWithPassphrasePrompt(t *testing.T) {
+	kring, _ := ReadKeyRing(readerFromHex(dsaElGamalTestKeysHex))
+	if !kring[0].PrivateKey.Encrypted {
+		t.Fatal("test key is not encrypted")
+	}
+
+	calls := 0
+	config := &packet.Config{
+		PassphrasePrompt: func(keys []*packet.PrivateKey, symmetric bool) ([]byte, error) {
+			calls++
+			if calls == 1 {
+				return []byte("wrong"), nil
+			}
+			return []byte("passphrase"), nil
+		},
+	}
+
+	out := bytes.NewBuffer(nil)
+	message := bytes.NewBufferString(signedInput)
+	if err := DetachSign(out, kring[0], message, config); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("prompt called %d times, want 2", calls)
+	}
+
+	testDetachedSignature(t, kring, out, signedInput, "check", kring[0].PrimaryKey.KeyId)
+}
+
+// v6SigningEntity returns a copy of e whose primary key, with the same key
+// material, is a version 6 key, so that it can make version 6 signatures.
+// The copy has no subkeys.
+func v6SigningEntity(t *testing.T, e *Entity) *Entity {
+	pub := *e.PrimaryKey
+	pub.Version = 6
+	buf := new(bytes.Buffer)
+	if err := pub.Serialize(buf); err != nil {
+		t.Fatal(err)
+	}
+	// Parsing the key again gives it its version 6 fingerprint and key id.
+	p, err := packet.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv := *e.PrivateKey
+	priv.PublicKey = *p.(*packet.PublicKey)
+	return &Entity{
+		PrimaryKey: &priv.PublicKey,
+		PrivateKey: &priv,
+		Identities: e.Identities,
+	}
+}
+
+func TestSignDetachedV6(t *testing.T) {
+	kring, _ := ReadKeyRing(readerFromHex(testKeys1And2PrivateHex))
+	config := &packet.Config{SignatureVersion: 6}
+	out := bytes.NewBuffer(nil)
+
+	// Version 6 signatures can only be made by version 6 keys.
+	err := DetachSign(out, kring[0], bytes.NewBufferString(signedInput), config)
+	if _, ok := err.(errors.InvalidArgumentError); !ok {
+		t.Errorf("got %v, want an InvalidArgumentError for a version 4 key", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("%d bytes written", out.Len())
+	}
+
+	signer := v6SigningEntity(t, kring[0])
+	kring = EntityList{signer}
+	err = DetachSign(out, signer, bytes.NewBufferString(signedInput), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigBytes := out.Bytes()
+
+	p, err := packet.Read(bytes.NewReader(sigBytes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := p.(*packet.Signature)
+	if sig.Version != 6 || len(sig.Salt) != 16 {
+		t.Fatalf("got version %d with %d byte salt, want 6 and 16", sig.Version, len(sig.Salt))
+	}
+	reserialized := bytes.NewBuffer(nil)
+	if err := sig.Serialize(reserialized); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reserialized.Bytes(), sigBytes) {
+		t.Error("v6 signature did not survive a parse/serialize round trip")
+	}
+
+	testDetachedSignature(t, kring, bytes.NewBuffer(sigBytes), signedInput, "check", signer.PrimaryKey.KeyId)
+
+	// The salt is part of the signed data, so changing it must
+	// invalidate the signature.
+	sig.Salt[0] ^= 1
+	tampered := bytes.NewBuffer(nil)
+	if err := sig.Serialize(tampered); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CheckDetachedSignature(kring, bytes.NewBufferString(signedInput), tampered); err == nil {
+		t.Error("signature with modified salt verified")
+	}
+}
+
 func TestSignWithSigner(t *testing.T) {
 	kring, err := ReadKeyRing(readerFromHex(testKeys1And2PrivateHex))
 	if err != nil {
@@ -195,6 +301,17 @@ func TestNewEntity(t *testing.T) {
 	if int(bl) != cfg.RSABits {
 		t.Errorf("BitLength %v, expected %v", bl, cfg.RSABits)
 	}
+	bl, err = e.Subkeys[0].PublicKey.BitLength()
+	if err != nil {
+		t.Errorf("failed to find subkey bit length: %s", err)
+	}
+	if int(bl) != cfg.RSABits {
+		t.Errorf("subkey BitLength %v, expected %v", bl, cfg.RSABits)
+	}
+
+	if _, err := NewEntity("Test User", "test", "test@example.com", &packet.Config{RSABits: 512}); err == nil {
+		t.Error("made an entity with a 512 bit RSA key")
+	}
 
 	w := bytes.NewBuffer(nil)
 	if err := e.SerializePrivate(w, nil); err != nil {
@@ -257,6 +374,43 @@ func TestSymmetricEncryption(t *testing.T) {
 	}
 }
 
+func TestSymmetricEncryptionConfig(t *testing.T) {
+	buf := new(bytes.Buffer)
+	hints := &FileHints{FileName: "hello.txt", ModTime: time.Unix(1600000000, 0)}
+	config := &packet.Config{DefaultCipher: packet.CipherAES128, DefaultCompressionAlgo: packet.CompressionZLIB}
+	plaintext, err := SymmetricallyEncrypt(buf, []byte("testing"), hints, config)
+	if err != nil {
+		t.Fatalf("error writing headers: %s", err)
+	}
+	message := []byte("hello world\n")
+	if _, err := plaintext.Write(message); err != nil {
+		t.Errorf("error writing to plaintext writer: %s", err)
+	}
+	if err := plaintext.Close(); err != nil {
+		t.Errorf("error closing plaintext writer: %s", err)
+	}
+
+	md, err := ReadMessage(buf, nil, func(keys []Key, symmetric bool) ([]byte, error) {
+		return []byte("testing"), nil
+	}, nil)
+	if err != nil {
+		t.Fatalf("error rereading message: %s", err)
+	}
+	contents, err := ioutil.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		t.Errorf("error rereading message: %s", err)
+	}
+	if !bytes.Equal(message, contents) {
+		t.Errorf("recovered message incorrect got '%s', want '%s'", contents, message)
+	}
+	if md.SymmetricAlgo != packet.CipherAES128 {
+		t.Errorf("got cipher %d, want %d", md.SymmetricAlgo, packet.CipherAES128)
+	}
+	if md.LiteralData.FileName != hints.FileName || md.LiteralData.Time != uint32(hints.ModTime.Unix()) {
+		t.Errorf("got file name %q and time %d", md.LiteralData.FileName, md.LiteralData.Time)
+	}
+}
+
 var testEncryptionTests = []struct {
 	keyRingHex string
 	isSigned   bool
@@ -383,11 +537,439 @@ func armoredAttachedSign(w io.Writer, signer *Entity, message io.Reader, config
 	return in.Close()
 }
 
+func TestEncryptionCompressionPreference(t *testing.T) {
+	entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ident := range entity.Identities {
+		ident.SelfSignature.PreferredCompression = []uint8{uint8(packet.CompressionZLIB)}
+	}
+
+	config := &packet.Config{DefaultCompressionAlgo: packet.CompressionZIP}
+	buf := new(bytes.Buffer)
+	w, err := Encrypt(buf, []*Entity{entity}, entity, nil, config)
+	if err != nil {
+		t.Fatalf("error in Encrypt: %s", err)
+	}
+	const message = "testing"
+	if _, err := w.Write([]byte(message)); err != nil {
+		t.Fatalf("error writing plaintext: %s", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("error closing WriteCloser: %s", err)
+	}
+	ciphertext := buf.Bytes()
+
+	packets := packet.NewReader(bytes.NewReader(ciphertext))
+	p, err := packets.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ek, ok := p.(*packet.EncryptedKey)
+	if !ok {
+		t.Fatalf("got %T, want *packet.EncryptedKey", p)
+	}
+	if err := ek.Decrypt(entity.Subkeys[0].PrivateKey, nil); err != nil {
+		t.Fatal(err)
+	}
+	p, err = packets.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	se, ok := p.(*packet.SymmetricallyEncrypted)
+	if !ok {
+		t.Fatalf("got %T, want *packet.SymmetricallyEncrypted", p)
+	}
+	decrypted, err := se.Decrypt(ek.CipherFunc, ek.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err = packet.Read(decrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := p.(*packet.Compressed); !ok || c.Algo != packet.CompressionZLIB {
+		t.Errorf("got %#v, want ZLIB compressed data", p)
+	}
+
+	md, err := ReadMessage(bytes.NewReader(ciphertext), EntityList{entity}, nil, nil)
+	if err != nil {
+		t.Fatalf("error reading message: %s", err)
+	}
+	plaintext, err := ioutil.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		t.Fatalf("error reading encrypted contents: %s", err)
+	}
+	if string(plaintext) != message {
+		t.Errorf("got: %s, want: %s", plaintext, message)
+	}
+	if md.SignatureError != nil || md.Signature == nil {
+		t.Errorf("failed to validate: %s", md.SignatureError)
+	}
+}
+
+func TestFileHints(t *testing.T) {
+	kring, _ := ReadKeyRing(readerFromHex(testKeys1And2PrivateHex))
+	hints := &FileHints{
+		IsBinary: true,
+		FileName: "report.pdf",
+		ModTime:  time.Date(2019, 6, 1, 12, 30, 0, 0, time.UTC),
+	}
+	passphrase := []byte("passphrase")
+	prompt := func(keys []Key, symmetric bool) ([]byte, error) {
+		return passphrase, nil
+	}
+
+	writers := map[string]func(w io.Writer, hints *FileHints) (io.WriteCloser, error){
+		"SymmetricallyEncrypt": func(w io.Writer, hints *FileHints) (io.WriteCloser, error) {
+			return SymmetricallyEncrypt(w, passphrase, hints, nil)
+		},
+		"Encrypt": func(w io.Writer, hints *FileHints) (io.WriteCloser, error) {
+			return Encrypt(w, kring[:1], nil, hints, nil)
+		},
+		"SignMessage": func(w io.Writer, hints *FileHints) (io.WriteCloser, error) {
+			return SignMessage(w, kring[0], hints, nil)
+		},
+	}
+	for name, write := range writers {
+		for _, hints := range []*FileHints{hints, nil} {
+			buf := new(bytes.Buffer)
+			w, err := write(buf, hints)
+			if err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+			if _, err := w.Write([]byte("contents")); err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+
+			md, err := ReadMessage(buf, kring, prompt, nil)
+			if err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+			want := hints
+			if want == nil {
+				want = &FileHints{}
+			}
+			lit := md.LiteralData
+			if lit.FileName != want.FileName || lit.IsBinary != want.IsBinary || !lit.ModTime().Equal(want.ModTime) {
+				t.Errorf("%s: got %q, binary %v, time %v, want %+v", name, lit.FileName, lit.IsBinary, lit.ModTime(), want)
+			}
+		}
+	}
+}
+
+func TestFileHintsText(t *testing.T) {
+	kring, _ := ReadKeyRing(readerFromHex(testKeys1And2PrivateHex))
+	passphrase := []byte("passphrase")
+	prompt := func(keys []Key, symmetric bool) ([]byte, error) {
+		return passphrase, nil
+	}
+	hints := &FileHints{IsText: true}
+
+	writers := map[string]func(w io.Writer) (io.WriteCloser, error){
+		"SymmetricallyEncrypt": func(w io.Writer) (io.WriteCloser, error) {
+			return SymmetricallyEncrypt(w, passphrase, hints, nil)
+		},
+		"Encrypt": func(w io.Writer) (io.WriteCloser, error) {
+			return Encrypt(w, kring[:1], nil, hints, nil)
+		},
+	}
+	const text = "First line\nSecond line\r\nThird line"
+	for name, write := range writers {
+		buf := new(bytes.Buffer)
+		w, err := write(buf)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		// Split the writes inside a line ending.
+		if _, err := w.Write([]byte(text[:23])); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if _, err := w.Write([]byte(text[23:])); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		md, err := ReadMessage(buf, kring, prompt, nil)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		contents, err := ioutil.ReadAll(md.UnverifiedBody)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		const crlf = "First line\r\nSecond line\r\nThird line"
+		if string(contents) != crlf {
+			t.Errorf("%s: got %q, want %q", name, contents, crlf)
+		}
+	}
+}
+
+func TestEncryptToKeys(t *testing.T) {
+	created := time.Unix(1500000000, 0)
+	config := &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA, Time: func() time.Time { return created }}
+	entity, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := created.Add(time.Hour)
+	if err := entity.AddEncryptionSubkey(&packet.Config{Time: func() time.Time { return later }}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Encrypt would pick the newer subkey; target the older one.
+	target := entity.Subkeys[0]
+	keys := EntityList{entity}.KeysById(target.PublicKey.KeyId, nil)
+	buf := new(bytes.Buffer)
+	w, err := EncryptToKeys(buf, keys, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("contents"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	md, err := ReadMessage(buf, EntityList{entity}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(md.EncryptedToKeyIds) != 1 || md.EncryptedToKeyIds[0] != target.PublicKey.KeyId {
+		t.Errorf("encrypted to %x, want only %x", md.EncryptedToKeyIds, target.PublicKey.KeyId)
+	}
+	if md.DecryptedWith.PublicKey != target.PublicKey {
+		t.Error("message was not decrypted with the chosen subkey")
+	}
+
+	if _, err := EncryptToKeys(buf, []Key{{PublicKey: entity.PrimaryKey}}, nil, nil, nil); err == nil {
+		t.Error("encrypted to a signing-only key")
+	}
+}
+
+func TestEncryptHiddenRecipients(t *testing.T) {
+	var recipients EntityList
+	for i := 0; i < 2; i++ {
+		e, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+		if err != nil {
+			t.Fatal(err)
+		}
+		recipients = append(recipients, e)
+	}
+
+	buf := new(bytes.Buffer)
+	w, err := Encrypt(buf, recipients, nil, nil, &packet.Config{HideRecipients: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("contents"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Either recipient can decrypt the message by trying all its keys.
+	for i, e := range recipients {
+		md, err := ReadMessage(bytes.NewReader(buf.Bytes()), EntityList{e}, nil, nil)
+		if err != nil {
+			t.Fatalf("#%d: %s", i, err)
+		}
+		if len(md.EncryptedToKeyIds) != 2 || md.EncryptedToKeyIds[0] != 0 || md.EncryptedToKeyIds[1] != 0 {
+			t.Errorf("#%d: encrypted to %x, want two wildcard key IDs", i, md.EncryptedToKeyIds)
+		}
+		if md.DecryptedWith.PublicKey != e.Subkeys[0].PublicKey {
+			t.Errorf("#%d: message was not decrypted with the recipient's subkey", i)
+		}
+		contents, err := ioutil.ReadAll(md.UnverifiedBody)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(contents) != "contents" {
+			t.Errorf("#%d: got %q, want %q", i, contents, "contents")
+		}
+	}
+}
+
+func TestEncryptionCipherNegotiation(t *testing.T) {
+	newKey := func(ciphers ...packet.CipherFunction) *Entity {
+		e, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var prefs []uint8
+		for _, c := range ciphers {
+			prefs = append(prefs, uint8(c))
+		}
+		for _, ident := range e.Identities {
+			ident.SelfSignature.PreferredSymmetric = prefs
+		}
+		return e
+	}
+	aes128 := newKey(packet.CipherAES128)
+	aes256 := newKey(packet.CipherAES256)
+	both := newKey(packet.CipherAES256, packet.CipherAES128)
+	any := newKey()
+
+	tests := []struct {
+		to         []*Entity
+		cipher     packet.CipherFunction
+		wantCipher packet.CipherFunction
+	}{
+		{[]*Entity{aes128, both}, packet.CipherAES256, packet.CipherAES128},
+		{[]*Entity{aes256, both}, 0, packet.CipherAES256},
+		{[]*Entity{both, any}, packet.CipherAES256, packet.CipherAES256},
+		{[]*Entity{both, any}, packet.CipherCAST5, packet.CipherAES256},
+		{[]*Entity{aes128, aes256}, 0, packet.Cipher3DES},
+	}
+	for i, test := range tests {
+		buf := new(bytes.Buffer)
+		w, err := Encrypt(buf, test.to, nil, nil, &packet.Config{DefaultCipher: test.cipher})
+		if err != nil {
+			t.Fatalf("#%d: error in Encrypt: %s", i, err)
+		}
+		const message = "testing"
+		if _, err := w.Write([]byte(message)); err != nil {
+			t.Fatalf("#%d: error writing plaintext: %s", i, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("#%d: error closing WriteCloser: %s", i, err)
+		}
+
+		// There is one encrypted key for each recipient.
+		packets := packet.NewReader(bytes.NewReader(buf.Bytes()))
+		for j, to := range test.to {
+			p, err := packets.Next()
+			if err != nil {
+				t.Fatalf("#%d: %s", i, err)
+			}
+			ek, ok := p.(*packet.EncryptedKey)
+			if !ok {
+				t.Fatalf("#%d: got %T, want *packet.EncryptedKey", i, p)
+			}
+			if ek.KeyId != to.Subkeys[0].PublicKey.KeyId {
+				t.Errorf("#%d: encrypted key %d is for %x", i, j, ek.KeyId)
+			}
+			if err := ek.Decrypt(to.Subkeys[0].PrivateKey, nil); err != nil {
+				t.Fatalf("#%d: %s", i, err)
+			}
+			if ek.CipherFunc != test.wantCipher {
+				t.Errorf("#%d: got cipher %d, want %d", i, ek.CipherFunc, test.wantCipher)
+			}
+		}
+
+		for _, to := range test.to {
+			md, err := ReadMessage(bytes.NewReader(buf.Bytes()), EntityList{to}, nil, nil)
+			if err != nil {
+				t.Fatalf("#%d: error reading message: %s", i, err)
+			}
+			plaintext, err := ioutil.ReadAll(md.UnverifiedBody)
+			if err != nil {
+				t.Fatalf("#%d: error reading encrypted contents: %s", i, err)
+			}
+			if string(plaintext) != message {
+				t.Errorf("#%d: got: %s, want: %s", i, plaintext, message)
+			}
+		}
+	}
+}
+
+func TestEncryptionFeatures(t *testing.T) {
+	newKey := func(features []byte) *Entity {
+		e, err := NewEntity("Golang Gopher", "Test Key", "no-reply@golang.com", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, ident := range e.Identities {
+			ident.SelfSignature.Features = features
+		}
+		return e
+	}
+	plain := newKey(nil)
+	aead := newKey([]byte{packet.FeatureMDC | packet.FeatureAEAD})
+	aead2 := newKey([]byte{packet.FeatureMDC | packet.FeatureAEAD})
+
+	tests := []struct {
+		to         []*Entity
+		disableMDC bool
+		wantAEAD   bool
+	}{
+		{[]*Entity{plain}, false, false},
+		{[]*Entity{aead}, false, true},
+		{[]*Entity{aead, aead2}, false, true},
+		{[]*Entity{aead, plain}, false, false},
+		{[]*Entity{plain}, true, false},
+		{[]*Entity{aead}, true, false},
+	}
+	for i, test := range tests {
+		buf := new(bytes.Buffer)
+		config := &packet.Config{DisableMDC: test.disableMDC}
+		w, err := Encrypt(buf, test.to, nil, nil, config)
+		if err != nil {
+			t.Fatalf("#%d: error in Encrypt: %s", i, err)
+		}
+		const message = "testing"
+		if _, err := w.Write([]byte(message)); err != nil {
+			t.Fatalf("#%d: error writing plaintext: %s", i, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("#%d: error closing WriteCloser: %s", i, err)
+		}
+
+		packets := packet.NewReader(bytes.NewReader(buf.Bytes()))
+		var p packet.Packet
+		for {
+			if p, err = packets.Next(); err != nil {
+				t.Fatalf("#%d: %s", i, err)
+			}
+			if _, ok := p.(*packet.EncryptedKey); !ok {
+				break
+			}
+		}
+		switch p := p.(type) {
+		case *packet.AEADEncrypted:
+			if !test.wantAEAD {
+				t.Errorf("#%d: got an AEAD encrypted message", i)
+			}
+		case *packet.SymmetricallyEncrypted:
+			if test.wantAEAD {
+				t.Errorf("#%d: got a symmetrically encrypted message, want AEAD", i)
+			}
+			if p.MDC == test.disableMDC {
+				t.Errorf("#%d: got MDC %t, want %t", i, p.MDC, !test.disableMDC)
+			}
+		default:
+			t.Fatalf("#%d: got %T after the encrypted keys", i, p)
+		}
+
+		for _, to := range test.to {
+			md, err := ReadMessage(bytes.NewReader(buf.Bytes()), EntityList{to}, nil, nil)
+			if err != nil {
+				t.Fatalf("#%d: error reading message: %s", i, err)
+			}
+			plaintext, err := ioutil.ReadAll(md.UnverifiedBody)
+			if err != nil {
+				t.Fatalf("#%d: error reading encrypted contents: %s", i, err)
+			}
+			if string(plaintext) != message {
+				t.Errorf("#%d: got: %s, want: %s", i, plaintext, message)
+			}
+			if md.IntegrityProtected == test.disableMDC {
+				t.Errorf("#%d: got IntegrityProtected %t", i, md.IntegrityProtected)
+			}
+		}
+	}
+}
+
 func TestSignAttached(t *testing.T) {
 	var testCompressionAlgos = []packet.CompressionAlgo{
 		packet.CompressionNone,
 		packet.CompressionZIP,
 		packet.CompressionZLIB,
+		packet.CompressionBZIP2,
 	}
 
 	for _, compAlgo := range testCompressionAlgos {
@@ -440,3 +1022,166 @@ func TestSignAttached(t *testing.T) {
 		}
 	}
 }
+
+func TestSignMessage(t *testing.T) {
+	kring, _ := ReadKeyRing(readerFromHex(testKeys1And2PrivateHex))
+	buf := new(bytes.Buffer)
+	hints := &FileHints{FileName: "message.txt", ModTime: time.Unix(1500000000, 0)}
+	w, err := SignMessage(buf, kring[0], hints, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte(signedInput)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := packet.Read(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.(*packet.OnePassSignature); !ok {
+		t.Fatalf("got %T, want *packet.OnePassSignature first", p)
+	}
+
+	md, err := ReadMessage(buf, kring, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.LiteralData.FileName != hints.FileName {
+		t.Errorf("got file name %q, want %q", md.LiteralData.FileName, hints.FileName)
+	}
+	contents, err := ioutil.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != signedInput {
+		t.Errorf("got %q, want %q", contents, signedInput)
+	}
+	if md.SignedBy == nil || md.SignedBy.Entity != kring[0] {
+		t.Error("message not signed by the signer")
+	}
+	if md.SignatureError != nil || md.Signature == nil {
+		t.Errorf("failed to validate: %s", md.SignatureError)
+	}
+}
+
+func TestSignMessageV6(t *testing.T) {
+	kring, _ := ReadKeyRing(readerFromHex(testKeys1And2PrivateHex))
+	config := &packet.Config{SignatureVersion: 6}
+	buf := new(bytes.Buffer)
+	if _, err := SignMessage(buf, kring[0], nil, config); err == nil {
+		t.Error("made a version 6 signature with a version 4 key")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("%d bytes written", buf.Len())
+	}
+
+	signer := v6SigningEntity(t, kring[0])
+	kring = EntityList{signer}
+	w, err := SignMessage(buf, signer, nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte(signedInput)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := packet.Read(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, ok := p.(*packet.OnePassSignature)
+	if !ok {
+		t.Fatalf("got %T, want *packet.OnePassSignature first", p)
+	}
+	if ops.Version != 6 || len(ops.Salt) != 16 {
+		t.Fatalf("got one-pass signature version %d with %d byte salt, want 6 and 16", ops.Version, len(ops.Salt))
+	}
+	if !bytes.Equal(ops.KeyFingerprint, signer.PrimaryKey.FullFingerprint()) {
+		t.Errorf("got fingerprint %x, want %x", ops.KeyFingerprint, signer.PrimaryKey.FullFingerprint())
+	}
+
+	readMessage := func(msg []byte) *MessageDetails {
+		md, err := ReadMessage(bytes.NewReader(msg), kring, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if md.SignedBy == nil || md.SignedBy.Entity != signer {
+			t.Fatal("message not signed by the signer")
+		}
+		if _, err := ioutil.ReadAll(md.UnverifiedBody); err != nil {
+			t.Fatal(err)
+		}
+		return md
+	}
+	md := readMessage(buf.Bytes())
+	if md.SignatureError != nil || md.Signature == nil {
+		t.Fatalf("failed to validate: %s", md.SignatureError)
+	}
+	if md.Signature.Version != 6 || !bytes.Equal(md.Signature.Salt, ops.Salt) {
+		t.Errorf("got version %d signature with salt %x, want version 6 with %x", md.Signature.Version, md.Signature.Salt, ops.Salt)
+	}
+
+	// The salt follows the two byte packet header, the version, signature
+	// type, hash and public key algorithm and the salt length.
+	tampered := append([]byte(nil), buf.Bytes()...)
+	tampered[7] ^= 1
+	md = readMessage(tampered)
+	if _, ok := md.SignatureError.(errors.StructuralError); !ok {
+		t.Errorf("got %v, want a StructuralError for a mismatched salt", md.SignatureError)
+	}
+}
+
+func TestSignMessageText(t *testing.T) {
+	kring, _ := ReadKeyRing(readerFromHex(testKeys1And2PrivateHex))
+	buf := new(bytes.Buffer)
+	w, err := SignMessage(buf, kring[0], &FileHints{IsText: true, IsBinary: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const text = "First line\nSecond line\n"
+	if _, err := w.Write([]byte(text)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	md, err := ReadMessage(bytes.NewReader(buf.Bytes()), kring, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.LiteralData.IsBinary {
+		t.Error("literal data marked as binary")
+	}
+	// Text is stored with CRLF line endings.
+	contents, err := ioutil.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crlf := strings.Replace(text, "\n", "\r\n", -1)
+	if string(contents) != crlf {
+		t.Errorf("got %q, want %q", contents, crlf)
+	}
+	if md.SignatureError != nil || md.Signature == nil {
+		t.Fatalf("failed to validate: %s", md.SignatureError)
+	}
+	if md.Signature.SigType != packet.SigTypeText {
+		t.Errorf("got signature type %d, want %d", md.Signature.SigType, packet.SigTypeText)
+	}
+
+	// The signature holds for the text with LF line endings too.
+	sig := new(bytes.Buffer)
+	if err := md.Signature.Serialize(sig); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CheckDetachedSignature(kring, strings.NewReader(text), sig); err != nil {
+		t.Errorf("signature did not verify with LF line endings: %s", err)
+	}
+}
@@ -15,6 +15,8 @@ import (
 	"math/big"
 	"testing"
 	"testing/quick"
+
+	_ "github.com/keybase/go-crypto/sha3"
 )
 
 func decodeBase64(in string) []byte {
@@ -223,6 +225,33 @@ func TestVerifyPKCS1v15(t *testing.T) {
 	}
 }
 
+func TestSignPKCS1v15SHA3(t *testing.T) {
+	// The test key is too small for the longer SHA3 hashes.
+	for _, hash := range []crypto.Hash{crypto.SHA3_224, crypto.SHA3_256} {
+		h := hash.New()
+		h.Write([]byte("Test.\n"))
+		digest := h.Sum(nil)
+
+		s, err := SignPKCS1v15(nil, rsaPrivateKey, hash, digest)
+		if err != nil {
+			t.Errorf("%v: %s", hash, err)
+			continue
+		}
+		if err := VerifyPKCS1v15(&rsaPrivateKey.PublicKey, hash, digest, s); err != nil {
+			t.Errorf("%v: %s", hash, err)
+		}
+		// The DigestInfo names the hash, so the signature doesn't hold
+		// for another one of the same size.
+		other := crypto.SHA224
+		if hash.Size() == 32 {
+			other = crypto.SHA256
+		}
+		if err := VerifyPKCS1v15(&rsaPrivateKey.PublicKey, other, digest, s); err == nil {
+			t.Errorf("%v signature verified as %v", hash, other)
+		}
+	}
+}
+
 func TestOverlongMessagePKCS1v15(t *testing.T) {
 	ciphertext := decodeBase64("fjOVdirUzFoLlukv80dBllMLjXythIf22feqPrNo0YoIjzyzyoMFiLjAc/Y4krkeZ11XFThIrEvw\nkRiZcCq5ng==")
 	_, err := DecryptPKCS1v15(nil, rsaPrivateKey, ciphertext)